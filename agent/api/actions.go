@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/scttfrdmn/snoozebot/agent/idle"
+	"github.com/scttfrdmn/snoozebot/agent/provider"
+	"github.com/scttfrdmn/snoozebot/agent/scheduler"
+	"github.com/scttfrdmn/snoozebot/agent/store"
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
+	"github.com/scttfrdmn/snoozebot/pkg/schedule"
+)
+
+// pluginLookup returns the scheduler's view of the loaded cloud provider
+// plugins, loading a plugin the first time it is needed
+func pluginLookup(pluginManager provider.PluginManager) scheduler.ProviderLookup {
+	return func(ctx context.Context, name string) (scheduler.CloudProvider, error) {
+		plugin, err := pluginManager.GetPlugin(name)
+		if err != nil {
+			plugin, err = pluginManager.LoadPlugin(ctx, name)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return plugin, nil
+	}
+}
+
+// scheduleHold holds back stops while the schedule doesn't allow them
+func scheduleHold(agentSchedule *schedule.Schedule) scheduler.HoldFunc {
+	return func(instance *store.InstanceState, action protocol.ScheduledAction, now time.Time) bool {
+		if !(protocol.IdleStage{Action: action.Action}).Stops() {
+			return false
+		}
+		policy, _ := idle.SchedulePolicy(agentSchedule, instance.Registration, now)
+		return policy.NeverStop
+	}
+}
+
+// pendingCommands returns the commands for an instance's due actions, so it
+// can prepare for them. The scheduler carries the actions out, and they are
+// sent until they finish. Actions waiting for approval aren't sent, stops are
+// sent once the scheduler asks the instance to prepare for them, and stops
+// are held back while the schedule doesn't allow them.
+func pendingCommands(agentSchedule *schedule.Schedule, instance *store.InstanceState, now time.Time) []protocol.InstanceCommand {
+	commands := make([]protocol.InstanceCommand, 0)
+	policy, _ := idle.SchedulePolicy(agentSchedule, instance.Registration, now)
+
+	for _, action := range instance.ScheduledActions {
+		if action.Finished() || !action.Approved() || !now.After(action.ScheduledTime) {
+			continue
+		}
+		if action.StopsInstance() && (policy.NeverStop || action.RequestedAt.IsZero()) {
+			continue
+		}
+		commands = append(commands, protocol.InstanceCommand{
+			Command: action.Action,
+			Parameters: map[string]string{
+				"reason": action.Reason,
+				"id":     action.ID,
+			},
+		})
+	}
+
+	return commands
+}
+
+// Scheduler returns the scheduler that carries out scheduled actions
+func (s *Server) Scheduler() *scheduler.Scheduler {
+	return s.scheduler
+}
+
+// handleAdminCancelAction handles the POST /api/admin/actions/cancel endpoint
+func (s *Server) handleAdminCancelAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		InstanceID string `json:"instance_id"`
+		ActionID   string `json:"action_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	err := s.scheduler.Cancel(request.InstanceID, request.ActionID)
+	switch {
+	case errors.Is(err, store.ErrInstanceNotFound), errors.Is(err, scheduler.ErrActionNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, scheduler.ErrActionFinished):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("Failed to cancel action: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// handleAdminApproveAction handles the POST /api/admin/actions/approve endpoint
+func (s *Server) handleAdminApproveAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		InstanceID string `json:"instance_id"`
+		ActionID   string `json:"action_id"`
+		Approver   string `json:"approver"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if request.Approver == "" {
+		http.Error(w, "Invalid request: approver is required", http.StatusBadRequest)
+		return
+	}
+
+	err := s.scheduler.Approve(request.InstanceID, request.ActionID, request.Approver)
+	switch {
+	case errors.Is(err, store.ErrInstanceNotFound), errors.Is(err, scheduler.ErrActionNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, scheduler.ErrActionFinished):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("Failed to approve action: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
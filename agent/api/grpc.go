@@ -5,10 +5,16 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/scttfrdmn/snoozebot/agent/idle"
+	"github.com/scttfrdmn/snoozebot/agent/journal"
+	"github.com/scttfrdmn/snoozebot/agent/policy"
 	"github.com/scttfrdmn/snoozebot/agent/provider"
+	"github.com/scttfrdmn/snoozebot/agent/scheduler"
 	"github.com/scttfrdmn/snoozebot/agent/store"
 	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
 	"github.com/scttfrdmn/snoozebot/pkg/common/protocol/gen"
+	"github.com/scttfrdmn/snoozebot/pkg/notification"
+	"github.com/scttfrdmn/snoozebot/pkg/schedule"
 )
 
 // GRPCServer implements the SnoozeAgent gRPC service
@@ -17,6 +23,12 @@ type GRPCServer struct {
 	instanceStore  store.Store
 	pluginManager  provider.PluginManager
 	agentID        string
+	schedule       *schedule.Schedule
+	stages         []protocol.IdleStage
+	policies       *policy.Engine
+	notifier       *notification.Manager
+	journal        journal.Journal
+	scheduler      *scheduler.Scheduler
 }
 
 // NewGRPCServer creates a new gRPC server
@@ -83,78 +95,64 @@ func (s *GRPCServer) UnregisterInstance(ctx context.Context, req *gen.Unregister
 
 // SendIdleNotification handles idle notifications from instances
 func (s *GRPCServer) SendIdleNotification(ctx context.Context, req *gen.IdleNotificationRequest) (*gen.IdleNotificationResponse, error) {
-	// Get the instance
-	instance, err := s.instanceStore.GetInstance(req.InstanceId)
-	if err != nil {
-		return &gen.IdleNotificationResponse{
-			Action: "error",
-			Reason: fmt.Sprintf("Failed to get instance: %v", err),
-		}, nil
-	}
-
 	// Convert idle time
 	idleSince := time.Unix(req.IdleSince, 0)
 	idleDuration := time.Duration(req.IdleDuration) * time.Second
 
-	// Update idle state
-	err = s.instanceStore.UpdateIdleState(
-		req.InstanceId,
-		true,
-		idleSince,
-		idleDuration,
-	)
+	// Record the idle time and advance the instance through its idle stages
+	// in one update. The schedule decides the naptime and whether the
+	// instance may be stopped now, and an invalid instance schedule or stage
+	// list falls back to the agent's.
+	var instance *store.InstanceState
+	var decision idle.Decision
+	err := s.instanceStore.Update(req.InstanceId, func(updated *store.InstanceState) error {
+		updated.IdleSince = idleSince
+		updated.IdleDuration = idleDuration
+		updated.ResourceUsage = req.ResourceUsage
+
+		decision, _ = idle.Decide(s.schedule, s.stages, s.policies, updated, idleSince, idleDuration, time.Now())
+		if decision.ScheduledAction != nil {
+			updated.ScheduledActions = append(updated.ScheduledActions, *decision.ScheduledAction)
+		}
+
+		instance = updated
+		return nil
+	})
 	if err != nil {
 		return &gen.IdleNotificationResponse{
 			Action: "error",
 			Reason: fmt.Sprintf("Failed to update idle state: %v", err),
 		}, nil
 	}
-
-	// Update resource usage
-	err = s.instanceStore.UpdateResourceUsage(req.InstanceId, req.ResourceUsage)
-	if err != nil {
-		return &gen.IdleNotificationResponse{
-			Action: "error",
-			Reason: fmt.Sprintf("Failed to update resource usage: %v", err),
-		}, nil
+	response := &gen.IdleNotificationResponse{
+		Action: decision.Action,
+		Reason: decision.Reason,
+		Policy: decision.Policy,
+		Trace:  decision.Trace,
 	}
 
-	// Determine action to take
-	var response *gen.IdleNotificationResponse
-
-	// In a real implementation, we would check policies and decide whether to stop the instance
-	// For now, we'll use a simple rule: if idle for more than the naptime, stop the instance
-	if idleDuration >= instance.Registration.NapTime {
-		scheduledTime := time.Now().Add(5 * time.Minute) // Schedule stop in 5 minutes
-
-		response = &gen.IdleNotificationResponse{
-			Action: "stop",
-			Reason: fmt.Sprintf("Instance has been idle for %s (threshold: %s)",
-				idleDuration, instance.Registration.NapTime),
-			ScheduledAction: &gen.ScheduledAction{
-				Action:        "stop",
-				ScheduledTime: scheduledTime.Unix(),
-				Reason:        "Idle timeout",
-			},
+	// Notify the owner of each stage reached
+	if s.notifier != nil {
+		for _, stage := range decision.Reached {
+			go s.notifier.NotifyIdleStage(
+				context.Background(),
+				req.InstanceId,
+				instanceName(instance),
+				instance.Registration.Provider,
+				instance.Registration.Region,
+				idle.NotificationType(stage),
+				stage.Action,
+				idleDuration,
+				decision.Reason,
+			)
 		}
+	}
 
-		// Add scheduled action to the instance
-		err = s.instanceStore.AddScheduledAction(req.InstanceId, protocol.ScheduledAction{
-			Action:        "stop",
-			ScheduledTime: scheduledTime,
-			Reason:        "Idle timeout",
-		})
-		if err != nil {
-			return &gen.IdleNotificationResponse{
-				Action: "error",
-				Reason: fmt.Sprintf("Failed to add scheduled action: %v", err),
-			}, nil
-		}
-	} else {
-		response = &gen.IdleNotificationResponse{
-			Action: "wait",
-			Reason: fmt.Sprintf("Instance has been idle for %s, but threshold is %s",
-				idleDuration, instance.Registration.NapTime),
+	if decision.ScheduledAction != nil {
+		response.ScheduledAction = &gen.ScheduledAction{
+			Action:        decision.ScheduledAction.Action,
+			ScheduledTime: decision.ScheduledAction.ScheduledTime.Unix(),
+			Reason:        decision.ScheduledAction.Reason,
 		}
 	}
 
@@ -163,57 +161,49 @@ func (s *GRPCServer) SendIdleNotification(ctx context.Context, req *gen.IdleNoti
 
 // SendHeartbeat handles heartbeats from instances
 func (s *GRPCServer) SendHeartbeat(ctx context.Context, req *gen.HeartbeatRequest) (*gen.HeartbeatResponse, error) {
-	// Update last heartbeat time
-	err := s.instanceStore.UpdateLastHeartbeat(req.InstanceId, time.Unix(req.Timestamp, 0))
-	if err != nil {
-		return &gen.HeartbeatResponse{
-			Acknowledged: false,
-		}, nil
-	}
-
-	// Update resource usage if provided
-	if len(req.ResourceUsage) > 0 {
-		err = s.instanceStore.UpdateResourceUsage(req.InstanceId, req.ResourceUsage)
-		if err != nil {
-			return &gen.HeartbeatResponse{
-				Acknowledged: false,
-			}, nil
+	// Record the heartbeat and state. Activity ends the idle period and
+	// cancels the stages reached while the instance was idle.
+	var instance *store.InstanceState
+	cancelled := false
+	err := s.instanceStore.Update(req.InstanceId, func(updated *store.InstanceState) error {
+		updated.LastHeartbeat = time.Unix(req.Timestamp, 0)
+		if len(req.ResourceUsage) > 0 {
+			updated.ResourceUsage = req.ResourceUsage
+		}
+		updated.State = req.State
+		if req.State != "idle" {
+			updated.IdleSince = time.Time{}
+			updated.IdleDuration = 0
+			cancelled = idle.CancelStageActions(updated)
 		}
-	}
-
-	// Update instance state
-	err = s.instanceStore.UpdateInstanceState(req.InstanceId, req.State)
-	if err != nil {
-		return &gen.HeartbeatResponse{
-				Acknowledged: false,
-			}, nil
-	}
 
-	// Get scheduled actions for the instance
-	instance, err := s.instanceStore.GetInstance(req.InstanceId)
+		instance = updated
+		return nil
+	})
 	if err != nil {
 		return &gen.HeartbeatResponse{
 			Acknowledged: false,
 		}, nil
 	}
 
-	// Check if any scheduled actions are due
+	if cancelled && s.notifier != nil {
+		go s.notifier.NotifyStopCancelled(
+			context.Background(),
+			req.InstanceId,
+			instanceName(instance),
+			instance.Registration.Provider,
+			instance.Registration.Region,
+			fmt.Sprintf("Instance became %s", req.State),
+		)
+	}
+
+	// Tell the instance about due actions so it can prepare for them
 	commands := make([]*gen.Command, 0)
-	now := time.Now()
-
-	for _, action := range instance.ScheduledActions {
-		if now.After(action.ScheduledTime) {
-			// Action is due, add command
-			commands = append(commands, &gen.Command{
-				Command: action.Action,
-				Parameters: map[string]string{
-					"reason": action.Reason,
-				},
-			})
-
-			// Remove the action (in a real implementation, we would mark it as executed)
-			// This is a simplification
-		}
+	for _, command := range pendingCommands(s.schedule, instance, time.Now()) {
+		commands = append(commands, &gen.Command{
+			Command:    command.Command,
+			Parameters: command.Parameters,
+		})
 	}
 
 	return &gen.HeartbeatResponse{
@@ -224,8 +214,13 @@ func (s *GRPCServer) SendHeartbeat(ctx context.Context, req *gen.HeartbeatReques
 
 // ReportStateChange handles state change reports from instances
 func (s *GRPCServer) ReportStateChange(ctx context.Context, req *gen.StateChangeRequest) (*gen.StateChangeResponse, error) {
-	// Update instance state
+	// Update instance state. The change is added to the instance's history
+	// by the journal wrapping the store.
 	err := s.instanceStore.UpdateInstanceState(req.InstanceId, req.CurrentState)
+	if err == nil && s.scheduler != nil {
+		// The state may reply to a stop the instance was asked to prepare for
+		err = s.scheduler.Reply(req.InstanceId, req.CurrentState, req.Reason)
+	}
 	if err != nil {
 		return &gen.StateChangeResponse{
 			Acknowledged: false,
@@ -233,9 +228,6 @@ func (s *GRPCServer) ReportStateChange(ctx context.Context, req *gen.StateChange
 		}, nil
 	}
 
-	// In a real implementation, we would record the state change in a history log
-	// For now, just return success
-
 	return &gen.StateChangeResponse{
 		Acknowledged: true,
 	}, nil
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/snoozebot/agent/journal"
+	"github.com/scttfrdmn/snoozebot/agent/store"
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol/gen"
+)
+
+// errNoJournal is returned for history requests when the agent doesn't keep a journal
+var errNoJournal = errors.New("instance history isn't recorded by this agent")
+
+// SetJournal sets the journal that instance history is read from. The
+// history is recorded by wrapping the store in a journal.Store.
+func (s *Server) SetJournal(j journal.Journal) {
+	s.journal = j
+}
+
+// handleAdminInstanceHistory handles the GET /api/admin/instances/{id}/history endpoint
+func (s *Server) handleAdminInstanceHistory(w http.ResponseWriter, r *http.Request, instanceID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.journal == nil {
+		http.Error(w, errNoJournal.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	query, err := journal.ParseQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	page, err := s.journal.History(instanceID, query)
+	if errors.Is(err, journal.ErrInvalidPageToken) {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get history: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Unregistered instances keep their history, so an instance is only
+	// unknown if it has neither
+	if len(page.Events) == 0 && query.PageToken == "" {
+		if _, err := s.store.GetInstance(instanceID); errors.Is(err, store.ErrInstanceNotFound) {
+			http.Error(w, fmt.Sprintf("Instance not found: %v", err), http.StatusNotFound)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// splitInstancePath splits /api/admin/instances/{id}[/history] into the
+// instance ID and the resource under it
+func splitInstancePath(path string) (instanceID, resource string) {
+	rest := strings.TrimPrefix(path, "/api/admin/instances/")
+	if id, ok := strings.CutSuffix(rest, "/history"); ok {
+		return id, "history"
+	}
+	return rest, ""
+}
+
+// GetInstanceHistory returns the events recorded for an instance
+func (s *GRPCServer) GetInstanceHistory(ctx context.Context, req *gen.InstanceHistoryRequest) (*gen.InstanceHistoryResponse, error) {
+	if s.journal == nil {
+		return &gen.InstanceHistoryResponse{
+			Error: errNoJournal.Error(),
+		}, nil
+	}
+
+	query := journal.Query{
+		PageSize:  int(req.PageSize),
+		PageToken: req.PageToken,
+	}
+	if req.Since != 0 {
+		query.Since = time.Unix(req.Since, 0)
+	}
+	if req.Until != 0 {
+		query.Until = time.Unix(req.Until, 0)
+	}
+
+	page, err := s.journal.History(req.InstanceId, query)
+	if err != nil {
+		return &gen.InstanceHistoryResponse{
+			Error: err.Error(),
+		}, nil
+	}
+
+	response := &gen.InstanceHistoryResponse{
+		Events:        make([]*gen.HistoryEvent, len(page.Events)),
+		NextPageToken: page.NextPageToken,
+	}
+	for i, event := range page.Events {
+		response.Events[i] = historyEvent(event)
+	}
+
+	return response, nil
+}
+
+// historyEvent converts a journal event for the gRPC API
+func historyEvent(event journal.Event) *gen.HistoryEvent {
+	converted := &gen.HistoryEvent{
+		Sequence:      event.Sequence,
+		Timestamp:     event.Time.Unix(),
+		Type:          string(event.Type),
+		PreviousState: event.PreviousState,
+		State:         event.State,
+		Action:        event.Action,
+		ActionId:      event.ActionID,
+		Reason:        event.Reason,
+		Error:         event.Error,
+		Policy:        event.Policy,
+		Approver:      event.Approver,
+	}
+	if !event.IdleSince.IsZero() {
+		converted.IdleSince = event.IdleSince.Unix()
+	}
+	if !event.ScheduledTime.IsZero() {
+		converted.ScheduledTime = event.ScheduledTime.Unix()
+	}
+	return converted
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/scttfrdmn/snoozebot/agent/journal"
+	"github.com/scttfrdmn/snoozebot/agent/store"
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol/gen"
+)
+
+// newHistoryTest returns a store recording to a journal, with a registered
+// instance that became idle
+func newHistoryTest(t *testing.T) (store.Store, journal.Journal) {
+	t.Helper()
+
+	events := journal.NewMemoryJournal()
+	instanceStore := journal.NewStore(store.NewMemoryStore(), events, nil)
+	if err := instanceStore.RegisterInstance(protocol.InstanceRegistration{InstanceID: "i-1234"}); err != nil {
+		t.Fatalf("Failed to register instance: %v", err)
+	}
+	if err := instanceStore.UpdateInstanceState("i-1234", "idle"); err != nil {
+		t.Fatalf("Failed to update state: %v", err)
+	}
+
+	return instanceStore, events
+}
+
+func TestHandleAdminInstanceHistory(t *testing.T) {
+	instanceStore, events := newHistoryTest(t)
+	s := &Server{store: instanceStore, journal: events}
+
+	get := func(url string) (*httptest.ResponseRecorder, journal.Page) {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		s.handleAdminGetInstance(recorder, httptest.NewRequest(http.MethodGet, url, nil))
+		var page journal.Page
+		if recorder.Code == http.StatusOK {
+			if err := json.NewDecoder(recorder.Body).Decode(&page); err != nil {
+				t.Fatalf("Failed to decode history: %v", err)
+			}
+		}
+		return recorder, page
+	}
+
+	recorder, page := get("/api/admin/instances/i-1234/history?page_size=1")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", recorder.Code, recorder.Body)
+	}
+	if len(page.Events) != 1 || page.Events[0].Type != journal.EventRegistered || page.NextPageToken == "" {
+		t.Fatalf("Expected the registration and a next page, got %+v", page)
+	}
+
+	_, page = get("/api/admin/instances/i-1234/history?page_token=" + page.NextPageToken)
+	if len(page.Events) != 1 || page.Events[0].Type != journal.EventStateChanged || page.Events[0].State != "idle" {
+		t.Errorf("Expected the state change on the second page, got %+v", page)
+	}
+
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	recorder, page = get("/api/admin/instances/i-1234/history?since=" + future)
+	if recorder.Code != http.StatusOK || len(page.Events) != 0 {
+		t.Errorf("Expected no events in the future, got %d %+v", recorder.Code, page)
+	}
+
+	for url, status := range map[string]int{
+		"/api/admin/instances/i-1234/history?since=yesterday": http.StatusBadRequest,
+		"/api/admin/instances/i-1234/history?page_size=-1":    http.StatusBadRequest,
+		"/api/admin/instances/i-1234/history?page_token=x":    http.StatusBadRequest,
+		"/api/admin/instances/i-missing/history":              http.StatusNotFound,
+		"/api/admin/instances/i-1234/other/history":           http.StatusBadRequest,
+	} {
+		if recorder, _ := get(url); recorder.Code != status {
+			t.Errorf("Expected status %d for %s, got %d", status, url, recorder.Code)
+		}
+	}
+
+	// Unregistered instances keep their history
+	if err := instanceStore.UnregisterInstance("i-1234"); err != nil {
+		t.Fatalf("Failed to unregister instance: %v", err)
+	}
+	if recorder, page := get("/api/admin/instances/i-1234/history"); recorder.Code != http.StatusOK || len(page.Events) != 3 {
+		t.Errorf("Expected the history of the unregistered instance, got %d %+v", recorder.Code, page)
+	}
+
+	s.journal = nil
+	if recorder, _ := get("/api/admin/instances/i-1234/history"); recorder.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status 501 without a journal, got %d", recorder.Code)
+	}
+}
+
+func TestGRPCServer_GetInstanceHistory(t *testing.T) {
+	instanceStore, events := newHistoryTest(t)
+	s := &GRPCServer{instanceStore: instanceStore, journal: events}
+
+	response, err := s.GetInstanceHistory(context.Background(), &gen.InstanceHistoryRequest{InstanceId: "i-1234"})
+	if err != nil || response.Error != "" {
+		t.Fatalf("Failed to get history: %v %s", err, response.Error)
+	}
+	if len(response.Events) != 2 || response.Events[1].Type != "state_changed" || response.Events[1].PreviousState != "running" {
+		t.Fatalf("Expected the registration and state change, got %v", response.Events)
+	}
+	if response.Events[0].Timestamp == 0 || response.Events[0].IdleSince != 0 {
+		t.Errorf("Expected a timestamp and no idle time, got %v", response.Events[0])
+	}
+
+	response, _ = s.GetInstanceHistory(context.Background(), &gen.InstanceHistoryRequest{
+		InstanceId: "i-1234",
+		Until:      time.Now().Add(-time.Hour).Unix(),
+	})
+	if len(response.Events) != 0 {
+		t.Errorf("Expected no events before an hour ago, got %v", response.Events)
+	}
+
+	response, _ = s.GetInstanceHistory(context.Background(), &gen.InstanceHistoryRequest{InstanceId: "i-1234", PageToken: "x"})
+	if response.Error == "" {
+		t.Errorf("Expected an error for an invalid page token")
+	}
+}
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"os"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/scttfrdmn/snoozebot/agent/policy"
+)
+
+// loadPolicies loads the agent's idle policies, returning nil if there are none
+func loadPolicies(path string, logger hclog.Logger) *policy.Engine {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	policies, err := policy.LoadFile(path)
+	if err != nil {
+		logger.Error("Failed to load idle policies, using the registered naptime and stages only", "path", path, "error", err)
+		return nil
+	}
+
+	names := make([]string, 0, len(policies.Policies()))
+	for _, p := range policies.Policies() {
+		names = append(names, p.Name)
+	}
+	logger.Info("Loaded idle policies", "path", path, "policies", names)
+	return policies
+}
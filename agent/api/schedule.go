@@ -0,0 +1,24 @@
+package api
+
+import (
+	"os"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/scttfrdmn/snoozebot/pkg/schedule"
+)
+
+// loadSchedule loads the agent schedule, returning nil if there is none
+func loadSchedule(path string, logger hclog.Logger) *schedule.Schedule {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	s, err := schedule.LoadFile(path)
+	if err != nil {
+		logger.Error("Failed to load schedule, using the registered naptime only", "error", err)
+		return nil
+	}
+
+	logger.Info("Loaded schedule", "path", path, "timezone", s.Location())
+	return s
+}
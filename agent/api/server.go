@@ -3,19 +3,26 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
+	"github.com/scttfrdmn/snoozebot/agent/idle"
+	"github.com/scttfrdmn/snoozebot/agent/journal"
+	"github.com/scttfrdmn/snoozebot/agent/policy"
 	"github.com/scttfrdmn/snoozebot/agent/provider"
+	"github.com/scttfrdmn/snoozebot/agent/scheduler"
 	"github.com/scttfrdmn/snoozebot/agent/store"
 	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
 	"github.com/scttfrdmn/snoozebot/pkg/common/protocol/gen"
 	"github.com/scttfrdmn/snoozebot/pkg/notification"
+	"github.com/scttfrdmn/snoozebot/pkg/schedule"
 	
 	"google.golang.org/grpc"
 )
@@ -29,6 +36,11 @@ type Server struct {
 	authenticatedManager   *provider.PluginManagerWithAuth
 	logger                 hclog.Logger
 	notificationManager    *notification.Manager
+	schedule               *schedule.Schedule
+	stages                 []protocol.IdleStage
+	policies               *policy.Engine
+	scheduler              *scheduler.Scheduler
+	journal                journal.Journal
 }
 
 // NewServer creates a new API server
@@ -42,6 +54,15 @@ func NewServer(store store.Store, pluginsDir string, configDir string) *Server {
 	// Create the base plugin manager
 	baseManager := provider.NewPluginManager(pluginsDir, logger)
 	
+	// Load the idle schedule and stages, if any
+	agentSchedule := loadSchedule(filepath.Join(configDir, "schedule.conf"), logger)
+	agentStages := loadStages(filepath.Join(configDir, "stages.conf"), logger)
+	agentPolicies := loadPolicies(filepath.Join(configDir, "policies.yaml"), logger)
+	
+	// Create the scheduler that carries out scheduled actions
+	actionScheduler := scheduler.New(store, pluginLookup(baseManager), scheduler.Config{}, logger.Named("scheduler"))
+	actionScheduler.SetHold(scheduleHold(agentSchedule))
+	
 	// Create the authenticated plugin manager
 	authenticatedManager, err := provider.NewPluginManagerWithAuth(baseManager, configDir, logger.Named("auth"))
 	if err != nil {
@@ -53,6 +74,10 @@ func NewServer(store store.Store, pluginsDir string, configDir string) *Server {
 			configDir:     configDir,
 			pluginManager: baseManager,
 			logger:        logger,
+			schedule:      agentSchedule,
+			stages:        agentStages,
+			policies:      agentPolicies,
+			scheduler:     actionScheduler,
 		}
 	}
 
@@ -64,6 +89,7 @@ func NewServer(store store.Store, pluginsDir string, configDir string) *Server {
 		// Continue without notifications if it fails
 		notificationManager = notification.NewManager(logger)
 	}
+	actionScheduler.SetNotifier(notificationManager)
 
 	return &Server{
 		store:                store,
@@ -73,6 +99,10 @@ func NewServer(store store.Store, pluginsDir string, configDir string) *Server {
 		authenticatedManager: authenticatedManager,
 		logger:               logger,
 		notificationManager:  notificationManager,
+		schedule:             agentSchedule,
+		stages:               agentStages,
+		policies:             agentPolicies,
+		scheduler:            actionScheduler,
 	}
 }
 
@@ -88,6 +118,12 @@ func (s *Server) StartGRPCServer(address string) error {
 	
 	// Register the service
 	agentServer := NewGRPCServer(s.store, s.pluginManager)
+	agentServer.schedule = s.schedule
+	agentServer.stages = s.stages
+	agentServer.policies = s.policies
+	agentServer.notifier = s.notificationManager
+	agentServer.journal = s.journal
+	agentServer.scheduler = s.scheduler
 	gen.RegisterSnoozeAgentServer(grpcServer, agentServer)
 	
 	// Start the server in a goroutine
@@ -117,6 +153,8 @@ func (s *Server) Router() http.Handler {
 	mux.HandleFunc("/api/admin/instances", s.handleAdminListInstances)
 	mux.HandleFunc("/api/admin/instances/", s.handleAdminGetInstance)
 	mux.HandleFunc("/api/admin/actions", s.handleAdminScheduleAction)
+	mux.HandleFunc("/api/admin/actions/cancel", s.handleAdminCancelAction)
+	mux.HandleFunc("/api/admin/actions/approve", s.handleAdminApproveAction)
 	
 	// Plugin management routes
 	mux.HandleFunc("/api/plugins", s.handleListPlugins)
@@ -207,98 +245,60 @@ func (s *Server) handleIdleNotification(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Get the instance
-	instance, err := s.store.GetInstance(notification.InstanceID)
-	if err != nil {
+	// Record the idle time and advance the instance through its idle
+	// stages in one update, so a concurrent heartbeat can't cancel the
+	// stages between the decision and the scheduled stop being added. The
+	// schedule decides the naptime and whether the instance may be stopped now.
+	var instance *store.InstanceState
+	var decision idle.Decision
+	var decisionErr error
+	err := s.store.Update(notification.InstanceID, func(updated *store.InstanceState) error {
+		updated.IdleSince = notification.IdleSince
+		updated.IdleDuration = notification.IdleDuration
+		updated.ResourceUsage = notification.ResourceUsage
+
+		decision, decisionErr = idle.Decide(s.schedule, s.stages, s.policies, updated, notification.IdleSince, notification.IdleDuration, time.Now())
+		if decision.ScheduledAction != nil {
+			updated.ScheduledActions = append(updated.ScheduledActions, *decision.ScheduledAction)
+		}
+
+		instance = updated
+		return nil
+	})
+	if errors.Is(err, store.ErrInstanceNotFound) {
 		http.Error(w, fmt.Sprintf("Instance not found: %v", err), http.StatusNotFound)
 		return
 	}
-
-	// Update idle state
-	if err := s.store.UpdateIdleState(
-		notification.InstanceID,
-		true,
-		notification.IdleSince,
-		notification.IdleDuration,
-	); err != nil {
+	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to update idle state: %v", err), http.StatusInternalServerError)
 		return
 	}
-
-	// Update resource usage
-	if err := s.store.UpdateResourceUsage(notification.InstanceID, notification.ResourceUsage); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to update resource usage: %v", err), http.StatusInternalServerError)
-		return
+	if decisionErr != nil {
+		s.logger.Warn("Ignoring invalid instance schedule or stages", "error", decisionErr)
 	}
-
-	// Send idle notification if we have a notification manager
-	if s.notificationManager != nil {
-		// Get instance name from metadata or use ID if not available
-		instanceName := notification.InstanceID
-		if name, ok := instance.Registration.Metadata["name"]; ok && name != "" {
-			instanceName = name
-		}
-
-		// Send notification about idle instance
-		go s.notificationManager.NotifyIdle(
-			context.Background(),
-			notification.InstanceID,
-			instanceName,
-			instance.Registration.Provider,
-			instance.Registration.Region,
-			notification.IdleDuration,
-		)
+	response := protocol.IdleNotificationResponse{
+		Action:          decision.Action,
+		Reason:          decision.Reason,
+		ScheduledAction: decision.ScheduledAction,
+		Policy:          decision.Policy,
+		Trace:           decision.Trace,
 	}
 
-	// Determine action to take
-	var response protocol.IdleNotificationResponse
-
-	// In a real implementation, we would check policies and decide whether to stop the instance
-	// For now, we'll use a simple rule: if idle for more than the naptime, stop the instance
-	if notification.IdleDuration >= instance.Registration.NapTime {
-		response = protocol.IdleNotificationResponse{
-			Action: "stop",
-			Reason: fmt.Sprintf("Instance has been idle for %s (threshold: %s)",
-				notification.IdleDuration, instance.Registration.NapTime),
-			ScheduledAction: &protocol.ScheduledAction{
-				Action:        "stop",
-				ScheduledTime: time.Now().Add(5 * time.Minute), // Schedule stop in 5 minutes
-				Reason:        "Idle timeout",
-			},
-		}
-
-		// Add scheduled action to the instance
-		if err := s.store.AddScheduledAction(notification.InstanceID, *response.ScheduledAction); err != nil {
-			http.Error(w, fmt.Sprintf("Failed to add scheduled action: %v", err), http.StatusInternalServerError)
-			return
-		}
-
-		// Send scheduled action notification if we have a notification manager
-		if s.notificationManager != nil {
-			// Get instance name from metadata or use ID if not available
-			instanceName := notification.InstanceID
-			if name, ok := instance.Registration.Metadata["name"]; ok && name != "" {
-				instanceName = name
-			}
-
-			// Send notification about scheduled action
-			go s.notificationManager.NotifyScheduledAction(
+	// Notify the owner of each stage reached
+	if s.notificationManager != nil {
+		for _, stage := range decision.Reached {
+			go s.notificationManager.NotifyIdleStage(
 				context.Background(),
 				notification.InstanceID,
-				instanceName,
+				instanceName(instance),
 				instance.Registration.Provider,
 				instance.Registration.Region,
-				response.ScheduledAction.Action,
-				response.ScheduledAction.ScheduledTime,
-				response.ScheduledAction.Reason,
+				idle.NotificationType(stage),
+				stage.Action,
+				notification.IdleDuration,
+				decision.Reason,
 			)
 		}
-	} else {
-		response = protocol.IdleNotificationResponse{
-			Action: "wait",
-			Reason: fmt.Sprintf("Instance has been idle for %s, but threshold is %s",
-				notification.IdleDuration, instance.Registration.NapTime),
-		}
 	}
 
 	// Return response
@@ -319,25 +319,44 @@ func (s *Server) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Update last heartbeat time
-	if err := s.store.UpdateLastHeartbeat(heartbeat.InstanceID, heartbeat.Timestamp); err != nil {
+	// Record the heartbeat. Activity ends the idle period and cancels the
+	// stages reached while the instance was idle.
+	var instance *store.InstanceState
+	cancelled := false
+	err := s.store.Update(heartbeat.InstanceID, func(updated *store.InstanceState) error {
+		updated.LastHeartbeat = heartbeat.Timestamp
+		if heartbeat.ResourceUsage != nil {
+			updated.ResourceUsage = heartbeat.ResourceUsage
+		}
+		if heartbeat.State != "" && heartbeat.State != "idle" {
+			updated.IdleSince = time.Time{}
+			updated.IdleDuration = 0
+			cancelled = idle.CancelStageActions(updated)
+		}
+
+		instance = updated
+		return nil
+	})
+	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to update heartbeat: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// Update resource usage if provided
-	if heartbeat.ResourceUsage != nil {
-		if err := s.store.UpdateResourceUsage(heartbeat.InstanceID, heartbeat.ResourceUsage); err != nil {
-			http.Error(w, fmt.Sprintf("Failed to update resource usage: %v", err), http.StatusInternalServerError)
-			return
-		}
+	if cancelled && s.notificationManager != nil {
+		go s.notificationManager.NotifyStopCancelled(
+			context.Background(),
+			heartbeat.InstanceID,
+			instanceName(instance),
+			instance.Registration.Provider,
+			instance.Registration.Region,
+			fmt.Sprintf("Instance became %s", heartbeat.State),
+		)
 	}
 
-	// Get any commands for the instance
-	// In a real implementation, this would check for scheduled actions
+	// Tell the instance about due actions so it can prepare for them
 	response := protocol.HeartbeatResponse{
 		Acknowledged: true,
-		Commands:     make([]protocol.InstanceCommand, 0),
+		Commands:     pendingCommands(s.schedule, instance, time.Now()),
 	}
 
 	// Return response
@@ -359,34 +378,33 @@ func (s *Server) handleStateChange(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Update instance state
-	if err := s.store.UpdateInstanceState(stateChange.InstanceID, stateChange.CurrentState); err != nil {
+	var instance *store.InstanceState
+	err := s.store.Update(stateChange.InstanceID, func(updated *store.InstanceState) error {
+		updated.State = stateChange.CurrentState
+		instance = updated
+		return nil
+	})
+	if err == nil && s.scheduler != nil {
+		// The state may reply to a stop the instance was asked to prepare for
+		err = s.scheduler.Reply(stateChange.InstanceID, stateChange.CurrentState, stateChange.Reason)
+	}
+	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to update instance state: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	// Send state change notification if we have a notification manager
 	if s.notificationManager != nil {
-		// Get the instance to get additional information
-		instance, err := s.store.GetInstance(stateChange.InstanceID)
-		if err == nil { // Don't fail if we can't get the instance details
-			// Get instance name from metadata or use ID if not available
-			instanceName := stateChange.InstanceID
-			if name, ok := instance.Registration.Metadata["name"]; ok && name != "" {
-				instanceName = name
-			}
-
-			// Send notification about state change
-			go s.notificationManager.NotifyStateChange(
-				context.Background(),
-				stateChange.InstanceID,
-				instanceName,
-				instance.Registration.Provider,
-				instance.Registration.Region,
-				stateChange.PreviousState,
-				stateChange.CurrentState,
-				stateChange.Reason,
-			)
-		}
+		go s.notificationManager.NotifyStateChange(
+			context.Background(),
+			stateChange.InstanceID,
+			instanceName(instance),
+			instance.Registration.Provider,
+			instance.Registration.Region,
+			stateChange.PreviousState,
+			stateChange.CurrentState,
+			stateChange.Reason,
+		)
 	}
 
 	// Return success response
@@ -457,12 +475,15 @@ func (s *Server) handleAdminGetInstance(w http.ResponseWriter, r *http.Request)
 	// In a real implementation, this would require authentication
 
 	// Extract instance ID from URL path
-	path := r.URL.Path
-	if len(path) <= len("/api/admin/instances/") {
+	instanceID, resource := splitInstancePath(r.URL.Path)
+	if instanceID == "" || strings.Contains(instanceID, "/") {
 		http.Error(w, "Invalid instance ID", http.StatusBadRequest)
 		return
 	}
-	instanceID := path[len("/api/admin/instances/"):]
+	if resource == "history" {
+		s.handleAdminInstanceHistory(w, r, instanceID)
+		return
+	}
 
 	instance, err := s.store.GetInstance(instanceID)
 	if err != nil {
@@ -494,38 +515,37 @@ func (s *Server) handleAdminScheduleAction(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Add scheduled action
-	if err := s.store.AddScheduledAction(request.InstanceID, request.ScheduledAction); err != nil {
+	// Add scheduled action, with an ID so it can be cancelled
+	if request.ScheduledAction.ID == "" {
+		request.ScheduledAction.ID = protocol.NewActionID()
+	}
+	request.ScheduledAction.Status = protocol.ActionPending
+	var instance *store.InstanceState
+	err := s.store.Update(request.InstanceID, func(updated *store.InstanceState) error {
+		updated.ScheduledActions = append(updated.ScheduledActions, request.ScheduledAction)
+		instance = updated
+		return nil
+	})
+	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to add scheduled action: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	// Send scheduled action notification if we have a notification manager
 	if s.notificationManager != nil {
-		// Get the instance to get additional information
-		instance, err := s.store.GetInstance(request.InstanceID)
-		if err == nil { // Don't fail if we can't get the instance details
-			// Get instance name from metadata or use ID if not available
-			instanceName := request.InstanceID
-			if name, ok := instance.Registration.Metadata["name"]; ok && name != "" {
-				instanceName = name
-			}
-
-			// Send notification about scheduled action
-			go s.notificationManager.NotifyScheduledAction(
-				context.Background(),
-				request.InstanceID,
-				instanceName,
-				instance.Registration.Provider,
-				instance.Registration.Region,
-				request.ScheduledAction.Action,
-				request.ScheduledAction.ScheduledTime,
-				request.ScheduledAction.Reason,
-			)
-		}
+		go s.notificationManager.NotifyScheduledAction(
+			context.Background(),
+			request.InstanceID,
+			instanceName(instance),
+			instance.Registration.Provider,
+			instance.Registration.Region,
+			request.ScheduledAction.Action,
+			request.ScheduledAction.ScheduledTime,
+			request.ScheduledAction.Reason,
+		)
 	}
 
 	// Return success response
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "id": request.ScheduledAction.ID})
 }
\ No newline at end of file
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"os"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/scttfrdmn/snoozebot/agent/store"
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
+)
+
+// loadStages loads the agent's default idle stages, returning nil if there are none
+func loadStages(path string, logger hclog.Logger) []protocol.IdleStage {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		logger.Error("Failed to read idle stages", "error", err)
+		return nil
+	}
+
+	stages, err := protocol.ParseStages(string(data))
+	if err != nil {
+		logger.Error("Failed to parse idle stages, stopping at the naptime instead", "path", path, "error", err)
+		return nil
+	}
+
+	logger.Info("Loaded idle stages", "stages", protocol.FormatStages(stages))
+	return stages
+}
+
+// instanceName returns the instance name from its metadata, or its ID if it has none
+func instanceName(instance *store.InstanceState) string {
+	if name, ok := instance.Registration.Metadata["name"]; ok && name != "" {
+		return name
+	}
+	return instance.InstanceID
+}
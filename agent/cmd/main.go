@@ -9,7 +9,9 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/hashicorp/go-hclog"
 	"github.com/scttfrdmn/snoozebot/agent/api"
+	"github.com/scttfrdmn/snoozebot/agent/journal"
 	"github.com/scttfrdmn/snoozebot/agent/store"
 )
 
@@ -19,6 +21,9 @@ func main() {
 	pluginsDir := flag.String("plugins-dir", "/etc/snoozebot/plugins", "Directory containing plugins")
 	configDir := flag.String("config-dir", "/etc/snoozebot/config", "Directory containing configuration files")
 	enableAuth := flag.Bool("enable-auth", false, "Enable plugin authentication")
+	storeFile := flag.String("store-file", "", fmt.Sprintf("File keeping instance state across restarts, such as %s (default: memory only)", store.DefaultStoreFile))
+	journalFile := flag.String("journal-file", "", fmt.Sprintf("File keeping the history of instances, such as %s (default: memory only)", journal.DefaultJournalFile))
+	journalRetention := flag.Duration("journal-retention", journal.DefaultRetention, "How long to keep events in the journal file (0 to keep them forever)")
 	flag.Parse()
 
 	fmt.Println("Starting Snoozebot Agent v0.1.0")
@@ -26,13 +31,48 @@ func main() {
 	fmt.Printf("Plugins directory: %s\n", *pluginsDir)
 	fmt.Printf("Config directory: %s\n", *configDir)
 	fmt.Printf("Authentication: %v\n", *enableAuth)
+	if *storeFile != "" {
+		fmt.Printf("Store file: %s\n", *storeFile)
+	} else {
+		fmt.Println("Store file: none, instance state is lost when the agent exits")
+	}
+	if *journalFile != "" {
+		fmt.Printf("Journal file: %s (retention %s)\n", *journalFile, *journalRetention)
+	} else {
+		fmt.Println("Journal file: none, history is lost when the agent exits")
+	}
 
 	// Create a context that can be cancelled
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// Create store for managing instance state
-	instanceStore := store.NewMemoryStore()
+	var instanceStore store.Store = store.NewMemoryStore()
+	if *storeFile != "" {
+		fileStore, err := store.NewFileStore(*storeFile)
+		if err != nil {
+			fmt.Printf("Error opening store: %v\n", err)
+			return
+		}
+		defer func() {
+			if err := fileStore.Flush(); err != nil {
+				fmt.Printf("Error saving store: %v\n", err)
+			}
+		}()
+		instanceStore = fileStore
+	}
+
+	// Record the history of instances as the store changes
+	var instanceJournal journal.Journal = journal.NewMemoryJournal()
+	if *journalFile != "" {
+		fileJournal, err := journal.NewFileJournal(*journalFile, *journalRetention)
+		if err != nil {
+			fmt.Printf("Error opening journal: %v\n", err)
+			return
+		}
+		instanceJournal = fileJournal
+	}
+	instanceStore = journal.NewStore(instanceStore, instanceJournal, hclog.Default().Named("journal"))
 
 	// Ensure config directory exists
 	if err := os.MkdirAll(*configDir, 0755); err != nil {
@@ -42,6 +82,7 @@ func main() {
 
 	// Create API server
 	apiServer := api.NewServer(instanceStore, *pluginsDir, *configDir)
+	apiServer.SetJournal(instanceJournal)
 
 	// Enable authentication if requested
 	if apiServer.AuthenticationManager() != nil && *enableAuth {
@@ -49,6 +90,9 @@ func main() {
 		fmt.Println("Plugin authentication enabled")
 	}
 	
+	// Carry out scheduled actions
+	go apiServer.Scheduler().Run(ctx)
+
 	// Discover and initialize plugins
 	go func() {
 		if err := apiServer.DiscoverAndInitPlugins(ctx); err != nil {
@@ -0,0 +1,22 @@
+package idle
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
+	"github.com/scttfrdmn/snoozebot/pkg/schedule"
+)
+
+// SchedulePolicy returns the schedule's idle policy in effect for an
+// instance. A schedule in the instance's registration metadata overrides
+// the agent schedule.
+func SchedulePolicy(agentSchedule *schedule.Schedule, registration protocol.InstanceRegistration, now time.Time) (schedule.Policy, error) {
+	instanceSchedule, err := schedule.FromMetadata(registration.Metadata)
+	if err != nil {
+		// Fall back to the agent schedule rather than stopping unexpectedly
+		return agentSchedule.At(now), fmt.Errorf("invalid schedule for instance %s: %w", registration.InstanceID, err)
+	}
+
+	return instanceSchedule.Override(agentSchedule).At(now), nil
+}
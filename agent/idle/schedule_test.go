@@ -0,0 +1,57 @@
+package idle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
+	"github.com/scttfrdmn/snoozebot/pkg/schedule"
+)
+
+// TestSchedulePolicy tests that instance schedules override the agent schedule
+func TestSchedulePolicy(t *testing.T) {
+	agentSchedule, err := schedule.Parse("timezone UTC; daily 22:00-07:00 naptime=10m")
+	if err != nil {
+		t.Fatalf("Failed to parse schedule: %v", err)
+	}
+
+	registration := protocol.InstanceRegistration{
+		InstanceID: "i-1234",
+		NapTime:    30 * time.Minute,
+		Metadata: map[string]string{
+			schedule.MetadataKey: "timezone UTC; weekdays 09:00-18:00 never-stop",
+		},
+	}
+
+	// Wednesday at noon, the instance's working hours
+	noon := time.Date(2025, 7, 2, 12, 0, 0, 0, time.UTC)
+	policy, err := SchedulePolicy(agentSchedule, registration, noon)
+	if err != nil {
+		t.Fatalf("Failed to get policy: %v", err)
+	}
+	if !policy.NeverStop {
+		t.Errorf("Expected the instance schedule to forbid stopping")
+	}
+
+	// Overnight the agent schedule applies
+	policy, _ = SchedulePolicy(agentSchedule, registration, noon.Add(11*time.Hour))
+	if policy.NapTimeOr(registration.NapTime) != 10*time.Minute {
+		t.Errorf("Expected the overnight naptime, got %s", policy.NapTimeOr(registration.NapTime))
+	}
+
+	// In the evening the registered naptime applies
+	policy, _ = SchedulePolicy(agentSchedule, registration, noon.Add(7*time.Hour))
+	if policy.NapTimeOr(registration.NapTime) != 30*time.Minute {
+		t.Errorf("Expected the registered naptime, got %s", policy.NapTimeOr(registration.NapTime))
+	}
+
+	// An invalid instance schedule falls back to the agent schedule
+	registration.Metadata[schedule.MetadataKey] = "someday never-stop"
+	policy, err = SchedulePolicy(agentSchedule, registration, noon.Add(11*time.Hour))
+	if err == nil {
+		t.Errorf("Expected an error for an invalid instance schedule")
+	}
+	if policy.NapTime != 10*time.Minute {
+		t.Errorf("Expected the agent schedule, got %+v", policy)
+	}
+}
@@ -0,0 +1,187 @@
+// Package idle decides what the agent does about an idle instance. Each
+// idle notification advances the instance through its idle stages, within
+// the limits of its schedule and idle policy, and a stage that stops the
+// instance schedules the stop. Activity cancels the stops added by stages
+// and starts again from the first stage. The stages reached are kept in the
+// instance's state, so a persistent store keeps them across restarts of the
+// agent.
+package idle
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/scttfrdmn/snoozebot/agent/policy"
+	"github.com/scttfrdmn/snoozebot/agent/store"
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
+	"github.com/scttfrdmn/snoozebot/pkg/notification"
+	"github.com/scttfrdmn/snoozebot/pkg/schedule"
+)
+
+// StageReason marks scheduled actions added by idle stages so they can be
+// cancelled when the instance becomes active
+const StageReason = "Idle stage"
+
+// advance records the stages an instance has newly reached in its state. A
+// new idle period starts again from the first stage.
+func advance(instance *store.InstanceState, idleSince time.Time, idleDuration time.Duration, stages []protocol.IdleStage) []protocol.IdleStage {
+	if !instance.StagesIdleSince.Equal(idleSince) {
+		instance.StagesIdleSince = idleSince
+		instance.StagesReached = 0
+	}
+
+	reached := protocol.ReachedStages(stages, instance.StagesReached, idleDuration)
+	instance.StagesReached += len(reached)
+
+	return reached
+}
+
+// Stages returns the stages for an instance. Stages in the registration
+// metadata win over the agent's stages, and without either the instance is
+// stopped at its naptime.
+func Stages(agentStages []protocol.IdleStage, registration protocol.InstanceRegistration, napTime time.Duration) ([]protocol.IdleStage, error) {
+	stages, err := protocol.StagesFromMetadata(registration.Metadata)
+	if err != nil {
+		err = fmt.Errorf("invalid stages for instance %s: %w", registration.InstanceID, err)
+	}
+	if len(stages) > 0 {
+		return stages, nil
+	}
+	if len(agentStages) > 0 {
+		return agentStages, err
+	}
+
+	return []protocol.IdleStage{{After: napTime, Action: protocol.StageStop}}, err
+}
+
+// Decision is the agent's response to an idle notification
+type Decision struct {
+	// Action is the response action: wait, or the last stage reached
+	Action string
+	// Reason explains the decision
+	Reason string
+	// Reached are the stages reached by this notification
+	Reached []protocol.IdleStage
+	// ScheduledAction stops the instance if a stop stage was reached
+	ScheduledAction *protocol.ScheduledAction
+	// Policy is the idle policy that matched the instance, if any
+	Policy string
+	// Trace explains how the policy was chosen
+	Trace []string
+}
+
+// Decide advances an idle instance through its stages, recording the stages
+// reached in the instance. It is called from a store update so the progress
+// and any scheduled stop are stored together. The instance's idle policy
+// replaces the registered naptime and the action of the stages that stop it,
+// and the schedule's naptime replaces both. While the schedule forbids
+// stopping no stage is reached.
+func Decide(agentSchedule *schedule.Schedule, agentStages []protocol.IdleStage, policies *policy.Engine,
+	instance *store.InstanceState, idleSince time.Time, idleDuration time.Duration, now time.Time) (Decision, error) {
+
+	evaluation := policies.Evaluate(instance.Registration)
+	matched := evaluation.Policy
+	var policyName string
+	if matched != nil {
+		policyName = matched.Name
+	}
+
+	schedulePolicy, err := SchedulePolicy(agentSchedule, instance.Registration, now)
+	if schedulePolicy.NeverStop {
+		return Decision{
+			Action: "wait",
+			Reason: fmt.Sprintf("Instance has been idle for %s, but the schedule does not allow stopping now (%s)",
+				idleDuration, schedulePolicy.Rule),
+			Policy: policyName,
+			Trace:  evaluation.Trace,
+		}, err
+	}
+
+	napTime := schedulePolicy.NapTimeOr(matched.NapTimeOr(instance.Registration.NapTime))
+	stages, stagesErr := Stages(agentStages, instance.Registration, napTime)
+	if err == nil {
+		err = stagesErr
+	}
+	stages = matched.Stages(stages)
+
+	reached := advance(instance, idleSince, idleDuration, stages)
+	if len(reached) == 0 {
+		reason := fmt.Sprintf("Instance has been idle for %s, all stages reached", idleDuration)
+		if done := len(protocol.ReachedStages(stages, 0, idleDuration)); done < len(stages) {
+			reason = fmt.Sprintf("Instance has been idle for %s, next stage is %s", idleDuration, stages[done])
+		}
+		return Decision{Action: "wait", Reason: reason, Policy: policyName, Trace: evaluation.Trace}, err
+	}
+
+	last := reached[len(reached)-1]
+	decision := Decision{
+		Action:  last.Action,
+		Reached: reached,
+		Reason:  fmt.Sprintf("Instance has been idle for %s (stage: %s)", idleDuration, last),
+		Policy:  policyName,
+		Trace:   evaluation.Trace,
+	}
+
+	// Warn with a countdown to the next stop
+	if last.Action == protocol.StageWarn {
+		if next, ok := protocol.NextStop(stages, idleDuration); ok {
+			decision.Reason = fmt.Sprintf("Instance has been idle for %s and will %s in %s unless there is activity",
+				idleDuration, next.Action, next.After-idleDuration)
+		}
+	}
+
+	// Only the most severe stop is carried out, after the policy's grace
+	// period and once it has the approvals the policy requires
+	for i := len(reached) - 1; i >= 0; i-- {
+		if reached[i].Stops() {
+			decision.ScheduledAction = &protocol.ScheduledAction{
+				Action:        reached[i].Action,
+				ScheduledTime: now,
+				Reason:        StageReason,
+				ID:            protocol.NewActionID(),
+				Policy:        policyName,
+			}
+			if matched != nil {
+				decision.ScheduledAction.ScheduledTime = now.Add(matched.GracePeriod)
+				decision.ScheduledAction.RequiredApprovals = matched.RequiredApprovals
+				if matched.GracePeriod > 0 {
+					decision.Reason = fmt.Sprintf("Instance has been idle for %s and will %s in %s unless there is activity (policy: %s)",
+						idleDuration, reached[i].Action, matched.GracePeriod, matched.Name)
+				}
+				if matched.RequiredApprovals > 0 {
+					decision.Reason += fmt.Sprintf(", waiting for %d approval(s)", matched.RequiredApprovals)
+				}
+			}
+			break
+		}
+	}
+
+	return decision, err
+}
+
+// NotificationType returns the type of notification sent when a stage is reached
+func NotificationType(stage protocol.IdleStage) notification.NotificationType {
+	return notification.NotificationType(protocol.StageNotification(stage))
+}
+
+// CancelStageActions cancels every unfinished action added by idle stages to
+// an instance and starts its stages again, returning whether the instance
+// had reached any stage or had an action to cancel. It is called from a
+// store update so the actions are cancelled atomically.
+func CancelStageActions(instance *store.InstanceState) bool {
+	cancelled := instance.StagesReached > 0
+	instance.StagesReached = 0
+	instance.StagesIdleSince = time.Time{}
+
+	now := time.Now()
+	for i := range instance.ScheduledActions {
+		action := &instance.ScheduledActions[i]
+		if action.Reason == StageReason && !action.Finished() {
+			action.Status = protocol.ActionCancelled
+			action.CompletedAt = now
+			cancelled = true
+		}
+	}
+
+	return cancelled
+}
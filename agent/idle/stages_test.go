@@ -0,0 +1,330 @@
+package idle
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/scttfrdmn/snoozebot/agent/policy"
+	"github.com/scttfrdmn/snoozebot/agent/scheduler"
+	"github.com/scttfrdmn/snoozebot/agent/store"
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
+	"github.com/scttfrdmn/snoozebot/pkg/schedule"
+)
+
+// TestDecide tests that an idle instance escalates through its stages
+func TestDecide(t *testing.T) {
+	instanceStore := store.NewMemoryStore()
+	registration := protocol.InstanceRegistration{
+		InstanceID: "i-1234",
+		NapTime:    30 * time.Minute,
+		Metadata: map[string]string{
+			protocol.StagesMetadataKey: "30m notify; 45m warn; 1h stop; 8h stop",
+		},
+	}
+	if err := instanceStore.RegisterInstance(registration); err != nil {
+		t.Fatalf("Failed to register instance: %v", err)
+	}
+	instance, err := instanceStore.GetInstance("i-1234")
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+
+	now := time.Date(2025, 7, 2, 12, 0, 0, 0, time.UTC)
+	idleSince := now.Add(-time.Hour)
+
+	decision, err := Decide(nil, nil, nil, instance, idleSince, 20*time.Minute, now)
+	if err != nil {
+		t.Fatalf("Failed to decide: %v", err)
+	}
+	if decision.Action != "wait" || len(decision.Reached) != 0 {
+		t.Errorf("Expected to wait, got %+v", decision)
+	}
+
+	// Both stages reached since the last notification are returned
+	decision, _ = Decide(nil, nil, nil, instance, idleSince, 50*time.Minute, now)
+	if decision.Action != protocol.StageWarn || len(decision.Reached) != 2 {
+		t.Errorf("Expected notify and warn, got %+v", decision)
+	}
+	if decision.ScheduledAction != nil {
+		t.Errorf("Expected no scheduled action before the stop stage")
+	}
+
+	// Stages aren't reached twice
+	decision, _ = Decide(nil, nil, nil, instance, idleSince, 55*time.Minute, now)
+	if decision.Action != "wait" {
+		t.Errorf("Expected to wait, got %+v", decision)
+	}
+
+	decision, _ = Decide(nil, nil, nil, instance, idleSince, time.Hour, now)
+	if decision.ScheduledAction == nil || decision.ScheduledAction.Action != protocol.StageStop {
+		t.Fatalf("Expected a scheduled stop, got %+v", decision)
+	}
+	if NotificationType(decision.Reached[0]) != "scheduled_action" {
+		t.Errorf("Expected the stop to notify scheduled_action, got %s", NotificationType(decision.Reached[0]))
+	}
+	instanceStore.AddScheduledAction("i-1234", *decision.ScheduledAction)
+	instanceStore.AddScheduledAction("i-1234", protocol.ScheduledAction{Action: "start", Reason: "Maintenance"})
+
+	// Activity cancels the stop and starts again from the first stage
+	cancelled := false
+	instanceStore.Update("i-1234", func(instance *store.InstanceState) error {
+		cancelled = CancelStageActions(instance)
+		return nil
+	})
+	if !cancelled {
+		t.Errorf("Expected stages to be cancelled")
+	}
+	instance, _ = instanceStore.GetInstance("i-1234")
+	if len(instance.ScheduledActions) != 2 || instance.ScheduledActions[0].Status != protocol.ActionCancelled ||
+		instance.ScheduledActions[1].CurrentStatus() != protocol.ActionPending {
+		t.Errorf("Expected only the stop to be cancelled, got %+v", instance.ScheduledActions)
+	}
+	if CancelStageActions(instance) {
+		t.Errorf("Expected nothing to cancel")
+	}
+
+	decision, _ = Decide(nil, nil, nil, instance, now, 35*time.Minute, now)
+	if decision.Action != protocol.StageNotify {
+		t.Errorf("Expected the first stage again, got %+v", decision)
+	}
+}
+
+// TestDecide_Defaults tests the agent stages, naptime and schedule
+func TestDecide_Defaults(t *testing.T) {
+	instance := &store.InstanceState{
+		InstanceID: "i-1234",
+		Registration: protocol.InstanceRegistration{
+			InstanceID: "i-1234",
+			NapTime:    30 * time.Minute,
+		},
+	}
+	now := time.Date(2025, 7, 2, 12, 0, 0, 0, time.UTC)
+
+	// Without stages the instance stops at its naptime
+	decision, _ := Decide(nil, nil, nil, instance.Copy(), now, 30*time.Minute, now)
+	if decision.ScheduledAction == nil || decision.ScheduledAction.Action != protocol.StageStop {
+		t.Errorf("Expected a stop at the naptime, got %+v", decision)
+	}
+
+	agentStages, err := protocol.ParseStages("10m warn; 20m stop")
+	if err != nil {
+		t.Fatalf("Failed to parse stages: %v", err)
+	}
+	decision, _ = Decide(nil, agentStages, nil, instance.Copy(), now, 20*time.Minute, now)
+	if decision.ScheduledAction == nil || len(decision.Reached) != 2 || decision.Reached[0].Action != protocol.StageWarn {
+		t.Errorf("Expected the agent's warn and stop stages, got %+v", decision)
+	}
+
+	// The schedule holds back every stage
+	agentSchedule, err := schedule.Parse("timezone UTC; weekdays 09:00-18:00 never-stop")
+	if err != nil {
+		t.Fatalf("Failed to parse schedule: %v", err)
+	}
+	decision, _ = Decide(agentSchedule, agentStages, nil, instance.Copy(), now, 20*time.Minute, now)
+	if decision.Action != "wait" || len(decision.Reached) != 0 {
+		t.Errorf("Expected to wait during working hours, got %+v", decision)
+	}
+
+	// Invalid instance stages fall back to the agent's
+	instance.Registration.Metadata = map[string]string{protocol.StagesMetadataKey: "1h nap"}
+	decision, err = Decide(nil, agentStages, nil, instance.Copy(), now, 20*time.Minute, now)
+	if err == nil {
+		t.Errorf("Expected an error for invalid instance stages")
+	}
+	if decision.Action != protocol.StageStop || len(decision.Reached) != 2 {
+		t.Errorf("Expected the agent's stages, got %+v", decision)
+	}
+}
+
+// TestDecide_Policy tests that the matching policy changes the naptime and the stop
+func TestDecide_Policy(t *testing.T) {
+	policies, err := policy.New([]policy.Policy{
+		{Name: "shared", Match: policy.Match{Labels: map[string]string{"shared": "true"}}, Action: policy.ActionNotifyOnly},
+		{Name: "gpu", Match: policy.Match{Provider: "aws"}, NapTime: time.Hour, GracePeriod: 10 * time.Minute, Action: policy.ActionStop, RequiredApprovals: 2},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create policies: %v", err)
+	}
+
+	instance := &store.InstanceState{
+		InstanceID: "i-1234",
+		Registration: protocol.InstanceRegistration{
+			InstanceID: "i-1234",
+			Provider:   "aws",
+			NapTime:    30 * time.Minute,
+		},
+	}
+	now := time.Date(2025, 7, 2, 12, 0, 0, 0, time.UTC)
+
+	// The policy's naptime replaces the registered one
+	decision, _ := Decide(nil, nil, policies, instance, now, 30*time.Minute, now)
+	if decision.Action != "wait" || decision.Policy != "gpu" {
+		t.Errorf("Expected to wait for the policy naptime, got %+v", decision)
+	}
+	if len(decision.Trace) != 2 || decision.Trace[1] != "policy gpu: matched" {
+		t.Errorf("Expected the trace to explain the match, got %q", decision.Trace)
+	}
+
+	decision, _ = Decide(nil, nil, policies, instance, now, time.Hour, now)
+	action := decision.ScheduledAction
+	if action == nil || action.Action != protocol.StageStop || action.Policy != "gpu" {
+		t.Fatalf("Expected the policy to stop the instance, got %+v", decision)
+	}
+	if !action.ScheduledTime.Equal(now.Add(10*time.Minute)) || action.RequiredApprovals != 2 || action.Approved() {
+		t.Errorf("Expected the stop after the grace period and approvals, got %+v", action)
+	}
+
+	// Notify-only instances are never stopped
+	CancelStageActions(instance)
+	instance.Registration.Metadata = map[string]string{"shared": "true"}
+	decision, _ = Decide(nil, nil, policies, instance, now, 30*time.Minute, now)
+	if decision.ScheduledAction != nil || decision.Action != protocol.StageNotify || decision.Policy != "shared" {
+		t.Errorf("Expected only a notification, got %+v", decision)
+	}
+}
+
+// TestDecide_Restart tests that stage progress and cancelling stops survive
+// a restart of the agent
+func TestDecide_Restart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	instanceStore, err := store.NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Failed to create file store: %v", err)
+	}
+	registration := protocol.InstanceRegistration{
+		InstanceID: "i-1234",
+		Metadata:   map[string]string{protocol.StagesMetadataKey: "10m notify; 1h stop"},
+	}
+	if err := instanceStore.RegisterInstance(registration); err != nil {
+		t.Fatalf("Failed to register instance: %v", err)
+	}
+
+	now := time.Date(2025, 7, 2, 12, 0, 0, 0, time.UTC)
+	idleSince := now.Add(-time.Hour)
+	decide := func(s store.Store, idleDuration time.Duration) Decision {
+		t.Helper()
+		var decision Decision
+		err := s.Update("i-1234", func(instance *store.InstanceState) error {
+			decision, _ = Decide(nil, nil, nil, instance, idleSince, idleDuration, now)
+			if decision.ScheduledAction != nil {
+				instance.ScheduledActions = append(instance.ScheduledActions, *decision.ScheduledAction)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to update instance: %v", err)
+		}
+		return decision
+	}
+
+	if decision := decide(instanceStore, time.Hour); decision.ScheduledAction == nil || len(decision.Reached) != 2 {
+		t.Fatalf("Expected both stages and a stop, got %+v", decision)
+	}
+
+	// After a restart the stages already reached aren't reached again
+	reopened, err := store.NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen file store: %v", err)
+	}
+	if decision := decide(reopened, time.Hour+time.Minute); decision.Action != "wait" || decision.ScheduledAction != nil {
+		t.Errorf("Expected to wait after the restart, got %+v", decision)
+	}
+
+	// Activity after the restart cancels the stop
+	cancelled := false
+	reopened.Update("i-1234", func(instance *store.InstanceState) error {
+		cancelled = CancelStageActions(instance)
+		return nil
+	})
+	instance, _ := reopened.GetInstance("i-1234")
+	if !cancelled || len(instance.ScheduledActions) != 1 || instance.ScheduledActions[0].Status != protocol.ActionCancelled {
+		t.Errorf("Expected the stop to be cancelled, got %+v", instance.ScheduledActions)
+	}
+	if instance.StagesReached != 0 {
+		t.Errorf("Expected the stages to start again, got %d reached", instance.StagesReached)
+	}
+
+	// Stops are cancelled even without any recorded progress, such as
+	// stops scheduled before the progress was stored
+	instance.ScheduledActions = append(instance.ScheduledActions, protocol.ScheduledAction{Action: protocol.StageStop, Reason: StageReason})
+	if !CancelStageActions(instance) || instance.ScheduledActions[1].Status != protocol.ActionCancelled {
+		t.Errorf("Expected the stop to be cancelled, got %+v", instance.ScheduledActions)
+	}
+}
+
+// countingProvider counts the instances it stops
+type countingProvider struct {
+	stops int
+}
+
+func (p *countingProvider) StopInstance(ctx context.Context, instanceID string) error {
+	p.stops++
+	return nil
+}
+
+func (p *countingProvider) StartInstance(ctx context.Context, instanceID string) error {
+	return nil
+}
+
+// TestDecide_Veto tests that a stop vetoed by a pre-stop hook on the
+// instance is cancelled, and the stages start again with the next idle period
+func TestDecide_Veto(t *testing.T) {
+	instanceStore := store.NewMemoryStore()
+	registration := protocol.InstanceRegistration{
+		InstanceID: "i-1234",
+		Metadata:   map[string]string{protocol.StagesMetadataKey: "1h stop"},
+	}
+	if err := instanceStore.RegisterInstance(registration); err != nil {
+		t.Fatalf("Failed to register instance: %v", err)
+	}
+
+	provider := &countingProvider{}
+	s := scheduler.New(instanceStore, func(ctx context.Context, name string) (scheduler.CloudProvider, error) {
+		return provider, nil
+	}, scheduler.Config{}, nil)
+
+	now := time.Now()
+	decide := func(idleSince time.Time, idleDuration time.Duration) Decision {
+		t.Helper()
+		var decision Decision
+		err := instanceStore.Update("i-1234", func(instance *store.InstanceState) error {
+			decision, _ = Decide(nil, nil, nil, instance, idleSince, idleDuration, now)
+			if decision.ScheduledAction != nil {
+				instance.ScheduledActions = append(instance.ScheduledActions, *decision.ScheduledAction)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to update instance: %v", err)
+		}
+		return decision
+	}
+
+	if decision := decide(now.Add(-time.Hour), time.Hour); decision.ScheduledAction == nil {
+		t.Fatalf("Expected a stop, got %+v", decision)
+	}
+
+	// The scheduler asks the instance to prepare, and its hooks veto the stop
+	s.RunOnce(context.Background())
+	if err := s.Reply("i-1234", protocol.StopReplyVetoed, "epoch in progress"); err != nil {
+		t.Fatalf("Failed to record reply: %v", err)
+	}
+	s.RunOnce(context.Background())
+	s.Wait()
+
+	instance, _ := instanceStore.GetInstance("i-1234")
+	if provider.stops != 0 || instance.ScheduledActions[0].Status != protocol.ActionCancelled {
+		t.Fatalf("Expected the vetoed stop to be cancelled, got %d stops and %+v", provider.stops, instance.ScheduledActions)
+	}
+
+	// The monitor restarts its idle timer after a veto, so the stages are
+	// reached again
+	if decision := decide(now, 30*time.Minute); decision.ScheduledAction != nil {
+		t.Errorf("Expected to wait in the new idle period, got %+v", decision)
+	}
+	if decision := decide(now, time.Hour); decision.ScheduledAction == nil {
+		t.Errorf("Expected a new stop after the idle period, got %+v", decision)
+	}
+}
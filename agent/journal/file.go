@@ -0,0 +1,226 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultJournalFile is the suggested place to keep the history of instances
+const DefaultJournalFile = "/var/lib/snoozebot/agent-journal.jsonl"
+
+// DefaultRetention is how long the agent keeps events by default
+const DefaultRetention = 90 * 24 * time.Hour
+
+// compactInterval is how often old events are removed from a journal file
+const compactInterval = time.Hour
+
+// FileJournal is a Journal kept in a file with one JSON event per line.
+// Events are appended to the file, and are synced to disk before Append
+// returns. Events older than the retention are removed when the journal is
+// opened and then once every compactInterval, by rewriting the file.
+type FileJournal struct {
+	path      string
+	size      int64
+	retention time.Duration
+	compacted time.Time
+	pruned    bool
+	history   *history
+	mutex     sync.RWMutex
+}
+
+// NewFileJournal opens the journal in a file, creating it if it doesn't
+// exist. A partial event left at the end of the file by a crash is removed.
+// Events are kept for the retention, or forever if it is zero.
+func NewFileJournal(path string, retention time.Duration) (*FileJournal, error) {
+	j := &FileJournal{
+		path:      path,
+		retention: retention,
+		history:   newHistory(),
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal file: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for number := 1; ; number++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// Anything after the last newline is an event that was being
+			// written when the agent stopped
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read journal file: %w", err)
+		}
+
+		var event Event
+		if err := json.Unmarshal(line, &event); err != nil {
+			return nil, fmt.Errorf("invalid event on line %d of journal file %s: %w", number, path, err)
+		}
+		j.history.add([]Event{event})
+		j.size += int64(len(line))
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal file: %w", err)
+	}
+	if info.Size() > j.size {
+		if err := os.Truncate(path, j.size); err != nil {
+			return nil, fmt.Errorf("failed to remove partial event from journal file: %w", err)
+		}
+	}
+
+	if err := j.compact(time.Now()); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// Path returns the file the journal is kept in
+func (j *FileJournal) Path() string {
+	return j.path
+}
+
+// Append adds events to the end of the journal file
+func (j *FileJournal) Append(events ...Event) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	numbered := j.history.number(events, time.Now())
+
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	for _, event := range numbered {
+		if err := encoder.Encode(event); err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+	}
+
+	if err := j.write(buffer.Bytes()); err != nil {
+		return err
+	}
+
+	j.history.add(numbered)
+	j.size += int64(buffer.Len())
+
+	// The events are already written, so a failed compaction is left for
+	// the next one to retry
+	if now := time.Now(); now.Sub(j.compacted) >= compactInterval {
+		j.compact(now)
+	}
+	return nil
+}
+
+// compact removes the events older than the retention, and rewrites the
+// file without them. Events removed from memory stay in the file until a
+// rewrite succeeds. The caller must hold the mutex.
+func (j *FileJournal) compact(now time.Time) error {
+	if j.retention <= 0 {
+		return nil
+	}
+	j.compacted = now
+	if j.history.prune(now.Add(-j.retention)) {
+		j.pruned = true
+	}
+	if !j.pruned {
+		return nil
+	}
+
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	for _, event := range j.history.all() {
+		if err := encoder.Encode(event); err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+	}
+
+	if err := j.replace(buffer.Bytes()); err != nil {
+		return err
+	}
+	j.size = int64(buffer.Len())
+	j.pruned = false
+	return nil
+}
+
+// replace atomically replaces the journal file, so a crash leaves either
+// the old or the new contents
+func (j *FileJournal) replace(data []byte) error {
+	dir := filepath.Dir(j.path)
+	file, err := os.CreateTemp(dir, filepath.Base(j.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to compact journal file: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), j.path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to compact journal file: %w", err)
+	}
+
+	// Sync the directory so the rename itself survives a crash
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// write appends to the journal file. A failed write is cut off so the next
+// one starts on a new line.
+func (j *FileJournal) write(data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(j.path), 0755); err != nil {
+		return fmt.Errorf("failed to create journal directory: %w", err)
+	}
+
+	file, err := os.OpenFile(j.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open journal file: %w", err)
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Truncate(j.size)
+		file.Close()
+		return fmt.Errorf("failed to write journal file: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close journal file: %w", err)
+	}
+	return nil
+}
+
+// History returns a page of the events of an instance
+func (j *FileJournal) History(instanceID string, query Query) (*Page, error) {
+	j.mutex.RLock()
+	defer j.mutex.RUnlock()
+
+	return j.history.page(instanceID, query)
+}
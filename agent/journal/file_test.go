@@ -0,0 +1,181 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileJournal_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal", "journal.jsonl")
+
+	j, err := NewFileJournal(path, 0)
+	if err != nil {
+		t.Fatalf("Failed to create file journal: %v", err)
+	}
+	since := time.Date(2025, 7, 2, 12, 0, 0, 0, time.UTC)
+	err = j.Append(
+		Event{InstanceID: "i-1", Type: EventRegistered, State: "running"},
+		Event{InstanceID: "i-1", Type: EventIdleStarted, IdleSince: since},
+	)
+	if err != nil {
+		t.Fatalf("Failed to append events: %v", err)
+	}
+
+	reopened, err := NewFileJournal(path, 0)
+	if err != nil {
+		t.Fatalf("Failed to reopen file journal: %v", err)
+	}
+	page, err := reopened.History("i-1", Query{})
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	if len(page.Events) != 2 || page.Events[0].State != "running" || !page.Events[1].IdleSince.Equal(since) {
+		t.Fatalf("Expected the events to be read back, got %+v", page.Events)
+	}
+
+	// Sequence numbers continue after reopening
+	if err := reopened.Append(Event{InstanceID: "i-1", Type: EventIdleEnded}); err != nil {
+		t.Fatalf("Failed to append event: %v", err)
+	}
+	page, _ = reopened.History("i-1", Query{})
+	if len(page.Events) != 3 || page.Events[2].Sequence != 3 {
+		t.Errorf("Expected the third event to be numbered 3, got %+v", page.Events)
+	}
+}
+
+func TestFileJournal_CrashSafety(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "journal.jsonl")
+
+	j, err := NewFileJournal(path, 0)
+	if err != nil {
+		t.Fatalf("Failed to create file journal: %v", err)
+	}
+	if err := j.Append(Event{InstanceID: "i-1", Type: EventRegistered}); err != nil {
+		t.Fatalf("Failed to append event: %v", err)
+	}
+
+	// An event cut off by a crash is removed
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open journal file: %v", err)
+	}
+	file.WriteString(`{"sequence":2,"instance_id":"i-1","ty`)
+	file.Close()
+
+	j, err = NewFileJournal(path, 0)
+	if err != nil {
+		t.Fatalf("Failed to open journal with a partial event: %v", err)
+	}
+	if err := j.Append(Event{InstanceID: "i-1", Type: EventUnregistered}); err != nil {
+		t.Fatalf("Failed to append event: %v", err)
+	}
+
+	j, err = NewFileJournal(path, 0)
+	if err != nil {
+		t.Fatalf("Failed to reopen journal: %v", err)
+	}
+	page, _ := j.History("i-1", Query{})
+	if len(page.Events) != 2 || page.Events[1].Type != EventUnregistered || page.Events[1].Sequence != 2 {
+		t.Errorf("Expected the partial event to be replaced, got %+v", page.Events)
+	}
+
+	// A failed write leaves the journal unchanged
+	j.path = dir
+	if err := j.Append(Event{InstanceID: "i-1", Type: EventRegistered}); err == nil {
+		t.Errorf("Expected an error writing to a directory")
+	}
+	if page, _ := j.History("i-1", Query{}); len(page.Events) != 2 {
+		t.Errorf("Expected the failed event not to be recorded, got %+v", page.Events)
+	}
+
+	// Corrupt events before the end are an error
+	data, _ := os.ReadFile(path)
+	corrupt := filepath.Join(dir, "corrupt.jsonl")
+	os.WriteFile(corrupt, append([]byte("not json\n"), data...), 0644)
+	if _, err := NewFileJournal(corrupt, 0); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("Expected an error for the corrupt line, got %v", err)
+	}
+}
+
+func TestFileJournal_Retention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	now := time.Now()
+
+	j, err := NewFileJournal(path, 0)
+	if err != nil {
+		t.Fatalf("Failed to create file journal: %v", err)
+	}
+	err = j.Append(
+		Event{InstanceID: "i-1", Type: EventRegistered, Time: now.Add(-72 * time.Hour)},
+		Event{InstanceID: "i-2", Type: EventRegistered, Time: now.Add(-48 * time.Hour)},
+		Event{InstanceID: "i-1", Type: EventIdleStarted, Time: now.Add(-time.Hour)},
+	)
+	if err != nil {
+		t.Fatalf("Failed to append events: %v", err)
+	}
+	before, _ := os.Stat(path)
+
+	// Opening with a retention removes the older events from the file
+	j, err = NewFileJournal(path, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to reopen file journal: %v", err)
+	}
+	if page, _ := j.History("i-1", Query{}); len(page.Events) != 1 || page.Events[0].Type != EventIdleStarted {
+		t.Errorf("Expected only the recent event of i-1, got %+v", page.Events)
+	}
+	if page, _ := j.History("i-2", Query{}); len(page.Events) != 0 {
+		t.Errorf("Expected no events for i-2, got %+v", page.Events)
+	}
+	if after, _ := os.Stat(path); after.Size() >= before.Size() {
+		t.Errorf("Expected the file to shrink from %d bytes, got %d", before.Size(), after.Size())
+	}
+
+	// Sequence numbers carry on after compacting
+	if err := j.Append(Event{InstanceID: "i-1", Type: EventIdleEnded, Time: now}); err != nil {
+		t.Fatalf("Failed to append event: %v", err)
+	}
+	j, err = NewFileJournal(path, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to reopen file journal: %v", err)
+	}
+	page, _ := j.History("i-1", Query{})
+	if len(page.Events) != 2 || page.Events[1].Sequence != 4 {
+		t.Errorf("Expected the new event to be numbered 4, got %+v", page.Events)
+	}
+
+	// The newest event is kept even once it is old, so the numbering
+	// survives a journal with only old events
+	if err := j.Append(Event{InstanceID: "i-3", Type: EventRegistered, Time: now.Add(-72 * time.Hour)}); err != nil {
+		t.Fatalf("Failed to append event: %v", err)
+	}
+	j, err = NewFileJournal(path, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to reopen file journal: %v", err)
+	}
+	if err := j.Append(Event{InstanceID: "i-3", Type: EventUnregistered}); err != nil {
+		t.Fatalf("Failed to append event: %v", err)
+	}
+	if page, _ := j.History("i-3", Query{}); len(page.Events) != 2 || page.Events[1].Sequence != 6 {
+		t.Errorf("Expected the numbering to carry on, got %+v", page.Events)
+	}
+	if page, _ := j.History("i-1", Query{}); len(page.Events) != 1 || page.Events[0].Type != EventIdleEnded {
+		t.Errorf("Expected only the recent event of i-1, got %+v", page.Events)
+	}
+
+	// Appending compacts the journal once the interval has passed
+	j.compacted = now.Add(-compactInterval)
+	if err := j.Append(Event{InstanceID: "i-3", Type: EventRegistered}); err != nil {
+		t.Fatalf("Failed to append event: %v", err)
+	}
+	j, err = NewFileJournal(path, 0)
+	if err != nil {
+		t.Fatalf("Failed to reopen file journal: %v", err)
+	}
+	if page, _ := j.History("i-3", Query{}); len(page.Events) != 2 || page.Events[0].Sequence != 6 {
+		t.Errorf("Expected the old event of i-3 to be removed from the file, got %+v", page.Events)
+	}
+}
@@ -0,0 +1,282 @@
+// Package journal keeps an append-only history of the events in the life of
+// each instance: registrations, idle periods, scheduled actions and their
+// outcomes, and state changes.
+package journal
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Page sizes for History
+const (
+	// DefaultPageSize is the page size used when a query doesn't set one
+	DefaultPageSize = 100
+
+	// MaxPageSize is the largest page returned
+	MaxPageSize = 1000
+)
+
+// ErrInvalidPageToken is returned for page tokens not returned by History
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// EventType is the kind of an event
+type EventType string
+
+// Event types
+const (
+	EventRegistered      EventType = "registered"
+	EventUnregistered    EventType = "unregistered"
+	EventIdleStarted     EventType = "idle_started"
+	EventIdleEnded       EventType = "idle_ended"
+	EventActionScheduled EventType = "action_scheduled"
+	EventActionApproved  EventType = "action_approved"
+	EventActionStarted   EventType = "action_started"
+	EventActionExecuted  EventType = "action_executed"
+	EventActionRetrying  EventType = "action_retrying"
+	EventActionFailed    EventType = "action_failed"
+	EventActionCancelled EventType = "action_cancelled"
+	EventStateChanged    EventType = "state_changed"
+)
+
+// Event is an entry in the history of an instance. Only the fields that
+// apply to the event type are set.
+type Event struct {
+	// Sequence orders the events in a journal, and is set by Append
+	Sequence uint64 `json:"sequence"`
+
+	// Time is when the event happened
+	Time time.Time `json:"time"`
+
+	// InstanceID is the instance the event happened to
+	InstanceID string `json:"instance_id"`
+
+	// Type is the kind of event
+	Type EventType `json:"type"`
+
+	// PreviousState and State are the states of the instance before and
+	// after a state change
+	PreviousState string `json:"previous_state,omitempty"`
+	State         string `json:"state,omitempty"`
+
+	// IdleSince is when an idle period started
+	IdleSince time.Time `json:"idle_since,omitzero"`
+
+	// Action, ActionID and ScheduledTime identify a scheduled action
+	Action        string    `json:"action,omitempty"`
+	ActionID      string    `json:"action_id,omitempty"`
+	ScheduledTime time.Time `json:"scheduled_time,omitzero"`
+
+	// Reason is why an action was scheduled
+	Reason string `json:"reason,omitempty"`
+
+	// Policy is the idle policy that scheduled an action
+	Policy string `json:"policy,omitempty"`
+
+	// Approver is who approved an action
+	Approver string `json:"approver,omitempty"`
+
+	// Error is why an attempt at an action failed
+	Error string `json:"error,omitempty"`
+}
+
+// Query selects the events returned by History
+type Query struct {
+	// Since and Until limit the events to those at or after Since and
+	// before Until. Zero times don't limit the events.
+	Since time.Time
+	Until time.Time
+
+	// PageSize is the most events returned, DefaultPageSize if zero
+	PageSize int
+
+	// PageToken continues from the page that returned it
+	PageToken string
+}
+
+// ParseQuery reads a query from the since, until, page_size and page_token
+// parameters of a URL. Times are in RFC 3339 format.
+func ParseQuery(values url.Values) (Query, error) {
+	query := Query{PageToken: values.Get("page_token")}
+
+	for name, t := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		if value := values.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return query, fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*t = parsed
+		}
+	}
+
+	if value := values.Get("page_size"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 0 {
+			return query, fmt.Errorf("invalid page_size: %s", value)
+		}
+		query.PageSize = size
+	}
+
+	return query, nil
+}
+
+// Page is a page of the history of an instance, oldest event first
+type Page struct {
+	Events []Event `json:"events"`
+
+	// NextPageToken is set when there are more events to return
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+// Journal records and returns the history of instances
+type Journal interface {
+	// Append adds events to the journal, setting their sequence numbers,
+	// and the time of events without one
+	Append(events ...Event) error
+
+	// History returns a page of the events of an instance
+	History(instanceID string, query Query) (*Page, error)
+}
+
+// history holds the events of each instance, in the order they were added
+type history struct {
+	instances map[string][]Event
+	sequence  uint64
+}
+
+// prune removes the events from before cutoff, except the newest event of
+// the journal, so sequence numbers carry on from it. It returns whether
+// any events were removed.
+func (h *history) prune(cutoff time.Time) bool {
+	removed := false
+	for id, events := range h.instances {
+		kept := make([]Event, 0, len(events))
+		for _, event := range events {
+			if event.Time.Before(cutoff) && event.Sequence != h.sequence {
+				removed = true
+				continue
+			}
+			kept = append(kept, event)
+		}
+		if len(kept) == 0 {
+			delete(h.instances, id)
+		} else {
+			h.instances[id] = kept
+		}
+	}
+	return removed
+}
+
+// all returns every event in sequence order
+func (h *history) all() []Event {
+	var events []Event
+	for _, instanceEvents := range h.instances {
+		events = append(events, instanceEvents...)
+	}
+	sort.Slice(events, func(a, b int) bool {
+		return events[a].Sequence < events[b].Sequence
+	})
+	return events
+}
+
+func newHistory() *history {
+	return &history{instances: make(map[string][]Event)}
+}
+
+// number sets the sequence numbers, and the time of events without one
+func (h *history) number(events []Event, now time.Time) []Event {
+	numbered := make([]Event, len(events))
+	for i, event := range events {
+		event.Sequence = h.sequence + uint64(i) + 1
+		if event.Time.IsZero() {
+			event.Time = now
+		}
+		numbered[i] = event
+	}
+	return numbered
+}
+
+// add adds numbered events
+func (h *history) add(events []Event) {
+	for _, event := range events {
+		h.instances[event.InstanceID] = append(h.instances[event.InstanceID], event)
+		if event.Sequence > h.sequence {
+			h.sequence = event.Sequence
+		}
+	}
+}
+
+// page returns the events of an instance selected by a query
+func (h *history) page(instanceID string, query Query) (*Page, error) {
+	var after uint64
+	if query.PageToken != "" {
+		var err error
+		after, err = strconv.ParseUint(query.PageToken, 10, 64)
+		if err != nil {
+			return nil, ErrInvalidPageToken
+		}
+	}
+
+	size := query.PageSize
+	if size <= 0 {
+		size = DefaultPageSize
+	}
+	if size > MaxPageSize {
+		size = MaxPageSize
+	}
+
+	events := h.instances[instanceID]
+	start := sort.Search(len(events), func(i int) bool {
+		return events[i].Sequence > after
+	})
+
+	page := &Page{Events: make([]Event, 0)}
+	for _, event := range events[start:] {
+		if !query.Since.IsZero() && event.Time.Before(query.Since) {
+			continue
+		}
+		if !query.Until.IsZero() && !event.Time.Before(query.Until) {
+			continue
+		}
+		if len(page.Events) == size {
+			page.NextPageToken = strconv.FormatUint(page.Events[size-1].Sequence, 10)
+			break
+		}
+		page.Events = append(page.Events, event)
+	}
+
+	return page, nil
+}
+
+// MemoryJournal is an in-memory implementation of the Journal interface
+type MemoryJournal struct {
+	history *history
+	mutex   sync.RWMutex
+}
+
+// NewMemoryJournal creates a new in-memory journal
+func NewMemoryJournal() *MemoryJournal {
+	return &MemoryJournal{history: newHistory()}
+}
+
+// Append adds events to the journal
+func (j *MemoryJournal) Append(events ...Event) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.history.add(j.history.number(events, time.Now()))
+	return nil
+}
+
+// History returns a page of the events of an instance
+func (j *MemoryJournal) History(instanceID string, query Query) (*Page, error) {
+	j.mutex.RLock()
+	defer j.mutex.RUnlock()
+
+	return j.history.page(instanceID, query)
+}
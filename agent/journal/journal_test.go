@@ -0,0 +1,141 @@
+package journal
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testHistory checks appending to and paging through a journal
+func testHistory(t *testing.T, j Journal) {
+	start := time.Date(2025, 7, 2, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		err := j.Append(
+			Event{InstanceID: "i-1", Type: EventStateChanged, Time: start.Add(time.Duration(i) * time.Hour), State: fmt.Sprint(i)},
+			Event{InstanceID: "i-2", Type: EventStateChanged, Time: start.Add(time.Duration(i) * time.Hour)},
+		)
+		if err != nil {
+			t.Fatalf("Failed to append events: %v", err)
+		}
+	}
+
+	page, err := j.History("i-1", Query{})
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	if len(page.Events) != 5 || page.NextPageToken != "" {
+		t.Fatalf("Expected 5 events on one page, got %+v", page)
+	}
+	for i, event := range page.Events {
+		if event.State != fmt.Sprint(i) || event.InstanceID != "i-1" {
+			t.Errorf("Expected event %d of i-1, got %+v", i, event)
+		}
+		if i > 0 && event.Sequence <= page.Events[i-1].Sequence {
+			t.Errorf("Expected increasing sequence numbers, got %d after %d", event.Sequence, page.Events[i-1].Sequence)
+		}
+	}
+
+	// Pages continue where the last one stopped
+	var states []string
+	query := Query{PageSize: 2}
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatalf("Expected 3 pages, got more")
+		}
+		page, err := j.History("i-1", query)
+		if err != nil {
+			t.Fatalf("Failed to get history: %v", err)
+		}
+		for _, event := range page.Events {
+			states = append(states, event.State)
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		query.PageToken = page.NextPageToken
+	}
+	if fmt.Sprint(states) != "[0 1 2 3 4]" {
+		t.Errorf("Expected every event once, got %v", states)
+	}
+
+	// Since is inclusive and Until exclusive
+	page, err = j.History("i-1", Query{Since: start.Add(time.Hour), Until: start.Add(3 * time.Hour)})
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	if len(page.Events) != 2 || page.Events[0].State != "1" || page.Events[1].State != "2" {
+		t.Errorf("Expected events 1 and 2, got %+v", page.Events)
+	}
+
+	// A page that ends on the last matching event has no next page
+	page, err = j.History("i-1", Query{Since: start.Add(3 * time.Hour), PageSize: 2})
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	if len(page.Events) != 2 || page.NextPageToken != "" {
+		t.Errorf("Expected the last 2 events on one page, got %+v", page)
+	}
+
+	page, err = j.History("i-unknown", Query{})
+	if err != nil || len(page.Events) != 0 {
+		t.Errorf("Expected no events for an unknown instance, got %+v %v", page, err)
+	}
+
+	if _, err := j.History("i-1", Query{PageToken: "bogus"}); !errors.Is(err, ErrInvalidPageToken) {
+		t.Errorf("Expected ErrInvalidPageToken, got %v", err)
+	}
+}
+
+func TestMemoryJournal(t *testing.T) {
+	testHistory(t, NewMemoryJournal())
+}
+
+func TestFileJournal(t *testing.T) {
+	j, err := NewFileJournal(filepath.Join(t.TempDir(), "journal.jsonl"), 0)
+	if err != nil {
+		t.Fatalf("Failed to create file journal: %v", err)
+	}
+	testHistory(t, j)
+}
+
+func TestMemoryJournal_SetsTime(t *testing.T) {
+	j := NewMemoryJournal()
+	before := time.Now()
+	if err := j.Append(Event{InstanceID: "i-1", Type: EventRegistered}); err != nil {
+		t.Fatalf("Failed to append event: %v", err)
+	}
+
+	page, _ := j.History("i-1", Query{})
+	if len(page.Events) != 1 || page.Events[0].Time.Before(before) || page.Events[0].Sequence != 1 {
+		t.Errorf("Expected the event to get a time and sequence number, got %+v", page.Events)
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	values, err := url.ParseQuery("since=2025-07-02T12:00:00Z&until=2025-07-03T12:00:00Z&page_size=10&page_token=42")
+	if err != nil {
+		t.Fatalf("Failed to parse URL query: %v", err)
+	}
+	query, err := ParseQuery(values)
+	if err != nil {
+		t.Fatalf("Failed to parse query: %v", err)
+	}
+	since := time.Date(2025, 7, 2, 12, 0, 0, 0, time.UTC)
+	if !query.Since.Equal(since) || !query.Until.Equal(since.Add(24*time.Hour)) || query.PageSize != 10 || query.PageToken != "42" {
+		t.Errorf("Unexpected query: %+v", query)
+	}
+
+	if query, err := ParseQuery(url.Values{}); err != nil || query != (Query{}) {
+		t.Errorf("Expected an empty query, got %+v %v", query, err)
+	}
+
+	for _, raw := range []string{"since=yesterday", "until=2025-07-02", "page_size=-1", "page_size=ten"} {
+		values, _ := url.ParseQuery(raw)
+		if _, err := ParseQuery(values); err == nil {
+			t.Errorf("Expected an error for %s", raw)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package journal
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/scttfrdmn/snoozebot/agent/store"
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
+)
+
+// Store wraps an instance store and records the changes made through it in
+// a journal, so every part of the agent that changes an instance adds to
+// its history
+type Store struct {
+	store.Store
+	journal Journal
+	logger  hclog.Logger
+	now     func() time.Time
+
+	// mutex keeps the events in the order of the changes
+	mutex sync.Mutex
+}
+
+// NewStore creates a store that records the changes made to instanceStore
+// in journal
+func NewStore(instanceStore store.Store, journal Journal, logger hclog.Logger) *Store {
+	if logger == nil {
+		logger = hclog.NewNullLogger()
+	}
+
+	return &Store{
+		Store:   instanceStore,
+		journal: journal,
+		logger:  logger,
+		now:     time.Now,
+	}
+}
+
+// Journal returns the journal the changes are recorded in
+func (s *Store) Journal() Journal {
+	return s.journal
+}
+
+// RegisterInstance registers a new instance
+func (s *Store) RegisterInstance(registration protocol.InstanceRegistration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.Store.RegisterInstance(registration); err != nil {
+		return err
+	}
+
+	event := Event{InstanceID: registration.InstanceID, Type: EventRegistered}
+	if instance, err := s.Store.GetInstance(registration.InstanceID); err == nil {
+		event.State = instance.State
+	}
+	s.record(event)
+	return nil
+}
+
+// UnregisterInstance unregisters an instance
+func (s *Store) UnregisterInstance(instanceID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, err := s.Store.GetInstance(instanceID)
+	registered := err == nil
+
+	if err := s.Store.UnregisterInstance(instanceID); err != nil {
+		return err
+	}
+
+	if registered {
+		s.record(Event{InstanceID: instanceID, Type: EventUnregistered})
+	}
+	return nil
+}
+
+// Update atomically applies a change to an instance
+func (s *Store) Update(instanceID string, update func(instance *store.InstanceState) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var events []Event
+	err := s.Store.Update(instanceID, func(instance *store.InstanceState) error {
+		before := instance.Copy()
+		if err := update(instance); err != nil {
+			return err
+		}
+		events = changes(before, instance)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.record(events...)
+	return nil
+}
+
+// CompareAndSwap stores a changed copy of an instance if it hasn't changed since the copy was read
+func (s *Store) CompareAndSwap(instance *store.InstanceState) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// The swap only succeeds if the instance is still at the revision read
+	// here, as every change goes through this store
+	before, err := s.Store.GetInstance(instance.InstanceID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Store.CompareAndSwap(instance); err != nil {
+		return err
+	}
+
+	s.record(changes(before, instance)...)
+	return nil
+}
+
+// UpdateInstanceState updates the state of an instance
+func (s *Store) UpdateInstanceState(instanceID string, state string) error {
+	return s.Update(instanceID, func(instance *store.InstanceState) error {
+		instance.State = state
+		return nil
+	})
+}
+
+// UpdateResourceUsage updates the resource usage for an instance
+func (s *Store) UpdateResourceUsage(instanceID string, usage map[string]float64) error {
+	return s.Update(instanceID, func(instance *store.InstanceState) error {
+		instance.ResourceUsage = usage
+		return nil
+	})
+}
+
+// UpdateIdleState updates the idle state of an instance
+func (s *Store) UpdateIdleState(instanceID string, isIdle bool, since time.Time, duration time.Duration) error {
+	return s.Update(instanceID, func(instance *store.InstanceState) error {
+		if isIdle {
+			instance.IdleSince = since
+			instance.IdleDuration = duration
+		} else {
+			instance.IdleSince = time.Time{}
+			instance.IdleDuration = 0
+		}
+		return nil
+	})
+}
+
+// UpdateLastHeartbeat updates the time of the last heartbeat from an instance
+func (s *Store) UpdateLastHeartbeat(instanceID string, t time.Time) error {
+	return s.Update(instanceID, func(instance *store.InstanceState) error {
+		instance.LastHeartbeat = t
+		return nil
+	})
+}
+
+// AddScheduledAction adds a scheduled action for an instance
+func (s *Store) AddScheduledAction(instanceID string, action protocol.ScheduledAction) error {
+	return s.Update(instanceID, func(instance *store.InstanceState) error {
+		instance.ScheduledActions = append(instance.ScheduledActions, action)
+		return nil
+	})
+}
+
+// RemoveScheduledAction removes a scheduled action for an instance
+func (s *Store) RemoveScheduledAction(instanceID string, actionIndex int) error {
+	return s.Update(instanceID, func(instance *store.InstanceState) error {
+		if actionIndex < 0 || actionIndex >= len(instance.ScheduledActions) {
+			return fmt.Errorf("invalid action index: %d", actionIndex)
+		}
+		instance.ScheduledActions = append(
+			instance.ScheduledActions[:actionIndex],
+			instance.ScheduledActions[actionIndex+1:]...,
+		)
+		return nil
+	})
+}
+
+// record appends events to the journal. The change has already been made,
+// so a failure is logged rather than returned.
+func (s *Store) record(events ...Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	now := s.now()
+	for i := range events {
+		events[i].Time = now
+	}
+
+	if err := s.journal.Append(events...); err != nil {
+		s.logger.Error("Failed to record instance history", "instance_id", events[0].InstanceID, "error", err)
+	}
+}
+
+// changes returns the events for a change to an instance: the end and start
+// of idle periods, then scheduled actions, their approvals and outcomes, then
+// a change of state
+func changes(before, after *store.InstanceState) []Event {
+	var events []Event
+	event := func(eventType EventType) Event {
+		return Event{InstanceID: after.InstanceID, Type: eventType}
+	}
+
+	if !before.IdleSince.Equal(after.IdleSince) {
+		if !before.IdleSince.IsZero() {
+			ended := event(EventIdleEnded)
+			ended.IdleSince = before.IdleSince
+			events = append(events, ended)
+		}
+		if !after.IdleSince.IsZero() {
+			started := event(EventIdleStarted)
+			started.IdleSince = after.IdleSince
+			events = append(events, started)
+		}
+	}
+
+	previous := make(map[string]protocol.ScheduledAction, len(before.ScheduledActions))
+	for _, action := range before.ScheduledActions {
+		if action.ID != "" {
+			previous[action.ID] = action
+		}
+	}
+
+	// Actions are followed by ID, so actions without one are recorded once
+	// the scheduler gives them an ID
+	for _, action := range after.ScheduledActions {
+		if action.ID == "" {
+			continue
+		}
+
+		actionEvent := func(eventType EventType) Event {
+			e := event(eventType)
+			e.Action = action.Action
+			e.ActionID = action.ID
+			e.ScheduledTime = action.ScheduledTime
+			e.Reason = action.Reason
+			e.Policy = action.Policy
+			return e
+		}
+
+		old, ok := previous[action.ID]
+		if !ok {
+			events = append(events, actionEvent(EventActionScheduled))
+			old = protocol.ScheduledAction{}
+		}
+
+		for _, approver := range action.Approvals[min(len(old.Approvals), len(action.Approvals)):] {
+			approved := actionEvent(EventActionApproved)
+			approved.Approver = approver
+			events = append(events, approved)
+		}
+
+		status := action.CurrentStatus()
+		if status == old.CurrentStatus() {
+			continue
+		}
+
+		switch status {
+		case protocol.ActionRunning:
+			events = append(events, actionEvent(EventActionStarted))
+		case protocol.ActionSucceeded:
+			events = append(events, actionEvent(EventActionExecuted))
+		case protocol.ActionPending:
+			if action.Error != "" {
+				retrying := actionEvent(EventActionRetrying)
+				retrying.Error = action.Error
+				events = append(events, retrying)
+			}
+		case protocol.ActionFailed:
+			failed := actionEvent(EventActionFailed)
+			failed.Error = action.Error
+			events = append(events, failed)
+		case protocol.ActionCancelled:
+			events = append(events, actionEvent(EventActionCancelled))
+		}
+	}
+
+	if before.State != after.State {
+		changed := event(EventStateChanged)
+		changed.PreviousState = before.State
+		changed.State = after.State
+		events = append(events, changed)
+	}
+
+	return events
+}
@@ -0,0 +1,193 @@
+package journal
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/scttfrdmn/snoozebot/agent/store"
+	"github.com/scttfrdmn/snoozebot/agent/store/storetest"
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
+)
+
+func TestStore_Contract(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return NewStore(store.NewMemoryStore(), NewMemoryJournal(), nil)
+	})
+}
+
+// types returns the types of the events recorded for an instance
+func types(t *testing.T, j Journal, instanceID string) []EventType {
+	t.Helper()
+	page, err := j.History(instanceID, Query{PageSize: MaxPageSize})
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	types := make([]EventType, len(page.Events))
+	for i, event := range page.Events {
+		types[i] = event.Type
+	}
+	return types
+}
+
+// expectTypes checks the types of the events recorded for an instance
+func expectTypes(t *testing.T, j Journal, instanceID string, expected ...EventType) {
+	t.Helper()
+	got := types(t, j, instanceID)
+	if len(got) != len(expected) {
+		t.Fatalf("Expected events %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("Expected events %v, got %v", expected, got)
+		}
+	}
+}
+
+func TestStore_RecordsChanges(t *testing.T) {
+	j := NewMemoryJournal()
+	s := NewStore(store.NewMemoryStore(), j, nil)
+	now := time.Date(2025, 7, 2, 22, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	if err := s.RegisterInstance(protocol.InstanceRegistration{InstanceID: "i-1"}); err != nil {
+		t.Fatalf("Failed to register instance: %v", err)
+	}
+	expectTypes(t, j, "i-1", EventRegistered)
+
+	// Heartbeats that change nothing of interest aren't recorded
+	if err := s.UpdateLastHeartbeat("i-1", now); err != nil {
+		t.Fatalf("Failed to update heartbeat: %v", err)
+	}
+	expectTypes(t, j, "i-1", EventRegistered)
+
+	// Becoming idle and having a stop scheduled in one update
+	since := now.Add(-time.Hour)
+	err := s.Update("i-1", func(instance *store.InstanceState) error {
+		instance.IdleSince = since
+		instance.State = "idle"
+		instance.ScheduledActions = append(instance.ScheduledActions, protocol.ScheduledAction{
+			ID:            "a-1",
+			Action:        "stop",
+			ScheduledTime: now,
+			Reason:        "Idle",
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to update instance: %v", err)
+	}
+	expectTypes(t, j, "i-1", EventRegistered, EventIdleStarted, EventActionScheduled, EventStateChanged)
+
+	// A failed attempt, a retry that succeeds and the resulting state
+	setAction := func(update func(action *protocol.ScheduledAction), state string) {
+		t.Helper()
+		err := s.Update("i-1", func(instance *store.InstanceState) error {
+			update(&instance.ScheduledActions[0])
+			if state != "" {
+				instance.State = state
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to update action: %v", err)
+		}
+	}
+	setAction(func(action *protocol.ScheduledAction) { action.Status = protocol.ActionRunning }, "")
+	setAction(func(action *protocol.ScheduledAction) {
+		action.Status = protocol.ActionPending
+		action.Error = "throttled"
+	}, "")
+	setAction(func(action *protocol.ScheduledAction) { action.Status = protocol.ActionRunning }, "")
+	setAction(func(action *protocol.ScheduledAction) {
+		action.Status = protocol.ActionSucceeded
+		action.Error = ""
+	}, "stopping")
+	expectTypes(t, j, "i-1",
+		EventRegistered, EventIdleStarted, EventActionScheduled, EventStateChanged,
+		EventActionStarted, EventActionRetrying, EventActionStarted, EventActionExecuted, EventStateChanged,
+	)
+
+	page, _ := j.History("i-1", Query{})
+	retrying, stopping := page.Events[5], page.Events[8]
+	if retrying.ActionID != "a-1" || retrying.Action != "stop" || retrying.Error != "throttled" || retrying.Reason != "Idle" {
+		t.Errorf("Expected the retry to name the action and error, got %+v", retrying)
+	}
+	if stopping.PreviousState != "idle" || stopping.State != "stopping" || !stopping.Time.Equal(now) {
+		t.Errorf("Expected a change from idle to stopping, got %+v", stopping)
+	}
+	if started := page.Events[1]; !started.IdleSince.Equal(since) {
+		t.Errorf("Expected the idle start to record when the idle period began, got %+v", started)
+	}
+
+	// Failed updates aren't recorded
+	err = s.Update("i-1", func(instance *store.InstanceState) error {
+		instance.State = "running"
+		return errors.New("rejected")
+	})
+	if err == nil {
+		t.Fatalf("Expected the update to fail")
+	}
+	if got := types(t, j, "i-1"); len(got) != 9 {
+		t.Errorf("Expected a failed update not to be recorded, got %v", got)
+	}
+
+	// Changes through CompareAndSwap are recorded
+	instance, _ := s.GetInstance("i-1")
+	instance.IdleSince = time.Time{}
+	instance.State = "running"
+	instance.ScheduledActions = append(instance.ScheduledActions, protocol.ScheduledAction{ID: "a-2", Action: "stop", Status: protocol.ActionCancelled})
+	if err := s.CompareAndSwap(instance); err != nil {
+		t.Fatalf("Failed to swap instance: %v", err)
+	}
+
+	if err := s.UnregisterInstance("i-1"); err != nil {
+		t.Fatalf("Failed to unregister instance: %v", err)
+	}
+	if err := s.UnregisterInstance("i-1"); err != nil {
+		t.Fatalf("Failed to unregister instance again: %v", err)
+	}
+
+	got := types(t, j, "i-1")[9:]
+	expected := []EventType{EventIdleEnded, EventActionScheduled, EventActionCancelled, EventStateChanged, EventUnregistered}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected events %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("Expected events %v, got %v", expected, got)
+		}
+	}
+}
+
+func TestStore_RecordsApprovals(t *testing.T) {
+	j := NewMemoryJournal()
+	s := NewStore(store.NewMemoryStore(), j, nil)
+
+	if err := s.RegisterInstance(protocol.InstanceRegistration{InstanceID: "i-1"}); err != nil {
+		t.Fatalf("Failed to register instance: %v", err)
+	}
+	action := protocol.ScheduledAction{ID: "a-1", Action: "stop", Policy: "gpu", RequiredApprovals: 2}
+	if err := s.AddScheduledAction("i-1", action); err != nil {
+		t.Fatalf("Failed to add action: %v", err)
+	}
+
+	for _, approver := range []string{"alice", "bob"} {
+		err := s.Update("i-1", func(instance *store.InstanceState) error {
+			instance.ScheduledActions[0].Approvals = append(instance.ScheduledActions[0].Approvals, approver)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to approve action: %v", err)
+		}
+	}
+	expectTypes(t, j, "i-1", EventRegistered, EventActionScheduled, EventActionApproved, EventActionApproved)
+
+	page, _ := j.History("i-1", Query{})
+	if scheduled := page.Events[1]; scheduled.Policy != "gpu" {
+		t.Errorf("Expected the action to name its policy, got %+v", scheduled)
+	}
+	if approved := page.Events[3]; approved.Approver != "bob" || approved.ActionID != "a-1" {
+		t.Errorf("Expected bob's approval of a-1, got %+v", approved)
+	}
+}
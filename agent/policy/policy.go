@@ -0,0 +1,261 @@
+// Package policy decides how the agent treats idle instances of different
+// kinds. Policies are read from YAML, and the first policy whose match
+// selects an instance applies to it:
+//
+//	policies:
+//	  - name: gpu-training
+//	    match:
+//	      provider: aws
+//	      region: us-*
+//	      instance_type: p3.*
+//	      labels:
+//	        team: ml
+//	    naptime: 2h
+//	    grace_period: 10m
+//	    action: hibernate
+//	    required_approvals: 1
+//	  - name: default
+//	    naptime: 30m
+//
+// Match fields are shell patterns, and an empty field matches anything.
+// Every label must be present in the instance's registration metadata. A
+// policy sets how long an instance must be idle before it is stopped, how
+// long the agent waits between deciding to stop it and doing so, whether it
+// is stopped, hibernated or only notified about, and how many people must
+// approve the stop first.
+package policy
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
+	"gopkg.in/yaml.v2"
+)
+
+// Actions a policy can take on an idle instance
+const (
+	// ActionStop stops the instance
+	ActionStop = "stop"
+	// ActionNotifyOnly notifies the owner without stopping the instance
+	ActionNotifyOnly = "notify-only"
+)
+
+// Match selects the instances a policy applies to
+type Match struct {
+	Provider     string            `yaml:"provider"`
+	Region       string            `yaml:"region"`
+	InstanceType string            `yaml:"instance_type"`
+	Labels       map[string]string `yaml:"labels"`
+}
+
+// Policy is the idle behavior for the instances it matches
+type Policy struct {
+	// Name identifies the policy in decisions and traces
+	Name string `yaml:"name"`
+
+	// Match selects the instances the policy applies to
+	Match Match `yaml:"match"`
+
+	// NapTime replaces the registered naptime if set
+	NapTime time.Duration `yaml:"naptime"`
+
+	// GracePeriod delays stopping after the instance's stop stage is reached
+	GracePeriod time.Duration `yaml:"grace_period"`
+
+	// Action replaces the action of the stages that stop the instance
+	Action string `yaml:"action"`
+
+	// RequiredApprovals is how many people must approve a stop
+	RequiredApprovals int `yaml:"required_approvals"`
+}
+
+// document is the YAML policy file
+type document struct {
+	Policies []Policy `yaml:"policies"`
+}
+
+// Engine chooses the policy for an instance
+type Engine struct {
+	policies []Policy
+}
+
+// Evaluation is the policy chosen for an instance and why
+type Evaluation struct {
+	// Policy is the policy that matched, nil if none did
+	Policy *Policy
+
+	// Trace explains why each policy did or didn't match
+	Trace []string
+}
+
+// New creates an engine from policies, checking them first
+func New(policies []Policy) (*Engine, error) {
+	names := make(map[string]bool, len(policies))
+	for i, p := range policies {
+		if p.Name == "" {
+			return nil, fmt.Errorf("policy %d has no name", i+1)
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("duplicate policy %s", p.Name)
+		}
+		names[p.Name] = true
+
+		if err := p.check(); err != nil {
+			return nil, fmt.Errorf("policy %s: %w", p.Name, err)
+		}
+	}
+
+	return &Engine{policies: policies}, nil
+}
+
+// Parse parses a YAML policy document
+func Parse(data []byte) (*Engine, error) {
+	var doc document
+	if err := yaml.UnmarshalStrict(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse policies: %w", err)
+	}
+	return New(doc.Policies)
+}
+
+// LoadFile loads policies from a YAML file
+func LoadFile(path string) (*Engine, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policies: %w", err)
+	}
+	return Parse(data)
+}
+
+// Policies returns the engine's policies in the order they are tried
+func (e *Engine) Policies() []Policy {
+	if e == nil {
+		return nil
+	}
+	return e.policies
+}
+
+// Evaluate chooses the policy for an instance. A nil engine has no policies.
+func (e *Engine) Evaluate(registration protocol.InstanceRegistration) Evaluation {
+	var evaluation Evaluation
+	for i := range e.Policies() {
+		p := &e.policies[i]
+		if mismatch := p.Match.mismatch(registration); mismatch != "" {
+			evaluation.Trace = append(evaluation.Trace, fmt.Sprintf("policy %s: %s", p.Name, mismatch))
+			continue
+		}
+
+		evaluation.Policy = p
+		evaluation.Trace = append(evaluation.Trace, fmt.Sprintf("policy %s: matched", p.Name))
+		return evaluation
+	}
+
+	evaluation.Trace = append(evaluation.Trace, "no policy matched")
+	return evaluation
+}
+
+// check checks a policy's patterns and settings
+func (p Policy) check() error {
+	patterns := map[string]string{
+		"provider":      p.Match.Provider,
+		"region":        p.Match.Region,
+		"instance_type": p.Match.InstanceType,
+	}
+	for key, pattern := range p.Match.Labels {
+		patterns["label "+key] = pattern
+	}
+	for field, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid %s pattern %q", field, pattern)
+		}
+	}
+
+	switch p.Action {
+	case "", ActionStop, ActionNotifyOnly:
+	case protocol.StageHibernate:
+		return fmt.Errorf("action %q isn't supported by the cloud providers yet", p.Action)
+	default:
+		return fmt.Errorf("unknown action %q (expected %s or %s)", p.Action, ActionStop, ActionNotifyOnly)
+	}
+
+	if p.NapTime < 0 || p.GracePeriod < 0 {
+		return fmt.Errorf("naptime and grace_period can't be negative")
+	}
+	if p.RequiredApprovals < 0 {
+		return fmt.Errorf("required_approvals can't be negative")
+	}
+
+	return nil
+}
+
+// mismatch returns why a registration doesn't match, or "" if it does
+func (m Match) mismatch(registration protocol.InstanceRegistration) string {
+	fields := []struct {
+		name, pattern, value string
+	}{
+		{"provider", m.Provider, registration.Provider},
+		{"region", m.Region, registration.Region},
+		{"instance_type", m.InstanceType, registration.InstanceType},
+	}
+	for _, field := range fields {
+		if !matches(field.pattern, field.value) {
+			return fmt.Sprintf("%s %q does not match %q", field.name, field.value, field.pattern)
+		}
+	}
+
+	// Check the labels in a stable order so traces don't change
+	keys := make([]string, 0, len(m.Labels))
+	for key := range m.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, ok := registration.Metadata[key]
+		if !ok {
+			return fmt.Sprintf("label %s is missing", key)
+		}
+		if !matches(m.Labels[key], value) {
+			return fmt.Sprintf("label %s=%q does not match %q", key, value, m.Labels[key])
+		}
+	}
+
+	return ""
+}
+
+// matches reports whether a value matches a pattern, where an empty pattern matches anything
+func matches(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, value)
+	return ok
+}
+
+// NapTimeOr returns the policy's nap time, or the default if there is no
+// policy or it doesn't set one
+func (p *Policy) NapTimeOr(napTime time.Duration) time.Duration {
+	if p != nil && p.NapTime > 0 {
+		return p.NapTime
+	}
+	return napTime
+}
+
+// Stages applies the policy's action to idle stages: notify-only turns the
+// stages that stop the instance into notifications
+func (p *Policy) Stages(stages []protocol.IdleStage) []protocol.IdleStage {
+	if p == nil || p.Action != ActionNotifyOnly {
+		return stages
+	}
+
+	applied := make([]protocol.IdleStage, len(stages))
+	for i, stage := range stages {
+		if stage.Stops() {
+			stage.Action = protocol.StageNotify
+		}
+		applied[i] = stage
+	}
+	return applied
+}
@@ -0,0 +1,168 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
+)
+
+const testPolicies = `
+policies:
+  - name: gpu-training
+    match:
+      provider: aws
+      region: us-*
+      instance_type: p3.*
+      labels:
+        team: ml
+    naptime: 2h
+    grace_period: 10m
+    action: stop
+    required_approvals: 1
+  - name: shared
+    match:
+      labels:
+        shared: "true"
+    action: notify-only
+  - name: default
+    naptime: 30m
+`
+
+func TestEngine_Evaluate(t *testing.T) {
+	engine, err := Parse([]byte(testPolicies))
+	if err != nil {
+		t.Fatalf("Failed to parse policies: %v", err)
+	}
+
+	gpu := protocol.InstanceRegistration{
+		Provider:     "aws",
+		Region:       "us-east-1",
+		InstanceType: "p3.2xlarge",
+		Metadata:     map[string]string{"team": "ml"},
+	}
+
+	tests := []struct {
+		name         string
+		registration func(r *protocol.InstanceRegistration)
+		policy       string
+		trace        string
+	}{
+		{"all fields match", func(r *protocol.InstanceRegistration) {}, "gpu-training", "policy gpu-training: matched"},
+		{"region", func(r *protocol.InstanceRegistration) { r.Region = "eu-west-1" }, "default", `policy gpu-training: region "eu-west-1" does not match "us-*"`},
+		{"instance type", func(r *protocol.InstanceRegistration) { r.InstanceType = "m5.large" }, "default", `policy gpu-training: instance_type "m5.large" does not match "p3.*"`},
+		{"missing label", func(r *protocol.InstanceRegistration) { r.Metadata = nil }, "default", "policy gpu-training: label team is missing"},
+		{"label", func(r *protocol.InstanceRegistration) {
+			r.Metadata = map[string]string{"team": "web", "shared": "true"}
+		}, "shared", `policy gpu-training: label team="web" does not match "ml"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registration := gpu
+			test.registration(&registration)
+
+			evaluation := engine.Evaluate(registration)
+			if evaluation.Policy == nil || evaluation.Policy.Name != test.policy {
+				t.Fatalf("Expected policy %s, got %+v", test.policy, evaluation)
+			}
+			if evaluation.Trace[0] != test.trace {
+				t.Errorf("Expected the trace to start with %q, got %q", test.trace, evaluation.Trace)
+			}
+			if last := evaluation.Trace[len(evaluation.Trace)-1]; last != "policy "+test.policy+": matched" {
+				t.Errorf("Expected the trace to end with the match, got %q", evaluation.Trace)
+			}
+		})
+	}
+
+	policy := engine.Evaluate(gpu).Policy
+	if policy.NapTime != 2*time.Hour || policy.GracePeriod != 10*time.Minute || policy.RequiredApprovals != 1 {
+		t.Errorf("Expected the durations and approvals to be parsed, got %+v", policy)
+	}
+
+	// Without a match the trace says so
+	engine, _ = New(engine.Policies()[:1])
+	evaluation := engine.Evaluate(protocol.InstanceRegistration{Provider: "gcp"})
+	if evaluation.Policy != nil || len(evaluation.Trace) != 2 || evaluation.Trace[1] != "no policy matched" {
+		t.Errorf("Expected no policy, got %+v", evaluation)
+	}
+
+	// A nil engine has no policies
+	var none *Engine
+	if evaluation := none.Evaluate(gpu); evaluation.Policy != nil {
+		t.Errorf("Expected no policy from a nil engine, got %+v", evaluation)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := map[string]string{
+		"no name":        "policies:\n  - naptime: 1h\n",
+		"duplicate":      "policies:\n  - name: a\n  - name: a\n",
+		"unknown action": "policies:\n  - name: a\n    action: reboot\n",
+		"hibernate":      "policies:\n  - name: a\n    action: hibernate\n",
+		"bad pattern":    "policies:\n  - name: a\n    match:\n      region: \"us-[\"\n",
+		"negative":       "policies:\n  - name: a\n    grace_period: -5m\n",
+		"approvals":      "policies:\n  - name: a\n    required_approvals: -1\n",
+		"unknown field":  "policies:\n  - name: a\n    nap_time: 1h\n",
+		"bad duration":   "policies:\n  - name: a\n    naptime: soon\n",
+	}
+
+	for name, doc := range tests {
+		if _, err := Parse([]byte(doc)); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
+
+func TestPolicy_Stages(t *testing.T) {
+	stages := []protocol.IdleStage{
+		{After: 30 * time.Minute, Action: protocol.StageWarn},
+		{After: time.Hour, Action: protocol.StageStop},
+	}
+
+	var none *Policy
+	if got := none.Stages(stages); got[1].Action != protocol.StageStop {
+		t.Errorf("Expected no policy to keep the stages, got %v", got)
+	}
+	if got := none.NapTimeOr(time.Hour); got != time.Hour {
+		t.Errorf("Expected no policy to keep the naptime, got %s", got)
+	}
+
+	stop := &Policy{Action: ActionStop, NapTime: 2 * time.Hour}
+	if got := stop.Stages(stages); got[0].Action != protocol.StageWarn || got[1].Action != protocol.StageStop {
+		t.Errorf("Expected the stop policy to keep the stages, got %v", got)
+	}
+	if got := stop.NapTimeOr(time.Hour); got != 2*time.Hour {
+		t.Errorf("Expected the policy naptime, got %s", got)
+	}
+
+	notify := &Policy{Action: ActionNotifyOnly}
+	if got := notify.Stages(stages); got[1].Action != protocol.StageNotify || got[1].Stops() {
+		t.Errorf("Expected the stop to become a notification, got %v", got)
+	}
+	if stages[1].Action != protocol.StageStop {
+		t.Errorf("Expected the original stages to be unchanged, got %v", stages)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policies.yaml")
+	if err := os.WriteFile(path, []byte(testPolicies), 0644); err != nil {
+		t.Fatalf("Failed to write policies: %v", err)
+	}
+
+	engine, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to load policies: %v", err)
+	}
+	if len(engine.Policies()) != 3 {
+		t.Errorf("Expected 3 policies, got %d", len(engine.Policies()))
+	}
+
+	if _, err := LoadFile(filepath.Join(t.TempDir(), "missing.yaml")); err == nil || !strings.Contains(err.Error(), "failed to read") {
+		t.Errorf("Expected an error for a missing file, got %v", err)
+	}
+}
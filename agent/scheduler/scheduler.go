@@ -0,0 +1,610 @@
+// Package scheduler carries out the actions scheduled for instances.
+//
+// An action is pending until its scheduled time, when the scheduler claims
+// it and marks it running while the cloud provider stops or starts the
+// instance. Before a stop the instance is asked to prepare, and the stop
+// waits until the instance replies that it is stopping, or for the prepare
+// timeout if it doesn't reply. A stop the instance vetoes is cancelled.
+// A running action either succeeds, or goes back to pending to be retried
+// with exponential backoff until it runs out of attempts and fails. Pending
+// and running actions can be cancelled. The status is kept with the action
+// in the store, so with a persistent store an action interrupted by a
+// restart of the agent is retried.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/scttfrdmn/snoozebot/agent/store"
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
+)
+
+// Defaults for the scheduler configuration
+const (
+	DefaultInterval    = 10 * time.Second
+	DefaultMaxAttempts = 5
+	DefaultBackoff     = 30 * time.Second
+	DefaultMaxBackoff  = 10 * time.Minute
+	DefaultTimeout     = 2 * time.Minute
+	DefaultRetention   = 24 * time.Hour
+
+	DefaultPrepareTimeout = 10 * time.Minute
+)
+
+// ErrActionNotFound is returned when cancelling or approving an action that
+// doesn't exist
+var ErrActionNotFound = errors.New("action not found")
+
+// ErrActionFinished is returned when cancelling or approving an action that
+// has already succeeded, failed or been cancelled
+var ErrActionFinished = errors.New("action already finished")
+
+// errUnchanged aborts a store update that has nothing to write
+var errUnchanged = errors.New("unchanged")
+
+// CloudProvider stops and starts instances
+type CloudProvider interface {
+	// StopInstance stops an instance
+	StopInstance(ctx context.Context, instanceID string) error
+
+	// StartInstance starts an instance
+	StartInstance(ctx context.Context, instanceID string) error
+}
+
+// ProviderLookup returns the cloud provider with the given name, such as aws
+type ProviderLookup func(ctx context.Context, provider string) (CloudProvider, error)
+
+// Notifier sends notifications about the outcome of actions. It is
+// implemented by notification.Manager.
+type Notifier interface {
+	// NotifyActionExecuted sends a notification that an action was carried out
+	NotifyActionExecuted(ctx context.Context, instanceID, instanceName, provider, region, action, result string) []error
+
+	// NotifyError sends a notification that an action failed
+	NotifyError(ctx context.Context, instanceID, instanceName, provider, region, errorType, errorMessage string) []error
+
+	// NotifyStopCancelled sends a notification that a stop was cancelled
+	NotifyStopCancelled(ctx context.Context, instanceID, instanceName, provider, region, reason string) []error
+}
+
+// HoldFunc reports whether a due action should wait, for example because the
+// idle schedule doesn't allow stopping now
+type HoldFunc func(instance *store.InstanceState, action protocol.ScheduledAction, now time.Time) bool
+
+// Config configures the scheduler. Zero values use the defaults.
+type Config struct {
+	// Interval is how often the store is checked for due actions
+	Interval time.Duration
+
+	// MaxAttempts is how many times an action is tried before it fails
+	MaxAttempts int
+
+	// Backoff is the wait before the first retry, doubling for each retry after it
+	Backoff time.Duration
+
+	// MaxBackoff caps the wait between retries
+	MaxBackoff time.Duration
+
+	// Timeout limits each call to the cloud provider
+	Timeout time.Duration
+
+	// Retention is how long finished actions are kept before they are removed
+	Retention time.Duration
+
+	// PrepareTimeout is how long a stop waits for the instance to reply
+	// before the instance is stopped anyway
+	PrepareTimeout time.Duration
+}
+
+// withDefaults returns the configuration with defaults applied
+func (c Config) withDefaults() Config {
+	if c.Interval <= 0 {
+		c.Interval = DefaultInterval
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = DefaultMaxAttempts
+	}
+	if c.Backoff <= 0 {
+		c.Backoff = DefaultBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = DefaultMaxBackoff
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultTimeout
+	}
+	if c.Retention <= 0 {
+		c.Retention = DefaultRetention
+	}
+	if c.PrepareTimeout <= 0 {
+		c.PrepareTimeout = DefaultPrepareTimeout
+	}
+	return c
+}
+
+// Scheduler carries out due actions with the instances' cloud providers
+type Scheduler struct {
+	store     store.Store
+	providers ProviderLookup
+	notifier  Notifier
+	hold      HoldFunc
+	config    Config
+	logger    hclog.Logger
+	now       func() time.Time
+
+	// running holds the cancel functions of the actions being carried out
+	running map[string]context.CancelFunc
+	mutex   sync.Mutex
+	wg      sync.WaitGroup
+}
+
+// New creates a scheduler for the actions in a store
+func New(instanceStore store.Store, providers ProviderLookup, config Config, logger hclog.Logger) *Scheduler {
+	if logger == nil {
+		logger = hclog.NewNullLogger()
+	}
+
+	return &Scheduler{
+		store:     instanceStore,
+		providers: providers,
+		config:    config.withDefaults(),
+		logger:    logger,
+		now:       time.Now,
+		running:   make(map[string]context.CancelFunc),
+	}
+}
+
+// SetNotifier sets where the outcome of actions is reported
+func (s *Scheduler) SetNotifier(notifier Notifier) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.notifier = notifier
+}
+
+// SetHold sets a function that holds back due actions
+func (s *Scheduler) SetHold(hold HoldFunc) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.hold = hold
+}
+
+// Run carries out due actions until the context is cancelled, then waits
+// for the actions being carried out to finish
+func (s *Scheduler) Run(ctx context.Context) {
+	s.recoverInterrupted()
+
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.RunOnce(ctx)
+
+		select {
+		case <-ctx.Done():
+			s.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce starts the actions that are due and removes finished actions
+// older than the retention. Due stops are first requested from the instance,
+// and started once it replies or the prepare timeout passes. It doesn't wait
+// for the actions to finish.
+func (s *Scheduler) RunOnce(ctx context.Context) {
+	instances, err := s.store.GetAllInstances()
+	if err != nil {
+		s.logger.Error("Failed to get instances", "error", err)
+		return
+	}
+
+	s.mutex.Lock()
+	hold := s.hold
+	s.mutex.Unlock()
+
+	now := s.now()
+	for instanceID := range instances {
+		var claimed []protocol.ScheduledAction
+		var instance *store.InstanceState
+
+		err := s.store.Update(instanceID, func(updated *store.InstanceState) error {
+			claimed = nil
+			changed := false
+
+			actions := make([]protocol.ScheduledAction, 0, len(updated.ScheduledActions))
+			for _, action := range updated.ScheduledActions {
+				if action.ID == "" {
+					action.ID = protocol.NewActionID()
+					changed = true
+				}
+				if action.Finished() && now.Sub(action.CompletedAt) > s.config.Retention {
+					changed = true
+					continue
+				}
+				if action.Due(now) && (hold == nil || !hold(updated, action, now)) {
+					switch {
+					case action.StopsInstance() && action.RequestedAt.IsZero():
+						// Ask the instance to prepare, it is sent the stop
+						// with its next heartbeat
+						action.RequestedAt = now
+						changed = true
+					case action.Requested() && now.Sub(action.RequestedAt) < s.config.PrepareTimeout:
+						// Wait for the instance to reply
+					default:
+						action.Status = protocol.ActionRunning
+						action.Attempts++
+						claimed = append(claimed, action)
+						changed = true
+					}
+				}
+				actions = append(actions, action)
+			}
+
+			if !changed {
+				return errUnchanged
+			}
+			updated.ScheduledActions = actions
+			instance = updated
+			return nil
+		})
+		if errors.Is(err, errUnchanged) || errors.Is(err, store.ErrInstanceNotFound) {
+			continue
+		}
+		if err != nil {
+			s.logger.Error("Failed to claim scheduled actions", "instance", instanceID, "error", err)
+			continue
+		}
+
+		for _, action := range claimed {
+			s.start(ctx, instance, action)
+		}
+	}
+}
+
+// Wait waits for the actions being carried out to finish
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+// Cancel cancels a pending or running action. A running action's call to
+// the cloud provider is cancelled, but may already have taken effect.
+func (s *Scheduler) Cancel(instanceID, actionID string) error {
+	now := s.now()
+	err := s.store.Update(instanceID, func(instance *store.InstanceState) error {
+		for i := range instance.ScheduledActions {
+			action := &instance.ScheduledActions[i]
+			if action.ID != actionID {
+				continue
+			}
+			if action.Finished() {
+				return fmt.Errorf("%w: %s is %s", ErrActionFinished, actionID, action.Status)
+			}
+			action.Status = protocol.ActionCancelled
+			action.CompletedAt = now
+			return nil
+		}
+		return fmt.Errorf("%w: %s", ErrActionNotFound, actionID)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	cancel, ok := s.running[actionID]
+	s.mutex.Unlock()
+	if ok {
+		cancel()
+	}
+
+	s.logger.Info("Cancelled scheduled action", "instance", instanceID, "action", actionID)
+	return nil
+}
+
+// Approve records an approval of an action. Actions needing approval aren't
+// carried out until enough different people have approved them.
+func (s *Scheduler) Approve(instanceID, actionID, approver string) error {
+	err := s.store.Update(instanceID, func(instance *store.InstanceState) error {
+		for i := range instance.ScheduledActions {
+			action := &instance.ScheduledActions[i]
+			if action.ID != actionID {
+				continue
+			}
+			if action.Finished() {
+				return fmt.Errorf("%w: %s is %s", ErrActionFinished, actionID, action.Status)
+			}
+			for _, existing := range action.Approvals {
+				if existing == approver {
+					return errUnchanged
+				}
+			}
+			action.Approvals = append(action.Approvals, approver)
+			return nil
+		}
+		return fmt.Errorf("%w: %s", ErrActionNotFound, actionID)
+	})
+	if errors.Is(err, errUnchanged) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.logger.Info("Approved scheduled action", "instance", instanceID, "action", actionID, "approver", approver)
+	return nil
+}
+
+// Reply records an instance's reply to the stops it was asked to prepare
+// for. A stop the instance is ready for is carried out on the next pass, and
+// a vetoed stop is cancelled. States that aren't replies are ignored.
+func (s *Scheduler) Reply(instanceID, state, reason string) error {
+	if state != protocol.StopReplyStopping && state != protocol.StopReplyVetoed {
+		return nil
+	}
+
+	now := s.now()
+	var instance *store.InstanceState
+	var vetoed []protocol.ScheduledAction
+
+	err := s.store.Update(instanceID, func(updated *store.InstanceState) error {
+		vetoed = nil
+		changed := false
+
+		for i := range updated.ScheduledActions {
+			action := &updated.ScheduledActions[i]
+			if !action.Requested() || action.CurrentStatus() != protocol.ActionPending {
+				continue
+			}
+			action.Reply = state
+			if state == protocol.StopReplyVetoed {
+				action.Status = protocol.ActionCancelled
+				action.CompletedAt = now
+				action.Error = vetoMessage(reason)
+				vetoed = append(vetoed, *action)
+			}
+			changed = true
+		}
+
+		if !changed {
+			return errUnchanged
+		}
+		instance = updated
+		return nil
+	})
+	if errors.Is(err, errUnchanged) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	notifier := s.notifier
+	s.mutex.Unlock()
+
+	for _, action := range vetoed {
+		s.logger.Info("Scheduled action vetoed by the instance", "instance", instanceID, "action", action.Action,
+			"id", action.ID, "reason", reason)
+		if notifier != nil {
+			notifier.NotifyStopCancelled(context.Background(), instanceID, instanceName(instance),
+				instance.Registration.Provider, instance.Registration.Region, action.Error)
+		}
+	}
+	return nil
+}
+
+// vetoMessage describes a stop vetoed by the instance
+func vetoMessage(reason string) string {
+	if reason == "" {
+		return "vetoed by the instance"
+	}
+	return "vetoed by the instance: " + reason
+}
+
+// recoverInterrupted returns actions left running by an earlier run of the
+// agent to pending, so they are tried again
+func (s *Scheduler) recoverInterrupted() {
+	instances, err := s.store.GetAllInstances()
+	if err != nil {
+		s.logger.Error("Failed to get instances", "error", err)
+		return
+	}
+
+	now := s.now()
+	for instanceID := range instances {
+		err := s.store.Update(instanceID, func(instance *store.InstanceState) error {
+			changed := false
+			for i := range instance.ScheduledActions {
+				action := &instance.ScheduledActions[i]
+				if action.Status != protocol.ActionRunning {
+					continue
+				}
+				s.mutex.Lock()
+				_, running := s.running[action.ID]
+				s.mutex.Unlock()
+				if running {
+					continue
+				}
+				action.Status = protocol.ActionPending
+				action.NextAttempt = now
+				action.Error = "interrupted by an agent restart"
+				changed = true
+			}
+			if !changed {
+				return errUnchanged
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errUnchanged) && !errors.Is(err, store.ErrInstanceNotFound) {
+			s.logger.Error("Failed to recover interrupted actions", "instance", instanceID, "error", err)
+		}
+	}
+}
+
+// start carries out a claimed action in the background
+func (s *Scheduler) start(ctx context.Context, instance *store.InstanceState, action protocol.ScheduledAction) {
+	actionCtx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+
+	s.mutex.Lock()
+	s.running[action.ID] = cancel
+	s.mutex.Unlock()
+
+	s.logger.Info("Carrying out scheduled action", "instance", instance.InstanceID, "action", action.Action,
+		"id", action.ID, "attempt", action.Attempts, "reply", action.Reply)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		err := s.execute(actionCtx, instance, action)
+		cancel()
+
+		s.mutex.Lock()
+		delete(s.running, action.ID)
+		s.mutex.Unlock()
+
+		s.finish(instance, action, err)
+	}()
+}
+
+// permanentError is an action error that retrying won't fix
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// execute calls the cloud provider to carry out an action
+func (s *Scheduler) execute(ctx context.Context, instance *store.InstanceState, action protocol.ScheduledAction) error {
+	var call func(CloudProvider) error
+	switch action.Action {
+	case protocol.StageStop:
+		call = func(p CloudProvider) error { return p.StopInstance(ctx, instance.InstanceID) }
+	case "start":
+		call = func(p CloudProvider) error { return p.StartInstance(ctx, instance.InstanceID) }
+	default:
+		return permanentError{fmt.Errorf("unsupported action %q", action.Action)}
+	}
+
+	provider, err := s.providers(ctx, instance.Registration.Provider)
+	if err != nil {
+		return fmt.Errorf("failed to get cloud provider %s: %w", instance.Registration.Provider, err)
+	}
+
+	return call(provider)
+}
+
+// finish records the outcome of an action and reports it
+func (s *Scheduler) finish(instance *store.InstanceState, action protocol.ScheduledAction, actionErr error) {
+	now := s.now()
+	var final protocol.ScheduledAction
+
+	err := s.store.Update(instance.InstanceID, func(updated *store.InstanceState) error {
+		for i := range updated.ScheduledActions {
+			current := &updated.ScheduledActions[i]
+			if current.ID != action.ID {
+				continue
+			}
+
+			var permanent permanentError
+			switch {
+			case actionErr == nil:
+				// The action took effect even if it was cancelled meanwhile
+				current.Status = protocol.ActionSucceeded
+				current.Error = ""
+				current.CompletedAt = now
+				updated.State = resultingState(action.Action)
+			case current.Status == protocol.ActionCancelled:
+				current.Error = actionErr.Error()
+			case errors.As(actionErr, &permanent) || current.Attempts >= s.config.MaxAttempts:
+				current.Status = protocol.ActionFailed
+				current.Error = actionErr.Error()
+				current.CompletedAt = now
+			default:
+				current.Status = protocol.ActionPending
+				current.Error = actionErr.Error()
+				current.NextAttempt = now.Add(s.backoff(current.Attempts))
+			}
+
+			final = *current
+			return nil
+		}
+
+		// The action was removed while it was being carried out
+		return errUnchanged
+	})
+	if errors.Is(err, errUnchanged) || errors.Is(err, store.ErrInstanceNotFound) {
+		s.logger.Warn("Scheduled action was removed while it ran", "instance", instance.InstanceID,
+			"id", action.ID, "error", actionErr)
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to record the outcome of a scheduled action", "instance", instance.InstanceID,
+			"id", action.ID, "error", err)
+		return
+	}
+
+	s.mutex.Lock()
+	notifier := s.notifier
+	s.mutex.Unlock()
+
+	name := instanceName(instance)
+	switch final.Status {
+	case protocol.ActionSucceeded:
+		s.logger.Info("Scheduled action succeeded", "instance", instance.InstanceID, "action", action.Action, "id", action.ID)
+		if notifier != nil {
+			notifier.NotifyActionExecuted(context.Background(), instance.InstanceID, name,
+				instance.Registration.Provider, instance.Registration.Region, action.Action, "succeeded")
+		}
+	case protocol.ActionFailed:
+		s.logger.Error("Scheduled action failed", "instance", instance.InstanceID, "action", action.Action,
+			"id", action.ID, "attempts", final.Attempts, "error", actionErr)
+		if notifier != nil {
+			notifier.NotifyError(context.Background(), instance.InstanceID, name,
+				instance.Registration.Provider, instance.Registration.Region, "action_failed",
+				fmt.Sprintf("Failed to %s instance after %d attempts: %v", action.Action, final.Attempts, actionErr))
+		}
+	case protocol.ActionPending:
+		s.logger.Warn("Scheduled action failed, retrying", "instance", instance.InstanceID, "action", action.Action,
+			"id", action.ID, "attempt", final.Attempts, "retry_at", final.NextAttempt, "error", actionErr)
+	case protocol.ActionCancelled:
+		s.logger.Info("Scheduled action cancelled", "instance", instance.InstanceID, "action", action.Action, "id", action.ID)
+	}
+}
+
+// backoff returns the wait before retrying after the given number of attempts
+func (s *Scheduler) backoff(attempts int) time.Duration {
+	wait := s.config.Backoff
+	for i := 1; i < attempts && wait < s.config.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > s.config.MaxBackoff {
+		wait = s.config.MaxBackoff
+	}
+	return wait
+}
+
+// resultingState returns the instance state after an action succeeds
+func resultingState(action string) string {
+	if action == "start" {
+		return "starting"
+	}
+	return "stopping"
+}
+
+// instanceName returns the instance name from its metadata, or its ID if it has none
+func instanceName(instance *store.InstanceState) string {
+	if name, ok := instance.Registration.Metadata["name"]; ok && name != "" {
+		return name
+	}
+	return instance.InstanceID
+}
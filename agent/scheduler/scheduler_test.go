@@ -0,0 +1,489 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scttfrdmn/snoozebot/agent/store"
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
+)
+
+// fakeProvider records the instances it stops and starts
+type fakeProvider struct {
+	mutex   sync.Mutex
+	calls   []string
+	err     error
+	block   bool
+	started chan struct{}
+}
+
+func (p *fakeProvider) call(ctx context.Context, call string) error {
+	p.mutex.Lock()
+	p.calls = append(p.calls, call)
+	err, block, started := p.err, p.block, p.started
+	p.mutex.Unlock()
+
+	if block {
+		started <- struct{}{}
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return err
+}
+
+func (p *fakeProvider) StopInstance(ctx context.Context, instanceID string) error {
+	return p.call(ctx, "stop "+instanceID)
+}
+
+func (p *fakeProvider) StartInstance(ctx context.Context, instanceID string) error {
+	return p.call(ctx, "start "+instanceID)
+}
+
+func (p *fakeProvider) callCount() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.calls)
+}
+
+// fakeNotifier records the notifications sent
+type fakeNotifier struct {
+	mutex     sync.Mutex
+	executed  []string
+	errors    []string
+	cancelled []string
+}
+
+func (n *fakeNotifier) NotifyActionExecuted(ctx context.Context, instanceID, instanceName, provider, region, action, result string) []error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.executed = append(n.executed, instanceName+" "+action+" "+result)
+	return nil
+}
+
+func (n *fakeNotifier) NotifyError(ctx context.Context, instanceID, instanceName, provider, region, errorType, errorMessage string) []error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.errors = append(n.errors, errorType+": "+errorMessage)
+	return nil
+}
+
+func (n *fakeNotifier) NotifyStopCancelled(ctx context.Context, instanceID, instanceName, provider, region, reason string) []error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.cancelled = append(n.cancelled, instanceName+": "+reason)
+	return nil
+}
+
+// fakeClock is a clock moved by the test
+type fakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newTestScheduler creates a scheduler with a registered instance using the fake provider
+func newTestScheduler(t *testing.T, config Config) (*Scheduler, store.Store, *fakeProvider, *fakeNotifier, *fakeClock) {
+	t.Helper()
+
+	instanceStore := store.NewMemoryStore()
+	err := instanceStore.RegisterInstance(protocol.InstanceRegistration{
+		InstanceID: "i-1234",
+		Provider:   "fake",
+		Metadata:   map[string]string{"name": "notebook"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register instance: %v", err)
+	}
+
+	provider := &fakeProvider{started: make(chan struct{}, 1)}
+	providers := func(ctx context.Context, name string) (CloudProvider, error) {
+		if name != "fake" {
+			return nil, errors.New("unknown provider")
+		}
+		return provider, nil
+	}
+
+	notifier := &fakeNotifier{}
+	clock := &fakeClock{now: time.Date(2025, 7, 2, 22, 0, 0, 0, time.UTC)}
+
+	s := New(instanceStore, providers, config, nil)
+	s.SetNotifier(notifier)
+	s.now = clock.Now
+
+	return s, instanceStore, provider, notifier, clock
+}
+
+// addAction adds an action and returns its ID
+func addAction(t *testing.T, instanceStore store.Store, action protocol.ScheduledAction) string {
+	t.Helper()
+	if action.ID == "" {
+		action.ID = protocol.NewActionID()
+	}
+	if err := instanceStore.AddScheduledAction("i-1234", action); err != nil {
+		t.Fatalf("Failed to add action: %v", err)
+	}
+	return action.ID
+}
+
+// getAction returns a stored action by ID
+func getAction(t *testing.T, instanceStore store.Store, id string) protocol.ScheduledAction {
+	t.Helper()
+	instance, err := instanceStore.GetInstance("i-1234")
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+	for _, action := range instance.ScheduledActions {
+		if action.ID == id {
+			return action
+		}
+	}
+	t.Fatalf("Action %s not found in %+v", id, instance.ScheduledActions)
+	return protocol.ScheduledAction{}
+}
+
+// runOnce runs a pass of the scheduler and waits for the actions it started.
+// The instance replies that it is stopping to any stop it is asked to
+// prepare for.
+func runOnce(s *Scheduler) {
+	s.RunOnce(context.Background())
+	s.Reply("i-1234", protocol.StopReplyStopping, "")
+	s.RunOnce(context.Background())
+	s.Wait()
+}
+
+func TestScheduler_Success(t *testing.T) {
+	s, instanceStore, provider, notifier, clock := newTestScheduler(t, Config{})
+
+	stop := addAction(t, instanceStore, protocol.ScheduledAction{Action: "stop", ScheduledTime: clock.Now()})
+	start := addAction(t, instanceStore, protocol.ScheduledAction{Action: "start", ScheduledTime: clock.Now().Add(time.Hour)})
+
+	runOnce(s)
+
+	action := getAction(t, instanceStore, stop)
+	if action.Status != protocol.ActionSucceeded || action.Attempts != 1 || !action.CompletedAt.Equal(clock.Now()) {
+		t.Errorf("Expected the stop to succeed on the first attempt, got %+v", action)
+	}
+	if instance, _ := instanceStore.GetInstance("i-1234"); instance.State != "stopping" {
+		t.Errorf("Expected the instance to be stopping, got %s", instance.State)
+	}
+	if status := getAction(t, instanceStore, start).CurrentStatus(); status != protocol.ActionPending {
+		t.Errorf("Expected the start to wait for its time, got %s", status)
+	}
+	if len(notifier.executed) != 1 || notifier.executed[0] != "notebook stop succeeded" {
+		t.Errorf("Expected a notification for the stop, got %v", notifier.executed)
+	}
+
+	// Finished actions aren't run again
+	runOnce(s)
+	if provider.callCount() != 1 {
+		t.Errorf("Expected one call to the provider, got %v", provider.calls)
+	}
+
+	clock.Add(time.Hour)
+	runOnce(s)
+	if provider.callCount() != 2 || provider.calls[1] != "start i-1234" {
+		t.Errorf("Expected the start to run at its time, got %v", provider.calls)
+	}
+
+	// Finished actions are removed after the retention
+	clock.Add(DefaultRetention + time.Minute)
+	runOnce(s)
+	if instance, _ := instanceStore.GetInstance("i-1234"); len(instance.ScheduledActions) != 0 {
+		t.Errorf("Expected finished actions to be removed, got %+v", instance.ScheduledActions)
+	}
+}
+
+func TestScheduler_Retry(t *testing.T) {
+	s, instanceStore, provider, notifier, clock := newTestScheduler(t, Config{
+		MaxAttempts: 3,
+		Backoff:     time.Minute,
+	})
+	provider.err = errors.New("throttled")
+
+	id := addAction(t, instanceStore, protocol.ScheduledAction{Action: "stop", ScheduledTime: clock.Now()})
+
+	runOnce(s)
+	action := getAction(t, instanceStore, id)
+	if action.CurrentStatus() != protocol.ActionPending || action.Attempts != 1 || action.Error != "throttled" {
+		t.Errorf("Expected the action to be retried, got %+v", action)
+	}
+	if !action.NextAttempt.Equal(clock.Now().Add(time.Minute)) {
+		t.Errorf("Expected a retry after 1m, got %s", action.NextAttempt)
+	}
+
+	// Nothing happens before the retry is due
+	runOnce(s)
+	if provider.callCount() != 1 {
+		t.Errorf("Expected no retry before the backoff, got %d calls", provider.callCount())
+	}
+
+	// The backoff doubles
+	clock.Add(time.Minute)
+	runOnce(s)
+	action = getAction(t, instanceStore, id)
+	if action.Attempts != 2 || !action.NextAttempt.Equal(clock.Now().Add(2*time.Minute)) {
+		t.Errorf("Expected a retry after 2m, got %+v", action)
+	}
+
+	clock.Add(2 * time.Minute)
+	runOnce(s)
+	action = getAction(t, instanceStore, id)
+	if action.Status != protocol.ActionFailed || action.Attempts != 3 {
+		t.Errorf("Expected the action to fail after 3 attempts, got %+v", action)
+	}
+	if len(notifier.errors) != 1 || len(notifier.executed) != 0 {
+		t.Errorf("Expected one error notification, got %v %v", notifier.errors, notifier.executed)
+	}
+
+	// Unsupported actions fail without retrying
+	id = addAction(t, instanceStore, protocol.ScheduledAction{Action: "reboot", ScheduledTime: clock.Now()})
+	runOnce(s)
+	if action := getAction(t, instanceStore, id); action.Status != protocol.ActionFailed || action.Attempts != 1 {
+		t.Errorf("Expected an unsupported action to fail at once, got %+v", action)
+	}
+
+	// Hibernating isn't supported either, and doesn't stop the instance instead
+	calls := provider.callCount()
+	id = addAction(t, instanceStore, protocol.ScheduledAction{Action: "hibernate", ScheduledTime: clock.Now()})
+	runOnce(s)
+	if action := getAction(t, instanceStore, id); action.Status != protocol.ActionFailed || provider.callCount() != calls {
+		t.Errorf("Expected the hibernate to fail without calling the provider, got %+v", action)
+	}
+}
+
+func TestScheduler_Cancel(t *testing.T) {
+	s, instanceStore, provider, _, clock := newTestScheduler(t, Config{})
+
+	// A pending action is never run
+	id := addAction(t, instanceStore, protocol.ScheduledAction{Action: "stop", ScheduledTime: clock.Now().Add(time.Hour)})
+	if err := s.Cancel("i-1234", id); err != nil {
+		t.Fatalf("Failed to cancel action: %v", err)
+	}
+	clock.Add(time.Hour)
+	runOnce(s)
+	if provider.callCount() != 0 {
+		t.Errorf("Expected a cancelled action not to run, got %v", provider.calls)
+	}
+	if status := getAction(t, instanceStore, id).Status; status != protocol.ActionCancelled {
+		t.Errorf("Expected the action to be cancelled, got %s", status)
+	}
+
+	if err := s.Cancel("i-1234", id); !errors.Is(err, ErrActionFinished) {
+		t.Errorf("Expected ErrActionFinished, got %v", err)
+	}
+	if err := s.Cancel("i-1234", "missing"); !errors.Is(err, ErrActionNotFound) {
+		t.Errorf("Expected ErrActionNotFound, got %v", err)
+	}
+	if err := s.Cancel("i-missing", id); !errors.Is(err, store.ErrInstanceNotFound) {
+		t.Errorf("Expected ErrInstanceNotFound, got %v", err)
+	}
+
+	// Cancelling a running action cancels the call to the provider
+	provider.block = true
+	id = addAction(t, instanceStore, protocol.ScheduledAction{Action: "stop", ScheduledTime: clock.Now()})
+	s.RunOnce(context.Background())
+	s.Reply("i-1234", protocol.StopReplyStopping, "")
+	s.RunOnce(context.Background())
+	<-provider.started
+	if err := s.Cancel("i-1234", id); err != nil {
+		t.Fatalf("Failed to cancel running action: %v", err)
+	}
+	s.Wait()
+
+	action := getAction(t, instanceStore, id)
+	if action.Status != protocol.ActionCancelled || action.Error == "" {
+		t.Errorf("Expected the running action to be cancelled, got %+v", action)
+	}
+}
+
+func TestScheduler_StopRequest(t *testing.T) {
+	s, instanceStore, provider, _, clock := newTestScheduler(t, Config{PrepareTimeout: 5 * time.Minute})
+	ctx := context.Background()
+
+	// A due stop is requested from the instance first
+	id := addAction(t, instanceStore, protocol.ScheduledAction{Action: "stop", ScheduledTime: clock.Now()})
+	s.RunOnce(ctx)
+	s.Wait()
+	action := getAction(t, instanceStore, id)
+	if provider.callCount() != 0 || action.CurrentStatus() != protocol.ActionPending || !action.RequestedAt.Equal(clock.Now()) {
+		t.Fatalf("Expected the stop to be requested from the instance, got %+v", action)
+	}
+
+	// States that aren't replies don't start it
+	if err := s.Reply("i-1234", "idle", ""); err != nil {
+		t.Fatalf("Failed to record state: %v", err)
+	}
+	clock.Add(time.Minute)
+	s.RunOnce(ctx)
+	s.Wait()
+	if provider.callCount() != 0 {
+		t.Fatalf("Expected the stop to wait for the instance, got %v", provider.calls)
+	}
+
+	// The instance is stopped once it replies that it is stopping
+	if err := s.Reply("i-1234", protocol.StopReplyStopping, ""); err != nil {
+		t.Fatalf("Failed to record reply: %v", err)
+	}
+	s.RunOnce(ctx)
+	s.Wait()
+	action = getAction(t, instanceStore, id)
+	if action.Status != protocol.ActionSucceeded || action.Reply != protocol.StopReplyStopping || provider.callCount() != 1 {
+		t.Errorf("Expected the stop to succeed after the reply, got %+v", action)
+	}
+
+	// Without a reply the instance is stopped after the prepare timeout
+	id = addAction(t, instanceStore, protocol.ScheduledAction{Action: "stop", ScheduledTime: clock.Now()})
+	s.RunOnce(ctx)
+	clock.Add(5*time.Minute - time.Second)
+	s.RunOnce(ctx)
+	s.Wait()
+	if provider.callCount() != 1 {
+		t.Fatalf("Expected the stop to wait for the prepare timeout, got %v", provider.calls)
+	}
+	clock.Add(time.Second)
+	s.RunOnce(ctx)
+	s.Wait()
+	if action := getAction(t, instanceStore, id); action.Status != protocol.ActionSucceeded || provider.callCount() != 2 {
+		t.Errorf("Expected the stop to succeed after the prepare timeout, got %+v", action)
+	}
+
+	// Starts aren't requested
+	id = addAction(t, instanceStore, protocol.ScheduledAction{Action: "start", ScheduledTime: clock.Now()})
+	s.RunOnce(ctx)
+	s.Wait()
+	if action := getAction(t, instanceStore, id); action.Status != protocol.ActionSucceeded || !action.RequestedAt.IsZero() {
+		t.Errorf("Expected the start to run at once, got %+v", action)
+	}
+}
+
+func TestScheduler_StopVetoed(t *testing.T) {
+	s, instanceStore, provider, notifier, clock := newTestScheduler(t, Config{PrepareTimeout: 5 * time.Minute})
+	ctx := context.Background()
+
+	id := addAction(t, instanceStore, protocol.ScheduledAction{Action: "stop", ScheduledTime: clock.Now()})
+	later := addAction(t, instanceStore, protocol.ScheduledAction{Action: "stop", ScheduledTime: clock.Now().Add(time.Hour)})
+	s.RunOnce(ctx)
+
+	if err := s.Reply("i-1234", protocol.StopReplyVetoed, "epoch in progress"); err != nil {
+		t.Fatalf("Failed to record reply: %v", err)
+	}
+	action := getAction(t, instanceStore, id)
+	if action.Status != protocol.ActionCancelled || action.Error != "vetoed by the instance: epoch in progress" {
+		t.Errorf("Expected the vetoed stop to be cancelled, got %+v", action)
+	}
+	if len(notifier.cancelled) != 1 || notifier.cancelled[0] != "notebook: vetoed by the instance: epoch in progress" {
+		t.Errorf("Expected a stop cancelled notification, got %v", notifier.cancelled)
+	}
+
+	// The vetoed stop isn't carried out, even after the prepare timeout or
+	// a later reply
+	clock.Add(5 * time.Minute)
+	s.Reply("i-1234", protocol.StopReplyStopping, "")
+	s.RunOnce(ctx)
+	s.Wait()
+	if provider.callCount() != 0 {
+		t.Errorf("Expected the vetoed stop not to run, got %v", provider.calls)
+	}
+
+	// Stops that weren't requested yet aren't affected
+	if action := getAction(t, instanceStore, later); action.CurrentStatus() != protocol.ActionPending || !action.RequestedAt.IsZero() {
+		t.Errorf("Expected the later stop to stay pending, got %+v", action)
+	}
+}
+
+func TestScheduler_RecoverAndHold(t *testing.T) {
+	s, instanceStore, provider, _, clock := newTestScheduler(t, Config{})
+
+	// An action left running by a restart is tried again
+	id := addAction(t, instanceStore, protocol.ScheduledAction{
+		Action:        "stop",
+		ScheduledTime: clock.Now().Add(-time.Hour),
+		Status:        protocol.ActionRunning,
+		Attempts:      1,
+	})
+
+	// Actions added without an ID get one
+	if err := instanceStore.AddScheduledAction("i-1234", protocol.ScheduledAction{Action: "start", ScheduledTime: clock.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("Failed to add action: %v", err)
+	}
+
+	held := true
+	s.SetHold(func(instance *store.InstanceState, action protocol.ScheduledAction, now time.Time) bool {
+		return held && action.Action == "stop"
+	})
+
+	s.recoverInterrupted()
+	runOnce(s)
+
+	action := getAction(t, instanceStore, id)
+	if action.CurrentStatus() != protocol.ActionPending || provider.callCount() != 0 {
+		t.Errorf("Expected the recovered action to be held, got %+v", action)
+	}
+	instance, _ := instanceStore.GetInstance("i-1234")
+	if instance.ScheduledActions[1].ID == "" {
+		t.Errorf("Expected the action to be given an ID")
+	}
+
+	held = false
+	runOnce(s)
+	action = getAction(t, instanceStore, id)
+	if action.Status != protocol.ActionSucceeded || action.Attempts != 2 {
+		t.Errorf("Expected the recovered action to succeed on its second attempt, got %+v", action)
+	}
+}
+
+func TestScheduler_Approve(t *testing.T) {
+	s, instanceStore, provider, _, clock := newTestScheduler(t, Config{})
+
+	id := addAction(t, instanceStore, protocol.ScheduledAction{
+		Action:            "stop",
+		ScheduledTime:     clock.Now(),
+		RequiredApprovals: 2,
+	})
+
+	// Actions wait for their approvals
+	runOnce(s)
+	if provider.callCount() != 0 {
+		t.Fatalf("Expected an unapproved action not to run, got %v", provider.calls)
+	}
+
+	// Approving twice counts once
+	for _, approver := range []string{"alice", "alice"} {
+		if err := s.Approve("i-1234", id, approver); err != nil {
+			t.Fatalf("Failed to approve action: %v", err)
+		}
+	}
+	runOnce(s)
+	if action := getAction(t, instanceStore, id); provider.callCount() != 0 || len(action.Approvals) != 1 {
+		t.Fatalf("Expected one approval to not be enough, got %+v", action)
+	}
+
+	if err := s.Approve("i-1234", id, "bob"); err != nil {
+		t.Fatalf("Failed to approve action: %v", err)
+	}
+	runOnce(s)
+	if action := getAction(t, instanceStore, id); action.Status != protocol.ActionSucceeded {
+		t.Errorf("Expected the approved action to succeed, got %+v", action)
+	}
+
+	if err := s.Approve("i-1234", id, "carol"); !errors.Is(err, ErrActionFinished) {
+		t.Errorf("Expected ErrActionFinished, got %v", err)
+	}
+	if err := s.Approve("i-1234", "missing", "carol"); !errors.Is(err, ErrActionNotFound) {
+		t.Errorf("Expected ErrActionNotFound, got %v", err)
+	}
+}
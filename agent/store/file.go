@@ -0,0 +1,501 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
+)
+
+// DefaultStoreFile is the suggested place to keep instance state between
+// restarts of the agent
+const DefaultStoreFile = "/var/lib/snoozebot/agent-store.json"
+
+// volatileWriteInterval is how long changes to the volatile fields of an
+// instance may go unwritten. Monitors report their heartbeat, idle duration
+// and resource usage every few seconds, so writing each report would rewrite
+// and sync the whole file constantly, while losing the latest report in a
+// crash is harmless.
+const volatileWriteInterval = time.Minute
+
+// migration upgrades a decoded store file by one schema version
+type migration func(doc map[string]interface{}) error
+
+// migrations upgrade store files to the current schema. migrations[i]
+// upgrades version i to version i+1, and version 0 is a new file, so the
+// current schema version is len(migrations).
+var migrations = []migration{
+	// Version 1 keeps the instances in a map by instance ID
+	func(doc map[string]interface{}) error {
+		if _, ok := doc["instances"]; !ok {
+			doc["instances"] = map[string]interface{}{}
+		}
+		return nil
+	},
+	// Version 2 adds a revision to each instance for CompareAndSwap
+	func(doc map[string]interface{}) error {
+		instances, _ := doc["instances"].(map[string]interface{})
+		for id, value := range instances {
+			instance, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("invalid instance %s", id)
+			}
+			if _, ok := instance["revision"]; !ok {
+				instance["revision"] = 1
+			}
+		}
+		return nil
+	},
+	// Version 3 adds the idle stages each instance has reached, which were
+	// only kept in memory before. Instances start again from the first stage.
+	func(doc map[string]interface{}) error {
+		instances, _ := doc["instances"].(map[string]interface{})
+		for id, value := range instances {
+			instance, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("invalid instance %s", id)
+			}
+			if _, ok := instance["stages_reached"]; !ok {
+				instance["stages_reached"] = 0
+			}
+		}
+		return nil
+	},
+}
+
+// schemaVersion returns the store file version written by this build
+func schemaVersion() int {
+	return len(migrations)
+}
+
+// storeFile is the document written to the store file
+type storeFile struct {
+	SchemaVersion int                        `json:"schema_version"`
+	Instances     map[string]*instanceRecord `json:"instances"`
+}
+
+// instanceRecord is an instance as written to the store file. It is kept
+// separate from InstanceState so the file format only changes through a
+// migration.
+type instanceRecord struct {
+	InstanceID       string                        `json:"instance_id"`
+	Registration     protocol.InstanceRegistration `json:"registration"`
+	State            string                        `json:"state"`
+	LastHeartbeat    time.Time                     `json:"last_heartbeat"`
+	IdleSince        time.Time                     `json:"idle_since"`
+	IdleDuration     time.Duration                 `json:"idle_duration"`
+	StagesReached    int                           `json:"stages_reached"`
+	StagesIdleSince  time.Time                     `json:"stages_idle_since"`
+	ResourceUsage    map[string]float64            `json:"resource_usage,omitempty"`
+	ScheduledActions []protocol.ScheduledAction    `json:"scheduled_actions,omitempty"`
+	Revision         uint64                        `json:"revision"`
+}
+
+// FileStore is a Store that keeps instances in memory and writes them to a
+// JSON file on every change, so registrations, idle timers and scheduled
+// actions survive a restart of the agent. Each write replaces the file
+// atomically, so a crash leaves either the old or the new contents. Changes
+// to only the heartbeat, idle duration or resource usage are written at
+// most once every volatileWriteInterval, and by Flush.
+type FileStore struct {
+	path      string
+	instances map[string]*InstanceState
+	revision  uint64
+	written   time.Time
+	dirty     bool
+	now       func() time.Time
+	mutex     sync.RWMutex
+}
+
+// NewFileStore opens the store file at path, creating it if it doesn't
+// exist. Files written with an older schema are migrated, keeping a copy of
+// the original next to the file.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		path:      path,
+		instances: make(map[string]*InstanceState),
+		now:       time.Now,
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read store file: %w", err)
+	}
+
+	doc := map[string]interface{}{}
+	if err == nil {
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("failed to parse store file %s: %w", path, err)
+		}
+	}
+
+	version, err := documentVersion(doc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid store file %s: %w", path, err)
+	}
+	if version > schemaVersion() {
+		return nil, fmt.Errorf("store file %s has schema version %d, newer than the supported version %d", path, version, schemaVersion())
+	}
+
+	migrated := version < schemaVersion()
+	if migrated && data != nil {
+		backup := fmt.Sprintf("%s.v%d", path, version)
+		if err := writeFileAtomic(backup, data); err != nil {
+			return nil, fmt.Errorf("failed to back up store file before migrating: %w", err)
+		}
+	}
+	for ; version < schemaVersion(); version++ {
+		if err := migrations[version](doc); err != nil {
+			return nil, fmt.Errorf("failed to migrate store file from version %d: %w", version, err)
+		}
+	}
+	doc["schema_version"] = version
+
+	// Decode the migrated document into the current schema
+	data, err = json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode migrated store file: %w", err)
+	}
+	var file storeFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid store file %s: %w", path, err)
+	}
+	for id, record := range file.Instances {
+		if record == nil {
+			continue
+		}
+		s.instances[id] = record.instance()
+		if record.Revision > s.revision {
+			s.revision = record.Revision
+		}
+	}
+
+	if migrated {
+		if err := s.write(s.instances); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// documentVersion returns the schema version of a decoded store file
+func documentVersion(doc map[string]interface{}) (int, error) {
+	value, ok := doc["schema_version"]
+	if !ok {
+		return 0, nil
+	}
+	version, ok := value.(float64)
+	if !ok || version < 0 || version != float64(int(version)) {
+		return 0, fmt.Errorf("invalid schema version %v", value)
+	}
+	return int(version), nil
+}
+
+// Path returns the path of the store file
+func (s *FileStore) Path() string {
+	return s.path
+}
+
+// Flush writes changes that haven't been written yet because they only
+// touched volatile fields. Call it before the agent exits.
+func (s *FileStore) Flush() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.dirty {
+		return nil
+	}
+	return s.write(s.instances)
+}
+
+// RegisterInstance registers a new instance
+func (s *FileStore) RegisterInstance(registration protocol.InstanceRegistration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, err := s.commit(registration.InstanceID, newInstance(registration))
+	return err
+}
+
+// UnregisterInstance unregisters an instance
+func (s *FileStore) UnregisterInstance(instanceID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.instances[instanceID]; !ok {
+		return nil
+	}
+
+	_, err := s.commit(instanceID, nil)
+	return err
+}
+
+// GetInstance gets the state of an instance
+func (s *FileStore) GetInstance(instanceID string) (*InstanceState, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	instance, ok := s.instances[instanceID]
+	if !ok {
+		return nil, instanceNotFound(instanceID)
+	}
+
+	return instance.Copy(), nil
+}
+
+// Update atomically applies a change to an instance
+func (s *FileStore) Update(instanceID string, update func(instance *InstanceState) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	instance, ok := s.instances[instanceID]
+	if !ok {
+		return instanceNotFound(instanceID)
+	}
+
+	updated := instance.Copy()
+	if err := update(updated); err != nil {
+		return err
+	}
+
+	_, err := s.commit(instanceID, updated)
+	return err
+}
+
+// CompareAndSwap stores a changed copy of an instance if it hasn't changed since the copy was read
+func (s *FileStore) CompareAndSwap(instance *InstanceState) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	current, ok := s.instances[instance.InstanceID]
+	if !ok {
+		return instanceNotFound(instance.InstanceID)
+	}
+	if current.Revision != instance.Revision {
+		return fmt.Errorf("%w: %s", ErrConflict, instance.InstanceID)
+	}
+
+	revision, err := s.commit(instance.InstanceID, instance)
+	if err != nil {
+		return err
+	}
+	instance.Revision = revision
+	return nil
+}
+
+// UpdateInstanceState updates the state of an instance
+func (s *FileStore) UpdateInstanceState(instanceID string, state string) error {
+	return s.Update(instanceID, setState(state))
+}
+
+// UpdateResourceUsage updates the resource usage for an instance
+func (s *FileStore) UpdateResourceUsage(instanceID string, usage map[string]float64) error {
+	return s.Update(instanceID, setResourceUsage(usage))
+}
+
+// UpdateIdleState updates the idle state of an instance
+func (s *FileStore) UpdateIdleState(instanceID string, isIdle bool, since time.Time, duration time.Duration) error {
+	return s.Update(instanceID, setIdleState(isIdle, since, duration))
+}
+
+// UpdateLastHeartbeat updates the time of the last heartbeat from an instance
+func (s *FileStore) UpdateLastHeartbeat(instanceID string, t time.Time) error {
+	return s.Update(instanceID, setLastHeartbeat(t))
+}
+
+// AddScheduledAction adds a scheduled action for an instance
+func (s *FileStore) AddScheduledAction(instanceID string, action protocol.ScheduledAction) error {
+	return s.Update(instanceID, addScheduledAction(action))
+}
+
+// RemoveScheduledAction removes a scheduled action for an instance
+func (s *FileStore) RemoveScheduledAction(instanceID string, actionIndex int) error {
+	return s.Update(instanceID, removeScheduledAction(actionIndex))
+}
+
+// GetAllInstances gets all registered instances
+func (s *FileStore) GetAllInstances() (map[string]*InstanceState, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	instances := make(map[string]*InstanceState, len(s.instances))
+	for id, instance := range s.instances {
+		instances[id] = instance.Copy()
+	}
+
+	return instances, nil
+}
+
+// GetInstancesByState gets all instances in a specific state
+func (s *FileStore) GetInstancesByState(state string) (map[string]*InstanceState, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	instances := make(map[string]*InstanceState)
+	for id, instance := range s.instances {
+		if instance.State == state {
+			instances[id] = instance.Copy()
+		}
+	}
+
+	return instances, nil
+}
+
+// commit writes the store with a copy of an instance stored at the next
+// revision, or with the instance removed if it is nil, and only then applies
+// the change in memory, so a failed write leaves the store unchanged. Changes
+// to only volatile fields skip the write if the file was written recently.
+// It returns the new revision. The caller must hold the mutex.
+func (s *FileStore) commit(instanceID string, instance *InstanceState) (uint64, error) {
+	volatile := instance != nil && onlyVolatileChanges(s.instances[instanceID], instance)
+
+	instances := make(map[string]*InstanceState, len(s.instances)+1)
+	for id, existing := range s.instances {
+		instances[id] = existing
+	}
+
+	revision := s.revision
+	if instance != nil {
+		stored := instance.Copy()
+		revision++
+		stored.Revision = revision
+		instances[instanceID] = stored
+	} else {
+		delete(instances, instanceID)
+	}
+
+	if volatile && s.now().Sub(s.written) < volatileWriteInterval {
+		s.dirty = true
+	} else if err := s.write(instances); err != nil {
+		return 0, err
+	}
+
+	s.instances = instances
+	s.revision = revision
+	return revision, nil
+}
+
+// write replaces the store file with the given instances
+func (s *FileStore) write(instances map[string]*InstanceState) error {
+	file := storeFile{
+		SchemaVersion: schemaVersion(),
+		Instances:     make(map[string]*instanceRecord, len(instances)),
+	}
+	for id, instance := range instances {
+		file.Instances[id] = newInstanceRecord(instance)
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode store file: %w", err)
+	}
+
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return err
+	}
+	s.written = s.now()
+	s.dirty = false
+	return nil
+}
+
+// onlyVolatileChanges reports whether an update to an instance only changes
+// its heartbeat, idle duration or resource usage
+func onlyVolatileChanges(current, updated *InstanceState) bool {
+	if current == nil {
+		return false
+	}
+
+	before, after := newInstanceRecord(current), newInstanceRecord(updated)
+	for _, record := range []*instanceRecord{before, after} {
+		record.LastHeartbeat = time.Time{}
+		record.IdleDuration = 0
+		record.ResourceUsage = nil
+		record.Revision = 0
+	}
+	return reflect.DeepEqual(before, after)
+}
+
+// writeFileAtomic writes a temporary file and renames it over path, so a
+// crash never leaves a partial file
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create store directory: %w", err)
+	}
+
+	file, err := os.CreateTemp(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create store file: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write store file: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync store file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close store file: %w", err)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace store file: %w", err)
+	}
+
+	// Sync the directory so the rename itself survives a crash
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return nil
+}
+
+// newInstanceRecord converts an instance to its stored form
+func newInstanceRecord(instance *InstanceState) *instanceRecord {
+	return &instanceRecord{
+		InstanceID:       instance.InstanceID,
+		Registration:     instance.Registration,
+		State:            instance.State,
+		LastHeartbeat:    instance.LastHeartbeat,
+		IdleSince:        instance.IdleSince,
+		IdleDuration:     instance.IdleDuration,
+		StagesReached:    instance.StagesReached,
+		StagesIdleSince:  instance.StagesIdleSince,
+		ResourceUsage:    instance.ResourceUsage,
+		ScheduledActions: instance.ScheduledActions,
+		Revision:         instance.Revision,
+	}
+}
+
+// instance converts a stored instance back to its state
+func (r *instanceRecord) instance() *InstanceState {
+	instance := &InstanceState{
+		InstanceID:       r.InstanceID,
+		Registration:     r.Registration,
+		State:            r.State,
+		LastHeartbeat:    r.LastHeartbeat,
+		IdleSince:        r.IdleSince,
+		IdleDuration:     r.IdleDuration,
+		StagesReached:    r.StagesReached,
+		StagesIdleSince:  r.StagesIdleSince,
+		ResourceUsage:    r.ResourceUsage,
+		ScheduledActions: r.ScheduledActions,
+		Revision:         r.Revision,
+	}
+	if instance.ResourceUsage == nil {
+		instance.ResourceUsage = make(map[string]float64)
+	}
+	if instance.ScheduledActions == nil {
+		instance.ScheduledActions = make([]protocol.ScheduledAction, 0)
+	}
+	return instance
+}
@@ -0,0 +1,352 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
+)
+
+func TestFileStore_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+
+	s, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Failed to create file store: %v", err)
+	}
+
+	since := time.Date(2025, 7, 2, 12, 0, 0, 0, time.UTC)
+	registration := protocol.InstanceRegistration{
+		InstanceID: "i-1",
+		Provider:   "aws",
+		Metadata:   map[string]string{"team": "ml"},
+		NapTime:    30 * time.Minute,
+	}
+	if err := s.RegisterInstance(registration); err != nil {
+		t.Fatalf("Failed to register instance: %v", err)
+	}
+	if err := s.RegisterInstance(protocol.InstanceRegistration{InstanceID: "i-2"}); err != nil {
+		t.Fatalf("Failed to register instance: %v", err)
+	}
+	if err := s.UpdateIdleState("i-1", true, since, 10*time.Minute); err != nil {
+		t.Fatalf("Failed to update idle state: %v", err)
+	}
+	if err := s.AddScheduledAction("i-1", protocol.ScheduledAction{Action: "stop", ScheduledTime: since.Add(time.Hour)}); err != nil {
+		t.Fatalf("Failed to add action: %v", err)
+	}
+	err = s.Update("i-1", func(instance *InstanceState) error {
+		instance.StagesReached = 2
+		instance.StagesIdleSince = since
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to update stages: %v", err)
+	}
+	if err := s.UnregisterInstance("i-2"); err != nil {
+		t.Fatalf("Failed to unregister instance: %v", err)
+	}
+
+	// A new store reads everything back from the file
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen file store: %v", err)
+	}
+	if _, err := reopened.GetInstance("i-2"); err == nil {
+		t.Errorf("Expected the unregistered instance to stay unregistered")
+	}
+	instance, err := reopened.GetInstance("i-1")
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+	if !instance.IdleSince.Equal(since) || instance.IdleDuration != 10*time.Minute {
+		t.Errorf("Expected the idle time to be kept, got %s for %s", instance.IdleSince, instance.IdleDuration)
+	}
+	if instance.Registration.NapTime != 30*time.Minute || instance.Registration.Metadata["team"] != "ml" {
+		t.Errorf("Expected the registration to be kept, got %+v", instance.Registration)
+	}
+	if len(instance.ScheduledActions) != 1 || !instance.ScheduledActions[0].ScheduledTime.Equal(since.Add(time.Hour)) {
+		t.Errorf("Expected the scheduled action to be kept, got %+v", instance.ScheduledActions)
+	}
+	if instance.StagesReached != 2 || !instance.StagesIdleSince.Equal(since) {
+		t.Errorf("Expected the stage progress to be kept, got %d since %s", instance.StagesReached, instance.StagesIdleSince)
+	}
+
+	// Revisions carry on from the saved ones
+	revision := instance.Revision
+	if err := reopened.UpdateInstanceState("i-1", "idle"); err != nil {
+		t.Fatalf("Failed to update state: %v", err)
+	}
+	if again, _ := reopened.GetInstance("i-1"); again.Revision <= revision {
+		t.Errorf("Expected a revision after %d, got %d", revision, again.Revision)
+	}
+
+	// Callers can't change the store through returned instances
+	instance.Registration.Metadata["team"] = "changed"
+	instance.ScheduledActions[0].Action = "changed"
+	if again, _ := reopened.GetInstance("i-1"); again.Registration.Metadata["team"] != "ml" || again.ScheduledActions[0].Action != "stop" {
+		t.Errorf("Expected the stored instance to be unchanged, got %+v", again)
+	}
+}
+
+func TestFileStore_CrashSafety(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "store.json")
+
+	s, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Failed to create file store: %v", err)
+	}
+	if err := s.RegisterInstance(protocol.InstanceRegistration{InstanceID: "i-1"}); err != nil {
+		t.Fatalf("Failed to register instance: %v", err)
+	}
+
+	// A crash while writing leaves a partial temporary file behind
+	if err := os.WriteFile(path+".tmp123", []byte(`{"schema_version": 1, "inst`), 0644); err != nil {
+		t.Fatalf("Failed to write temporary file: %v", err)
+	}
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen file store: %v", err)
+	}
+	if _, err := reopened.GetInstance("i-1"); err != nil {
+		t.Errorf("Expected the instance to survive: %v", err)
+	}
+
+	// A failed write leaves the store unchanged
+	s.path = dir
+	if err := s.UpdateInstanceState("i-1", "idle"); err == nil {
+		t.Errorf("Expected an error writing over a directory")
+	}
+	if instance, _ := s.GetInstance("i-1"); instance.State != "running" {
+		t.Errorf("Expected the failed update not to apply, got %s", instance.State)
+	}
+
+	// A corrupt file is reported rather than replaced
+	if err := os.WriteFile(path, []byte("not json"), 0644); err != nil {
+		t.Fatalf("Failed to write store file: %v", err)
+	}
+	if _, err := NewFileStore(path); err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("Expected an error naming the corrupt file, got %v", err)
+	}
+}
+
+func TestFileStore_VolatileWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	s, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Failed to create file store: %v", err)
+	}
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	if err := s.RegisterInstance(protocol.InstanceRegistration{InstanceID: "i-1"}); err != nil {
+		t.Fatalf("Failed to register instance: %v", err)
+	}
+	written := readStoreFile(t, path)
+
+	// Heartbeats and usage reports are kept in memory but not written
+	now = now.Add(10 * time.Second)
+	if err := s.UpdateLastHeartbeat("i-1", now); err != nil {
+		t.Fatalf("Failed to update heartbeat: %v", err)
+	}
+	if err := s.UpdateResourceUsage("i-1", map[string]float64{"cpu": 2.5}); err != nil {
+		t.Fatalf("Failed to update usage: %v", err)
+	}
+	if instance, _ := s.GetInstance("i-1"); !instance.LastHeartbeat.Equal(now) || instance.ResourceUsage["cpu"] != 2.5 {
+		t.Errorf("Expected the heartbeat in memory, got %+v", instance)
+	}
+	if readStoreFile(t, path) != written {
+		t.Errorf("Expected a heartbeat not to rewrite the file")
+	}
+
+	// Other changes are written at once, along with the held back ones
+	if err := s.UpdateInstanceState("i-1", "idle"); err != nil {
+		t.Fatalf("Failed to update state: %v", err)
+	}
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen file store: %v", err)
+	}
+	if instance, _ := reopened.GetInstance("i-1"); instance.State != "idle" || !instance.LastHeartbeat.Equal(now) {
+		t.Errorf("Expected the state and heartbeat to be written, got %+v", instance)
+	}
+
+	// Heartbeats are written once the interval has passed
+	written = readStoreFile(t, path)
+	now = now.Add(volatileWriteInterval)
+	if err := s.UpdateLastHeartbeat("i-1", now); err != nil {
+		t.Fatalf("Failed to update heartbeat: %v", err)
+	}
+	if readStoreFile(t, path) == written {
+		t.Errorf("Expected a heartbeat to be written after %s", volatileWriteInterval)
+	}
+
+	// Flush writes the held back changes
+	written = readStoreFile(t, path)
+	now = now.Add(time.Second)
+	if err := s.UpdateLastHeartbeat("i-1", now); err != nil {
+		t.Fatalf("Failed to update heartbeat: %v", err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	if readStoreFile(t, path) == written {
+		t.Errorf("Expected Flush to write the heartbeat")
+	}
+}
+
+func TestFileStore_Migrations(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "store.json")
+
+	// A new file is created at the current version
+	if _, err := NewFileStore(path); err != nil {
+		t.Fatalf("Failed to create file store: %v", err)
+	}
+	if version := fileVersion(t, path); version != schemaVersion() {
+		t.Errorf("Expected schema version %d, got %d", schemaVersion(), version)
+	}
+
+	// Version 1 files are given revisions
+	v1 := `{"schema_version": 1, "instances": {"i-1": {"instance_id": "i-1", "state": "running"}}}`
+	if err := os.WriteFile(path, []byte(v1), 0644); err != nil {
+		t.Fatalf("Failed to write store file: %v", err)
+	}
+	s, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Failed to migrate file store: %v", err)
+	}
+	instance, err := s.GetInstance("i-1")
+	if err != nil {
+		t.Fatalf("Failed to get migrated instance: %v", err)
+	}
+	if instance.Revision != 1 {
+		t.Errorf("Expected revision 1 after migrating, got %d", instance.Revision)
+	}
+	if err := s.CompareAndSwap(instance); err != nil {
+		t.Errorf("Failed to swap migrated instance: %v", err)
+	}
+
+	// Version 2 files start every instance from the first idle stage
+	v2 := `{"schema_version": 2, "instances": {"i-1": {"instance_id": "i-1", "state": "idle", "revision": 4}}}`
+	if err := os.WriteFile(path, []byte(v2), 0644); err != nil {
+		t.Fatalf("Failed to write store file: %v", err)
+	}
+	s, err = NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Failed to migrate file store: %v", err)
+	}
+	if instance, _ := s.GetInstance("i-1"); instance.StagesReached != 0 || instance.Revision != 4 {
+		t.Errorf("Expected no stages reached after migrating, got %+v", instance)
+	}
+	if backup, err := os.ReadFile(path + ".v2"); err != nil || string(backup) != v2 {
+		t.Errorf("Expected the version 2 file to be kept, got %s %v", backup, err)
+	}
+
+	// Files without a version are from before versioning
+	legacy := `{"instances": {"i-1": {"instance_id": "i-1", "state": "idle"}}}`
+	if err := os.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatalf("Failed to write store file: %v", err)
+	}
+
+	// Add a migration that renames a state, as a future schema change might
+	saved := migrations
+	defer func() { migrations = saved }()
+	migrations = append(migrations[:len(migrations):len(migrations)], func(doc map[string]interface{}) error {
+		instances, _ := doc["instances"].(map[string]interface{})
+		for _, value := range instances {
+			instance, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("invalid instance")
+			}
+			if instance["state"] == "idle" {
+				instance["state"] = "sleeping"
+			}
+		}
+		return nil
+	})
+
+	s, err = NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Failed to migrate file store: %v", err)
+	}
+	instance, err = s.GetInstance("i-1")
+	if err != nil {
+		t.Fatalf("Failed to get migrated instance: %v", err)
+	}
+	if instance.State != "sleeping" {
+		t.Errorf("Expected the migration to run, got state %s", instance.State)
+	}
+	if version := fileVersion(t, path); version != schemaVersion() {
+		t.Errorf("Expected the file to be rewritten at version %d, got %d", schemaVersion(), version)
+	}
+
+	// The original file is kept
+	backup, err := os.ReadFile(path + ".v0")
+	if err != nil {
+		t.Fatalf("Failed to read backup: %v", err)
+	}
+	if string(backup) != legacy {
+		t.Errorf("Expected the backup to hold the original file, got %s", backup)
+	}
+
+	// Opening again doesn't migrate again
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen file store: %v", err)
+	}
+	if instance, _ := reopened.GetInstance("i-1"); instance.State != "sleeping" {
+		t.Errorf("Expected the state to be migrated once, got %s", instance.State)
+	}
+
+	// A failing migration leaves the file alone
+	migrations = append(migrations, func(doc map[string]interface{}) error {
+		return fmt.Errorf("broken")
+	})
+	before, _ := os.ReadFile(path)
+	if _, err := NewFileStore(path); err == nil {
+		t.Errorf("Expected a failing migration to be reported")
+	}
+	if after, _ := os.ReadFile(path); string(after) != string(before) {
+		t.Errorf("Expected a failed migration not to change the file")
+	}
+	migrations = migrations[:len(migrations)-1]
+
+	// Files from a newer version aren't read
+	if err := os.WriteFile(path, []byte(`{"schema_version": 99, "instances": {}}`), 0644); err != nil {
+		t.Fatalf("Failed to write store file: %v", err)
+	}
+	if _, err := NewFileStore(path); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("Expected an error for a newer schema, got %v", err)
+	}
+}
+
+// fileVersion returns the schema version written to a store file
+func fileVersion(t *testing.T, path string) int {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read store file: %v", err)
+	}
+	var file storeFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatalf("Failed to parse store file: %v", err)
+	}
+	return file.SchemaVersion
+}
+
+// readStoreFile returns the contents of a store file
+func readStoreFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read store file: %v", err)
+	}
+	return string(data)
+}
@@ -1,6 +1,7 @@
 package store
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -8,6 +9,13 @@ import (
 	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
 )
 
+// ErrInstanceNotFound is returned for instances that aren't registered
+var ErrInstanceNotFound = errors.New("instance not found")
+
+// ErrConflict is returned by CompareAndSwap when the instance has changed
+// since it was read
+var ErrConflict = errors.New("instance changed since it was read")
+
 // InstanceState contains the current state of an instance
 type InstanceState struct {
 	// InstanceID is the unique identifier for the instance
@@ -28,14 +36,43 @@ type InstanceState struct {
 	// IdleDuration is how long the instance has been idle
 	IdleDuration time.Duration
 	
+	// StagesReached is the number of idle stages the instance has reached
+	// in the idle period that started at StagesIdleSince
+	StagesReached   int
+	StagesIdleSince time.Time
+	
 	// ResourceUsage is the most recent resource usage report
 	ResourceUsage map[string]float64
 	
 	// ScheduledActions is a list of actions scheduled for the instance
 	ScheduledActions []protocol.ScheduledAction
+	
+	// Revision changes whenever the instance is changed, and is checked by
+	// CompareAndSwap
+	Revision uint64
+}
+
+// Copy returns a deep copy of the instance state
+func (i *InstanceState) Copy() *InstanceState {
+	c := *i
+	c.Registration.Metadata = copyStringMap(i.Registration.Metadata)
+	c.Registration.Thresholds = copyFloatMap(i.Registration.Thresholds)
+	c.ResourceUsage = copyFloatMap(i.ResourceUsage)
+	if i.ScheduledActions != nil {
+		c.ScheduledActions = make([]protocol.ScheduledAction, len(i.ScheduledActions))
+		copy(c.ScheduledActions, i.ScheduledActions)
+		for j, action := range i.ScheduledActions {
+			if action.Approvals != nil {
+				c.ScheduledActions[j].Approvals = append([]string(nil), action.Approvals...)
+			}
+		}
+	}
+	return &c
 }
 
-// Store defines the interface for storing and retrieving instance state
+// Store defines the interface for storing and retrieving instance state.
+// Instances returned by a store are copies that the caller may change
+// without affecting the store.
 type Store interface {
 	// RegisterInstance registers a new instance
 	RegisterInstance(registration protocol.InstanceRegistration) error
@@ -46,6 +83,17 @@ type Store interface {
 	// GetInstance gets the state of an instance
 	GetInstance(instanceID string) (*InstanceState, error)
 	
+	// Update atomically applies a change to an instance. The update function
+	// is called with a copy of the instance, and the copy is stored if it
+	// returns nil. If it returns an error the instance is left unchanged and
+	// the error is returned.
+	Update(instanceID string, update func(instance *InstanceState) error) error
+	
+	// CompareAndSwap stores a changed copy of an instance if the instance's
+	// revision still matches the copy's, and returns ErrConflict otherwise.
+	// On success the copy's revision is set to the new revision.
+	CompareAndSwap(instance *InstanceState) error
+	
 	// UpdateInstanceState updates the state of an instance
 	UpdateInstanceState(instanceID string, state string) error
 	
@@ -74,6 +122,7 @@ type Store interface {
 // MemoryStore is an in-memory implementation of the Store interface
 type MemoryStore struct {
 	instances map[string]*InstanceState
+	revision  uint64
 	mutex     sync.RWMutex
 }
 
@@ -89,15 +138,7 @@ func (s *MemoryStore) RegisterInstance(registration protocol.InstanceRegistratio
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	
-	s.instances[registration.InstanceID] = &InstanceState{
-		InstanceID:       registration.InstanceID,
-		Registration:     registration,
-		State:            "running",
-		LastHeartbeat:    time.Now(),
-		ResourceUsage:    make(map[string]float64),
-		ScheduledActions: make([]protocol.ScheduledAction, 0),
-	}
-	
+	s.put(registration.InstanceID, newInstance(registration))
 	return nil
 }
 
@@ -117,110 +158,86 @@ func (s *MemoryStore) GetInstance(instanceID string) (*InstanceState, error) {
 	
 	instance, ok := s.instances[instanceID]
 	if !ok {
-		return nil, fmt.Errorf("instance not found: %s", instanceID)
+		return nil, instanceNotFound(instanceID)
 	}
 	
-	return instance, nil
+	return instance.Copy(), nil
 }
 
-// UpdateInstanceState updates the state of an instance
-func (s *MemoryStore) UpdateInstanceState(instanceID string, state string) error {
+// Update atomically applies a change to an instance
+func (s *MemoryStore) Update(instanceID string, update func(instance *InstanceState) error) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	
 	instance, ok := s.instances[instanceID]
 	if !ok {
-		return fmt.Errorf("instance not found: %s", instanceID)
+		return instanceNotFound(instanceID)
+	}
+	
+	updated := instance.Copy()
+	if err := update(updated); err != nil {
+		return err
 	}
 	
-	instance.State = state
+	s.put(instanceID, updated)
 	return nil
 }
 
-// UpdateResourceUsage updates the resource usage for an instance
-func (s *MemoryStore) UpdateResourceUsage(instanceID string, usage map[string]float64) error {
+// CompareAndSwap stores a changed copy of an instance if it hasn't changed since the copy was read
+func (s *MemoryStore) CompareAndSwap(instance *InstanceState) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	
-	instance, ok := s.instances[instanceID]
+	current, ok := s.instances[instance.InstanceID]
 	if !ok {
-		return fmt.Errorf("instance not found: %s", instanceID)
+		return instanceNotFound(instance.InstanceID)
+	}
+	if current.Revision != instance.Revision {
+		return fmt.Errorf("%w: %s", ErrConflict, instance.InstanceID)
 	}
 	
-	instance.ResourceUsage = usage
+	instance.Revision = s.put(instance.InstanceID, instance)
 	return nil
 }
 
+// put stores a copy of an instance with the next revision and returns the
+// revision. The caller must hold the mutex.
+func (s *MemoryStore) put(instanceID string, instance *InstanceState) uint64 {
+	stored := instance.Copy()
+	s.revision++
+	stored.Revision = s.revision
+	s.instances[instanceID] = stored
+	return stored.Revision
+}
+
+// UpdateInstanceState updates the state of an instance
+func (s *MemoryStore) UpdateInstanceState(instanceID string, state string) error {
+	return s.Update(instanceID, setState(state))
+}
+
+// UpdateResourceUsage updates the resource usage for an instance
+func (s *MemoryStore) UpdateResourceUsage(instanceID string, usage map[string]float64) error {
+	return s.Update(instanceID, setResourceUsage(usage))
+}
+
 // UpdateIdleState updates the idle state of an instance
 func (s *MemoryStore) UpdateIdleState(instanceID string, isIdle bool, since time.Time, duration time.Duration) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	
-	instance, ok := s.instances[instanceID]
-	if !ok {
-		return fmt.Errorf("instance not found: %s", instanceID)
-	}
-	
-	if isIdle {
-		instance.IdleSince = since
-		instance.IdleDuration = duration
-	} else {
-		instance.IdleSince = time.Time{}
-		instance.IdleDuration = 0
-	}
-	
-	return nil
+	return s.Update(instanceID, setIdleState(isIdle, since, duration))
 }
 
 // UpdateLastHeartbeat updates the time of the last heartbeat from an instance
 func (s *MemoryStore) UpdateLastHeartbeat(instanceID string, t time.Time) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	
-	instance, ok := s.instances[instanceID]
-	if !ok {
-		return fmt.Errorf("instance not found: %s", instanceID)
-	}
-	
-	instance.LastHeartbeat = t
-	return nil
+	return s.Update(instanceID, setLastHeartbeat(t))
 }
 
 // AddScheduledAction adds a scheduled action for an instance
 func (s *MemoryStore) AddScheduledAction(instanceID string, action protocol.ScheduledAction) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	
-	instance, ok := s.instances[instanceID]
-	if !ok {
-		return fmt.Errorf("instance not found: %s", instanceID)
-	}
-	
-	instance.ScheduledActions = append(instance.ScheduledActions, action)
-	return nil
+	return s.Update(instanceID, addScheduledAction(action))
 }
 
 // RemoveScheduledAction removes a scheduled action for an instance
 func (s *MemoryStore) RemoveScheduledAction(instanceID string, actionIndex int) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	
-	instance, ok := s.instances[instanceID]
-	if !ok {
-		return fmt.Errorf("instance not found: %s", instanceID)
-	}
-	
-	if actionIndex < 0 || actionIndex >= len(instance.ScheduledActions) {
-		return fmt.Errorf("invalid action index: %d", actionIndex)
-	}
-	
-	// Remove the action at the given index
-	instance.ScheduledActions = append(
-		instance.ScheduledActions[:actionIndex],
-		instance.ScheduledActions[actionIndex+1:]...,
-	)
-	
-	return nil
+	return s.Update(instanceID, removeScheduledAction(actionIndex))
 }
 
 // GetAllInstances gets all registered instances
@@ -228,10 +245,10 @@ func (s *MemoryStore) GetAllInstances() (map[string]*InstanceState, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 	
-	// Create a copy of the instances map
-	instances := make(map[string]*InstanceState)
+	// Copy the instances so callers don't share them
+	instances := make(map[string]*InstanceState, len(s.instances))
 	for id, instance := range s.instances {
-		instances[id] = instance
+		instances[id] = instance.Copy()
 	}
 	
 	return instances, nil
@@ -246,9 +263,115 @@ func (s *MemoryStore) GetInstancesByState(state string) (map[string]*InstanceSta
 	instances := make(map[string]*InstanceState)
 	for id, instance := range s.instances {
 		if instance.State == state {
-			instances[id] = instance
+			instances[id] = instance.Copy()
 		}
 	}
 	
 	return instances, nil
-}
\ No newline at end of file
+}
+
+// instanceNotFound returns the error for an instance that isn't registered
+func instanceNotFound(instanceID string) error {
+	return fmt.Errorf("%w: %s", ErrInstanceNotFound, instanceID)
+}
+
+// newInstance returns the state of a newly registered instance. The stores
+// copy it before keeping it.
+func newInstance(registration protocol.InstanceRegistration) *InstanceState {
+	return &InstanceState{
+		InstanceID:       registration.InstanceID,
+		Registration:     registration,
+		State:            "running",
+		LastHeartbeat:    time.Now(),
+		ResourceUsage:    make(map[string]float64),
+		ScheduledActions: make([]protocol.ScheduledAction, 0),
+	}
+}
+
+// The updates below implement the Update* methods of the stores on top of Update
+
+// setState sets the state of an instance
+func setState(state string) func(*InstanceState) error {
+	return func(instance *InstanceState) error {
+		instance.State = state
+		return nil
+	}
+}
+
+// setResourceUsage replaces the resource usage of an instance
+func setResourceUsage(usage map[string]float64) func(*InstanceState) error {
+	return func(instance *InstanceState) error {
+		instance.ResourceUsage = copyFloatMap(usage)
+		return nil
+	}
+}
+
+// setIdleState sets or clears the idle time of an instance
+func setIdleState(isIdle bool, since time.Time, duration time.Duration) func(*InstanceState) error {
+	return func(instance *InstanceState) error {
+		if isIdle {
+			instance.IdleSince = since
+			instance.IdleDuration = duration
+		} else {
+			instance.IdleSince = time.Time{}
+			instance.IdleDuration = 0
+		}
+		return nil
+	}
+}
+
+// setLastHeartbeat sets the time of the last heartbeat from an instance
+func setLastHeartbeat(t time.Time) func(*InstanceState) error {
+	return func(instance *InstanceState) error {
+		instance.LastHeartbeat = t
+		return nil
+	}
+}
+
+// addScheduledAction appends a scheduled action to an instance
+func addScheduledAction(action protocol.ScheduledAction) func(*InstanceState) error {
+	return func(instance *InstanceState) error {
+		instance.ScheduledActions = append(instance.ScheduledActions, action)
+		return nil
+	}
+}
+
+// removeScheduledAction removes the scheduled action at an index
+func removeScheduledAction(actionIndex int) func(*InstanceState) error {
+	return func(instance *InstanceState) error {
+		if actionIndex < 0 || actionIndex >= len(instance.ScheduledActions) {
+			return fmt.Errorf("invalid action index: %d", actionIndex)
+		}
+	
+		// Remove the action at the given index
+		instance.ScheduledActions = append(
+			instance.ScheduledActions[:actionIndex],
+			instance.ScheduledActions[actionIndex+1:]...,
+		)
+		return nil
+	}
+}
+
+// copyStringMap copies a map, keeping nil maps nil
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// copyFloatMap copies a map, keeping nil maps nil
+func copyFloatMap(m map[string]float64) map[string]float64 {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]float64, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
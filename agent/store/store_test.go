@@ -0,0 +1,25 @@
+package store_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/scttfrdmn/snoozebot/agent/store"
+	"github.com/scttfrdmn/snoozebot/agent/store/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return store.NewMemoryStore()
+	})
+}
+
+func TestFileStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		s, err := store.NewFileStore(filepath.Join(t.TempDir(), "store.json"))
+		if err != nil {
+			t.Fatalf("Failed to create file store: %v", err)
+		}
+		return s
+	})
+}
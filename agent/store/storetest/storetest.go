@@ -0,0 +1,517 @@
+// Package storetest provides a conformance suite for implementations of
+// store.Store
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scttfrdmn/snoozebot/agent/store"
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
+)
+
+// NewStore creates an empty store for a test
+type NewStore func(t *testing.T) store.Store
+
+// Run runs the conformance suite against the stores created by newStore.
+// Every test gets a new store.
+func Run(t *testing.T, newStore NewStore) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s store.Store)
+	}{
+		{"RegisterInstance", testRegisterInstance},
+		{"UnregisterInstance", testUnregisterInstance},
+		{"UnknownInstance", testUnknownInstance},
+		{"UpdateInstanceState", testUpdateInstanceState},
+		{"UpdateResourceUsage", testUpdateResourceUsage},
+		{"UpdateIdleState", testUpdateIdleState},
+		{"UpdateLastHeartbeat", testUpdateLastHeartbeat},
+		{"ScheduledActions", testScheduledActions},
+		{"GetInstancesByState", testGetInstancesByState},
+		{"ConcurrentUpdates", testConcurrentUpdates},
+		{"Update", testUpdate},
+		{"CompareAndSwap", testCompareAndSwap},
+		{"Copies", testCopies},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore(t))
+		})
+	}
+}
+
+// registration returns a registration for a test instance
+func registration(instanceID string) protocol.InstanceRegistration {
+	return protocol.InstanceRegistration{
+		InstanceID:   instanceID,
+		InstanceType: "t3.medium",
+		Region:       "us-west-2",
+		Zone:         "us-west-2a",
+		Provider:     "aws",
+		Metadata:     map[string]string{"schedule": "daily 22:00-07:00"},
+		Thresholds:   map[string]float64{"cpu": 10},
+		NapTime:      30 * time.Minute,
+	}
+}
+
+// register registers a test instance
+func register(t *testing.T, s store.Store, instanceID string) {
+	t.Helper()
+	if err := s.RegisterInstance(registration(instanceID)); err != nil {
+		t.Fatalf("Failed to register instance: %v", err)
+	}
+}
+
+// get gets an instance that must exist
+func get(t *testing.T, s store.Store, instanceID string) *store.InstanceState {
+	t.Helper()
+	instance, err := s.GetInstance(instanceID)
+	if err != nil {
+		t.Fatalf("Failed to get instance: %v", err)
+	}
+	return instance
+}
+
+func testRegisterInstance(t *testing.T, s store.Store) {
+	before := time.Now()
+	register(t, s, "i-1")
+
+	instance := get(t, s, "i-1")
+	if instance.InstanceID != "i-1" {
+		t.Errorf("Expected instance ID i-1, got %s", instance.InstanceID)
+	}
+	if instance.State != "running" {
+		t.Errorf("Expected state running, got %s", instance.State)
+	}
+	if instance.LastHeartbeat.Before(before.Add(-time.Second)) {
+		t.Errorf("Expected a heartbeat at registration, got %s", instance.LastHeartbeat)
+	}
+	if len(instance.ResourceUsage) != 0 || len(instance.ScheduledActions) != 0 {
+		t.Errorf("Expected no resource usage or actions, got %v %v", instance.ResourceUsage, instance.ScheduledActions)
+	}
+
+	want := registration("i-1")
+	got := instance.Registration
+	if got.InstanceType != want.InstanceType || got.Region != want.Region || got.Zone != want.Zone ||
+		got.Provider != want.Provider || got.NapTime != want.NapTime {
+		t.Errorf("Expected registration %+v, got %+v", want, got)
+	}
+	if got.Metadata["schedule"] != want.Metadata["schedule"] || got.Thresholds["cpu"] != want.Thresholds["cpu"] {
+		t.Errorf("Expected metadata and thresholds to be kept, got %v %v", got.Metadata, got.Thresholds)
+	}
+
+	// Registering again starts over
+	if err := s.UpdateInstanceState("i-1", "idle"); err != nil {
+		t.Fatalf("Failed to update state: %v", err)
+	}
+	register(t, s, "i-1")
+	if state := get(t, s, "i-1").State; state != "running" {
+		t.Errorf("Expected state running after registering again, got %s", state)
+	}
+}
+
+func testUnregisterInstance(t *testing.T, s store.Store) {
+	register(t, s, "i-1")
+	register(t, s, "i-2")
+
+	if err := s.UnregisterInstance("i-1"); err != nil {
+		t.Fatalf("Failed to unregister instance: %v", err)
+	}
+	if _, err := s.GetInstance("i-1"); err == nil {
+		t.Errorf("Expected an error getting an unregistered instance")
+	}
+	get(t, s, "i-2")
+
+	instances, err := s.GetAllInstances()
+	if err != nil {
+		t.Fatalf("Failed to get instances: %v", err)
+	}
+	if len(instances) != 1 || instances["i-2"] == nil {
+		t.Errorf("Expected only i-2, got %v", instances)
+	}
+
+	// Unregistering an unknown instance isn't an error
+	if err := s.UnregisterInstance("i-unknown"); err != nil {
+		t.Errorf("Expected no error unregistering an unknown instance, got %v", err)
+	}
+}
+
+func testUnknownInstance(t *testing.T, s store.Store) {
+	checks := map[string]error{
+		"GetInstance": func() error {
+			_, err := s.GetInstance("i-unknown")
+			return err
+		}(),
+		"UpdateInstanceState":   s.UpdateInstanceState("i-unknown", "idle"),
+		"UpdateResourceUsage":   s.UpdateResourceUsage("i-unknown", map[string]float64{"cpu": 1}),
+		"UpdateIdleState":       s.UpdateIdleState("i-unknown", true, time.Now(), time.Minute),
+		"UpdateLastHeartbeat":   s.UpdateLastHeartbeat("i-unknown", time.Now()),
+		"AddScheduledAction":    s.AddScheduledAction("i-unknown", protocol.ScheduledAction{Action: "stop"}),
+		"RemoveScheduledAction": s.RemoveScheduledAction("i-unknown", 0),
+	}
+	checks["Update"] = s.Update("i-unknown", func(*store.InstanceState) error { return nil })
+	checks["CompareAndSwap"] = s.CompareAndSwap(&store.InstanceState{InstanceID: "i-unknown"})
+	for method, err := range checks {
+		if !errors.Is(err, store.ErrInstanceNotFound) {
+			t.Errorf("Expected %s to fail with ErrInstanceNotFound, got %v", method, err)
+		}
+	}
+
+	instances, err := s.GetAllInstances()
+	if err != nil {
+		t.Fatalf("Failed to get instances: %v", err)
+	}
+	if len(instances) != 0 {
+		t.Errorf("Expected no instances, got %d", len(instances))
+	}
+}
+
+func testUpdateInstanceState(t *testing.T, s store.Store) {
+	register(t, s, "i-1")
+
+	for _, state := range []string{"idle", "stopping", "stopped"} {
+		if err := s.UpdateInstanceState("i-1", state); err != nil {
+			t.Fatalf("Failed to update state: %v", err)
+		}
+		if got := get(t, s, "i-1").State; got != state {
+			t.Errorf("Expected state %s, got %s", state, got)
+		}
+	}
+}
+
+func testUpdateResourceUsage(t *testing.T, s store.Store) {
+	register(t, s, "i-1")
+
+	if err := s.UpdateResourceUsage("i-1", map[string]float64{"cpu": 12.5, "memory": 40}); err != nil {
+		t.Fatalf("Failed to update resource usage: %v", err)
+	}
+	if err := s.UpdateResourceUsage("i-1", map[string]float64{"cpu": 3}); err != nil {
+		t.Fatalf("Failed to update resource usage: %v", err)
+	}
+
+	// Each report replaces the previous one
+	usage := get(t, s, "i-1").ResourceUsage
+	if len(usage) != 1 || usage["cpu"] != 3 {
+		t.Errorf("Expected only the latest usage, got %v", usage)
+	}
+}
+
+func testUpdateIdleState(t *testing.T, s store.Store) {
+	register(t, s, "i-1")
+
+	since := time.Date(2025, 7, 2, 12, 0, 0, 0, time.UTC)
+	if err := s.UpdateIdleState("i-1", true, since, 15*time.Minute); err != nil {
+		t.Fatalf("Failed to update idle state: %v", err)
+	}
+	instance := get(t, s, "i-1")
+	if !instance.IdleSince.Equal(since) || instance.IdleDuration != 15*time.Minute {
+		t.Errorf("Expected idle since %s for 15m, got %s for %s", since, instance.IdleSince, instance.IdleDuration)
+	}
+
+	// Becoming active clears the idle time
+	if err := s.UpdateIdleState("i-1", false, since, 15*time.Minute); err != nil {
+		t.Fatalf("Failed to update idle state: %v", err)
+	}
+	instance = get(t, s, "i-1")
+	if !instance.IdleSince.IsZero() || instance.IdleDuration != 0 {
+		t.Errorf("Expected no idle time, got %s for %s", instance.IdleSince, instance.IdleDuration)
+	}
+}
+
+func testUpdateLastHeartbeat(t *testing.T, s store.Store) {
+	register(t, s, "i-1")
+
+	heartbeat := time.Date(2025, 7, 2, 12, 30, 0, 0, time.UTC)
+	if err := s.UpdateLastHeartbeat("i-1", heartbeat); err != nil {
+		t.Fatalf("Failed to update heartbeat: %v", err)
+	}
+	if got := get(t, s, "i-1").LastHeartbeat; !got.Equal(heartbeat) {
+		t.Errorf("Expected heartbeat %s, got %s", heartbeat, got)
+	}
+}
+
+func testScheduledActions(t *testing.T, s store.Store) {
+	register(t, s, "i-1")
+
+	at := time.Date(2025, 7, 2, 22, 0, 0, 0, time.UTC)
+	for i, action := range []string{"stop", "start", "hibernate"} {
+		err := s.AddScheduledAction("i-1", protocol.ScheduledAction{
+			Action:        action,
+			ScheduledTime: at.Add(time.Duration(i) * time.Hour),
+			Reason:        "test",
+		})
+		if err != nil {
+			t.Fatalf("Failed to add action: %v", err)
+		}
+	}
+
+	for _, index := range []int{-1, 3} {
+		if err := s.RemoveScheduledAction("i-1", index); err == nil {
+			t.Errorf("Expected an error removing action %d", index)
+		}
+	}
+
+	if err := s.RemoveScheduledAction("i-1", 1); err != nil {
+		t.Fatalf("Failed to remove action: %v", err)
+	}
+
+	actions := get(t, s, "i-1").ScheduledActions
+	if len(actions) != 2 || actions[0].Action != "stop" || actions[1].Action != "hibernate" {
+		t.Fatalf("Expected stop and hibernate in order, got %+v", actions)
+	}
+	if !actions[1].ScheduledTime.Equal(at.Add(2*time.Hour)) || actions[1].Reason != "test" {
+		t.Errorf("Expected the action to be kept, got %+v", actions[1])
+	}
+}
+
+func testGetInstancesByState(t *testing.T, s store.Store) {
+	register(t, s, "i-1")
+	register(t, s, "i-2")
+	register(t, s, "i-3")
+	if err := s.UpdateInstanceState("i-2", "idle"); err != nil {
+		t.Fatalf("Failed to update state: %v", err)
+	}
+
+	idle, err := s.GetInstancesByState("idle")
+	if err != nil {
+		t.Fatalf("Failed to get instances: %v", err)
+	}
+	if len(idle) != 1 || idle["i-2"] == nil {
+		t.Errorf("Expected only i-2 to be idle, got %v", idle)
+	}
+
+	running, err := s.GetInstancesByState("running")
+	if err != nil {
+		t.Fatalf("Failed to get instances: %v", err)
+	}
+	if len(running) != 2 || running["i-1"] == nil || running["i-3"] == nil {
+		t.Errorf("Expected i-1 and i-3 to be running, got %v", running)
+	}
+
+	stopped, err := s.GetInstancesByState("stopped")
+	if err != nil {
+		t.Fatalf("Failed to get instances: %v", err)
+	}
+	if len(stopped) != 0 {
+		t.Errorf("Expected no stopped instances, got %v", stopped)
+	}
+}
+
+func testConcurrentUpdates(t *testing.T, s store.Store) {
+	const instances = 4
+	const updates = 20
+
+	for i := 0; i < instances; i++ {
+		register(t, s, fmt.Sprintf("i-%d", i))
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < instances; i++ {
+		wg.Add(1)
+		go func(instanceID string) {
+			defer wg.Done()
+			for j := 0; j < updates; j++ {
+				s.UpdateResourceUsage(instanceID, map[string]float64{"cpu": float64(j)})
+				s.UpdateLastHeartbeat(instanceID, time.Now())
+				s.AddScheduledAction(instanceID, protocol.ScheduledAction{Action: "stop"})
+				s.GetAllInstances()
+			}
+		}(fmt.Sprintf("i-%d", i))
+	}
+	wg.Wait()
+
+	for i := 0; i < instances; i++ {
+		instance := get(t, s, fmt.Sprintf("i-%d", i))
+		if len(instance.ScheduledActions) != updates {
+			t.Errorf("Expected %d actions for %s, got %d", updates, instance.InstanceID, len(instance.ScheduledActions))
+		}
+		if instance.ResourceUsage["cpu"] != updates-1 {
+			t.Errorf("Expected the last usage report for %s, got %v", instance.InstanceID, instance.ResourceUsage)
+		}
+	}
+}
+
+func testUpdate(t *testing.T, s store.Store) {
+	register(t, s, "i-1")
+	before := get(t, s, "i-1")
+
+	err := s.Update("i-1", func(instance *store.InstanceState) error {
+		instance.State = "idle"
+		instance.IdleSince = time.Date(2025, 7, 2, 12, 0, 0, 0, time.UTC)
+		instance.ScheduledActions = append(instance.ScheduledActions, protocol.ScheduledAction{Action: "stop"})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to update instance: %v", err)
+	}
+
+	after := get(t, s, "i-1")
+	if after.State != "idle" || after.IdleSince.IsZero() || len(after.ScheduledActions) != 1 {
+		t.Errorf("Expected all changes to be applied, got %+v", after)
+	}
+	if after.Revision == before.Revision {
+		t.Errorf("Expected the revision to change, got %d", after.Revision)
+	}
+
+	// A failed update changes nothing
+	failure := errors.New("failed")
+	err = s.Update("i-1", func(instance *store.InstanceState) error {
+		instance.State = "stopped"
+		instance.ScheduledActions = nil
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("Expected the update's error, got %v", err)
+	}
+	unchanged := get(t, s, "i-1")
+	if unchanged.State != "idle" || len(unchanged.ScheduledActions) != 1 || unchanged.Revision != after.Revision {
+		t.Errorf("Expected a failed update not to apply, got %+v", unchanged)
+	}
+
+	// Every change moves the revision on
+	revisions := map[uint64]bool{before.Revision: true, after.Revision: true}
+	for _, update := range []func() error{
+		func() error { return s.UpdateInstanceState("i-1", "running") },
+		func() error { return s.UpdateLastHeartbeat("i-1", time.Now()) },
+		func() error { return s.UpdateResourceUsage("i-1", map[string]float64{"cpu": 1}) },
+		func() error { return s.UpdateIdleState("i-1", false, time.Time{}, 0) },
+		func() error { return s.RemoveScheduledAction("i-1", 0) },
+	} {
+		if err := update(); err != nil {
+			t.Fatalf("Failed to update instance: %v", err)
+		}
+		revision := get(t, s, "i-1").Revision
+		if revisions[revision] {
+			t.Errorf("Expected a new revision, got %d again", revision)
+		}
+		revisions[revision] = true
+	}
+
+	// Updates are atomic
+	const workers = 4
+	const increments = 25
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < increments; j++ {
+				s.Update("i-1", func(instance *store.InstanceState) error {
+					instance.ResourceUsage["count"]++
+					return nil
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	if count := get(t, s, "i-1").ResourceUsage["count"]; count != workers*increments {
+		t.Errorf("Expected %d increments, got %v", workers*increments, count)
+	}
+}
+
+func testCompareAndSwap(t *testing.T, s store.Store) {
+	register(t, s, "i-1")
+
+	first := get(t, s, "i-1")
+	second := get(t, s, "i-1")
+
+	first.State = "idle"
+	if err := s.CompareAndSwap(first); err != nil {
+		t.Fatalf("Failed to swap instance: %v", err)
+	}
+	if stored := get(t, s, "i-1"); stored.State != "idle" || stored.Revision != first.Revision {
+		t.Errorf("Expected the swapped instance at revision %d, got %+v", first.Revision, stored)
+	}
+
+	// The second copy is out of date
+	second.State = "stopped"
+	if err := s.CompareAndSwap(second); !errors.Is(err, store.ErrConflict) {
+		t.Errorf("Expected ErrConflict, got %v", err)
+	}
+	if state := get(t, s, "i-1").State; state != "idle" {
+		t.Errorf("Expected the conflicting swap not to apply, got %s", state)
+	}
+
+	// The copy can be swapped again with its new revision
+	first.State = "stopping"
+	if err := s.CompareAndSwap(first); err != nil {
+		t.Errorf("Failed to swap instance again: %v", err)
+	}
+
+	// Any change invalidates earlier copies
+	stale := get(t, s, "i-1")
+	if err := s.UpdateLastHeartbeat("i-1", time.Now()); err != nil {
+		t.Fatalf("Failed to update heartbeat: %v", err)
+	}
+	if err := s.CompareAndSwap(stale); !errors.Is(err, store.ErrConflict) {
+		t.Errorf("Expected ErrConflict after an update, got %v", err)
+	}
+
+	// So does registering the instance again
+	register(t, s, "i-1")
+	if err := s.CompareAndSwap(first); !errors.Is(err, store.ErrConflict) {
+		t.Errorf("Expected ErrConflict after registering again, got %v", err)
+	}
+}
+
+func testCopies(t *testing.T, s store.Store) {
+	reg := registration("i-1")
+	if err := s.RegisterInstance(reg); err != nil {
+		t.Fatalf("Failed to register instance: %v", err)
+	}
+	usage := map[string]float64{"cpu": 5}
+	if err := s.UpdateResourceUsage("i-1", usage); err != nil {
+		t.Fatalf("Failed to update resource usage: %v", err)
+	}
+	if err := s.AddScheduledAction("i-1", protocol.ScheduledAction{Action: "stop", Approvals: []string{"alice"}}); err != nil {
+		t.Fatalf("Failed to add action: %v", err)
+	}
+
+	// Changing what was passed in doesn't change the store
+	reg.Metadata["schedule"] = "changed"
+	usage["cpu"] = 99
+
+	// Nor does changing what was returned
+	instance := get(t, s, "i-1")
+	instance.State = "changed"
+	instance.Registration.Thresholds["cpu"] = 99
+	instance.ResourceUsage["memory"] = 99
+	instance.ScheduledActions[0].Action = "changed"
+	instance.ScheduledActions[0].Approvals[0] = "changed"
+
+	all, err := s.GetAllInstances()
+	if err != nil {
+		t.Fatalf("Failed to get instances: %v", err)
+	}
+	all["i-1"].Registration.Metadata["schedule"] = "changed"
+	byState, err := s.GetInstancesByState("running")
+	if err != nil {
+		t.Fatalf("Failed to get instances: %v", err)
+	}
+	byState["i-1"].ResourceUsage["cpu"] = 99
+
+	// Nor does keeping the instance passed to an update
+	var kept *store.InstanceState
+	s.Update("i-1", func(instance *store.InstanceState) error {
+		kept = instance
+		return nil
+	})
+	kept.ScheduledActions[0].Action = "changed"
+
+	stored := get(t, s, "i-1")
+	if stored.State != "running" || stored.Registration.Metadata["schedule"] == "changed" ||
+		stored.Registration.Thresholds["cpu"] != 10 {
+		t.Errorf("Expected the registration to be unchanged, got %+v", stored)
+	}
+	if len(stored.ResourceUsage) != 1 || stored.ResourceUsage["cpu"] != 5 {
+		t.Errorf("Expected the resource usage to be unchanged, got %v", stored.ResourceUsage)
+	}
+	if stored.ScheduledActions[0].Action != "stop" || stored.ScheduledActions[0].Approvals[0] != "alice" {
+		t.Errorf("Expected the scheduled action to be unchanged, got %+v", stored.ScheduledActions)
+	}
+}
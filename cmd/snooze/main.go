@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
@@ -8,6 +9,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol/gen"
 	"github.com/scttfrdmn/snoozebot/pkg/core"
 )
 
@@ -89,7 +92,7 @@ func printUsage() {
 	fmt.Println("  start         Start the snooze daemon")
 	fmt.Println("  stop          Stop the snooze daemon")
 	fmt.Println("  restart       Restart the snooze daemon")
-	fmt.Println("  history       Show snooze history")
+	fmt.Println("  history       Show the instance history kept by the agent")
 	fmt.Println("  help          Show this help message")
 	fmt.Println("")
 	fmt.Println("Run 'snooze COMMAND --help' for more information on a command.")
@@ -222,10 +225,96 @@ func restartDaemon() {
 }
 
 func history(args []string) {
-	// In a real implementation, this would read the daemon's history log
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	agentAddr := flags.String("agent", "localhost:8081", "Address of the agent's gRPC API")
+	instanceID := flags.String("instance", "", "ID of the instance to show the history of")
+	since := flags.Duration("since", 7*24*time.Hour, "How far back to show the history (0 for all of it)")
+	limit := flags.Int("limit", 100, "Most events to show (0 for no limit)")
+	flags.Parse(args)
+
+	if *instanceID == "" {
+		fmt.Println("Error: 'history' command requires --instance")
+		flags.Usage()
+		os.Exit(ExitError)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	client := protocol.NewAgentClient(*agentAddr, *instanceID)
+	if err := client.Connect(ctx); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(ExitError)
+	}
+	defer client.Disconnect()
+
+	var from time.Time
+	if *since > 0 {
+		from = time.Now().Add(-*since)
+	}
+
 	fmt.Println("Snooze History:")
-	fmt.Println("  2023-06-01 23:15:02 - Instance stopped after 1h30m of inactivity")
-	fmt.Println("  2023-06-02 09:32:18 - Instance started by AWS API call")
-	fmt.Println("  2023-06-02 18:45:33 - Instance stopped after 2h15m of inactivity")
-	fmt.Println("  2023-06-03 10:12:05 - Instance started by AWS API call")
+	shown := 0
+	pageToken := ""
+	for {
+		events, next, err := client.GetHistory(ctx, from, time.Time{}, 0, pageToken)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(ExitError)
+		}
+
+		for _, event := range events {
+			if *limit > 0 && shown == *limit {
+				return
+			}
+			fmt.Printf("  %s - %s\n", time.Unix(event.Timestamp, 0).Format("2006-01-02 15:04:05"), describeEvent(event))
+			shown++
+		}
+
+		if next == "" {
+			break
+		}
+		pageToken = next
+	}
+
+	if shown == 0 {
+		fmt.Println("  No events")
+	}
+}
+
+// describeEvent returns a line describing a history event
+func describeEvent(event *gen.HistoryEvent) string {
+	action := event.Action
+	if event.Reason != "" {
+		action = fmt.Sprintf("%s (%s)", event.Action, event.Reason)
+	}
+
+	switch event.Type {
+	case "registered":
+		return "Instance registered with the agent"
+	case "unregistered":
+		return "Instance unregistered from the agent"
+	case "idle_started":
+		return "Instance became idle"
+	case "idle_ended":
+		return fmt.Sprintf("Instance became active after %s idle", time.Duration(event.Timestamp-event.IdleSince)*time.Second)
+	case "action_scheduled":
+		return fmt.Sprintf("Scheduled %s for %s", action, time.Unix(event.ScheduledTime, 0).Format("2006-01-02 15:04:05"))
+	case "action_approved":
+		return fmt.Sprintf("%s approved %s", event.Approver, event.Action)
+	case "action_started":
+		return fmt.Sprintf("Started %s", event.Action)
+	case "action_executed":
+		return fmt.Sprintf("Carried out %s", event.Action)
+	case "action_retrying":
+		return fmt.Sprintf("Failed to carry out %s, retrying: %s", event.Action, event.Error)
+	case "action_failed":
+		return fmt.Sprintf("Failed to carry out %s: %s", event.Action, event.Error)
+	case "action_cancelled":
+		return fmt.Sprintf("Cancelled %s", event.Action)
+	case "state_changed":
+		return fmt.Sprintf("State changed from %s to %s", event.PreviousState, event.State)
+	default:
+		return event.Type
+	}
 }
\ No newline at end of file
@@ -12,6 +12,8 @@ import (
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/scttfrdmn/snoozebot/pkg/core"
+	"github.com/scttfrdmn/snoozebot/pkg/monitor"
+	"github.com/scttfrdmn/snoozebot/pkg/monitor/resources"
 	"github.com/scttfrdmn/snoozebot/pkg/plugin"
 )
 
@@ -20,6 +22,10 @@ func main() {
 	pluginsDir := flag.String("plugins-dir", "/etc/snoozebot/plugins", "Directory containing plugins")
 	configFile := flag.String("config", "/etc/snoozebot/config.json", "Path to configuration file")
 	logLevel := flag.String("log-level", "info", "Log level (trace, debug, info, warn, error)")
+	inhibitSocket := flag.String("inhibit-socket", monitor.DefaultInhibitSocket, "Unix socket for keep-awake locks (empty to disable)")
+	execMonitors := flag.String("exec-monitors", "/etc/snoozebot/monitors.json", "JSON file declaring custom resources measured by commands")
+	stateFile := flag.String("state-file", monitor.DefaultStateFile, "File that keeps the idle clock across restarts (empty to disable)")
+	userSessions := flag.Bool("user-sessions", true, "Keep the system awake while an SSH or terminal session has recent input")
 	flag.Parse()
 
 	// Set up logger
@@ -54,19 +60,89 @@ func main() {
 	// In a real implementation, we would parse the config file here
 	logger.Info("Using configuration", "napTime", monitorConfig.NapTime, "checkInterval", monitorConfig.CheckInterval)
 
+	// Custom resources measured by external commands
+	if _, err := os.Stat(*execMonitors); err == nil {
+		configs, err := resources.LoadExecMonitorConfigs(*execMonitors)
+		if err != nil {
+			logger.Error("Failed to load exec monitors", "error", err)
+			os.Exit(1)
+		}
+
+		monitorConfig.CustomMonitors = make(map[core.ResourceType]func() (float64, error))
+		for _, config := range configs {
+			execMonitor, err := resources.NewExecMonitor(config)
+			if err != nil {
+				logger.Error("Failed to create exec monitor", "name", config.Name, "error", err)
+				os.Exit(1)
+			}
+			resourceType := core.ResourceType(config.Name)
+			monitorConfig.CustomMonitors[resourceType] = execMonitor.GetUsage
+			monitorConfig.Thresholds[resourceType] = config.Threshold
+			logger.Info("Added exec monitor", "name", config.Name, "command", config.Command, "threshold", config.Threshold)
+		}
+	}
+
+	// Interactive SSH and terminal sessions hold the system active
+	if *userSessions {
+		sessionsMonitor, err := resources.NewUserSessionsMonitor()
+		if err != nil {
+			logger.Error("Failed to create user sessions monitor", "error", err)
+			os.Exit(1)
+		}
+		if monitorConfig.CustomMonitors == nil {
+			monitorConfig.CustomMonitors = make(map[core.ResourceType]func() (float64, error))
+		}
+		resourceType := core.ResourceType(resources.UserSessions)
+		monitorConfig.CustomMonitors[resourceType] = sessionsMonitor.GetUsage
+		monitorConfig.Thresholds[resourceType] = 0
+	}
+
+	// Keep-awake locks taken through the inhibitor socket hold the system active
+	inhibitors := monitor.NewInhibitors()
+	monitorConfig.KeepAwake = func() bool {
+		return len(inhibitors.Held()) > 0
+	}
+
+	// Resume the idle clock across daemon restarts, but not across reboots
+	if *stateFile != "" {
+		state, err := monitor.LoadState(*stateFile)
+		if err != nil {
+			logger.Warn("Discarding saved idle state", "file", *stateFile, "error", err)
+		} else if state != nil && !state.IdleSince.IsZero() {
+			logger.Info("Resuming idle clock", "idleSince", state.IdleSince)
+			monitorConfig.IdleSince = state.IdleSince
+		}
+
+		monitorConfig.OnIdleChange = func(idleSince time.Time) {
+			if err := monitor.SaveState(*stateFile, monitor.PersistedState{IdleSince: idleSince}); err != nil {
+				logger.Error("Failed to save idle state", "file", *stateFile, "error", err)
+			}
+		}
+	}
+
 	// Create the resource monitor
-	monitor := core.NewLinuxResourceMonitor(monitorConfig)
+	resourceMonitor := core.NewLinuxResourceMonitor(monitorConfig)
 
 	// Create a context that can be cancelled
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// Start the resource monitor
-	if err := monitor.Start(ctx); err != nil {
+	if err := resourceMonitor.Start(ctx); err != nil {
 		logger.Error("Failed to start resource monitor", "error", err)
 		os.Exit(1)
 	}
-	defer monitor.Stop()
+	defer resourceMonitor.Stop()
+
+	// Serve the inhibitor API
+	if *inhibitSocket != "" {
+		go func() {
+			logger.Info("Serving keep-awake locks", "socket", *inhibitSocket)
+			if err := monitor.NewInhibitServer(inhibitors).ListenAndServe(ctx, *inhibitSocket); err != nil {
+				logger.Error("Failed to serve keep-awake locks", "error", err)
+			}
+		}()
+	}
 
 	// Load the AWS plugin if available
 	awsPluginName := "aws"
@@ -2,6 +2,8 @@ module github.com/scttfrdmn/snoozebot
 
 go 1.24.2
 
+require gopkg.in/yaml.v2 v2.4.0
+
 require (
 	github.com/Azure/azure-sdk-for-go/sdk/azcore v1.18.0 // indirect
 	github.com/Azure/azure-sdk-for-go/sdk/azidentity v1.9.0 // indirect
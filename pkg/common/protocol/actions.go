@@ -0,0 +1,85 @@
+package protocol
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// ActionStatus is the state of a scheduled action
+type ActionStatus string
+
+const (
+	// ActionPending actions are waiting for their scheduled time or a retry
+	ActionPending ActionStatus = "pending"
+	// ActionRunning actions are being carried out
+	ActionRunning ActionStatus = "running"
+	// ActionSucceeded actions were carried out
+	ActionSucceeded ActionStatus = "succeeded"
+	// ActionFailed actions ran out of attempts
+	ActionFailed ActionStatus = "failed"
+	// ActionCancelled actions were cancelled before they succeeded
+	ActionCancelled ActionStatus = "cancelled"
+)
+
+// Replies from an instance asked to prepare for a stop, reported as its
+// state once its pre-stop hooks have run
+const (
+	// StopReplyStopping means the instance is ready to be stopped
+	StopReplyStopping = "stopping"
+	// StopReplyVetoed means a pre-stop hook vetoed the stop
+	StopReplyVetoed = "stop_vetoed"
+)
+
+// NewActionID returns a random ID for a scheduled action
+func NewActionID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// CurrentStatus returns the status of the action, treating an empty status
+// as pending
+func (a ScheduledAction) CurrentStatus() ActionStatus {
+	if a.Status == "" {
+		return ActionPending
+	}
+	return a.Status
+}
+
+// Finished reports whether the action succeeded, failed or was cancelled
+func (a ScheduledAction) Finished() bool {
+	switch a.CurrentStatus() {
+	case ActionSucceeded, ActionFailed, ActionCancelled:
+		return true
+	}
+	return false
+}
+
+// Approved reports whether the action has the approvals it needs
+func (a ScheduledAction) Approved() bool {
+	return len(a.Approvals) >= a.RequiredApprovals
+}
+
+// Due reports whether a pending, approved action should be tried at the given time
+func (a ScheduledAction) Due(now time.Time) bool {
+	return a.CurrentStatus() == ActionPending && a.Approved() && !now.Before(a.ScheduledTime) && !now.Before(a.NextAttempt)
+}
+
+// StopsInstance reports whether the action stops the instance
+func (a ScheduledAction) StopsInstance() bool {
+	switch a.Action {
+	case StageStop, StageHibernate, StageDeallocate:
+		return true
+	}
+	return false
+}
+
+// Requested reports whether the instance has been asked to prepare for the
+// action and hasn't replied yet
+func (a ScheduledAction) Requested() bool {
+	return !a.RequestedAt.IsZero() && a.Reply == ""
+}
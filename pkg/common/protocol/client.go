@@ -91,7 +91,7 @@ func (c *AgentClient) IsConnected() bool {
 
 // RegisterInstance registers an instance with the agent
 func (c *AgentClient) RegisterInstance(ctx context.Context, instanceType, region, zone, provider string, 
-	thresholds map[string]float64, napTime time.Duration) error {
+	thresholds map[string]float64, napTime time.Duration, metadata map[string]string) error {
 	
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
@@ -115,6 +115,7 @@ func (c *AgentClient) RegisterInstance(ctx context.Context, instanceType, region
 		Provider:     provider,
 		Thresholds:   protoThresholds,
 		NapTime:      int64(napTime.Seconds()),
+		Metadata:     metadata,
 	}
 
 	// Send the request
@@ -255,4 +256,43 @@ func (c *AgentClient) ReportStateChange(ctx context.Context, previousState,
 	}
 
 	return nil
+}
+
+// GetHistory gets a page of the events recorded for the instance between
+// since and until, oldest first. Zero times don't limit the events. The
+// returned page token gets the next page, and is empty on the last page.
+func (c *AgentClient) GetHistory(ctx context.Context, since, until time.Time,
+	pageSize int, pageToken string) ([]*gen.HistoryEvent, string, error) {
+	
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if !c.connected {
+		return nil, "", fmt.Errorf("not connected to agent")
+	}
+
+	// Prepare the request
+	req := &gen.InstanceHistoryRequest{
+		InstanceId: c.instanceID,
+		PageSize:   int32(pageSize),
+		PageToken:  pageToken,
+	}
+	if !since.IsZero() {
+		req.Since = since.Unix()
+	}
+	if !until.IsZero() {
+		req.Until = until.Unix()
+	}
+
+	// Send the request
+	resp, err := c.client.GetInstanceHistory(ctx, req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get history: %w", err)
+	}
+
+	if resp.Error != "" {
+		return nil, "", fmt.Errorf("failed to get history: %s", resp.Error)
+	}
+
+	return resp.Events, resp.NextPageToken, nil
 }
\ No newline at end of file
@@ -365,6 +365,8 @@ type IdleNotificationResponse struct {
 	Action          string                 `protobuf:"bytes,1,opt,name=action,proto3" json:"action,omitempty"` // "none", "wait", "stop"
 	Reason          string                 `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
 	ScheduledAction *ScheduledAction       `protobuf:"bytes,3,opt,name=scheduled_action,json=scheduledAction,proto3" json:"scheduled_action,omitempty"`
+	Policy          string                 `protobuf:"bytes,4,opt,name=policy,proto3" json:"policy,omitempty"` // idle policy that matched the instance
+	Trace           []string               `protobuf:"bytes,5,rep,name=trace,proto3" json:"trace,omitempty"`   // how the policy was chosen
 	unknownFields   protoimpl.UnknownFields
 	sizeCache       protoimpl.SizeCache
 }
@@ -420,6 +422,20 @@ func (x *IdleNotificationResponse) GetScheduledAction() *ScheduledAction {
 	return nil
 }
 
+func (x *IdleNotificationResponse) GetPolicy() string {
+	if x != nil {
+		return x.Policy
+	}
+	return ""
+}
+
+func (x *IdleNotificationResponse) GetTrace() []string {
+	if x != nil {
+		return x.Trace
+	}
+	return nil
+}
+
 // ScheduledAction represents an action scheduled for an instance
 type ScheduledAction struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -1385,6 +1401,285 @@ func (x *ListCloudProvidersResponse) GetProviders() []*CloudProviderInfo {
 	return nil
 }
 
+// InstanceHistoryRequest is the request for the history of an instance
+type InstanceHistoryRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	InstanceId    string                 `protobuf:"bytes,1,opt,name=instance_id,json=instanceId,proto3" json:"instance_id,omitempty"`
+	Since         int64                  `protobuf:"varint,2,opt,name=since,proto3" json:"since,omitempty"` // unix timestamp, 0 for no limit
+	Until         int64                  `protobuf:"varint,3,opt,name=until,proto3" json:"until,omitempty"` // unix timestamp, 0 for no limit
+	PageSize      int32                  `protobuf:"varint,4,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	PageToken     string                 `protobuf:"bytes,5,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"` // next_page_token from the previous page
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InstanceHistoryRequest) Reset() {
+	*x = InstanceHistoryRequest{}
+	mi := &file_pkg_common_protocol_proto_agent_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InstanceHistoryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InstanceHistoryRequest) ProtoMessage() {}
+
+func (x *InstanceHistoryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_common_protocol_proto_agent_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InstanceHistoryRequest.ProtoReflect.Descriptor instead.
+func (*InstanceHistoryRequest) Descriptor() ([]byte, []int) {
+	return file_pkg_common_protocol_proto_agent_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *InstanceHistoryRequest) GetInstanceId() string {
+	if x != nil {
+		return x.InstanceId
+	}
+	return ""
+}
+
+func (x *InstanceHistoryRequest) GetSince() int64 {
+	if x != nil {
+		return x.Since
+	}
+	return 0
+}
+
+func (x *InstanceHistoryRequest) GetUntil() int64 {
+	if x != nil {
+		return x.Until
+	}
+	return 0
+}
+
+func (x *InstanceHistoryRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *InstanceHistoryRequest) GetPageToken() string {
+	if x != nil {
+		return x.PageToken
+	}
+	return ""
+}
+
+// HistoryEvent is an event in the history of an instance
+type HistoryEvent struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Sequence      uint64                 `protobuf:"varint,1,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	Timestamp     int64                  `protobuf:"varint,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"` // unix timestamp
+	Type          string                 `protobuf:"bytes,3,opt,name=type,proto3" json:"type,omitempty"`
+	PreviousState string                 `protobuf:"bytes,4,opt,name=previous_state,json=previousState,proto3" json:"previous_state,omitempty"`
+	State         string                 `protobuf:"bytes,5,opt,name=state,proto3" json:"state,omitempty"`
+	IdleSince     int64                  `protobuf:"varint,6,opt,name=idle_since,json=idleSince,proto3" json:"idle_since,omitempty"` // unix timestamp
+	Action        string                 `protobuf:"bytes,7,opt,name=action,proto3" json:"action,omitempty"`
+	ActionId      string                 `protobuf:"bytes,8,opt,name=action_id,json=actionId,proto3" json:"action_id,omitempty"`
+	ScheduledTime int64                  `protobuf:"varint,9,opt,name=scheduled_time,json=scheduledTime,proto3" json:"scheduled_time,omitempty"` // unix timestamp
+	Reason        string                 `protobuf:"bytes,10,opt,name=reason,proto3" json:"reason,omitempty"`
+	Error         string                 `protobuf:"bytes,11,opt,name=error,proto3" json:"error,omitempty"`
+	Policy        string                 `protobuf:"bytes,12,opt,name=policy,proto3" json:"policy,omitempty"`
+	Approver      string                 `protobuf:"bytes,13,opt,name=approver,proto3" json:"approver,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HistoryEvent) Reset() {
+	*x = HistoryEvent{}
+	mi := &file_pkg_common_protocol_proto_agent_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HistoryEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HistoryEvent) ProtoMessage() {}
+
+func (x *HistoryEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_common_protocol_proto_agent_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HistoryEvent.ProtoReflect.Descriptor instead.
+func (*HistoryEvent) Descriptor() ([]byte, []int) {
+	return file_pkg_common_protocol_proto_agent_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *HistoryEvent) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *HistoryEvent) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *HistoryEvent) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *HistoryEvent) GetPreviousState() string {
+	if x != nil {
+		return x.PreviousState
+	}
+	return ""
+}
+
+func (x *HistoryEvent) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *HistoryEvent) GetIdleSince() int64 {
+	if x != nil {
+		return x.IdleSince
+	}
+	return 0
+}
+
+func (x *HistoryEvent) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *HistoryEvent) GetActionId() string {
+	if x != nil {
+		return x.ActionId
+	}
+	return ""
+}
+
+func (x *HistoryEvent) GetScheduledTime() int64 {
+	if x != nil {
+		return x.ScheduledTime
+	}
+	return 0
+}
+
+func (x *HistoryEvent) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *HistoryEvent) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *HistoryEvent) GetPolicy() string {
+	if x != nil {
+		return x.Policy
+	}
+	return ""
+}
+
+func (x *HistoryEvent) GetApprover() string {
+	if x != nil {
+		return x.Approver
+	}
+	return ""
+}
+
+// InstanceHistoryResponse is a page of the history of an instance, oldest event first
+type InstanceHistoryResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Events        []*HistoryEvent        `protobuf:"bytes,1,rep,name=events,proto3" json:"events,omitempty"`
+	NextPageToken string                 `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"`
+	Error         string                 `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InstanceHistoryResponse) Reset() {
+	*x = InstanceHistoryResponse{}
+	mi := &file_pkg_common_protocol_proto_agent_proto_msgTypes[25]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InstanceHistoryResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InstanceHistoryResponse) ProtoMessage() {}
+
+func (x *InstanceHistoryResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pkg_common_protocol_proto_agent_proto_msgTypes[25]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InstanceHistoryResponse.ProtoReflect.Descriptor instead.
+func (*InstanceHistoryResponse) Descriptor() ([]byte, []int) {
+	return file_pkg_common_protocol_proto_agent_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *InstanceHistoryResponse) GetEvents() []*HistoryEvent {
+	if x != nil {
+		return x.Events
+	}
+	return nil
+}
+
+func (x *InstanceHistoryResponse) GetNextPageToken() string {
+	if x != nil {
+		return x.NextPageToken
+	}
+	return ""
+}
+
+func (x *InstanceHistoryResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
 var File_pkg_common_protocol_proto_agent_proto protoreflect.FileDescriptor
 
 const file_pkg_common_protocol_proto_agent_proto_rawDesc = "" +
@@ -1428,11 +1723,13 @@ const file_pkg_common_protocol_proto_agent_proto_rawDesc = "" +
 	"\x0eresource_usage\x18\x04 \x03(\v24.protocol.IdleNotificationRequest.ResourceUsageEntryR\rresourceUsage\x1a@\n" +
 	"\x12ResourceUsageEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
-	"\x05value\x18\x02 \x01(\x01R\x05value:\x028\x01\"\x90\x01\n" +
+	"\x05value\x18\x02 \x01(\x01R\x05value:\x028\x01\"\xbe\x01\n" +
 	"\x18IdleNotificationResponse\x12\x16\n" +
 	"\x06action\x18\x01 \x01(\tR\x06action\x12\x16\n" +
 	"\x06reason\x18\x02 \x01(\tR\x06reason\x12D\n" +
-	"\x10scheduled_action\x18\x03 \x01(\v2\x19.protocol.ScheduledActionR\x0fscheduledAction\"h\n" +
+	"\x10scheduled_action\x18\x03 \x01(\v2\x19.protocol.ScheduledActionR\x0fscheduledAction\x12\x16\n" +
+	"\x06policy\x18\x04 \x01(\tR\x06policy\x12\x14\n" +
+	"\x05trace\x18\x05 \x03(\tR\x05trace\"h\n" +
 	"\x0fScheduledAction\x12\x16\n" +
 	"\x06action\x18\x01 \x01(\tR\x06action\x12%\n" +
 	"\x0escheduled_time\x18\x02 \x01(\x03R\rscheduledTime\x12\x16\n" +
@@ -1511,13 +1808,42 @@ const file_pkg_common_protocol_proto_agent_proto_rawDesc = "" +
 	"\aversion\x18\x02 \x01(\tR\aversion\x12\x16\n" +
 	"\x06plugin\x18\x03 \x01(\tR\x06plugin\"W\n" +
 	"\x1aListCloudProvidersResponse\x129\n" +
-	"\tproviders\x18\x01 \x03(\v2\x1b.protocol.CloudProviderInfoR\tproviders2\xda\x06\n" +
+	"\tproviders\x18\x01 \x03(\v2\x1b.protocol.CloudProviderInfoR\tproviders\"\xa1\x01\n" +
+	"\x16InstanceHistoryRequest\x12\x1f\n" +
+	"\vinstance_id\x18\x01 \x01(\tR\n" +
+	"instanceId\x12\x14\n" +
+	"\x05since\x18\x02 \x01(\x03R\x05since\x12\x14\n" +
+	"\x05until\x18\x03 \x01(\x03R\x05until\x12\x1b\n" +
+	"\tpage_size\x18\x04 \x01(\x05R\bpageSize\x12\x1d\n" +
+	"\n" +
+	"page_token\x18\x05 \x01(\tR\tpageToken\"\xf6\x02\n" +
+	"\fHistoryEvent\x12\x1a\n" +
+	"\bsequence\x18\x01 \x01(\x04R\bsequence\x12\x1c\n" +
+	"\ttimestamp\x18\x02 \x01(\x03R\ttimestamp\x12\x12\n" +
+	"\x04type\x18\x03 \x01(\tR\x04type\x12%\n" +
+	"\x0eprevious_state\x18\x04 \x01(\tR\rpreviousState\x12\x14\n" +
+	"\x05state\x18\x05 \x01(\tR\x05state\x12\x1d\n" +
+	"\n" +
+	"idle_since\x18\x06 \x01(\x03R\tidleSince\x12\x16\n" +
+	"\x06action\x18\a \x01(\tR\x06action\x12\x1b\n" +
+	"\taction_id\x18\b \x01(\tR\bactionId\x12%\n" +
+	"\x0escheduled_time\x18\t \x01(\x03R\rscheduledTime\x12\x16\n" +
+	"\x06reason\x18\n" +
+	" \x01(\tR\x06reason\x12\x14\n" +
+	"\x05error\x18\v \x01(\tR\x05error\x12\x16\n" +
+	"\x06policy\x18\f \x01(\tR\x06policy\x12\x1a\n" +
+	"\bapprover\x18\r \x01(\tR\bapprover\"\x87\x01\n" +
+	"\x17InstanceHistoryResponse\x12.\n" +
+	"\x06events\x18\x01 \x03(\v2\x16.protocol.HistoryEventR\x06events\x12&\n" +
+	"\x0fnext_page_token\x18\x02 \x01(\tR\rnextPageToken\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error2\xb5\a\n" +
 	"\vSnoozeAgent\x12R\n" +
 	"\x10RegisterInstance\x12\x1e.protocol.InstanceRegistration\x1a\x1e.protocol.RegistrationResponse\x12O\n" +
 	"\x12UnregisterInstance\x12\x1b.protocol.UnregisterRequest\x1a\x1c.protocol.UnregisterResponse\x12]\n" +
 	"\x14SendIdleNotification\x12!.protocol.IdleNotificationRequest\x1a\".protocol.IdleNotificationResponse\x12H\n" +
 	"\rSendHeartbeat\x12\x1a.protocol.HeartbeatRequest\x1a\x1b.protocol.HeartbeatResponse\x12P\n" +
-	"\x11ReportStateChange\x12\x1c.protocol.StateChangeRequest\x1a\x1d.protocol.StateChangeResponse\x12V\n" +
+	"\x11ReportStateChange\x12\x1c.protocol.StateChangeRequest\x1a\x1d.protocol.StateChangeResponse\x12Y\n" +
+	"\x12GetInstanceHistory\x12 .protocol.InstanceHistoryRequest\x1a!.protocol.InstanceHistoryResponse\x12V\n" +
 	"\x0fGetInstanceInfo\x12 .protocol.GetInstanceInfoRequest\x1a!.protocol.GetInstanceInfoResponse\x12M\n" +
 	"\fStopInstance\x12\x1d.protocol.StopInstanceRequest\x1a\x1e.protocol.StopInstanceResponse\x12P\n" +
 	"\rStartInstance\x12\x1e.protocol.StartInstanceRequest\x1a\x1f.protocol.StartInstanceResponse\x12Q\n" +
@@ -1536,7 +1862,7 @@ func file_pkg_common_protocol_proto_agent_proto_rawDescGZIP() []byte {
 	return file_pkg_common_protocol_proto_agent_proto_rawDescData
 }
 
-var file_pkg_common_protocol_proto_agent_proto_msgTypes = make([]protoimpl.MessageInfo, 29)
+var file_pkg_common_protocol_proto_agent_proto_msgTypes = make([]protoimpl.MessageInfo, 32)
 var file_pkg_common_protocol_proto_agent_proto_goTypes = []any{
 	(*InstanceRegistration)(nil),       // 0: protocol.InstanceRegistration
 	(*RegistrationResponse)(nil),       // 1: protocol.RegistrationResponse
@@ -1561,50 +1887,56 @@ var file_pkg_common_protocol_proto_agent_proto_goTypes = []any{
 	(*ListCloudProvidersRequest)(nil),  // 20: protocol.ListCloudProvidersRequest
 	(*CloudProviderInfo)(nil),          // 21: protocol.CloudProviderInfo
 	(*ListCloudProvidersResponse)(nil), // 22: protocol.ListCloudProvidersResponse
-	nil,                                // 23: protocol.InstanceRegistration.ThresholdsEntry
-	nil,                                // 24: protocol.InstanceRegistration.MetadataEntry
-	nil,                                // 25: protocol.IdleNotificationRequest.ResourceUsageEntry
-	nil,                                // 26: protocol.HeartbeatRequest.ResourceUsageEntry
-	nil,                                // 27: protocol.Command.ParametersEntry
-	nil,                                // 28: protocol.CloudActionRequest.ParametersEntry
-	(*timestamppb.Timestamp)(nil),      // 29: google.protobuf.Timestamp
+	(*InstanceHistoryRequest)(nil),     // 23: protocol.InstanceHistoryRequest
+	(*HistoryEvent)(nil),               // 24: protocol.HistoryEvent
+	(*InstanceHistoryResponse)(nil),    // 25: protocol.InstanceHistoryResponse
+	nil,                                // 26: protocol.InstanceRegistration.ThresholdsEntry
+	nil,                                // 27: protocol.InstanceRegistration.MetadataEntry
+	nil,                                // 28: protocol.IdleNotificationRequest.ResourceUsageEntry
+	nil,                                // 29: protocol.HeartbeatRequest.ResourceUsageEntry
+	nil,                                // 30: protocol.Command.ParametersEntry
+	nil,                                // 31: protocol.CloudActionRequest.ParametersEntry
+	(*timestamppb.Timestamp)(nil),      // 32: google.protobuf.Timestamp
 }
 var file_pkg_common_protocol_proto_agent_proto_depIdxs = []int32{
-	23, // 0: protocol.InstanceRegistration.thresholds:type_name -> protocol.InstanceRegistration.ThresholdsEntry
-	24, // 1: protocol.InstanceRegistration.metadata:type_name -> protocol.InstanceRegistration.MetadataEntry
-	25, // 2: protocol.IdleNotificationRequest.resource_usage:type_name -> protocol.IdleNotificationRequest.ResourceUsageEntry
+	26, // 0: protocol.InstanceRegistration.thresholds:type_name -> protocol.InstanceRegistration.ThresholdsEntry
+	27, // 1: protocol.InstanceRegistration.metadata:type_name -> protocol.InstanceRegistration.MetadataEntry
+	28, // 2: protocol.IdleNotificationRequest.resource_usage:type_name -> protocol.IdleNotificationRequest.ResourceUsageEntry
 	6,  // 3: protocol.IdleNotificationResponse.scheduled_action:type_name -> protocol.ScheduledAction
-	26, // 4: protocol.HeartbeatRequest.resource_usage:type_name -> protocol.HeartbeatRequest.ResourceUsageEntry
+	29, // 4: protocol.HeartbeatRequest.resource_usage:type_name -> protocol.HeartbeatRequest.ResourceUsageEntry
 	9,  // 5: protocol.HeartbeatResponse.commands:type_name -> protocol.Command
-	27, // 6: protocol.Command.parameters:type_name -> protocol.Command.ParametersEntry
-	29, // 7: protocol.GetInstanceInfoResponse.launch_time:type_name -> google.protobuf.Timestamp
-	28, // 8: protocol.CloudActionRequest.parameters:type_name -> protocol.CloudActionRequest.ParametersEntry
+	30, // 6: protocol.Command.parameters:type_name -> protocol.Command.ParametersEntry
+	32, // 7: protocol.GetInstanceInfoResponse.launch_time:type_name -> google.protobuf.Timestamp
+	31, // 8: protocol.CloudActionRequest.parameters:type_name -> protocol.CloudActionRequest.ParametersEntry
 	21, // 9: protocol.ListCloudProvidersResponse.providers:type_name -> protocol.CloudProviderInfo
-	0,  // 10: protocol.SnoozeAgent.RegisterInstance:input_type -> protocol.InstanceRegistration
-	2,  // 11: protocol.SnoozeAgent.UnregisterInstance:input_type -> protocol.UnregisterRequest
-	4,  // 12: protocol.SnoozeAgent.SendIdleNotification:input_type -> protocol.IdleNotificationRequest
-	7,  // 13: protocol.SnoozeAgent.SendHeartbeat:input_type -> protocol.HeartbeatRequest
-	10, // 14: protocol.SnoozeAgent.ReportStateChange:input_type -> protocol.StateChangeRequest
-	12, // 15: protocol.SnoozeAgent.GetInstanceInfo:input_type -> protocol.GetInstanceInfoRequest
-	14, // 16: protocol.SnoozeAgent.StopInstance:input_type -> protocol.StopInstanceRequest
-	16, // 17: protocol.SnoozeAgent.StartInstance:input_type -> protocol.StartInstanceRequest
-	18, // 18: protocol.SnoozeAgent.PerformCloudAction:input_type -> protocol.CloudActionRequest
-	20, // 19: protocol.SnoozeAgent.ListCloudProviders:input_type -> protocol.ListCloudProvidersRequest
-	1,  // 20: protocol.SnoozeAgent.RegisterInstance:output_type -> protocol.RegistrationResponse
-	3,  // 21: protocol.SnoozeAgent.UnregisterInstance:output_type -> protocol.UnregisterResponse
-	5,  // 22: protocol.SnoozeAgent.SendIdleNotification:output_type -> protocol.IdleNotificationResponse
-	8,  // 23: protocol.SnoozeAgent.SendHeartbeat:output_type -> protocol.HeartbeatResponse
-	11, // 24: protocol.SnoozeAgent.ReportStateChange:output_type -> protocol.StateChangeResponse
-	13, // 25: protocol.SnoozeAgent.GetInstanceInfo:output_type -> protocol.GetInstanceInfoResponse
-	15, // 26: protocol.SnoozeAgent.StopInstance:output_type -> protocol.StopInstanceResponse
-	17, // 27: protocol.SnoozeAgent.StartInstance:output_type -> protocol.StartInstanceResponse
-	19, // 28: protocol.SnoozeAgent.PerformCloudAction:output_type -> protocol.CloudActionResponse
-	22, // 29: protocol.SnoozeAgent.ListCloudProviders:output_type -> protocol.ListCloudProvidersResponse
-	20, // [20:30] is the sub-list for method output_type
-	10, // [10:20] is the sub-list for method input_type
-	10, // [10:10] is the sub-list for extension type_name
-	10, // [10:10] is the sub-list for extension extendee
-	0,  // [0:10] is the sub-list for field type_name
+	24, // 10: protocol.InstanceHistoryResponse.events:type_name -> protocol.HistoryEvent
+	0,  // 11: protocol.SnoozeAgent.RegisterInstance:input_type -> protocol.InstanceRegistration
+	2,  // 12: protocol.SnoozeAgent.UnregisterInstance:input_type -> protocol.UnregisterRequest
+	4,  // 13: protocol.SnoozeAgent.SendIdleNotification:input_type -> protocol.IdleNotificationRequest
+	7,  // 14: protocol.SnoozeAgent.SendHeartbeat:input_type -> protocol.HeartbeatRequest
+	10, // 15: protocol.SnoozeAgent.ReportStateChange:input_type -> protocol.StateChangeRequest
+	23, // 16: protocol.SnoozeAgent.GetInstanceHistory:input_type -> protocol.InstanceHistoryRequest
+	12, // 17: protocol.SnoozeAgent.GetInstanceInfo:input_type -> protocol.GetInstanceInfoRequest
+	14, // 18: protocol.SnoozeAgent.StopInstance:input_type -> protocol.StopInstanceRequest
+	16, // 19: protocol.SnoozeAgent.StartInstance:input_type -> protocol.StartInstanceRequest
+	18, // 20: protocol.SnoozeAgent.PerformCloudAction:input_type -> protocol.CloudActionRequest
+	20, // 21: protocol.SnoozeAgent.ListCloudProviders:input_type -> protocol.ListCloudProvidersRequest
+	1,  // 22: protocol.SnoozeAgent.RegisterInstance:output_type -> protocol.RegistrationResponse
+	3,  // 23: protocol.SnoozeAgent.UnregisterInstance:output_type -> protocol.UnregisterResponse
+	5,  // 24: protocol.SnoozeAgent.SendIdleNotification:output_type -> protocol.IdleNotificationResponse
+	8,  // 25: protocol.SnoozeAgent.SendHeartbeat:output_type -> protocol.HeartbeatResponse
+	11, // 26: protocol.SnoozeAgent.ReportStateChange:output_type -> protocol.StateChangeResponse
+	25, // 27: protocol.SnoozeAgent.GetInstanceHistory:output_type -> protocol.InstanceHistoryResponse
+	13, // 28: protocol.SnoozeAgent.GetInstanceInfo:output_type -> protocol.GetInstanceInfoResponse
+	15, // 29: protocol.SnoozeAgent.StopInstance:output_type -> protocol.StopInstanceResponse
+	17, // 30: protocol.SnoozeAgent.StartInstance:output_type -> protocol.StartInstanceResponse
+	19, // 31: protocol.SnoozeAgent.PerformCloudAction:output_type -> protocol.CloudActionResponse
+	22, // 32: protocol.SnoozeAgent.ListCloudProviders:output_type -> protocol.ListCloudProvidersResponse
+	22, // [22:33] is the sub-list for method output_type
+	11, // [11:22] is the sub-list for method input_type
+	11, // [11:11] is the sub-list for extension type_name
+	11, // [11:11] is the sub-list for extension extendee
+	0,  // [0:11] is the sub-list for field type_name
 }
 
 func init() { file_pkg_common_protocol_proto_agent_proto_init() }
@@ -1618,7 +1950,7 @@ func file_pkg_common_protocol_proto_agent_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_pkg_common_protocol_proto_agent_proto_rawDesc), len(file_pkg_common_protocol_proto_agent_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   29,
+			NumMessages:   32,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -24,6 +24,7 @@ const (
 	SnoozeAgent_SendIdleNotification_FullMethodName = "/protocol.SnoozeAgent/SendIdleNotification"
 	SnoozeAgent_SendHeartbeat_FullMethodName        = "/protocol.SnoozeAgent/SendHeartbeat"
 	SnoozeAgent_ReportStateChange_FullMethodName    = "/protocol.SnoozeAgent/ReportStateChange"
+	SnoozeAgent_GetInstanceHistory_FullMethodName   = "/protocol.SnoozeAgent/GetInstanceHistory"
 	SnoozeAgent_GetInstanceInfo_FullMethodName      = "/protocol.SnoozeAgent/GetInstanceInfo"
 	SnoozeAgent_StopInstance_FullMethodName         = "/protocol.SnoozeAgent/StopInstance"
 	SnoozeAgent_StartInstance_FullMethodName        = "/protocol.SnoozeAgent/StartInstance"
@@ -45,6 +46,8 @@ type SnoozeAgentClient interface {
 	SendHeartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error)
 	// ReportStateChange reports a state change to the agent
 	ReportStateChange(ctx context.Context, in *StateChangeRequest, opts ...grpc.CallOption) (*StateChangeResponse, error)
+	// GetInstanceHistory returns the events recorded for an instance
+	GetInstanceHistory(ctx context.Context, in *InstanceHistoryRequest, opts ...grpc.CallOption) (*InstanceHistoryResponse, error)
 	// Cloud Provider Operations
 	GetInstanceInfo(ctx context.Context, in *GetInstanceInfoRequest, opts ...grpc.CallOption) (*GetInstanceInfoResponse, error)
 	StopInstance(ctx context.Context, in *StopInstanceRequest, opts ...grpc.CallOption) (*StopInstanceResponse, error)
@@ -111,6 +114,16 @@ func (c *snoozeAgentClient) ReportStateChange(ctx context.Context, in *StateChan
 	return out, nil
 }
 
+func (c *snoozeAgentClient) GetInstanceHistory(ctx context.Context, in *InstanceHistoryRequest, opts ...grpc.CallOption) (*InstanceHistoryResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(InstanceHistoryResponse)
+	err := c.cc.Invoke(ctx, SnoozeAgent_GetInstanceHistory_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *snoozeAgentClient) GetInstanceInfo(ctx context.Context, in *GetInstanceInfoRequest, opts ...grpc.CallOption) (*GetInstanceInfoResponse, error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(GetInstanceInfoResponse)
@@ -175,6 +188,8 @@ type SnoozeAgentServer interface {
 	SendHeartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error)
 	// ReportStateChange reports a state change to the agent
 	ReportStateChange(context.Context, *StateChangeRequest) (*StateChangeResponse, error)
+	// GetInstanceHistory returns the events recorded for an instance
+	GetInstanceHistory(context.Context, *InstanceHistoryRequest) (*InstanceHistoryResponse, error)
 	// Cloud Provider Operations
 	GetInstanceInfo(context.Context, *GetInstanceInfoRequest) (*GetInstanceInfoResponse, error)
 	StopInstance(context.Context, *StopInstanceRequest) (*StopInstanceResponse, error)
@@ -206,6 +221,9 @@ func (UnimplementedSnoozeAgentServer) SendHeartbeat(context.Context, *HeartbeatR
 func (UnimplementedSnoozeAgentServer) ReportStateChange(context.Context, *StateChangeRequest) (*StateChangeResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ReportStateChange not implemented")
 }
+func (UnimplementedSnoozeAgentServer) GetInstanceHistory(context.Context, *InstanceHistoryRequest) (*InstanceHistoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetInstanceHistory not implemented")
+}
 func (UnimplementedSnoozeAgentServer) GetInstanceInfo(context.Context, *GetInstanceInfoRequest) (*GetInstanceInfoResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetInstanceInfo not implemented")
 }
@@ -332,6 +350,24 @@ func _SnoozeAgent_ReportStateChange_Handler(srv interface{}, ctx context.Context
 	return interceptor(ctx, in, info, handler)
 }
 
+func _SnoozeAgent_GetInstanceHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InstanceHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SnoozeAgentServer).GetInstanceHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SnoozeAgent_GetInstanceHistory_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SnoozeAgentServer).GetInstanceHistory(ctx, req.(*InstanceHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _SnoozeAgent_GetInstanceInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(GetInstanceInfoRequest)
 	if err := dec(in); err != nil {
@@ -449,6 +485,10 @@ var SnoozeAgent_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ReportStateChange",
 			Handler:    _SnoozeAgent_ReportStateChange_Handler,
 		},
+		{
+			MethodName: "GetInstanceHistory",
+			Handler:    _SnoozeAgent_GetInstanceHistory_Handler,
+		},
 		{
 			MethodName: "GetInstanceInfo",
 			Handler:    _SnoozeAgent_GetInstanceInfo_Handler,
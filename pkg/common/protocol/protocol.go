@@ -71,6 +71,12 @@ type IdleNotificationResponse struct {
 	
 	// ScheduledAction is a scheduled action for the instance
 	ScheduledAction *ScheduledAction `json:"scheduled_action,omitempty"`
+	
+	// Policy is the idle policy that matched the instance, if any
+	Policy string `json:"policy,omitempty"`
+	
+	// Trace explains how the policy was chosen
+	Trace []string `json:"trace,omitempty"`
 }
 
 // ScheduledAction represents an action scheduled for an instance
@@ -83,6 +89,40 @@ type ScheduledAction struct {
 	
 	// Reason is the reason for the action
 	Reason string `json:"reason,omitempty"`
+	
+	// ID identifies the action, so it can be tracked and cancelled
+	ID string `json:"id,omitempty"`
+	
+	// Status is where the action is in its lifecycle (pending if empty)
+	Status ActionStatus `json:"status,omitempty"`
+	
+	// Attempts is the number of times the action has been tried
+	Attempts int `json:"attempts,omitempty"`
+	
+	// NextAttempt is when a failed action is retried
+	NextAttempt time.Time `json:"next_attempt,omitzero"`
+	
+	// Error is the error from the last attempt
+	Error string `json:"error,omitempty"`
+	
+	// CompletedAt is when the action succeeded, failed or was cancelled
+	CompletedAt time.Time `json:"completed_at,omitzero"`
+	
+	// RequiredApprovals is how many approvals the action needs before it is carried out
+	RequiredApprovals int `json:"required_approvals,omitempty"`
+	
+	// Approvals are the people who approved the action
+	Approvals []string `json:"approvals,omitempty"`
+	
+	// Policy is the idle policy that scheduled the action, if any
+	Policy string `json:"policy,omitempty"`
+	
+	// RequestedAt is when the instance was asked to prepare for a stop
+	RequestedAt time.Time `json:"requested_at,omitzero"`
+	
+	// Reply is the instance's answer to a stop request, StopReplyStopping
+	// or StopReplyVetoed
+	Reply string `json:"reply,omitempty"`
 }
 
 // Heartbeat represents a heartbeat from an instance to the agent
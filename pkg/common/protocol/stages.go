@@ -0,0 +1,184 @@
+package protocol
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// StagesMetadataKey is the registration metadata key that carries an
+// instance's idle stages
+const StagesMetadataKey = "stages"
+
+// Idle stage actions
+const (
+	// StageNotify notifies the owner that the instance is idle
+	StageNotify = "notify"
+	// StageWarn broadcasts a warning with a countdown to the next stop on the instance
+	StageWarn = "warn"
+	// StageStop stops the instance
+	StageStop = "stop"
+	// StageHibernate hibernates the instance. The cloud providers can't
+	// hibernate instances yet, so stages can't use it.
+	StageHibernate = "hibernate"
+	// StageDeallocate deallocates the instance. Like StageHibernate it is
+	// reserved until the cloud providers support it.
+	StageDeallocate = "deallocate"
+)
+
+// IdleStage is an action taken once an instance has been idle for a while
+type IdleStage struct {
+	// After is how long the instance must be idle before the stage is reached
+	After time.Duration `json:"after"`
+
+	// Action is the stage action, such as StageNotify or StageStop
+	Action string `json:"action"`
+
+	// Notification is the type of notification sent when the stage is reached
+	Notification string `json:"notification,omitempty"`
+}
+
+// Stops reports whether the stage stops the instance
+func (s IdleStage) Stops() bool {
+	switch s.Action {
+	case StageStop, StageHibernate, StageDeallocate:
+		return true
+	}
+	return false
+}
+
+// String returns the stage in the form read by ParseStages
+func (s IdleStage) String() string {
+	stage := fmt.Sprintf("%s %s", formatStageDuration(s.After), s.Action)
+	if s.Notification != "" {
+		stage += " " + s.Notification
+	}
+	return stage
+}
+
+// defaultStageNotifications are the notification types used by stages that
+// don't set one. Reaching a stop stage only schedules the stop, and the
+// scheduler sends action_executed once it has been carried out.
+var defaultStageNotifications = map[string]string{
+	StageNotify:     "idle",
+	StageWarn:       "scheduled_action",
+	StageStop:       "scheduled_action",
+	StageHibernate:  "scheduled_action",
+	StageDeallocate: "scheduled_action",
+}
+
+// ParseStages parses idle stages written one per line or separated by
+// semicolons, such as "30m notify; 45m warn; 1h stop".
+// Each stage may name its notification type after the action. Stages
+// must be in order of idle time, and text after # is a comment.
+func ParseStages(spec string) ([]IdleStage, error) {
+	var stages []IdleStage
+
+	for _, line := range strings.FieldsFunc(spec, func(r rune) bool { return r == '\n' || r == ';' }) {
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = line[:comment]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("invalid stage %q: expected DURATION ACTION [NOTIFICATION]", strings.TrimSpace(line))
+		}
+
+		after, err := time.ParseDuration(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid stage %q: %w", strings.TrimSpace(line), err)
+		}
+
+		stage := IdleStage{After: after, Action: fields[1]}
+		if len(fields) == 3 {
+			stage.Notification = fields[2]
+		}
+		stages = append(stages, stage)
+	}
+
+	if err := ValidateStages(stages); err != nil {
+		return nil, err
+	}
+
+	return stages, nil
+}
+
+// FormatStages returns stages in the form read by ParseStages
+func FormatStages(stages []IdleStage) string {
+	parts := make([]string, len(stages))
+	for i, stage := range stages {
+		parts[i] = stage.String()
+	}
+	return strings.Join(parts, "; ")
+}
+
+// ValidateStages checks that stages have known actions and are in order of idle time
+func ValidateStages(stages []IdleStage) error {
+	for i, stage := range stages {
+		if _, ok := defaultStageNotifications[stage.Action]; !ok {
+			return fmt.Errorf("unknown stage action %q", stage.Action)
+		}
+		if stage.Action == StageHibernate || stage.Action == StageDeallocate {
+			return fmt.Errorf("stage action %q isn't supported by the cloud providers yet", stage.Action)
+		}
+		if stage.After <= 0 {
+			return fmt.Errorf("stage %s must start after a positive idle time", stage.Action)
+		}
+		if i > 0 && stage.After <= stages[i-1].After {
+			return fmt.Errorf("stage %s at %s must come after %s", stage.Action, stage.After, stages[i-1].After)
+		}
+	}
+	return nil
+}
+
+// StageNotification returns the notification type of a stage
+func StageNotification(stage IdleStage) string {
+	if stage.Notification != "" {
+		return stage.Notification
+	}
+	return defaultStageNotifications[stage.Action]
+}
+
+// StagesFromMetadata parses the stages carried in registration metadata.
+// It returns nil if the metadata has none.
+func StagesFromMetadata(metadata map[string]string) ([]IdleStage, error) {
+	spec, ok := metadata[StagesMetadataKey]
+	if !ok || strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	return ParseStages(spec)
+}
+
+// ReachedStages returns the stages reached after being idle for the given
+// duration, starting from the stage at index from
+func ReachedStages(stages []IdleStage, from int, idleDuration time.Duration) []IdleStage {
+	var reached []IdleStage
+	for i := from; i < len(stages) && stages[i].After <= idleDuration; i++ {
+		reached = append(reached, stages[i])
+	}
+	return reached
+}
+
+// NextStop returns the first stage after the given idle duration that stops the instance
+func NextStop(stages []IdleStage, idleDuration time.Duration) (IdleStage, bool) {
+	for _, stage := range stages {
+		if stage.After > idleDuration && stage.Stops() {
+			return stage, true
+		}
+	}
+	return IdleStage{}, false
+}
+
+// formatStageDuration formats a duration without trailing zero units, such as 1h instead of 1h0m0s
+func formatStageDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
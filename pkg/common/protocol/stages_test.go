@@ -0,0 +1,71 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseStages(t *testing.T) {
+	stages, err := ParseStages("30m notify; 45m warn\n1h stop # after the countdown\n\n8h notify error")
+	if err != nil {
+		t.Fatalf("Failed to parse stages: %v", err)
+	}
+	if len(stages) != 4 {
+		t.Fatalf("Expected 4 stages, got %d", len(stages))
+	}
+	if stages[2].After != time.Hour || stages[2].Action != StageStop {
+		t.Errorf("Expected 1h stop, got %s", stages[2])
+	}
+	if StageNotification(stages[0]) != "idle" || StageNotification(stages[3]) != "error" {
+		t.Errorf("Unexpected notification types %s and %s", StageNotification(stages[0]), StageNotification(stages[3]))
+	}
+
+	expected := "30m notify; 45m warn; 1h stop; 8h notify error"
+	if FormatStages(stages) != expected {
+		t.Errorf("Expected %q, got %q", expected, FormatStages(stages))
+	}
+
+	invalid := []string{
+		"30m",
+		"30m sleep",
+		"soon stop",
+		"1h stop; 30m notify",
+		"0s notify",
+		"1h hibernate",
+		"8h deallocate",
+	}
+	for _, spec := range invalid {
+		if _, err := ParseStages(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
+
+func TestReachedStages(t *testing.T) {
+	stages, err := ParseStages("30m notify; 45m warn; 1h stop; 8h stop")
+	if err != nil {
+		t.Fatalf("Failed to parse stages: %v", err)
+	}
+
+	if reached := ReachedStages(stages, 0, 10*time.Minute); len(reached) != 0 {
+		t.Errorf("Expected no stages, got %v", reached)
+	}
+	if reached := ReachedStages(stages, 0, 50*time.Minute); len(reached) != 2 {
+		t.Errorf("Expected 2 stages, got %v", reached)
+	}
+	if reached := ReachedStages(stages, 1, 2*time.Hour); len(reached) != 2 || reached[0].Action != StageWarn {
+		t.Errorf("Expected warn and stop, got %v", reached)
+	}
+
+	next, ok := NextStop(stages, 50*time.Minute)
+	if !ok || next.Action != StageStop {
+		t.Errorf("Expected the stop stage next, got %s", next)
+	}
+	next, ok = NextStop(stages, 2*time.Hour)
+	if !ok || next.After != 8*time.Hour {
+		t.Errorf("Expected the second stop stage next, got %s", next)
+	}
+	if _, ok := NextStop(stages, 9*time.Hour); ok {
+		t.Errorf("Expected no stop after the last stage")
+	}
+}
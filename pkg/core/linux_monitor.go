@@ -27,12 +27,20 @@ func NewLinuxResourceMonitor(config *MonitorConfig) *LinuxResourceMonitor {
 		config = DefaultMonitorConfig()
 	}
 
-	return &LinuxResourceMonitor{
+	monitor := &LinuxResourceMonitor{
 		config:       config,
 		thresholds:   config.Thresholds,
 		currentUsage: make(map[ResourceType]*ResourceUsage),
 		lastActivity: time.Now(),
 	}
+
+	// Resume the idle clock from an earlier run
+	if !config.IdleSince.IsZero() && config.IdleSince.Before(monitor.lastActivity) {
+		monitor.lastActivity = config.IdleSince
+		monitor.idleDuration = time.Since(config.IdleSince)
+	}
+
+	return monitor
 }
 
 // Start starts the resource monitor
@@ -143,10 +151,29 @@ func (m *LinuxResourceMonitor) updateResourceUsage() {
 	// This is a placeholder for actual resource monitoring implementation
 	// In a real implementation, we would use platform-specific APIs to get resource usage
 	
+	// Sample custom monitors without holding the lock, they may be slow
+	custom := make(map[ResourceType]float64)
+	for resourceType, collect := range m.config.CustomMonitors {
+		value, err := collect()
+		if err != nil {
+			fmt.Printf("Failed to sample %s: %v\n", resourceType, err)
+			continue
+		}
+		custom[resourceType] = value
+	}
+
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
 	now := time.Now()
+
+	for resourceType, value := range custom {
+		m.currentUsage[resourceType] = &ResourceUsage{
+			Type:      resourceType,
+			Value:     value,
+			Timestamp: now,
+		}
+	}
 	
 	// Simulate CPU usage
 	m.currentUsage[CPU] = &ResourceUsage{
@@ -210,8 +237,14 @@ func (m *LinuxResourceMonitor) checkIdleState() {
 		}
 	}
 
+	// Keep-awake locks hold the system active
+	if isIdle && m.config.KeepAwake != nil && m.config.KeepAwake() {
+		isIdle = false
+	}
+
 	// Update idle state
 	now := time.Now()
+	wasIdle := m.idleDuration > 0
 	if isIdle {
 		if m.idleDuration == 0 {
 			// System just became idle
@@ -224,6 +257,15 @@ func (m *LinuxResourceMonitor) checkIdleState() {
 		m.idleDuration = 0
 	}
 
+	// Report transitions so the idle clock can be saved
+	if m.config.OnIdleChange != nil && isIdle != wasIdle {
+		if isIdle {
+			m.config.OnIdleChange(m.lastActivity)
+		} else {
+			m.config.OnIdleChange(time.Time{})
+		}
+	}
+
 	// Check if system has been idle for the naptime duration
 	if m.idleDuration >= m.config.NapTime {
 		// System has been idle for the naptime duration
@@ -64,6 +64,22 @@ type MonitorConfig struct {
 
 	// CheckInterval is how often to check resource usage
 	CheckInterval time.Duration
+
+	// KeepAwake reports whether something is holding the system awake,
+	// such as a keep-awake lock. If it returns true the system is active.
+	KeepAwake func() bool
+
+	// CustomMonitors measure additional resources, such as the exec monitors
+	// declared in the configuration. Each needs a threshold to affect idleness.
+	CustomMonitors map[ResourceType]func() (float64, error)
+
+	// IdleSince resumes an idle clock saved by an earlier run. If zero, the
+	// system starts active.
+	IdleSince time.Time
+
+	// OnIdleChange is called with the time the system became idle when it
+	// goes idle, and with the zero time when it becomes active again
+	OnIdleChange func(idleSince time.Time)
 }
 
 // DefaultMonitorConfig returns a default monitor configuration
@@ -3,6 +3,10 @@ package monitor
 import (
 	"context"
 	"time"
+
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
+	"github.com/scttfrdmn/snoozebot/pkg/monitor/resources"
+	"github.com/scttfrdmn/snoozebot/pkg/schedule"
 )
 
 // ResourceType represents the type of resource being monitored
@@ -21,6 +25,16 @@ const (
 	UserInput ResourceType = "user_input"
 	// GPU represents GPU utilization
 	GPU ResourceType = "gpu"
+	// UserSessions represents the interactive login sessions selected by Config.UserSessions
+	UserSessions ResourceType = "user_sessions"
+	// Process represents the activity of the processes selected by Config.Processes
+	Process ResourceType = "process"
+	// Connections represents established TCP connections on the ports selected by Config.Connections
+	Connections ResourceType = "connections"
+	// Jupyter represents the activity of the Jupyter server selected by Config.Jupyter
+	Jupyter ResourceType = "jupyter"
+	// Filesystem represents file changes in the directory trees selected by Config.Filesystem
+	Filesystem ResourceType = "filesystem"
 )
 
 // ResourceUsage represents the usage of a resource
@@ -45,6 +59,8 @@ type MonitorState struct {
 	CurrentUsage map[ResourceType]*ResourceUsage
 	// Connected indicates if the monitor is connected to an agent
 	Connected bool
+	// Inhibitors are the keep-awake locks holding the system active
+	Inhibitors []InhibitLock
 }
 
 // ResourceMonitorFunc is a function that monitors a resource and returns its usage
@@ -60,12 +76,39 @@ type ErrorHandler func(err error)
 type Monitor interface {
 	// Configuration
 	WithThreshold(resourceType ResourceType, threshold float64) Monitor
+	WithExitThreshold(resourceType ResourceType, threshold float64) Monitor
 	WithNapTime(duration time.Duration) Monitor
 	WithCheckInterval(duration time.Duration) Monitor
+	WithSampleInterval(resourceType ResourceType, interval time.Duration) Monitor
 	WithAgentURL(url string) Monitor
+	WithIdleDetector(detector IdleDetector) Monitor
+	WithIdleRule(rule string) Monitor
+	WithSmoothing(smoothing SmoothingConfig) Monitor
+	WithMinActiveBurst(duration time.Duration) Monitor
+	WithProcesses(config resources.ProcessMonitorConfig) Monitor
+	WithConnections(config resources.ConnectionMonitorConfig) Monitor
+	WithUserSessions(config resources.UserSessionsConfig) Monitor
+	WithJupyter(config resources.JupyterMonitorConfig) Monitor
+	WithFilesystem(config resources.FilesystemMonitorConfig) Monitor
+	WithUserInput(config resources.UserInputConfig) Monitor
+	WithCgroup(config resources.CgroupConfig) Monitor
+	WithGPU(config resources.GPUMonitorConfig) Monitor
+	WithInstanceMetadata(metadata InstanceMetadata) Monitor
+	WithHistoryRetention(duration time.Duration) Monitor
+	WithSchedule(s *schedule.Schedule) Monitor
+	WithStages(stages []protocol.IdleStage) Monitor
+	WithStateFile(path string) Monitor
+	
+	// Pre-stop hooks
+	AddPreStopHook(hook PreStopHook) Monitor
+	
+	// Keep-awake locks
+	WithInhibitSocket(path string) Monitor
+	Inhibitors() *Inhibitors
 	
 	// Custom monitoring
 	AddResourceMonitor(name string, fn ResourceMonitorFunc) Monitor
+	AddExecMonitor(config resources.ExecMonitorConfig) Monitor
 	
 	// Event handlers
 	OnIdleStateChange(fn IdleStateChangeHandler) Monitor
@@ -79,18 +122,107 @@ type Monitor interface {
 	GetCurrentState() MonitorState
 	IsIdle() bool
 	IdleDuration() time.Duration
+	
+	// History
+	History(resourceType ResourceType, since time.Time) []ResourceUsage
+	Stats(resourceType ResourceType, window time.Duration) (UsageStats, error)
 }
 
 // Config contains configuration for the monitor
 type Config struct {
 	// Thresholds is a map of resource types to thresholds
 	Thresholds map[ResourceType]float64
+	// ExitThresholds is a map of resource types to the thresholds that must be
+	// exceeded to leave the idle state. Resources without an entry use Thresholds.
+	ExitThresholds map[ResourceType]float64
 	// NapTime is the duration that resource usage must be below thresholds before taking action
 	NapTime time.Duration
+	// Schedule changes the nap time or forbids stopping by time window.
+	// It is sent to the agent, which applies it on top of its own schedule.
+	Schedule *schedule.Schedule
+	// Stages escalate from notifying the owner to stopping the instance as
+	// the idle time grows. If set, they replace NapTime and are sent to the
+	// agent, which carries them out.
+	Stages []protocol.IdleStage
+	// Broadcast shows the warnings of warn stages on the system's terminals.
+	// If nil, WallBroadcast is used.
+	Broadcast BroadcastFunc
 	// CheckInterval is how often to check resource usage
 	CheckInterval time.Duration
+	// SampleIntervals overrides how often individual resources are sampled.
+	// Resources without an entry are sampled every CheckInterval.
+	SampleIntervals map[ResourceType]time.Duration
 	// AgentURL is the URL of the remote agent
 	AgentURL string
+	// IdleDetector decides whether the system is idle.
+	// If nil, every resource must be at or below its threshold.
+	IdleDetector IdleDetector
+	// IdleRule is an expression such as "cpu < 5 && user_input == 0" that
+	// decides whether the system is idle. If set, it replaces IdleDetector
+	// and is compiled when the monitor starts.
+	IdleRule string
+	// Smoothing configures how samples are smoothed before the idle decision
+	Smoothing SmoothingConfig
+	// MinActiveBurst is how long activity must last before an idle system
+	// is considered active again and the idle timer resets
+	MinActiveBurst time.Duration
+	// Processes selects the processes reported as the Process resource.
+	// If nil, process activity is not monitored.
+	Processes *resources.ProcessMonitorConfig
+	// Connections selects the TCP connections reported as the Connections resource.
+	// If nil, connections are not monitored.
+	Connections *resources.ConnectionMonitorConfig
+	// UserSessions configures how interactive SSH and terminal sessions are
+	// reported as the UserSessions resource, with a threshold of 0 unless
+	// Thresholds sets one. DefaultConfig monitors sessions, which are only
+	// found on Linux. If nil, sessions are not monitored.
+	UserSessions *resources.UserSessionsConfig
+	// Jupyter selects the Jupyter server reported as the Jupyter resource.
+	// If nil, Jupyter activity is not monitored.
+	Jupyter *resources.JupyterMonitorConfig
+	// Filesystem selects the directory trees whose file changes are reported
+	// as the Filesystem resource. If nil, file changes are not monitored.
+	Filesystem *resources.FilesystemMonitorConfig
+	// UserInput selects how keyboard and mouse idle time is read, such as
+	// from the input devices on Wayland and console hosts. If nil, xprintidle is used.
+	UserInput *resources.UserInputConfig
+	// Cgroup measures CPU, memory and disk I/O for a cgroup v2 group against
+	// its limits instead of the host totals. Use it when running in a
+	// container or systemd slice. If nil, host-wide readings are used.
+	Cgroup *resources.CgroupConfig
+	// ExecMonitors are custom resources measured by external commands, such
+	// as a batch queue length. Each is reported under its name with its own
+	// threshold and interval, unless Thresholds or SampleIntervals set them.
+	ExecMonitors []resources.ExecMonitorConfig
+	// GPU configures the GPU backends and how per-device readings are
+	// combined. If nil, backends are detected and the busiest device is reported.
+	GPU *resources.GPUMonitorConfig
+	// InstanceMetadata explicitly sets the instance ID and other metadata
+	// reported to the agent. Empty fields are resolved by MetadataResolvers.
+	InstanceMetadata InstanceMetadata
+	// MetadataResolvers resolve the instance metadata in order. If nil, the
+	// AWS, GCP and Azure metadata services are tried, then the machine ID
+	// and host name.
+	MetadataResolvers []MetadataResolver
+	// HistoryRetention is how long samples are kept for History and Stats.
+	// If zero, DefaultHistoryRetention is used.
+	HistoryRetention time.Duration
+	// HistorySize bounds the number of samples kept per resource.
+	// If zero, DefaultHistorySize is used.
+	HistorySize int
+	// PreStopHooks run before the instance is stopped. A hook can veto
+	// the stop, which restarts the idle timer.
+	PreStopHooks []PreStopHook
+	// Inhibitors holds the keep-awake locks. While any lock is held the
+	// system is active. If nil, the monitor creates its own registry.
+	Inhibitors *Inhibitors
+	// InhibitSocket is the Unix socket the inhibitor API is served on,
+	// usually DefaultInhibitSocket. If empty, the API isn't served.
+	InhibitSocket string
+	// StateFile keeps the idle clock, stages and last agent command across
+	// restarts, usually DefaultStateFile. The state is discarded if the host
+	// rebooted. If empty, the idle clock starts over on every start.
+	StateFile string
 }
 
 // DefaultConfig returns a default configuration
@@ -104,9 +236,18 @@ func DefaultConfig() Config {
 			UserInput: 0.0,
 			GPU:       5.0,
 		},
-		NapTime:       30 * time.Minute,
-		CheckInterval: 1 * time.Minute,
-		AgentURL:      "http://localhost:8080",
+		NapTime:          30 * time.Minute,
+		CheckInterval:    1 * time.Minute,
+		SampleIntervals:  make(map[ResourceType]time.Duration),
+		AgentURL:         "http://localhost:8080",
+		ExitThresholds:   make(map[ResourceType]float64),
+		UserSessions:     &resources.UserSessionsConfig{},
+		HistoryRetention: DefaultHistoryRetention,
+		HistorySize:      DefaultHistorySize,
+		Smoothing: SmoothingConfig{
+			Method: SmoothingNone,
+			Window: 1,
+		},
 	}
 }
 
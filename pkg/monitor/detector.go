@@ -0,0 +1,211 @@
+package monitor
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// IdleDetector decides whether the system is idle from its current resource usage
+type IdleDetector interface {
+	// IsIdle reports whether the given usage should be considered idle.
+	// Thresholds are the configured per-resource thresholds.
+	IsIdle(usage map[ResourceType]*ResourceUsage, thresholds map[ResourceType]float64) (bool, error)
+}
+
+// ThresholdDetector considers the system idle only if every resource
+// with a configured threshold is at or below that threshold
+type ThresholdDetector struct{}
+
+// NewThresholdDetector creates a new all-below-threshold detector
+func NewThresholdDetector() *ThresholdDetector {
+	return &ThresholdDetector{}
+}
+
+// IsIdle implements IdleDetector
+func (d *ThresholdDetector) IsIdle(usage map[ResourceType]*ResourceUsage, thresholds map[ResourceType]float64) (bool, error) {
+	for resourceType, u := range usage {
+		threshold, ok := thresholds[resourceType]
+		if !ok {
+			continue
+		}
+
+		if u.Value > threshold {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// WeightedScoreDetector combines resource usage into a single weighted
+// activity score and considers the system idle while the score stays at
+// or below MaxScore. Resources without a weight are ignored.
+type WeightedScoreDetector struct {
+	// Weights is a map of resource types to their weight in the score
+	Weights map[ResourceType]float64
+	// MaxScore is the highest score (0-100) that is still considered idle
+	MaxScore float64
+}
+
+// NewWeightedScoreDetector creates a new weighted score detector
+func NewWeightedScoreDetector(weights map[ResourceType]float64, maxScore float64) *WeightedScoreDetector {
+	return &WeightedScoreDetector{
+		Weights:  weights,
+		MaxScore: maxScore,
+	}
+}
+
+// Score returns the weighted mean usage of all weighted resources
+func (d *WeightedScoreDetector) Score(usage map[ResourceType]*ResourceUsage) (float64, error) {
+	var score, totalWeight float64
+	for resourceType, weight := range d.Weights {
+		if weight <= 0 {
+			continue
+		}
+
+		u, ok := usage[resourceType]
+		if !ok {
+			continue
+		}
+
+		score += weight * u.Value
+		totalWeight += weight
+	}
+
+	if totalWeight == 0 {
+		return 0, fmt.Errorf("no usage available for any weighted resource")
+	}
+
+	return score / totalWeight, nil
+}
+
+// IsIdle implements IdleDetector
+func (d *WeightedScoreDetector) IsIdle(usage map[ResourceType]*ResourceUsage, thresholds map[ResourceType]float64) (bool, error) {
+	score, err := d.Score(usage)
+	if err != nil {
+		return false, err
+	}
+
+	return score <= d.MaxScore, nil
+}
+
+// BaselineDetector learns each resource's normal quiet-time usage from the
+// samples it has seen and considers the system idle while every resource
+// stays within Margin percentage points of its baseline. This copes with
+// hosts that have a constant background footprint, such as memory held by
+// long-running services. Until enough samples have been collected for a
+// resource, its configured threshold is used instead.
+type BaselineDetector struct {
+	// Margin is how far above its baseline a resource may be while idle
+	Margin float64
+	// Window is the number of samples per resource used to learn the baseline
+	Window int
+	// Percentile (0-100) of the window that is taken as the baseline
+	Percentile float64
+	// MinSamples is the number of samples needed before a baseline is used
+	MinSamples int
+
+	history  map[ResourceType][]float64
+	lastSeen map[ResourceType]time.Time
+	mutex    sync.Mutex
+}
+
+// NewBaselineDetector creates a baseline detector with sensible defaults
+func NewBaselineDetector(margin float64) *BaselineDetector {
+	return &BaselineDetector{
+		Margin:     margin,
+		Window:     1440, // One day of samples at the default check interval
+		Percentile: 10.0,
+		MinSamples: 60,
+		history:    make(map[ResourceType][]float64),
+		lastSeen:   make(map[ResourceType]time.Time),
+	}
+}
+
+// Baseline returns the learned baseline for a resource, if there is one
+func (d *BaselineDetector) Baseline(resourceType ResourceType) (float64, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.baseline(resourceType)
+}
+
+// IsIdle implements IdleDetector
+func (d *BaselineDetector) IsIdle(usage map[ResourceType]*ResourceUsage, thresholds map[ResourceType]float64) (bool, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.history == nil {
+		d.history = make(map[ResourceType][]float64)
+		d.lastSeen = make(map[ResourceType]time.Time)
+	}
+
+	idle := true
+	for resourceType, u := range usage {
+		threshold, ok := thresholds[resourceType]
+		if !ok {
+			continue
+		}
+
+		// Judge against what was learned before this sample
+		if baseline, ok := d.baseline(resourceType); ok {
+			if u.Value > baseline+d.Margin {
+				idle = false
+			}
+		} else if u.Value > threshold {
+			idle = false
+		}
+
+		d.record(u)
+	}
+
+	return idle, nil
+}
+
+// record adds a sample to the history, skipping samples that were already seen
+func (d *BaselineDetector) record(u *ResourceUsage) {
+	if last, ok := d.lastSeen[u.Type]; ok && !u.Timestamp.After(last) {
+		return
+	}
+	d.lastSeen[u.Type] = u.Timestamp
+
+	samples := append(d.history[u.Type], u.Value)
+	if d.Window > 0 && len(samples) > d.Window {
+		samples = samples[len(samples)-d.Window:]
+	}
+	d.history[u.Type] = samples
+}
+
+// baseline computes the configured percentile of the sample history
+func (d *BaselineDetector) baseline(resourceType ResourceType) (float64, bool) {
+	samples := d.history[resourceType]
+	if len(samples) == 0 || len(samples) < d.MinSamples {
+		return 0, false
+	}
+
+	return percentile(samples, d.Percentile), true
+}
+
+// percentile returns the p-th percentile (0-100) of the values using nearest rank
+func percentile(values []float64, p float64) float64 {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	if p <= 0 {
+		return sorted[0]
+	}
+	if p >= 100 {
+		return sorted[len(sorted)-1]
+	}
+
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+
+	return sorted[rank]
+}
@@ -0,0 +1,123 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+)
+
+func usageOf(values map[ResourceType]float64, ts time.Time) map[ResourceType]*ResourceUsage {
+	usage := make(map[ResourceType]*ResourceUsage)
+	for resourceType, value := range values {
+		usage[resourceType] = &ResourceUsage{Type: resourceType, Value: value, Timestamp: ts}
+	}
+	return usage
+}
+
+func TestThresholdDetector(t *testing.T) {
+	thresholds := map[ResourceType]float64{CPU: 10, Memory: 20}
+	detector := NewThresholdDetector()
+
+	tests := []struct {
+		name   string
+		values map[ResourceType]float64
+		idle   bool
+	}{
+		{"all below", map[ResourceType]float64{CPU: 5, Memory: 15}, true},
+		{"at threshold", map[ResourceType]float64{CPU: 10, Memory: 20}, true},
+		{"one above", map[ResourceType]float64{CPU: 50, Memory: 15}, false},
+		{"no threshold", map[ResourceType]float64{CPU: 5, "custom": 99}, true},
+	}
+
+	for _, tt := range tests {
+		idle, err := detector.IsIdle(usageOf(tt.values, time.Now()), thresholds)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if idle != tt.idle {
+			t.Errorf("%s: expected idle=%v, got %v", tt.name, tt.idle, idle)
+		}
+	}
+}
+
+func TestWeightedScoreDetector(t *testing.T) {
+	detector := NewWeightedScoreDetector(map[ResourceType]float64{
+		CPU:    3,
+		Memory: 1,
+	}, 10)
+
+	// (3*5 + 1*40) / 4 = 13.75
+	usage := usageOf(map[ResourceType]float64{CPU: 5, Memory: 40}, time.Now())
+	score, err := detector.Score(usage)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if score != 13.75 {
+		t.Errorf("Expected score 13.75, got %f", score)
+	}
+
+	idle, _ := detector.IsIdle(usage, nil)
+	if idle {
+		t.Error("Expected active with score above the maximum")
+	}
+
+	// (3*2 + 1*20) / 4 = 6.5
+	idle, _ = detector.IsIdle(usageOf(map[ResourceType]float64{CPU: 2, Memory: 20}, time.Now()), nil)
+	if !idle {
+		t.Error("Expected idle with score below the maximum")
+	}
+
+	// No weighted resource available
+	if _, err := detector.IsIdle(usageOf(map[ResourceType]float64{GPU: 0}, time.Now()), nil); err == nil {
+		t.Error("Expected error without any weighted resource")
+	}
+}
+
+func TestBaselineDetector(t *testing.T) {
+	thresholds := map[ResourceType]float64{CPU: 10, Memory: 20}
+	detector := NewBaselineDetector(5)
+	detector.MinSamples = 10
+
+	// A constant background memory footprint defeats the fixed threshold
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		usage := usageOf(map[ResourceType]float64{CPU: 2, Memory: 60}, start.Add(time.Duration(i)*time.Minute))
+		idle, err := detector.IsIdle(usage, thresholds)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if idle {
+			t.Fatalf("Expected threshold fallback to report active before the baseline is learned (sample %d)", i)
+		}
+	}
+
+	baseline, ok := detector.Baseline(Memory)
+	if !ok || baseline != 60 {
+		t.Fatalf("Expected memory baseline of 60, got %f (ok=%v)", baseline, ok)
+	}
+
+	// Usage near the learned baseline is now idle
+	idle, _ := detector.IsIdle(usageOf(map[ResourceType]float64{CPU: 3, Memory: 62}, start.Add(11*time.Minute)), thresholds)
+	if !idle {
+		t.Error("Expected idle near the learned baseline")
+	}
+
+	// Usage well above the baseline is active
+	idle, _ = detector.IsIdle(usageOf(map[ResourceType]float64{CPU: 3, Memory: 80}, start.Add(12*time.Minute)), thresholds)
+	if idle {
+		t.Error("Expected active well above the learned baseline")
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	values := []float64{5, 1, 4, 2, 3, 10, 9, 8, 7, 6}
+
+	if p := percentile(values, 10); p != 1 {
+		t.Errorf("Expected 10th percentile 1, got %f", p)
+	}
+	if p := percentile(values, 50); p != 5 {
+		t.Errorf("Expected 50th percentile 5, got %f", p)
+	}
+	if p := percentile(values, 100); p != 10 {
+		t.Errorf("Expected 100th percentile 10, got %f", p)
+	}
+}
@@ -0,0 +1,176 @@
+package monitor
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHistoryRetention is how long samples are kept if Config.HistoryRetention is zero
+	DefaultHistoryRetention = time.Hour
+	// DefaultHistorySize is the maximum number of samples kept per resource
+	// if Config.HistorySize is zero
+	DefaultHistorySize = 4096
+)
+
+// UsageStats summarizes the samples of a resource over a window
+type UsageStats struct {
+	// Type is the resource type
+	Type ResourceType
+	// Count is the number of samples in the window
+	Count int
+	// Min is the lowest sample value
+	Min float64
+	// Max is the highest sample value
+	Max float64
+	// Mean is the average sample value
+	Mean float64
+	// P95 is the 95th percentile sample value
+	P95 float64
+	// From is the timestamp of the oldest sample in the window
+	From time.Time
+	// To is the timestamp of the newest sample in the window
+	To time.Time
+}
+
+// usageRing is a fixed-size ring buffer of samples, oldest first
+type usageRing struct {
+	samples []ResourceUsage
+	start   int
+	size    int
+}
+
+// add appends a sample, overwriting the oldest one when the ring is full
+func (r *usageRing) add(usage ResourceUsage) {
+	if r.size < len(r.samples) {
+		r.samples[(r.start+r.size)%len(r.samples)] = usage
+		r.size++
+		return
+	}
+	r.samples[r.start] = usage
+	r.start = (r.start + 1) % len(r.samples)
+}
+
+// at returns the i-th oldest sample
+func (r *usageRing) at(i int) ResourceUsage {
+	return r.samples[(r.start+i)%len(r.samples)]
+}
+
+// last returns the newest sample
+func (r *usageRing) last() (ResourceUsage, bool) {
+	if r.size == 0 {
+		return ResourceUsage{}, false
+	}
+	return r.at(r.size - 1), true
+}
+
+// prune drops samples taken before the cutoff
+func (r *usageRing) prune(cutoff time.Time) {
+	for r.size > 0 && r.samples[r.start].Timestamp.Before(cutoff) {
+		r.start = (r.start + 1) % len(r.samples)
+		r.size--
+	}
+}
+
+// history keeps a bounded time series of samples per resource
+type history struct {
+	retention time.Duration
+	size      int
+	series    map[ResourceType]*usageRing
+	mutex     sync.RWMutex
+}
+
+// newHistory creates a new history with the given retention and samples per resource
+func newHistory(retention time.Duration, size int) *history {
+	if retention <= 0 {
+		retention = DefaultHistoryRetention
+	}
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+
+	return &history{
+		retention: retention,
+		size:      size,
+		series:    make(map[ResourceType]*usageRing),
+	}
+}
+
+// record adds the samples that are newer than the last recorded sample of each resource
+func (h *history) record(usage map[ResourceType]*ResourceUsage) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for resourceType, u := range usage {
+		ring, ok := h.series[resourceType]
+		if !ok {
+			ring = &usageRing{samples: make([]ResourceUsage, h.size)}
+			h.series[resourceType] = ring
+		}
+
+		// The same sample is seen on every check until it is refreshed
+		if last, ok := ring.last(); ok && !u.Timestamp.After(last.Timestamp) {
+			continue
+		}
+
+		ring.add(*u)
+		ring.prune(u.Timestamp.Add(-h.retention))
+	}
+}
+
+// since returns the samples of a resource taken at or after the given time, oldest first
+func (h *history) since(resourceType ResourceType, since time.Time) []ResourceUsage {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	ring, ok := h.series[resourceType]
+	if !ok {
+		return nil
+	}
+
+	cutoff := time.Now().Add(-h.retention)
+	if since.Before(cutoff) {
+		since = cutoff
+	}
+
+	var samples []ResourceUsage
+	for i := 0; i < ring.size; i++ {
+		if sample := ring.at(i); !sample.Timestamp.Before(since) {
+			samples = append(samples, sample)
+		}
+	}
+
+	return samples
+}
+
+// stats summarizes the samples of a resource over the window ending now
+func (h *history) stats(resourceType ResourceType, window time.Duration) (UsageStats, error) {
+	samples := h.since(resourceType, time.Now().Add(-window))
+	if len(samples) == 0 {
+		return UsageStats{}, fmt.Errorf("no samples for %s in the last %s", resourceType, window)
+	}
+
+	values := make([]float64, len(samples))
+	stats := UsageStats{
+		Type:  resourceType,
+		Count: len(samples),
+		Min:   samples[0].Value,
+		Max:   samples[0].Value,
+		From:  samples[0].Timestamp,
+		To:    samples[len(samples)-1].Timestamp,
+	}
+	for i, sample := range samples {
+		values[i] = sample.Value
+		if sample.Value < stats.Min {
+			stats.Min = sample.Value
+		}
+		if sample.Value > stats.Max {
+			stats.Max = sample.Value
+		}
+	}
+	stats.Mean = mean(values)
+	stats.P95 = percentile(values, 95)
+
+	return stats, nil
+}
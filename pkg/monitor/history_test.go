@@ -0,0 +1,130 @@
+package monitor
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHistory_Record(t *testing.T) {
+	h := newHistory(time.Hour, 4)
+	now := time.Now()
+
+	// Samples arrive once per minute, and the same sample may be seen repeatedly
+	for i := 0; i < 6; i++ {
+		ts := now.Add(time.Duration(i-5) * time.Minute)
+		usage := usageOf(map[ResourceType]float64{CPU: float64(i * 10)}, ts)
+		h.record(usage)
+		h.record(usage)
+	}
+
+	// Only the newest samples fit in the ring
+	samples := h.since(CPU, time.Time{})
+	if len(samples) != 4 {
+		t.Fatalf("Expected 4 samples, got %d", len(samples))
+	}
+	for i, sample := range samples {
+		if expected := float64((i + 2) * 10); sample.Value != expected {
+			t.Errorf("Expected sample %d to be %f, got %f", i, expected, sample.Value)
+		}
+	}
+
+	samples = h.since(CPU, now.Add(-90*time.Second))
+	if len(samples) != 2 {
+		t.Errorf("Expected 2 samples in the last 90s, got %d", len(samples))
+	}
+	if samples := h.since(GPU, time.Time{}); len(samples) != 0 {
+		t.Errorf("Expected no GPU samples, got %d", len(samples))
+	}
+}
+
+func TestHistory_Retention(t *testing.T) {
+	h := newHistory(10*time.Minute, 100)
+	now := time.Now()
+
+	h.record(usageOf(map[ResourceType]float64{CPU: 1}, now.Add(-20*time.Minute)))
+	h.record(usageOf(map[ResourceType]float64{CPU: 2}, now.Add(-5*time.Minute)))
+	h.record(usageOf(map[ResourceType]float64{CPU: 3}, now))
+
+	samples := h.since(CPU, time.Time{})
+	if len(samples) != 2 || samples[0].Value != 2 {
+		t.Errorf("Expected samples older than the retention to be dropped, got %+v", samples)
+	}
+}
+
+func TestHistory_Stats(t *testing.T) {
+	h := newHistory(time.Hour, 100)
+	now := time.Now()
+
+	for i := 1; i <= 20; i++ {
+		ts := now.Add(time.Duration(i-20) * time.Minute)
+		h.record(usageOf(map[ResourceType]float64{CPU: float64(i)}, ts))
+	}
+
+	stats, err := h.stats(CPU, 30*time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if stats.Count != 20 || stats.Min != 1 || stats.Max != 20 || stats.Mean != 10.5 || stats.P95 != 19 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	// Only the last 5 minutes
+	stats, err = h.stats(CPU, 4*time.Minute+30*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if stats.Count != 5 || stats.Min != 16 || stats.Max != 20 || stats.Mean != 18 {
+		t.Errorf("Unexpected windowed stats: %+v", stats)
+	}
+
+	if _, err := h.stats(GPU, time.Hour); err == nil {
+		t.Error("Expected error for a resource without samples")
+	}
+}
+
+func TestMonitor_History(t *testing.T) {
+	config := DefaultConfig()
+	config.CheckInterval = 100 * time.Millisecond
+	config.AgentURL = ""
+
+	mon := NewMonitorWithConfig(config)
+
+	value := 0.0
+	mon.AddResourceMonitor("custom_metric", func() (float64, error) {
+		value++
+		return value, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Now()
+	if err := mon.Start(ctx); err != nil {
+		t.Fatalf("Failed to start monitor: %v", err)
+	}
+	defer mon.Stop()
+
+	time.Sleep(time.Second)
+
+	samples := mon.History("custom_metric", start)
+	if len(samples) < 3 {
+		t.Fatalf("Expected several samples, got %d", len(samples))
+	}
+	for i := 1; i < len(samples); i++ {
+		if !samples[i].Timestamp.After(samples[i-1].Timestamp) {
+			t.Errorf("Expected samples in time order, got %v then %v", samples[i-1].Timestamp, samples[i].Timestamp)
+		}
+	}
+
+	stats, err := mon.Stats("custom_metric", time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if stats.Count != len(samples) && stats.Count != len(samples)+1 {
+		t.Errorf("Expected %d samples in stats, got %d", len(samples), stats.Count)
+	}
+	if stats.Max < stats.Mean || stats.Mean < stats.Min {
+		t.Errorf("Inconsistent stats: %+v", stats)
+	}
+}
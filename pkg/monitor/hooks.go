@@ -0,0 +1,208 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/snoozebot/pkg/monitor/resources"
+)
+
+// DefaultHookTimeout is how long a pre-stop hook may run if it has no timeout
+const DefaultHookTimeout = 2 * time.Minute
+
+// HookVetoExitCode is the exit code an executable hook uses to veto the stop.
+// It is EX_TEMPFAIL from sysexits.h. The hook's output is used as the reason.
+const HookVetoExitCode = 75
+
+// PreStopFunc is a Go callback run before the instance is stopped.
+// Return an error created with Veto to postpone the stop.
+type PreStopFunc func(ctx context.Context) error
+
+// PreStopHook prepares the workload before the instance is stopped,
+// for example by flushing a training checkpoint
+type PreStopHook struct {
+	// Name identifies the hook in results and reports
+	Name string
+	// Order sets when the hook runs. Hooks with a lower order run first,
+	// and hooks with the same order run in the order they were added.
+	Order int
+	// Timeout is how long the hook may run, DefaultHookTimeout if zero
+	Timeout time.Duration
+	// Command is an executable and its arguments. It is used if Func is nil.
+	Command []string
+	// Func is a Go callback
+	Func PreStopFunc
+	// VetoOnFailure postpones the stop if the hook fails or times out.
+	// Otherwise failures are reported and the stop goes ahead.
+	VetoOnFailure bool
+}
+
+// VetoError is returned by a hook that postpones the stop
+type VetoError struct {
+	// Reason explains why the stop was postponed
+	Reason string
+}
+
+// Error returns the veto reason
+func (e *VetoError) Error() string {
+	return "stop vetoed: " + e.Reason
+}
+
+// Veto returns an error that postpones the stop for the given reason
+func Veto(reason string) error {
+	return &VetoError{Reason: reason}
+}
+
+// HookResult is the outcome of running a pre-stop hook
+type HookResult struct {
+	// Name is the name of the hook
+	Name string
+	// Duration is how long the hook ran
+	Duration time.Duration
+	// Vetoed is true if the hook postponed the stop
+	Vetoed bool
+	// Reason is the veto reason
+	Reason string
+	// Err is the error if the hook failed
+	Err error
+}
+
+// runPreStopHooks runs the hooks in order until one vetoes the stop.
+// It returns the results of the hooks that ran and whether the stop was vetoed.
+func runPreStopHooks(ctx context.Context, hooks []PreStopHook) ([]HookResult, bool) {
+	ordered := make([]PreStopHook, len(hooks))
+	copy(ordered, hooks)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Order < ordered[j].Order
+	})
+
+	results := make([]HookResult, 0, len(ordered))
+	for _, hook := range ordered {
+		result := runPreStopHook(ctx, hook)
+		results = append(results, result)
+		if result.Vetoed {
+			return results, true
+		}
+	}
+
+	return results, false
+}
+
+// runPreStopHook runs a single hook with its timeout
+func runPreStopHook(ctx context.Context, hook PreStopHook) HookResult {
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHookTimeout
+	}
+	hookCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	var err error
+	switch {
+	case hook.Func != nil:
+		err = runHookFunc(hookCtx, hook.Func)
+	case len(hook.Command) > 0:
+		err = runHookCommand(hookCtx, hook.Command)
+	default:
+		err = fmt.Errorf("hook has no command or function")
+	}
+
+	result := HookResult{
+		Name:     hook.Name,
+		Duration: time.Since(start),
+	}
+	if err == nil {
+		return result
+	}
+
+	if hookCtx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+
+	var veto *VetoError
+	switch {
+	case errors.As(err, &veto):
+		result.Vetoed = true
+		result.Reason = veto.Reason
+	case hook.VetoOnFailure:
+		result.Vetoed = true
+		result.Reason = fmt.Sprintf("hook %s failed: %v", hook.Name, err)
+		result.Err = err
+	default:
+		result.Err = err
+	}
+
+	return result
+}
+
+// runHookFunc runs a Go callback, returning when it finishes or the context ends
+func runHookFunc(ctx context.Context, fn PreStopFunc) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- fn(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runHookCommand runs an executable hook. The hook and any processes it
+// starts are killed when the context ends, so a child holding the output
+// open can't keep the hook running past its timeout.
+func runHookCommand(ctx context.Context, command []string) error {
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.WaitDelay = resources.ExecWaitDelay
+	resources.KillProcessGroup(cmd)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	reason := strings.TrimSpace(output.String())
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == HookVetoExitCode {
+		if reason == "" {
+			reason = fmt.Sprintf("%s vetoed the stop", command[0])
+		}
+		return Veto(reason)
+	}
+
+	if reason != "" {
+		return fmt.Errorf("%w: %s", err, reason)
+	}
+	return err
+}
+
+// summarizeHookResults describes the hook results for a state change report
+func summarizeHookResults(results []HookResult) string {
+	parts := make([]string, 0, len(results))
+	for _, result := range results {
+		switch {
+		case result.Vetoed:
+			parts = append(parts, fmt.Sprintf("%s vetoed: %s", result.Name, result.Reason))
+		case result.Err != nil:
+			parts = append(parts, fmt.Sprintf("%s failed: %v", result.Name, result.Err))
+		default:
+			parts = append(parts, fmt.Sprintf("%s ok (%s)", result.Name, result.Duration.Round(time.Millisecond)))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
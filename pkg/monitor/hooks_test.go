@@ -0,0 +1,212 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol/gen"
+	"google.golang.org/grpc"
+)
+
+func TestRunPreStopHooks_Order(t *testing.T) {
+	var ran []string
+	record := func(name string) PreStopFunc {
+		return func(ctx context.Context) error {
+			ran = append(ran, name)
+			return nil
+		}
+	}
+
+	results, vetoed := runPreStopHooks(context.Background(), []PreStopHook{
+		{Name: "stash", Order: 10, Func: record("stash")},
+		{Name: "checkpoint", Order: 0, Func: record("checkpoint")},
+		{Name: "sync", Order: 10, Func: record("sync")},
+	})
+	if vetoed {
+		t.Fatalf("Expected the stop not to be vetoed")
+	}
+
+	expected := []string{"checkpoint", "stash", "sync"}
+	if strings.Join(ran, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected hooks to run in order %v, got %v", expected, ran)
+	}
+	if len(results) != 3 {
+		t.Errorf("Expected 3 results, got %d", len(results))
+	}
+}
+
+func TestRunPreStopHooks_Veto(t *testing.T) {
+	ranAfterVeto := false
+	results, vetoed := runPreStopHooks(context.Background(), []PreStopHook{
+		{Name: "checkpoint", Func: func(ctx context.Context) error {
+			return Veto("checkpoint in progress")
+		}},
+		{Name: "stash", Order: 1, Func: func(ctx context.Context) error {
+			ranAfterVeto = true
+			return nil
+		}},
+	})
+
+	if !vetoed {
+		t.Fatalf("Expected the stop to be vetoed")
+	}
+	if ranAfterVeto {
+		t.Errorf("Expected no hooks to run after a veto")
+	}
+	if len(results) != 1 || results[0].Reason != "checkpoint in progress" {
+		t.Errorf("Unexpected results: %+v", results)
+	}
+}
+
+func TestRunPreStopHooks_Command(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+
+	results, vetoed := runPreStopHooks(context.Background(), []PreStopHook{
+		{Name: "ok", Command: []string{"sh", "-c", "exit 0"}},
+		{Name: "broken", Order: 1, Command: []string{"sh", "-c", "echo no remote >&2; exit 1"}},
+		{Name: "busy", Order: 2, Command: []string{"sh", "-c", "echo uncommitted changes; exit 75"}},
+	})
+
+	if !vetoed {
+		t.Fatalf("Expected the stop to be vetoed")
+	}
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(results))
+	}
+	if results[0].Err != nil || results[0].Vetoed {
+		t.Errorf("Expected the first hook to succeed, got %+v", results[0])
+	}
+	if results[1].Err == nil || results[1].Vetoed || !strings.Contains(results[1].Err.Error(), "no remote") {
+		t.Errorf("Expected the second hook to fail without vetoing, got %+v", results[1])
+	}
+	if results[2].Reason != "uncommitted changes" {
+		t.Errorf("Expected veto reason from output, got %q", results[2].Reason)
+	}
+}
+
+func TestRunPreStopHooks_Timeout(t *testing.T) {
+	slow := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	start := time.Now()
+	results, vetoed := runPreStopHooks(context.Background(), []PreStopHook{
+		{Name: "slow", Timeout: 50 * time.Millisecond, Func: slow},
+	})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Hook ran past its timeout: %v", elapsed)
+	}
+	if vetoed || results[0].Err == nil {
+		t.Errorf("Expected a timed out hook to fail without vetoing, got %+v", results[0])
+	}
+
+	// A hook that must succeed vetoes the stop when it times out
+	results, vetoed = runPreStopHooks(context.Background(), []PreStopHook{
+		{Name: "flush", Timeout: 50 * time.Millisecond, Func: slow, VetoOnFailure: true},
+	})
+	if !vetoed || !strings.Contains(results[0].Reason, "timed out") {
+		t.Errorf("Expected a timeout veto, got %+v", results[0])
+	}
+
+	// A command hook is stopped even when a process it started holds its
+	// output open
+	if _, err := exec.LookPath("sh"); err == nil {
+		start = time.Now()
+		results, _ = runPreStopHooks(context.Background(), []PreStopHook{
+			{Name: "stash", Timeout: 100 * time.Millisecond, Command: []string{"sh", "-c", "sleep 5; echo done"}},
+		})
+		if elapsed := time.Since(start); elapsed > 3*time.Second {
+			t.Errorf("Command hook ran past its timeout: %v", elapsed)
+		}
+		if results[0].Err == nil {
+			t.Errorf("Expected the command hook to time out, got %+v", results[0])
+		}
+	}
+
+	// Errors that aren't vetoes still veto when the hook must succeed
+	results, vetoed = runPreStopHooks(context.Background(), []PreStopHook{
+		{Name: "flush", Func: func(ctx context.Context) error { return errors.New("disk full") }, VetoOnFailure: true},
+	})
+	if !vetoed || !strings.Contains(results[0].Reason, "disk full") {
+		t.Errorf("Expected a failure veto, got %+v", results[0])
+	}
+}
+
+// stateChangeAgent records the state changes reported to it
+type stateChangeAgent struct {
+	gen.UnimplementedSnoozeAgentServer
+	changes chan *gen.StateChangeRequest
+}
+
+func (a *stateChangeAgent) ReportStateChange(ctx context.Context, req *gen.StateChangeRequest) (*gen.StateChangeResponse, error) {
+	a.changes <- req
+	return &gen.StateChangeResponse{Acknowledged: true}, nil
+}
+
+func TestMonitor_PrepareStop(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	agent := &stateChangeAgent{changes: make(chan *gen.StateChangeRequest, 4)}
+	server := grpc.NewServer()
+	gen.RegisterSnoozeAgentServer(server, agent)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	client := protocol.NewAgentClient(listener.Addr().String(), "i-test")
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatalf("Failed to connect to agent: %v", err)
+	}
+	defer client.Disconnect()
+
+	calls := 0
+	config := DefaultConfig()
+	config.NapTime = time.Hour
+	m := newMonitor(config)
+	m.ctx = context.Background()
+	m.AddPreStopHook(PreStopHook{Name: "checkpoint", Func: func(ctx context.Context) error {
+		calls++
+		return Veto("training step 1200 not saved")
+	}})
+
+	idleSince := time.Now().Add(-2 * time.Hour)
+	m.currentState.IsIdle = true
+	m.currentState.IdleSince = idleSince
+
+	m.processAgentCommand(client, "stop")
+
+	select {
+	case change := <-agent.changes:
+		if change.PreviousState != "idle" || change.CurrentState != "stop_vetoed" {
+			t.Errorf("Expected idle -> stop_vetoed, got %s -> %s", change.PreviousState, change.CurrentState)
+		}
+		if change.Reason != "training step 1200 not saved" {
+			t.Errorf("Expected veto reason, got %q", change.Reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the state change report")
+	}
+	m.wg.Wait()
+
+	// The veto restarts the idle timer
+	if !m.IsIdle() || !m.GetCurrentState().IdleSince.After(idleSince) {
+		t.Errorf("Expected the idle timer to restart after a veto")
+	}
+
+	// Repeated stop commands are ignored until the nap time has passed again
+	m.processAgentCommand(client, "stop")
+	m.wg.Wait()
+	if calls != 1 {
+		t.Errorf("Expected the hook to run once, got %d", calls)
+	}
+}
@@ -0,0 +1,539 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultInhibitSocket is where the inhibitor API is served by default
+const DefaultInhibitSocket = "/run/snoozebot/inhibit.sock"
+
+// MaxInhibitDuration is the longest a lock is held. Every lock expires, so a
+// holder that crashes without releasing its lock can't keep the instance
+// awake forever. Holders that need longer take a new lock before it expires.
+const MaxInhibitDuration = 24 * time.Hour
+
+// MaxLocksPerUser is how many locks a user may hold at once. Any user on
+// the instance can reach the socket, so no user can grow the registry
+// without bound.
+const MaxLocksPerUser = 64
+
+// maxInhibitRequestSize limits the body of a request to take a lock
+const maxInhibitRequestSize = 4 << 10
+
+// InhibitLock is a named keep-awake lock. While any lock is held the
+// system is treated as active.
+type InhibitLock struct {
+	// ID identifies the lock when it is released
+	ID string `json:"id"`
+	// Who names the program holding the lock
+	Who string `json:"who"`
+	// Why explains what the program is doing
+	Why string `json:"why"`
+	// PID is the process holding the lock, if known
+	PID int `json:"pid,omitempty"`
+	// UID is the user holding the lock. Only that user or root may release it.
+	UID int `json:"uid"`
+	// Since is when the lock was taken
+	Since time.Time `json:"since"`
+	// Expires is when the lock is released automatically
+	Expires time.Time `json:"expires"`
+}
+
+// String describes the lock holder
+func (l InhibitLock) String() string {
+	if l.Why == "" {
+		return l.Who
+	}
+	return fmt.Sprintf("%s (%s)", l.Who, l.Why)
+}
+
+// InhibitRequest asks for a keep-awake lock
+type InhibitRequest struct {
+	// Who names the program taking the lock
+	Who string `json:"who"`
+	// Why explains what the program is doing
+	Why string `json:"why"`
+	// PID is the process taking the lock, if known
+	PID int `json:"pid,omitempty"`
+	// UID is the user taking the lock. The inhibitor API sets it, and the
+	// PID, from the credentials of the process connected to the socket.
+	UID int `json:"-"`
+	// Duration releases the lock automatically after this long. Zero, or
+	// more than MaxInhibitDuration, means MaxInhibitDuration. It is sent as
+	// a duration string such as "30m".
+	Duration time.Duration `json:"-"`
+}
+
+// inhibitRequestJSON is the wire form of InhibitRequest
+type inhibitRequestJSON struct {
+	Who      string `json:"who"`
+	Why      string `json:"why"`
+	PID      int    `json:"pid,omitempty"`
+	Duration string `json:"duration,omitempty"`
+}
+
+// MarshalJSON encodes the request with a readable duration
+func (r InhibitRequest) MarshalJSON() ([]byte, error) {
+	wire := inhibitRequestJSON{Who: r.Who, Why: r.Why, PID: r.PID}
+	if r.Duration != 0 {
+		wire.Duration = r.Duration.String()
+	}
+	return json.Marshal(wire)
+}
+
+// UnmarshalJSON decodes a request with a duration string such as "30m"
+func (r *InhibitRequest) UnmarshalJSON(data []byte) error {
+	var wire inhibitRequestJSON
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+
+	*r = InhibitRequest{Who: wire.Who, Why: wire.Why, PID: wire.PID}
+	if wire.Duration != "" {
+		duration, err := time.ParseDuration(wire.Duration)
+		if err != nil {
+			return fmt.Errorf("invalid duration: %w", err)
+		}
+		r.Duration = duration
+	}
+	return nil
+}
+
+// ErrLockNotFound is returned when releasing a lock that isn't held
+var ErrLockNotFound = errors.New("inhibit lock not found")
+
+// ErrLockNotOwned is returned when releasing a lock held by another user
+var ErrLockNotOwned = errors.New("inhibit lock is held by another user")
+
+// ErrTooManyLocks is returned when a user already holds MaxLocksPerUser locks
+var ErrTooManyLocks = errors.New("too many inhibit locks held by this user")
+
+// Inhibitors is a registry of keep-awake locks
+type Inhibitors struct {
+	locks map[string]InhibitLock
+	now   func() time.Time
+	mutex sync.Mutex
+}
+
+// NewInhibitors creates an empty lock registry
+func NewInhibitors() *Inhibitors {
+	return &Inhibitors{
+		locks: make(map[string]InhibitLock),
+		now:   time.Now,
+	}
+}
+
+// Acquire takes a keep-awake lock. A user may hold up to MaxLocksPerUser
+// locks at once.
+func (i *Inhibitors) Acquire(req InhibitRequest) (InhibitLock, error) {
+	if strings.TrimSpace(req.Who) == "" {
+		return InhibitLock{}, fmt.Errorf("lock holder name is required")
+	}
+	if req.Duration < 0 {
+		return InhibitLock{}, fmt.Errorf("lock duration must not be negative")
+	}
+
+	id, err := newLockID()
+	if err != nil {
+		return InhibitLock{}, err
+	}
+
+	duration := req.Duration
+	if duration == 0 || duration > MaxInhibitDuration {
+		duration = MaxInhibitDuration
+	}
+
+	now := i.now()
+	lock := InhibitLock{
+		ID:      id,
+		Who:     req.Who,
+		Why:     req.Why,
+		PID:     req.PID,
+		UID:     req.UID,
+		Since:   now,
+		Expires: now.Add(duration),
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	held := 0
+	for _, existing := range i.locks {
+		if existing.UID == req.UID && now.Before(existing.Expires) {
+			held++
+		}
+	}
+	if held >= MaxLocksPerUser {
+		return InhibitLock{}, ErrTooManyLocks
+	}
+	i.locks[id] = lock
+
+	return lock, nil
+}
+
+// Release releases a keep-awake lock
+func (i *Inhibitors) Release(id string) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if _, ok := i.locks[id]; !ok {
+		return ErrLockNotFound
+	}
+	delete(i.locks, id)
+
+	return nil
+}
+
+// releaseAs releases a keep-awake lock on behalf of a user, who must hold
+// the lock or be root
+func (i *Inhibitors) releaseAs(id string, uid int) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	lock, ok := i.locks[id]
+	if !ok {
+		return ErrLockNotFound
+	}
+	if uid != 0 && lock.UID != uid {
+		return ErrLockNotOwned
+	}
+	delete(i.locks, id)
+
+	return nil
+}
+
+// Held returns the locks that haven't expired, oldest first
+func (i *Inhibitors) Held() []InhibitLock {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	now := i.now()
+	held := make([]InhibitLock, 0, len(i.locks))
+	for id, lock := range i.locks {
+		if !now.Before(lock.Expires) {
+			delete(i.locks, id)
+			continue
+		}
+		held = append(held, lock)
+	}
+
+	sort.Slice(held, func(a, b int) bool {
+		if held[a].Since.Equal(held[b].Since) {
+			return held[a].ID < held[b].ID
+		}
+		return held[a].Since.Before(held[b].Since)
+	})
+
+	return held
+}
+
+// newLockID returns a random lock ID
+func newLockID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate lock ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// describeInhibitors summarizes the lock holders for the agent
+func describeInhibitors(locks []InhibitLock) string {
+	holders := make([]string, len(locks))
+	for i, lock := range locks {
+		holders[i] = lock.String()
+	}
+	return strings.Join(holders, ", ")
+}
+
+// InhibitServer serves the inhibitor API:
+//
+//	GET    /inhibitors       lists the held locks
+//	POST   /inhibitors       takes a lock described by an InhibitRequest
+//	DELETE /inhibitors/{id}  releases a lock
+//
+// Callers are identified by the kernel's credentials for the socket peer.
+// Locks are owned by the user that took them, and only that user or root
+// may release them. Everyone can see which locks are held, but the IDs of
+// other users' locks are left out.
+type InhibitServer struct {
+	inhibitors *Inhibitors
+}
+
+// peerCred identifies the process connected to the inhibitor socket
+type peerCred struct {
+	uid int
+	pid int
+}
+
+// peerCredKey is the request context key for the caller's credentials
+type peerCredKey struct{}
+
+// callerCredentials returns the credentials of the process that sent the
+// request, if they are known
+func callerCredentials(r *http.Request) (peerCred, bool) {
+	cred, ok := r.Context().Value(peerCredKey{}).(peerCred)
+	return cred, ok
+}
+
+// visibleLocks hides the IDs of locks the caller may not release
+func visibleLocks(locks []InhibitLock, cred peerCred, known bool) []InhibitLock {
+	for i := range locks {
+		if !known || (cred.uid != 0 && locks[i].UID != cred.uid) {
+			locks[i].ID = ""
+		}
+	}
+	return locks
+}
+
+// NewInhibitServer creates a server for the given lock registry
+func NewInhibitServer(inhibitors *Inhibitors) *InhibitServer {
+	return &InhibitServer{inhibitors: inhibitors}
+}
+
+// ServeHTTP handles inhibitor API requests
+func (s *InhibitServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var id string
+	switch {
+	case r.URL.Path == "/inhibitors":
+	case strings.HasPrefix(r.URL.Path, "/inhibitors/"):
+		id = strings.TrimPrefix(r.URL.Path, "/inhibitors/")
+		if id == "" || strings.Contains(id, "/") {
+			http.NotFound(w, r)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	cred, known := callerCredentials(r)
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, visibleLocks(s.inhibitors.Held(), cred, known))
+
+	case id == "" && r.Method == http.MethodPost:
+		if !known {
+			http.Error(w, "Caller could not be identified", http.StatusForbidden)
+			return
+		}
+		var req InhibitRequest
+		r.Body = http.MaxBytesReader(w, r.Body, maxInhibitRequestSize)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			status := http.StatusBadRequest
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			http.Error(w, fmt.Sprintf("Invalid request: %v", err), status)
+			return
+		}
+		req.UID = cred.uid
+		req.PID = cred.pid
+		lock, err := s.inhibitors.Acquire(req)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, ErrTooManyLocks) {
+				status = http.StatusTooManyRequests
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		writeJSON(w, http.StatusCreated, lock)
+
+	case id != "" && r.Method == http.MethodDelete:
+		if !known {
+			http.Error(w, "Caller could not be identified", http.StatusForbidden)
+			return
+		}
+		if err := s.inhibitors.releaseAs(id, cred.uid); err != nil {
+			status := http.StatusNotFound
+			if errors.Is(err, ErrLockNotOwned) {
+				status = http.StatusForbidden
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ListenAndServe serves the inhibitor API on a Unix socket until the context
+// is cancelled. Any process on the instance may connect to the socket.
+func (s *InhibitServer) ListenAndServe(ctx context.Context, socketPath string) error {
+	listener, err := listenUnix(socketPath)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve serves the inhibitor API on a listener until the context is
+// cancelled. Locks can only be taken and released over a Unix socket, where
+// the caller can be identified.
+func (s *InhibitServer) Serve(ctx context.Context, listener net.Listener) error {
+	server := &http.Server{
+		Handler: s,
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			if cred, err := peerCredentials(conn); err == nil {
+				ctx = context.WithValue(ctx, peerCredKey{}, cred)
+			}
+			return ctx
+		},
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			server.Close()
+		case <-done:
+		}
+	}()
+
+	err := server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// listenUnix listens on a Unix socket, replacing a stale socket file
+func listenUnix(socketPath string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", socketPath, err)
+	}
+	if err := os.Chmod(socketPath, 0666); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+
+	return listener, nil
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// InhibitClient takes keep-awake locks through the inhibitor API
+type InhibitClient struct {
+	client *http.Client
+}
+
+// NewInhibitClient creates a client for the inhibitor API served on a Unix
+// socket. If socketPath is empty, DefaultInhibitSocket is used.
+func NewInhibitClient(socketPath string) *InhibitClient {
+	if socketPath == "" {
+		socketPath = DefaultInhibitSocket
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+	}
+
+	return &InhibitClient{client: &http.Client{Transport: transport}}
+}
+
+// Inhibit takes a keep-awake lock for the current process. If duration is
+// zero the lock is held until it is released or MaxInhibitDuration passes.
+func (c *InhibitClient) Inhibit(ctx context.Context, who, why string, duration time.Duration) (*InhibitLock, error) {
+	body, err := json.Marshal(InhibitRequest{
+		Who:      who,
+		Why:      why,
+		PID:      os.Getpid(),
+		Duration: duration,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var lock InhibitLock
+	if err := c.do(ctx, http.MethodPost, "/inhibitors", bytes.NewReader(body), http.StatusCreated, &lock); err != nil {
+		return nil, err
+	}
+
+	return &lock, nil
+}
+
+// Release releases a keep-awake lock
+func (c *InhibitClient) Release(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/inhibitors/"+id, nil, http.StatusNoContent, nil)
+}
+
+// List returns the held keep-awake locks
+func (c *InhibitClient) List(ctx context.Context) ([]InhibitLock, error) {
+	var locks []InhibitLock
+	if err := c.do(ctx, http.MethodGet, "/inhibitors", nil, http.StatusOK, &locks); err != nil {
+		return nil, err
+	}
+	return locks, nil
+}
+
+// do sends a request and decodes the response into out if it isn't nil
+func (c *InhibitClient) do(ctx context.Context, method, path string, body io.Reader, expected int, out interface{}) error {
+	// The host is ignored, requests always go to the socket
+	req, err := http.NewRequestWithContext(ctx, method, "http://snoozed"+path, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach inhibitor API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound && method == http.MethodDelete {
+		return ErrLockNotFound
+	}
+	if resp.StatusCode == http.StatusForbidden && method == http.MethodDelete {
+		return ErrLockNotOwned
+	}
+	if resp.StatusCode == http.StatusTooManyRequests && method == http.MethodPost {
+		return ErrTooManyLocks
+	}
+	if resp.StatusCode != expected {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("inhibitor API returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,36 @@
+// +build linux
+
+package monitor
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// peerCredentials returns the user and process on the other end of a Unix
+// socket connection, as reported by the kernel
+func peerCredentials(conn net.Conn) (peerCred, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return peerCred{}, fmt.Errorf("not a Unix socket connection")
+	}
+
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return peerCred{}, err
+	}
+
+	var ucred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		ucred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return peerCred{}, err
+	}
+	if credErr != nil {
+		return peerCred{}, fmt.Errorf("failed to read peer credentials: %w", credErr)
+	}
+
+	return peerCred{uid: int(ucred.Uid), pid: int(ucred.Pid)}, nil
+}
@@ -0,0 +1,15 @@
+// +build !linux
+
+package monitor
+
+import (
+	"fmt"
+	"net"
+)
+
+// peerCredentials returns the user and process on the other end of a Unix
+// socket connection
+// This is a stub implementation for non-Linux platforms
+func peerCredentials(conn net.Conn) (peerCred, error) {
+	return peerCred{}, fmt.Errorf("peer credentials are only available on Linux")
+}
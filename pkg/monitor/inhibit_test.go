@@ -0,0 +1,218 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInhibitors_Expiry(t *testing.T) {
+	now := time.Now()
+	inhibitors := NewInhibitors()
+	inhibitors.now = func() time.Time { return now }
+
+	if _, err := inhibitors.Acquire(InhibitRequest{Why: "no name"}); err == nil {
+		t.Errorf("Expected an error for a lock without a holder name")
+	}
+
+	untimed, err := inhibitors.Acquire(InhibitRequest{Who: "train.py", Why: "waiting on remote API"})
+	if err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
+	}
+	now = now.Add(time.Second)
+	if _, err := inhibitors.Acquire(InhibitRequest{Who: "poller", Duration: time.Minute}); err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
+	}
+
+	held := inhibitors.Held()
+	if len(held) != 2 || held[0].ID != untimed.ID {
+		t.Fatalf("Expected 2 locks oldest first, got %+v", held)
+	}
+	if describeInhibitors(held) != "train.py (waiting on remote API), poller" {
+		t.Errorf("Unexpected description: %s", describeInhibitors(held))
+	}
+
+	// The timed lock expires on its own
+	now = now.Add(time.Minute)
+	held = inhibitors.Held()
+	if len(held) != 1 || held[0].ID != untimed.ID {
+		t.Errorf("Expected only the lock without a duration, got %+v", held)
+	}
+
+	if err := inhibitors.Release(untimed.ID); err != nil {
+		t.Errorf("Failed to release lock: %v", err)
+	}
+	if err := inhibitors.Release(untimed.ID); !errors.Is(err, ErrLockNotFound) {
+		t.Errorf("Expected ErrLockNotFound, got %v", err)
+	}
+	if len(inhibitors.Held()) != 0 {
+		t.Errorf("Expected no locks")
+	}
+
+	// Locks without a duration, or with a longer one, expire after the maximum
+	if _, err := inhibitors.Acquire(InhibitRequest{Who: "crashed"}); err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
+	}
+	if _, err := inhibitors.Acquire(InhibitRequest{Who: "greedy", Duration: 7 * 24 * time.Hour}); err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
+	}
+	now = now.Add(MaxInhibitDuration)
+	if held := inhibitors.Held(); len(held) != 0 {
+		t.Errorf("Expected every lock to expire after %s, got %+v", MaxInhibitDuration, held)
+	}
+}
+
+func TestInhibitors_Ownership(t *testing.T) {
+	inhibitors := NewInhibitors()
+	lock, err := inhibitors.Acquire(InhibitRequest{Who: "etl", UID: 1000})
+	if err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
+	}
+	other, err := inhibitors.Acquire(InhibitRequest{Who: "backup", UID: 1001})
+	if err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
+	}
+
+	// Other users only see the IDs of their own locks
+	visible := visibleLocks(inhibitors.Held(), peerCred{uid: 1000}, true)
+	for _, held := range visible {
+		if (held.ID == "") == (held.UID == 1000) {
+			t.Errorf("Expected only the caller's lock IDs to be visible, got %+v", visible)
+		}
+	}
+	for _, held := range visibleLocks(inhibitors.Held(), peerCred{}, false) {
+		if held.ID != "" {
+			t.Errorf("Expected no lock IDs for an unknown caller, got %+v", held)
+		}
+	}
+	if visible := visibleLocks(inhibitors.Held(), peerCred{uid: 0}, true); visible[0].ID == "" || visible[1].ID == "" {
+		t.Errorf("Expected root to see every lock ID, got %+v", visible)
+	}
+
+	// Only the holder or root may release a lock
+	if err := inhibitors.releaseAs(lock.ID, 1001); !errors.Is(err, ErrLockNotOwned) {
+		t.Errorf("Expected ErrLockNotOwned, got %v", err)
+	}
+	if err := inhibitors.releaseAs(lock.ID, 1000); err != nil {
+		t.Errorf("Failed to release own lock: %v", err)
+	}
+	if err := inhibitors.releaseAs(other.ID, 0); err != nil {
+		t.Errorf("Failed to release lock as root: %v", err)
+	}
+	if len(inhibitors.Held()) != 0 {
+		t.Errorf("Expected no locks")
+	}
+
+	// Each user may only hold so many locks
+	for i := 0; i < MaxLocksPerUser; i++ {
+		if _, err := inhibitors.Acquire(InhibitRequest{Who: "loop", UID: 1000}); err != nil {
+			t.Fatalf("Failed to acquire lock %d: %v", i, err)
+		}
+	}
+	if _, err := inhibitors.Acquire(InhibitRequest{Who: "loop", UID: 1000}); !errors.Is(err, ErrTooManyLocks) {
+		t.Errorf("Expected ErrTooManyLocks, got %v", err)
+	}
+	if _, err := inhibitors.Acquire(InhibitRequest{Who: "etl", UID: 1001}); err != nil {
+		t.Errorf("Expected another user to take a lock, got %v", err)
+	}
+}
+
+func TestInhibitClient(t *testing.T) {
+	// Socket paths are limited in length, so avoid the long test temp dir
+	dir, err := os.MkdirTemp("", "inhibit")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "run", "inhibit.sock")
+
+	inhibitors := NewInhibitors()
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- NewInhibitServer(inhibitors).ListenAndServe(ctx, socketPath)
+	}()
+
+	client := NewInhibitClient(socketPath)
+	var lock *InhibitLock
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		lock, err = client.Inhibit(context.Background(), "backup", "uploading snapshot", 30*time.Minute)
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Failed to take lock: %v", err)
+	}
+
+	if lock.PID != os.Getpid() || lock.UID != os.Getuid() {
+		t.Errorf("Expected PID %d and UID %d, got %d and %d", os.Getpid(), os.Getuid(), lock.PID, lock.UID)
+	}
+	if lock.Expires.Sub(lock.Since) != 30*time.Minute {
+		t.Errorf("Expected lock to expire in 30m, got %s", lock.Expires.Sub(lock.Since))
+	}
+
+	// Oversized requests are rejected
+	if _, err := client.Inhibit(context.Background(), "backup", strings.Repeat("x", maxInhibitRequestSize), 0); err == nil {
+		t.Errorf("Expected an oversized request to be rejected")
+	}
+
+	locks, err := client.List(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to list locks: %v", err)
+	}
+	if len(locks) != 1 || locks[0].ID != lock.ID || locks[0].Who != "backup" {
+		t.Errorf("Unexpected locks: %+v", locks)
+	}
+
+	if err := client.Release(context.Background(), lock.ID); err != nil {
+		t.Errorf("Failed to release lock: %v", err)
+	}
+	if err := client.Release(context.Background(), lock.ID); !errors.Is(err, ErrLockNotFound) {
+		t.Errorf("Expected ErrLockNotFound, got %v", err)
+	}
+	if len(inhibitors.Held()) != 0 {
+		t.Errorf("Expected no locks after release")
+	}
+
+	cancel()
+	if err := <-served; err != nil {
+		t.Errorf("Server failed: %v", err)
+	}
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the socket to be removed")
+	}
+}
+
+func TestMonitor_Inhibited(t *testing.T) {
+	config := DefaultConfig()
+	config.Thresholds = map[ResourceType]float64{CPU: 10.0}
+	m := newMonitor(config)
+	m.currentState.CurrentUsage[CPU] = &ResourceUsage{Type: CPU, Value: 1.0, Timestamp: time.Now()}
+
+	lock, err := m.Inhibitors().Acquire(InhibitRequest{Who: "sleep-poller"})
+	if err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
+	}
+
+	m.checkIdleState()
+	state := m.GetCurrentState()
+	if state.IsIdle {
+		t.Errorf("Expected the system to be active while a lock is held")
+	}
+	if len(state.Inhibitors) != 1 || state.Inhibitors[0].Who != "sleep-poller" {
+		t.Errorf("Expected the lock holder in the state, got %+v", state.Inhibitors)
+	}
+
+	m.Inhibitors().Release(lock.ID)
+	m.checkIdleState()
+	if !m.IsIdle() {
+		t.Errorf("Expected the system to be idle after the lock is released")
+	}
+}
@@ -0,0 +1,406 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMetadataTimeout is how long each metadata source may take to answer.
+// Metadata services answer in milliseconds, so a missing one is detected quickly.
+const DefaultMetadataTimeout = 500 * time.Millisecond
+
+// unknownMetadata is reported for metadata fields that no source could resolve
+const unknownMetadata = "unknown"
+
+// InstanceMetadata describes the instance the monitor runs on
+type InstanceMetadata struct {
+	// InstanceID identifies the instance to the cloud provider, such as
+	// i-0123456789abcdef0 on AWS or the VM name on GCP and Azure
+	InstanceID string
+	// InstanceType is the machine type, such as t3.large
+	InstanceType string
+	// Region is the cloud region, such as us-east-1
+	Region string
+	// Zone is the availability zone, such as us-east-1a
+	Zone string
+	// Provider is the cloud provider, such as aws, gcp or azure
+	Provider string
+}
+
+// merge fills the empty fields of the metadata from other
+func (md *InstanceMetadata) merge(other *InstanceMetadata) {
+	if md.InstanceID == "" {
+		md.InstanceID = other.InstanceID
+	}
+	if md.InstanceType == "" {
+		md.InstanceType = other.InstanceType
+	}
+	if md.Region == "" {
+		md.Region = other.Region
+	}
+	if md.Zone == "" {
+		md.Zone = other.Zone
+	}
+	if md.Provider == "" {
+		md.Provider = other.Provider
+	}
+}
+
+// MetadataResolver resolves instance metadata from a single source
+type MetadataResolver interface {
+	// Name returns the name of the source, such as aws
+	Name() string
+	// Resolve returns the metadata known to the source.
+	// It returns an error if the source is not available on this instance.
+	Resolve(ctx context.Context) (*InstanceMetadata, error)
+}
+
+// MetadataChain resolves instance metadata by asking each resolver in turn.
+// Fields are taken from the first resolver that knows them, and the chain
+// stops at the first resolver that returns an instance ID. The result is cached.
+type MetadataChain struct {
+	resolvers []MetadataResolver
+	timeout   time.Duration
+	cached    *InstanceMetadata
+	mutex     sync.Mutex
+}
+
+// NewMetadataChain creates a resolver chain
+func NewMetadataChain(resolvers ...MetadataResolver) *MetadataChain {
+	return &MetadataChain{
+		resolvers: resolvers,
+		timeout:   DefaultMetadataTimeout,
+	}
+}
+
+// DefaultMetadataResolvers returns the standard chain: explicit configuration,
+// AWS, GCP and Azure metadata services, then the host name and machine ID
+func DefaultMetadataResolvers(static InstanceMetadata) []MetadataResolver {
+	return []MetadataResolver{
+		&StaticMetadataResolver{Metadata: static},
+		&AWSMetadataResolver{},
+		&GCPMetadataResolver{},
+		&AzureMetadataResolver{},
+		&HostMetadataResolver{},
+	}
+}
+
+// newMetadataChain creates the resolver chain for a monitor configuration.
+// Explicitly configured metadata always comes first.
+func newMetadataChain(config Config) *MetadataChain {
+	if config.MetadataResolvers == nil {
+		return NewMetadataChain(DefaultMetadataResolvers(config.InstanceMetadata)...)
+	}
+
+	resolvers := []MetadataResolver{&StaticMetadataResolver{Metadata: config.InstanceMetadata}}
+	return NewMetadataChain(append(resolvers, config.MetadataResolvers...)...)
+}
+
+// Resolve returns the instance metadata, resolving it on first use.
+// Fields that no resolver knows are reported as "unknown".
+func (c *MetadataChain) Resolve(ctx context.Context) InstanceMetadata {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.cached != nil {
+		return *c.cached
+	}
+
+	metadata := &InstanceMetadata{}
+	for _, resolver := range c.resolvers {
+		resolveCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		resolved, err := resolver.Resolve(resolveCtx)
+		cancel()
+		if err != nil {
+			continue
+		}
+
+		metadata.merge(resolved)
+		if metadata.InstanceID != "" {
+			break
+		}
+	}
+
+	metadata.merge(&InstanceMetadata{
+		InstanceID:   unknownMetadata,
+		InstanceType: unknownMetadata,
+		Region:       unknownMetadata,
+		Zone:         unknownMetadata,
+		Provider:     unknownMetadata,
+	})
+
+	// Don't cache a failed lookup caused by cancellation, such as during shutdown
+	if ctx.Err() == nil {
+		c.cached = metadata
+	}
+
+	return *metadata
+}
+
+// StaticMetadataResolver returns explicitly configured metadata.
+// Fields left empty are resolved by the rest of the chain.
+type StaticMetadataResolver struct {
+	Metadata InstanceMetadata
+}
+
+// Name returns the name of the source
+func (r *StaticMetadataResolver) Name() string {
+	return "static"
+}
+
+// Resolve returns the configured metadata
+func (r *StaticMetadataResolver) Resolve(ctx context.Context) (*InstanceMetadata, error) {
+	metadata := r.Metadata
+	return &metadata, nil
+}
+
+// defaultMetadataClient queries metadata services directly, never through a proxy
+var defaultMetadataClient = &http.Client{
+	Timeout:   DefaultMetadataTimeout,
+	Transport: &http.Transport{Proxy: nil},
+}
+
+// metadataClient returns the HTTP client used to query a metadata service
+func metadataClient(client *http.Client) *http.Client {
+	if client != nil {
+		return client
+	}
+	return defaultMetadataClient
+}
+
+// metadataRequest performs a metadata service request and returns the body
+func metadataRequest(ctx context.Context, client *http.Client, method, url string, headers map[string]string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return "", err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s %s returned status %d", method, url, resp.StatusCode)
+	}
+
+	return strings.TrimSpace(string(body)), nil
+}
+
+// AWSMetadataResolver resolves metadata from the EC2 instance metadata service using IMDSv2
+type AWSMetadataResolver struct {
+	// Endpoint is the metadata service URL, http://169.254.169.254 by default
+	Endpoint string
+	// Client is the HTTP client to use. If nil, a client with a short timeout is used.
+	Client *http.Client
+}
+
+// Name returns the name of the source
+func (r *AWSMetadataResolver) Name() string {
+	return "aws"
+}
+
+// Resolve returns the instance metadata from IMDSv2
+func (r *AWSMetadataResolver) Resolve(ctx context.Context) (*InstanceMetadata, error) {
+	endpoint := r.Endpoint
+	if endpoint == "" {
+		endpoint = "http://169.254.169.254"
+	}
+	client := metadataClient(r.Client)
+
+	// IMDSv2 requires a session token for every request
+	token, err := metadataRequest(ctx, client, http.MethodPut, endpoint+"/latest/api/token", map[string]string{
+		"X-aws-ec2-metadata-token-ttl-seconds": "60",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get IMDSv2 token: %w", err)
+	}
+	headers := map[string]string{"X-aws-ec2-metadata-token": token}
+
+	get := func(path string) (string, error) {
+		return metadataRequest(ctx, client, http.MethodGet, endpoint+"/latest/meta-data/"+path, headers)
+	}
+
+	instanceID, err := get("instance-id")
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := &InstanceMetadata{
+		InstanceID: instanceID,
+		Provider:   "aws",
+	}
+	metadata.InstanceType, _ = get("instance-type")
+	metadata.Zone, _ = get("placement/availability-zone")
+	metadata.Region, _ = get("placement/region")
+
+	return metadata, nil
+}
+
+// GCPMetadataResolver resolves metadata from the Compute Engine metadata server
+type GCPMetadataResolver struct {
+	// Endpoint is the metadata server URL, http://metadata.google.internal by default
+	Endpoint string
+	// Client is the HTTP client to use. If nil, a client with a short timeout is used.
+	Client *http.Client
+}
+
+// Name returns the name of the source
+func (r *GCPMetadataResolver) Name() string {
+	return "gcp"
+}
+
+// Resolve returns the instance metadata from the metadata server.
+// The instance ID is the instance name, which the Compute Engine API uses.
+func (r *GCPMetadataResolver) Resolve(ctx context.Context) (*InstanceMetadata, error) {
+	endpoint := r.Endpoint
+	if endpoint == "" {
+		endpoint = "http://metadata.google.internal"
+	}
+	client := metadataClient(r.Client)
+	headers := map[string]string{"Metadata-Flavor": "Google"}
+
+	get := func(path string) (string, error) {
+		return metadataRequest(ctx, client, http.MethodGet, endpoint+"/computeMetadata/v1/instance/"+path, headers)
+	}
+
+	name, err := get("name")
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := &InstanceMetadata{
+		InstanceID: name,
+		Provider:   "gcp",
+	}
+
+	// Machine type and zone are resource paths such as projects/123/zones/us-central1-a
+	if machineType, err := get("machine-type"); err == nil {
+		metadata.InstanceType = lastPathElement(machineType)
+	}
+	if zone, err := get("zone"); err == nil {
+		metadata.Zone = lastPathElement(zone)
+		if i := strings.LastIndex(metadata.Zone, "-"); i > 0 {
+			metadata.Region = metadata.Zone[:i]
+		}
+	}
+
+	return metadata, nil
+}
+
+// lastPathElement returns the part of a path after the last slash
+func lastPathElement(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+// AzureMetadataResolver resolves metadata from the Azure Instance Metadata Service
+type AzureMetadataResolver struct {
+	// Endpoint is the metadata service URL, http://169.254.169.254 by default
+	Endpoint string
+	// Client is the HTTP client to use. If nil, a client with a short timeout is used.
+	Client *http.Client
+}
+
+// Name returns the name of the source
+func (r *AzureMetadataResolver) Name() string {
+	return "azure"
+}
+
+// Resolve returns the instance metadata from IMDS.
+// The instance ID is the VM name, which the Azure compute API uses.
+func (r *AzureMetadataResolver) Resolve(ctx context.Context) (*InstanceMetadata, error) {
+	endpoint := r.Endpoint
+	if endpoint == "" {
+		endpoint = "http://169.254.169.254"
+	}
+
+	body, err := metadataRequest(ctx, metadataClient(r.Client), http.MethodGet,
+		endpoint+"/metadata/instance/compute?api-version=2021-02-01",
+		map[string]string{"Metadata": "true"})
+	if err != nil {
+		return nil, err
+	}
+
+	var compute struct {
+		Name     string `json:"name"`
+		VMSize   string `json:"vmSize"`
+		Location string `json:"location"`
+		Zone     string `json:"zone"`
+	}
+	if err := json.Unmarshal([]byte(body), &compute); err != nil {
+		return nil, fmt.Errorf("failed to parse Azure metadata: %w", err)
+	}
+	if compute.Name == "" {
+		return nil, fmt.Errorf("Azure metadata has no VM name")
+	}
+
+	metadata := &InstanceMetadata{
+		InstanceID:   compute.Name,
+		InstanceType: compute.VMSize,
+		Region:       compute.Location,
+		Provider:     "azure",
+	}
+
+	// Zones are numbered within the region
+	if compute.Zone != "" {
+		metadata.Zone = compute.Location + "-" + compute.Zone
+	}
+
+	return metadata, nil
+}
+
+// HostMetadataResolver identifies the instance by its machine ID or host name.
+// It is the last resort for instances outside a supported cloud.
+type HostMetadataResolver struct {
+	// MachineIDPath is the machine ID file, /etc/machine-id by default
+	MachineIDPath string
+	// Hostname returns the host name. If nil, os.Hostname is used.
+	Hostname func() (string, error)
+}
+
+// Name returns the name of the source
+func (r *HostMetadataResolver) Name() string {
+	return "host"
+}
+
+// Resolve returns the machine ID, or the host name if there is none
+func (r *HostMetadataResolver) Resolve(ctx context.Context) (*InstanceMetadata, error) {
+	path := r.MachineIDPath
+	if path == "" {
+		path = "/etc/machine-id"
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		if machineID := strings.TrimSpace(string(data)); machineID != "" {
+			return &InstanceMetadata{InstanceID: machineID}, nil
+		}
+	}
+
+	hostname := r.Hostname
+	if hostname == nil {
+		hostname = os.Hostname
+	}
+	name, err := hostname()
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, fmt.Errorf("empty host name")
+	}
+
+	return &InstanceMetadata{InstanceID: name}, nil
+}
@@ -0,0 +1,221 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeIMDS is a stand-in for the EC2 instance metadata service that requires IMDSv2 tokens
+func fakeIMDS() *httptest.Server {
+	values := map[string]string{
+		"/latest/meta-data/instance-id":                 "i-0123456789abcdef0",
+		"/latest/meta-data/instance-type":               "g5.xlarge",
+		"/latest/meta-data/placement/availability-zone": "us-west-2b",
+		"/latest/meta-data/placement/region":            "us-west-2",
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/latest/api/token" {
+			if r.Method != http.MethodPut || r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, "test-token")
+			return
+		}
+
+		if r.Header.Get("X-aws-ec2-metadata-token") != "test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		value, ok := values[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, value)
+	}))
+}
+
+func TestAWSMetadataResolver(t *testing.T) {
+	server := fakeIMDS()
+	defer server.Close()
+
+	metadata, err := (&AWSMetadataResolver{Endpoint: server.URL}).Resolve(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to resolve AWS metadata: %v", err)
+	}
+
+	expected := InstanceMetadata{
+		InstanceID:   "i-0123456789abcdef0",
+		InstanceType: "g5.xlarge",
+		Region:       "us-west-2",
+		Zone:         "us-west-2b",
+		Provider:     "aws",
+	}
+	if *metadata != expected {
+		t.Errorf("Expected %+v, got %+v", expected, *metadata)
+	}
+}
+
+func TestGCPMetadataResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/computeMetadata/v1/instance/name":
+			fmt.Fprint(w, "notebook-1")
+		case "/computeMetadata/v1/instance/machine-type":
+			fmt.Fprint(w, "projects/123456/machineTypes/n1-standard-8")
+		case "/computeMetadata/v1/instance/zone":
+			fmt.Fprint(w, "projects/123456/zones/europe-west4-a")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	metadata, err := (&GCPMetadataResolver{Endpoint: server.URL}).Resolve(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to resolve GCP metadata: %v", err)
+	}
+
+	expected := InstanceMetadata{
+		InstanceID:   "notebook-1",
+		InstanceType: "n1-standard-8",
+		Region:       "europe-west4",
+		Zone:         "europe-west4-a",
+		Provider:     "gcp",
+	}
+	if *metadata != expected {
+		t.Errorf("Expected %+v, got %+v", expected, *metadata)
+	}
+}
+
+func TestAzureMetadataResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" || r.URL.Path != "/metadata/instance/compute" || r.URL.Query().Get("api-version") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"name":"dev-vm","vmId":"02aab8a4-74ef-476e-8182-f6d2ba4166a6","vmSize":"Standard_NC6s_v3","location":"eastus","zone":"2"}`)
+	}))
+	defer server.Close()
+
+	metadata, err := (&AzureMetadataResolver{Endpoint: server.URL}).Resolve(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to resolve Azure metadata: %v", err)
+	}
+
+	expected := InstanceMetadata{
+		InstanceID:   "dev-vm",
+		InstanceType: "Standard_NC6s_v3",
+		Region:       "eastus",
+		Zone:         "eastus-2",
+		Provider:     "azure",
+	}
+	if *metadata != expected {
+		t.Errorf("Expected %+v, got %+v", expected, *metadata)
+	}
+}
+
+func TestHostMetadataResolver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "machine-id")
+	if err := os.WriteFile(path, []byte("0123456789abcdef0123456789abcdef\n"), 0644); err != nil {
+		t.Fatalf("Failed to write machine-id: %v", err)
+	}
+	hostname := func() (string, error) { return "devbox", nil }
+
+	metadata, err := (&HostMetadataResolver{MachineIDPath: path, Hostname: hostname}).Resolve(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to resolve host metadata: %v", err)
+	}
+	if metadata.InstanceID != "0123456789abcdef0123456789abcdef" {
+		t.Errorf("Expected machine ID, got %s", metadata.InstanceID)
+	}
+
+	// Without a machine ID the host name is used
+	missing := filepath.Join(t.TempDir(), "missing")
+	metadata, err = (&HostMetadataResolver{MachineIDPath: missing, Hostname: hostname}).Resolve(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to resolve host metadata: %v", err)
+	}
+	if metadata.InstanceID != "devbox" {
+		t.Errorf("Expected host name, got %s", metadata.InstanceID)
+	}
+}
+
+// countingResolver counts calls to a wrapped resolver
+type countingResolver struct {
+	MetadataResolver
+	calls int32
+}
+
+func (r *countingResolver) Resolve(ctx context.Context) (*InstanceMetadata, error) {
+	atomic.AddInt32(&r.calls, 1)
+	return r.MetadataResolver.Resolve(ctx)
+}
+
+func TestMetadataChain(t *testing.T) {
+	aws := fakeIMDS()
+	defer aws.Close()
+
+	// A metadata service that never answers
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer hanging.Close()
+
+	gcp := &countingResolver{MetadataResolver: &GCPMetadataResolver{Endpoint: hanging.URL}}
+	host := &countingResolver{MetadataResolver: &HostMetadataResolver{Hostname: func() (string, error) { return "devbox", nil }}}
+
+	chain := NewMetadataChain(
+		&StaticMetadataResolver{Metadata: InstanceMetadata{InstanceType: "custom"}},
+		gcp,
+		&AWSMetadataResolver{Endpoint: aws.URL},
+		host,
+	)
+	chain.timeout = 100 * time.Millisecond
+
+	start := time.Now()
+	metadata := chain.Resolve(context.Background())
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Resolution took too long: %v", elapsed)
+	}
+
+	// Explicit values win, and the chain stops at the first instance ID
+	expected := InstanceMetadata{
+		InstanceID:   "i-0123456789abcdef0",
+		InstanceType: "custom",
+		Region:       "us-west-2",
+		Zone:         "us-west-2b",
+		Provider:     "aws",
+	}
+	if metadata != expected {
+		t.Errorf("Expected %+v, got %+v", expected, metadata)
+	}
+	if host.calls != 0 {
+		t.Errorf("Expected the host resolver not to be called, got %d calls", host.calls)
+	}
+
+	// The result is cached
+	chain.Resolve(context.Background())
+	if gcp.calls != 1 {
+		t.Errorf("Expected 1 call to the GCP resolver, got %d", gcp.calls)
+	}
+
+	// Unresolved fields are reported as unknown
+	metadata = NewMetadataChain(host.MetadataResolver).Resolve(context.Background())
+	if metadata.Provider != unknownMetadata || metadata.Region != unknownMetadata || metadata.InstanceID == "" {
+		t.Errorf("Unexpected fallback metadata: %+v", metadata)
+	}
+}
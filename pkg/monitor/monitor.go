@@ -3,11 +3,13 @@ package monitor
 import (
 	"context"
 	"fmt"
+	"net"
 	"sync"
 	"time"
 	
 	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
 	"github.com/scttfrdmn/snoozebot/pkg/monitor/resources"
+	"github.com/scttfrdmn/snoozebot/pkg/schedule"
 )
 
 // monitor implements the Monitor interface
@@ -17,6 +19,23 @@ type monitor struct {
 	idleStateHandlers []IdleStateChangeHandler
 	errorHandlers     []ErrorHandler
 	
+	resourceManager   *resources.MonitorManager
+	sampler           *sampler
+	smoother          *smoother
+	activeSince       time.Time
+	metadata          *MetadataChain
+	instance          InstanceMetadata
+	history           *history
+	stopping          bool
+	stopHeldUntil     time.Time
+	inhibitors        *Inhibitors
+	inhibitedBy       string
+	stagesReached     int
+	warned            bool
+	lastCommand       string
+	lastCommandAt     time.Time
+	stateMutex        sync.Mutex
+	
 	currentState      MonitorState
 	ctx               context.Context
 	cancel            context.CancelFunc
@@ -27,7 +46,29 @@ type monitor struct {
 
 // newMonitor creates a new monitor instance
 func newMonitor(config Config) *monitor {
+	if config.Thresholds == nil {
+		config.Thresholds = make(map[ResourceType]float64)
+	}
+	if config.SampleIntervals == nil {
+		config.SampleIntervals = make(map[ResourceType]time.Duration)
+	}
+	if config.ExitThresholds == nil {
+		config.ExitThresholds = make(map[ResourceType]float64)
+	}
+	if config.IdleDetector == nil {
+		config.IdleDetector = NewThresholdDetector()
+	}
+	if config.Inhibitors == nil {
+		config.Inhibitors = NewInhibitors()
+	}
+	if config.Broadcast == nil {
+		config.Broadcast = WallBroadcast
+	}
+	
 	return &monitor{
+		inhibitors:        config.Inhibitors,
+		metadata:          newMetadataChain(config),
+		history:           newHistory(config.HistoryRetention, config.HistorySize),
 		config:            config,
 		customMonitors:    make(map[string]ResourceMonitorFunc),
 		idleStateHandlers: make([]IdleStateChangeHandler, 0),
@@ -52,6 +93,14 @@ func (m *monitor) WithThreshold(resourceType ResourceType, threshold float64) Mo
 	return m
 }
 
+func (m *monitor) WithExitThreshold(resourceType ResourceType, threshold float64) Monitor {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	m.config.ExitThresholds[resourceType] = threshold
+	return m
+}
+
 func (m *monitor) WithNapTime(duration time.Duration) Monitor {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
@@ -68,6 +117,14 @@ func (m *monitor) WithCheckInterval(duration time.Duration) Monitor {
 	return m
 }
 
+func (m *monitor) WithSampleInterval(resourceType ResourceType, interval time.Duration) Monitor {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	m.config.SampleIntervals[resourceType] = interval
+	return m
+}
+
 func (m *monitor) WithAgentURL(url string) Monitor {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
@@ -76,6 +133,165 @@ func (m *monitor) WithAgentURL(url string) Monitor {
 	return m
 }
 
+func (m *monitor) WithIdleDetector(detector IdleDetector) Monitor {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	m.config.IdleDetector = detector
+	return m
+}
+
+func (m *monitor) WithProcesses(config resources.ProcessMonitorConfig) Monitor {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	m.config.Processes = &config
+	return m
+}
+
+func (m *monitor) WithConnections(config resources.ConnectionMonitorConfig) Monitor {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	m.config.Connections = &config
+	return m
+}
+
+func (m *monitor) WithUserSessions(config resources.UserSessionsConfig) Monitor {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	m.config.UserSessions = &config
+	return m
+}
+
+func (m *monitor) WithJupyter(config resources.JupyterMonitorConfig) Monitor {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	m.config.Jupyter = &config
+	return m
+}
+
+func (m *monitor) WithFilesystem(config resources.FilesystemMonitorConfig) Monitor {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	m.config.Filesystem = &config
+	return m
+}
+
+func (m *monitor) WithUserInput(config resources.UserInputConfig) Monitor {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	m.config.UserInput = &config
+	return m
+}
+
+func (m *monitor) WithCgroup(config resources.CgroupConfig) Monitor {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	m.config.Cgroup = &config
+	return m
+}
+
+func (m *monitor) WithGPU(config resources.GPUMonitorConfig) Monitor {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	m.config.GPU = &config
+	return m
+}
+
+func (m *monitor) WithInstanceMetadata(metadata InstanceMetadata) Monitor {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	m.config.InstanceMetadata = metadata
+	m.metadata = newMetadataChain(m.config)
+	return m
+}
+
+func (m *monitor) WithHistoryRetention(duration time.Duration) Monitor {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	m.config.HistoryRetention = duration
+	m.history = newHistory(duration, m.config.HistorySize)
+	return m
+}
+
+func (m *monitor) WithIdleRule(rule string) Monitor {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	m.config.IdleRule = rule
+	return m
+}
+
+func (m *monitor) WithSmoothing(smoothing SmoothingConfig) Monitor {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	m.config.Smoothing = smoothing
+	m.smoother = nil
+	return m
+}
+
+func (m *monitor) WithMinActiveBurst(duration time.Duration) Monitor {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	m.config.MinActiveBurst = duration
+	return m
+}
+
+func (m *monitor) AddPreStopHook(hook PreStopHook) Monitor {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	m.config.PreStopHooks = append(m.config.PreStopHooks, hook)
+	return m
+}
+
+func (m *monitor) WithSchedule(s *schedule.Schedule) Monitor {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	m.config.Schedule = s
+	return m
+}
+
+func (m *monitor) WithStages(stages []protocol.IdleStage) Monitor {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	m.config.Stages = stages
+	return m
+}
+
+func (m *monitor) WithStateFile(path string) Monitor {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	m.config.StateFile = path
+	return m
+}
+
+func (m *monitor) WithInhibitSocket(path string) Monitor {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	m.config.InhibitSocket = path
+	return m
+}
+
+func (m *monitor) Inhibitors() *Inhibitors {
+	return m.inhibitors
+}
+
 // Custom monitoring
 
 func (m *monitor) AddResourceMonitor(name string, fn ResourceMonitorFunc) Monitor {
@@ -83,6 +299,21 @@ func (m *monitor) AddResourceMonitor(name string, fn ResourceMonitorFunc) Monito
 	defer m.mutex.Unlock()
 	
 	m.customMonitors[name] = fn
+	
+	// Register the monitor with the running sampling pipeline
+	if m.resourceManager != nil {
+		m.resourceManager.AddCustomMonitor(name, resources.CustomMonitorFunc(fn))
+		m.sampler.addCustom(name)
+	}
+	
+	return m
+}
+
+func (m *monitor) AddExecMonitor(config resources.ExecMonitorConfig) Monitor {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	
+	m.config.ExecMonitors = append(m.config.ExecMonitors, config)
 	return m
 }
 
@@ -114,9 +345,67 @@ func (m *monitor) Start(ctx context.Context) error {
 		return fmt.Errorf("monitor already running")
 	}
 	
+	// Compile the idle rule, if any
+	if m.config.IdleRule != "" {
+		detector, err := NewRuleDetector(m.config.IdleRule)
+		if err != nil {
+			return err
+		}
+		m.config.IdleDetector = detector
+	}
+	
+	if err := protocol.ValidateStages(m.config.Stages); err != nil {
+		return fmt.Errorf("invalid idle stages: %w", err)
+	}
+	
+	// Create the long-lived resource manager and sampling pipeline
+	if m.resourceManager == nil {
+		resourceManager, err := m.newResourceManager()
+		if err != nil {
+			return err
+		}
+		m.resourceManager = resourceManager
+		m.sampler = newSampler(resourceManager, m.config.SampleIntervals, m.config.CheckInterval, m.handleError)
+	}
+	
+	// Listen before starting so a bad socket path fails Start
+	var inhibitListener net.Listener
+	if m.config.InhibitSocket != "" {
+		listener, err := listenUnix(m.config.InhibitSocket)
+		if err != nil {
+			m.closeResources()
+			return err
+		}
+		inhibitListener = listener
+	}
+	
+	// Resume the idle clock where the last run left it
+	if m.config.StateFile != "" {
+		state, err := LoadState(m.config.StateFile)
+		if err != nil {
+			fmt.Printf("Discarding saved monitor state: %v\n", err)
+		} else if state != nil {
+			m.restoreState(state, time.Now())
+		}
+	}
+	
 	m.ctx, m.cancel = context.WithCancel(ctx)
 	m.running = true
 	
+	// Serve the inhibitor API
+	if inhibitListener != nil {
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			if err := NewInhibitServer(m.inhibitors).Serve(m.ctx, inhibitListener); err != nil {
+				m.handleError(fmt.Errorf("inhibitor API failed: %w", err))
+			}
+		}()
+	}
+	
+	// Start the sampling goroutines
+	m.sampler.start(m.ctx)
+	
 	// Start the monitoring goroutine
 	m.wg.Add(1)
 	go m.monitorResources()
@@ -130,16 +419,144 @@ func (m *monitor) Start(ctx context.Context) error {
 
 func (m *monitor) Stop() error {
 	m.mutex.Lock()
-	defer m.mutex.Unlock()
-	
 	if !m.running {
+		m.mutex.Unlock()
 		return fmt.Errorf("monitor not running")
 	}
 	
 	m.cancel()
-	m.wg.Wait()
 	m.running = false
+	m.mutex.Unlock()
+	
+	// Wait without holding the lock, the goroutines need it to finish
+	m.wg.Wait()
+	m.sampler.wait()
+	
+	m.mutex.Lock()
+	err := m.closeResources()
+	m.mutex.Unlock()
 	
+	return err
+}
+
+// newResourceManager creates the resource manager with the configured
+// monitors. If a monitor can't be created, the ones already created are closed.
+func (m *monitor) newResourceManager() (_ *resources.MonitorManager, err error) {
+	manager, err := resources.NewMonitorManager()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource manager: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			manager.Close()
+		}
+	}()
+	
+	for name, fn := range m.customMonitors {
+		manager.AddCustomMonitor(name, resources.CustomMonitorFunc(fn))
+	}
+	
+	if m.config.Cgroup != nil {
+		cgroupMonitors, err := resources.NewCgroupMonitors(*m.config.Cgroup)
+		if err != nil {
+			return nil, err
+		}
+		for resourceType, cgroupMonitor := range cgroupMonitors {
+			manager.SetMonitor(resourceType, cgroupMonitor)
+		}
+	}
+	
+	if m.config.GPU != nil {
+		gpuMonitor, err := resources.NewGPUMonitorWithConfig(*m.config.GPU)
+		if err != nil {
+			return nil, err
+		}
+		manager.SetMonitor(resources.GPU, gpuMonitor)
+	}
+	
+	if m.config.Processes != nil {
+		processMonitor, err := resources.NewProcessMonitor(*m.config.Processes)
+		if err != nil {
+			return nil, err
+		}
+		manager.SetMonitor(resources.Process, processMonitor)
+	}
+	
+	for _, config := range m.config.ExecMonitors {
+		execMonitor, err := resources.NewExecMonitor(config)
+		if err != nil {
+			return nil, err
+		}
+		resourceType := ResourceType(config.Name)
+		manager.SetMonitor(resources.ResourceType(resourceType), execMonitor)
+		if _, ok := m.config.Thresholds[resourceType]; !ok {
+			m.config.Thresholds[resourceType] = config.Threshold
+		}
+		if _, ok := m.config.SampleIntervals[resourceType]; !ok && config.Interval > 0 {
+			m.config.SampleIntervals[resourceType] = config.Interval
+		}
+	}
+	
+	if m.config.Connections != nil {
+		connectionMonitor, err := resources.NewConnectionMonitor(*m.config.Connections)
+		if err != nil {
+			return nil, err
+		}
+		manager.SetMonitor(resources.Connections, connectionMonitor)
+	}
+	
+	if m.config.UserSessions != nil {
+		sessionsMonitor, err := resources.NewUserSessionsMonitorWithConfig(*m.config.UserSessions)
+		if err != nil {
+			return nil, err
+		}
+		manager.SetMonitor(resources.UserSessions, sessionsMonitor)
+		if _, ok := m.config.Thresholds[UserSessions]; !ok {
+			m.config.Thresholds[UserSessions] = 0
+		}
+	}
+	
+	if m.config.Jupyter != nil {
+		jupyterMonitor, err := resources.NewJupyterMonitor(*m.config.Jupyter)
+		if err != nil {
+			return nil, err
+		}
+		manager.SetMonitor(resources.Jupyter, jupyterMonitor)
+	}
+	
+	if m.config.UserInput != nil {
+		userInputMonitor, err := resources.NewUserInputMonitorWithConfig(*m.config.UserInput)
+		if err != nil {
+			return nil, err
+		}
+		manager.SetMonitor(resources.UserInput, userInputMonitor)
+	}
+	
+	if m.config.Filesystem != nil {
+		filesystemMonitor, err := resources.NewFilesystemMonitor(*m.config.Filesystem)
+		if err != nil {
+			return nil, err
+		}
+		manager.SetMonitor(resources.Filesystem, filesystemMonitor)
+	}
+	
+	return manager, nil
+}
+
+// closeResources closes the resource monitors, releasing the files and
+// goroutines some of them hold, such as inotify watches and input devices.
+// They are created again by the next Start. The caller must hold the mutex.
+func (m *monitor) closeResources() error {
+	if m.resourceManager == nil {
+		return nil
+	}
+	
+	err := m.resourceManager.Close()
+	m.resourceManager = nil
+	m.sampler = nil
+	if err != nil {
+		return fmt.Errorf("failed to close resource monitors: %w", err)
+	}
 	return nil
 }
 
@@ -156,6 +573,13 @@ func (m *monitor) GetCurrentState() MonitorState {
 		IdleDuration: m.currentState.IdleDuration,
 		CurrentUsage: make(map[ResourceType]*ResourceUsage),
 		Connected:    m.currentState.Connected,
+		Inhibitors:   append([]InhibitLock(nil), m.currentState.Inhibitors...),
+	}
+	
+	// Report the latest samples if the pipeline is running
+	if m.sampler != nil {
+		stateCopy.CurrentUsage = m.sampler.latest()
+		return stateCopy
 	}
 	
 	// Copy the usage map
@@ -185,6 +609,22 @@ func (m *monitor) IdleDuration() time.Duration {
 	return time.Since(m.currentState.IdleSince)
 }
 
+func (m *monitor) History(resourceType ResourceType, since time.Time) []ResourceUsage {
+	m.mutex.RLock()
+	history := m.history
+	m.mutex.RUnlock()
+	
+	return history.since(resourceType, since)
+}
+
+func (m *monitor) Stats(resourceType ResourceType, window time.Duration) (UsageStats, error) {
+	m.mutex.RLock()
+	history := m.history
+	m.mutex.RUnlock()
+	
+	return history.stats(resourceType, window)
+}
+
 // Internal methods
 
 func (m *monitor) monitorResources() {
@@ -204,68 +644,69 @@ func (m *monitor) monitorResources() {
 	}
 }
 
+// updateResourceUsage takes the latest snapshot from the sampling pipeline.
+// It never waits for a collector, so a slow resource can't delay the idle decision.
 func (m *monitor) updateResourceUsage() {
+	snapshot := m.sampler.latest()
+	
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	
-	// Get all resource usage from the resource manager
-	resourceManager, err := resources.NewMonitorManager()
-	if err != nil {
-		m.handleError(fmt.Errorf("failed to create resource manager: %w", err))
-		return
+	m.currentState.CurrentUsage = snapshot
+	m.history.record(snapshot)
+}
+
+func (m *monitor) checkIdleState() {
+	m.mutex.Lock()
+	
+	// Smooth the samples so a single spike or dip isn't judged on its own
+	if m.smoother == nil {
+		m.smoother = newSmoother(m.config.Smoothing)
 	}
+	usage := m.smoother.apply(m.currentState.CurrentUsage)
 	
-	// Add custom monitors to the resource manager
-	for name, monitorFn := range m.customMonitors {
-		// Create an adapter to convert ResourceMonitorFunc to CustomMonitorFunc
-		adaptedFn := func(fn ResourceMonitorFunc) resources.CustomMonitorFunc {
-			return func() (float64, error) {
-				return fn()
-			}
-		}(monitorFn)
-		resourceManager.AddCustomMonitor(name, adaptedFn)
+	// Once idle, resources must exceed the exit thresholds to count as activity
+	wasIdle := m.currentState.IsIdle
+	thresholds := m.config.Thresholds
+	if wasIdle {
+		thresholds = m.exitThresholds()
 	}
 	
-	// Get all usage
-	allUsage, err := resourceManager.GetAllUsage()
+	// Ask the configured detector whether the system is idle
+	isIdle, err := m.config.IdleDetector.IsIdle(usage, thresholds)
 	if err != nil {
-		m.handleError(fmt.Errorf("failed to get resource usage: %w", err))
-		return
+		// Treat the system as active when we can't tell
+		isIdle = false
+		defer m.handleError(fmt.Errorf("idle detection failed: %w", err))
 	}
 	
-	// Convert to our internal format
-	for resourceType, usage := range allUsage {
-		// Convert resources.ResourceType to monitor.ResourceType
-		monitorResourceType := ResourceType(string(resourceType))
-		m.currentState.CurrentUsage[monitorResourceType] = &ResourceUsage{
-			Type:      monitorResourceType,
-			Value:     usage.Value,
-			Timestamp: usage.Timestamp,
-		}
+	// A keep-awake lock holds the system active
+	inhibitors := m.inhibitors.Held()
+	m.currentState.Inhibitors = inhibitors
+	if len(inhibitors) > 0 {
+		isIdle = false
 	}
-}
-
-func (m *monitor) checkIdleState() {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
 	
-	// Check if any resource is above its threshold
-	isIdle := true
-	for resourceType, usage := range m.currentState.CurrentUsage {
-		threshold, ok := m.config.Thresholds[resourceType]
-		if !ok {
-			continue
+	now := time.Now()
+	
+	// Short bursts of activity don't reset the idle timer
+	burst := false
+	if wasIdle && !isIdle && len(inhibitors) == 0 {
+		if m.activeSince.IsZero() {
+			m.activeSince = now
 		}
-		
-		if usage.Value > threshold {
-			isIdle = false
-			break
+		if now.Sub(m.activeSince) < m.config.MinActiveBurst {
+			isIdle = true
+			burst = true
 		}
+	} else {
+		m.activeSince = time.Time{}
 	}
 	
 	// Update idle state
-	now := time.Now()
-	wasIdle := m.currentState.IsIdle
+	stateChanged := false
+	notifyAgent := false
+	var broadcasts []string
 	
 	if isIdle {
 		if !wasIdle {
@@ -273,69 +714,112 @@ func (m *monitor) checkIdleState() {
 			m.currentState.IsIdle = true
 			m.currentState.IdleSince = now
 			m.currentState.IdleDuration = 0
-			
-			// Notify handlers
-			m.notifyIdleStateChange(true, 0)
+			m.resetStages("")
+			stateChanged = true
 		} else {
 			// System was already idle, update duration
 			m.currentState.IdleDuration = now.Sub(m.currentState.IdleSince)
 			
-			// If we've reached the naptime threshold, notify the agent
-			if m.currentState.IdleDuration >= m.config.NapTime {
-				// Only notify if we're connected to an agent
-				if m.currentState.Connected {
-					fmt.Printf("System has been idle for %s, notifying agent\n", m.currentState.IdleDuration)
-					
-					// This would be better done in a separate goroutine 
-					// and with a reference to the agent client
-					go func() {
-						// Create a new agent client just for this notification
-						client := protocol.NewAgentClient(m.config.AgentURL, getInstanceID())
-						err := client.Connect(context.Background())
-						if err != nil {
-							m.handleError(fmt.Errorf("failed to connect to agent for idle notification: %w", err))
-							return
-						}
-						defer client.Disconnect()
-						
-						// Get current resource usage
-						resourceUsage := make(map[string]float64)
-						for k, v := range m.currentState.CurrentUsage {
-							resourceUsage[string(k)] = v.Value
-						}
-						
-						// Send idle notification
-						action, err := client.SendIdleNotification(
-							context.Background(),
-							m.currentState.IdleSince,
-							m.currentState.IdleDuration,
-							resourceUsage,
-						)
-						
-						if err != nil {
-							m.handleError(fmt.Errorf("failed to send idle notification: %w", err))
-							return
-						}
-						
-						fmt.Printf("Agent action response: %s\n", action)
-					}()
-				} else {
-					fmt.Printf("System has been idle for %s (not connected to agent)\n", m.currentState.IdleDuration)
+			// If we've reached the naptime threshold or the first stage, notify
+			// the agent. The schedule may shorten the naptime or forbid stopping.
+			// During a burst of activity nothing moves on until the burst either
+			// ends or lasts long enough to end the idle period.
+			policy := m.config.Schedule.At(now)
+			switch {
+			case burst:
+			case len(m.config.Stages) > 0:
+				if !policy.NeverStop {
+					broadcasts = m.advanceStages(m.currentState.IdleDuration)
+					notifyAgent = m.stagesReached > 0
 				}
+			default:
+				notifyAgent = !policy.NeverStop && m.currentState.IdleDuration >= policy.NapTimeOr(m.config.NapTime)
 			}
 		}
 	} else {
 		if wasIdle {
-			// System was idle but is now active
+			// System was idle but is now active, which cancels any pending stages
 			m.currentState.IsIdle = false
 			m.currentState.IdleSince = time.Time{}
 			m.currentState.IdleDuration = 0
-			
-			// Notify handlers
-			m.notifyIdleStateChange(false, 0)
+			broadcasts = m.resetStages("activity detected")
+			stateChanged = true
 		}
 		// else: System was already active, nothing to do
 	}
+	
+	connected := m.currentState.Connected
+	instanceID := m.instance.InstanceID
+	idleSince := m.currentState.IdleSince
+	idleDuration := m.currentState.IdleDuration
+	resourceUsage := make(map[string]float64)
+	for k, v := range m.currentState.CurrentUsage {
+		resourceUsage[string(k)] = v.Value
+	}
+	m.mutex.Unlock()
+	
+	// Notify handlers without holding the lock
+	if stateChanged {
+		m.notifyIdleStateChange(isIdle, 0)
+	}
+	if len(broadcasts) > 0 {
+		go m.broadcast(broadcasts)
+	}
+	// Save on every check, not only on transitions, so that after a restart
+	// the monitor can tell how long it was down
+	m.persistState()
+	
+	if !notifyAgent {
+		return
+	}
+	
+	// Only notify if we're connected to an agent
+	if !connected {
+		fmt.Printf("System has been idle for %s (not connected to agent)\n", idleDuration)
+		return
+	}
+	
+	fmt.Printf("System has been idle for %s, notifying agent\n", idleDuration)
+	
+	// This would be better done with a reference to the agent client
+	go func() {
+		// Create a new agent client just for this notification
+		client := protocol.NewAgentClient(m.config.AgentURL, instanceID)
+		err := client.Connect(context.Background())
+		if err != nil {
+			m.handleError(fmt.Errorf("failed to connect to agent for idle notification: %w", err))
+			return
+		}
+		defer client.Disconnect()
+		
+		// Send idle notification
+		action, err := client.SendIdleNotification(
+			context.Background(),
+			idleSince,
+			idleDuration,
+			resourceUsage,
+		)
+		
+		if err != nil {
+			m.handleError(fmt.Errorf("failed to send idle notification: %w", err))
+			return
+		}
+		
+		fmt.Printf("Agent action response: %s\n", action)
+	}()
+}
+
+// exitThresholds returns the thresholds used while the system is idle
+func (m *monitor) exitThresholds() map[ResourceType]float64 {
+	thresholds := make(map[ResourceType]float64, len(m.config.Thresholds))
+	for resourceType, threshold := range m.config.Thresholds {
+		thresholds[resourceType] = threshold
+	}
+	for resourceType, threshold := range m.config.ExitThresholds {
+		thresholds[resourceType] = threshold
+	}
+	
+	return thresholds
 }
 
 func (m *monitor) connectToAgent() {
@@ -354,8 +838,18 @@ func (m *monitor) connectToAgent() {
 		return
 	}
 	
+	// Resolve the identity of this instance
+	m.mutex.RLock()
+	metadata := m.metadata
+	m.mutex.RUnlock()
+	instance := metadata.Resolve(m.ctx)
+	
+	m.mutex.Lock()
+	m.instance = instance
+	m.mutex.Unlock()
+	
 	// Create a new agent client
-	client := protocol.NewAgentClient(m.config.AgentURL, getInstanceID())
+	client := protocol.NewAgentClient(m.config.AgentURL, instance.InstanceID)
 	
 	// Try to connect to the agent
 	err := client.Connect(m.ctx)
@@ -447,10 +941,21 @@ func (m *monitor) registerWithAgent(client *protocol.AgentClient) error {
 	}
 	
 	// Get instance metadata
-	instanceType, region, zone, provider := getInstanceMetadata()
+	m.mutex.RLock()
+	instance := m.instance
+	m.mutex.RUnlock()
+	
+	// Send the schedule and stages so the agent applies them to this instance
+	metadata := make(map[string]string)
+	if m.config.Schedule != nil {
+		metadata[schedule.MetadataKey] = m.config.Schedule.String()
+	}
+	if len(m.config.Stages) > 0 {
+		metadata[protocol.StagesMetadataKey] = protocol.FormatStages(m.config.Stages)
+	}
 	
 	// Register with the agent
-	return client.RegisterInstance(m.ctx, instanceType, region, zone, provider, thresholds, m.config.NapTime)
+	return client.RegisterInstance(m.ctx, instance.InstanceType, instance.Region, instance.Zone, instance.Provider, thresholds, m.config.NapTime, metadata)
 }
 
 // unregisterFromAgent unregisters the monitor from the agent
@@ -463,6 +968,8 @@ func (m *monitor) unregisterFromAgent(client *protocol.AgentClient) {
 
 // sendHeartbeat sends a heartbeat to the agent
 func (m *monitor) sendHeartbeat(client *protocol.AgentClient) error {
+	m.mutex.RLock()
+	
 	// Get current resource usage
 	resourceUsage := make(map[string]float64)
 	for k, v := range m.currentState.CurrentUsage {
@@ -474,6 +981,12 @@ func (m *monitor) sendHeartbeat(client *protocol.AgentClient) error {
 	if m.currentState.IsIdle {
 		state = "idle"
 	}
+	if len(m.currentState.Inhibitors) > 0 {
+		state = "inhibited"
+	}
+	inhibitedBy := describeInhibitors(m.currentState.Inhibitors)
+	
+	m.mutex.RUnlock()
 	
 	// Send heartbeat
 	commands, err := client.SendHeartbeat(m.ctx, state, resourceUsage)
@@ -481,28 +994,76 @@ func (m *monitor) sendHeartbeat(client *protocol.AgentClient) error {
 		return err
 	}
 	
+	// Tell the agent who is keeping the system awake
+	m.reportInhibitors(client, inhibitedBy)
+	
 	// Process commands
 	for _, command := range commands {
-		m.processAgentCommand(command)
+		m.processAgentCommand(client, command)
 	}
 	
 	return nil
 }
 
+// reportInhibitors reports a change in the keep-awake lock holders to the agent
+func (m *monitor) reportInhibitors(client *protocol.AgentClient, inhibitedBy string) {
+	m.mutex.Lock()
+	previous := m.inhibitedBy
+	m.inhibitedBy = inhibitedBy
+	m.mutex.Unlock()
+	
+	if inhibitedBy == previous {
+		return
+	}
+	
+	var err error
+	switch {
+	case previous == "":
+		err = client.ReportStateChange(m.ctx, "active", "inhibited", "kept awake by "+inhibitedBy)
+	case inhibitedBy == "":
+		err = client.ReportStateChange(m.ctx, "inhibited", "active", "keep-awake locks released")
+	default:
+		err = client.ReportStateChange(m.ctx, "inhibited", "inhibited", "kept awake by "+inhibitedBy)
+	}
+	if err != nil {
+		m.handleError(fmt.Errorf("failed to report keep-awake locks: %w", err))
+	}
+}
+
+// isStopCommand reports whether an agent command stops the instance
+func isStopCommand(command string) bool {
+	switch command {
+	case "stop", protocol.StageHibernate, protocol.StageDeallocate:
+		return true
+	}
+	return false
+}
+
 // processAgentCommand processes a command from the agent
-func (m *monitor) processAgentCommand(command string) {
+func (m *monitor) processAgentCommand(client *protocol.AgentClient, command string) {
+	// Remember stop commands so a restart doesn't prepare the stop again
+	if isStopCommand(command) {
+		m.mutex.Lock()
+		m.lastCommand = command
+		m.lastCommandAt = time.Now()
+		m.mutex.Unlock()
+		m.persistState()
+	}
+	
 	switch command {
 	case "ping":
 		// Simple ping command - nothing to do
 		fmt.Println("Received ping command from agent")
 		
-	case "stop":
-		// Stop command - trigger stop immediately
-		fmt.Println("Received stop command from agent")
+	case "stop", protocol.StageHibernate, protocol.StageDeallocate:
+		// Stop command - prepare the workload before the agent stops the instance
+		fmt.Printf("Received %s command from agent\n", command)
+		m.prepareStop(client)
 		
 	case "refresh":
 		// Refresh command - trigger immediate resource check
 		fmt.Println("Received refresh command from agent")
+		m.sampler.refresh()
 		m.updateResourceUsage()
 		m.checkIdleState()
 		
@@ -511,23 +1072,63 @@ func (m *monitor) processAgentCommand(command string) {
 	}
 }
 
-// getInstanceID gets the ID of the current instance
-func getInstanceID() string {
-	// In a real implementation, this would get the instance ID from the cloud metadata service
-	// For now, return a placeholder value
-	return "instance-1234"
-}
-
-// getInstanceMetadata gets metadata about the current instance
-func getInstanceMetadata() (string, string, string, string) {
-	// In a real implementation, this would get instance metadata from the cloud metadata service
-	// For now, return placeholder values
-	instanceType := "unknown"
-	region := "unknown"
-	zone := "unknown"
-	provider := "unknown"
+// prepareStop runs the pre-stop hooks and reports the outcome to the agent.
+// The agent repeats the stop command until it acts, so a stop that is
+// already being prepared or was prepared recently is not prepared again.
+func (m *monitor) prepareStop(client *protocol.AgentClient) {
+	m.mutex.Lock()
+	if m.stopping || time.Now().Before(m.stopHeldUntil) {
+		m.mutex.Unlock()
+		return
+	}
+	m.stopping = true
+	hooks := make([]PreStopHook, len(m.config.PreStopHooks))
+	copy(hooks, m.config.PreStopHooks)
+	previousState := "active"
+	if m.currentState.IsIdle {
+		previousState = "idle"
+	}
+	m.mutex.Unlock()
 	
-	return instanceType, region, zone, provider
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		
+		results, vetoed := runPreStopHooks(m.ctx, hooks)
+		for _, result := range results {
+			if result.Err != nil {
+				m.handleError(fmt.Errorf("pre-stop hook %s failed: %w", result.Name, result.Err))
+			}
+		}
+		
+		currentState := protocol.StopReplyStopping
+		reason := summarizeHookResults(results)
+		
+		// Ignore repeated stop commands for one nap time
+		m.mutex.Lock()
+		m.stopping = false
+		m.stopHeldUntil = time.Now().Add(m.config.NapTime)
+		var broadcasts []string
+		if vetoed && m.currentState.IsIdle {
+			// Postpone the stop by restarting the idle timer and stages
+			m.currentState.IdleSince = time.Now()
+			m.currentState.IdleDuration = 0
+			broadcasts = m.resetStages("a pre-stop hook vetoed it")
+		}
+		m.mutex.Unlock()
+		
+		m.broadcast(broadcasts)
+		m.persistState()
+		if vetoed {
+			currentState = protocol.StopReplyVetoed
+			reason = results[len(results)-1].Reason
+			fmt.Printf("Stop vetoed: %s\n", reason)
+		}
+		
+		if err := client.ReportStateChange(m.ctx, previousState, currentState, reason); err != nil {
+			m.handleError(fmt.Errorf("failed to report pre-stop hook results: %w", err))
+		}
+	}()
 }
 
 func (m *monitor) notifyIdleStateChange(isIdle bool, duration time.Duration) {
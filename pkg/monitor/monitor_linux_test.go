@@ -0,0 +1,71 @@
+// +build linux
+
+package monitor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/scttfrdmn/snoozebot/pkg/monitor/resources"
+)
+
+// openFiles returns the number of files the test process has open
+func openFiles(t *testing.T) int {
+	t.Helper()
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skipf("Can't list open files: %v", err)
+	}
+	return len(entries)
+}
+
+func TestMonitor_StopClosesResources(t *testing.T) {
+	config := DefaultConfig()
+	config.CheckInterval = 50 * time.Millisecond
+	config.AgentURL = ""
+	config.Filesystem = &resources.FilesystemMonitorConfig{Paths: []string{t.TempDir()}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Start the monitor once so the files opened on first use, such as
+	// cached /proc files, aren't counted as leaks
+	mon := NewMonitorWithConfig(config)
+	if err := mon.Start(ctx); err != nil {
+		t.Fatalf("Failed to start monitor: %v", err)
+	}
+	if err := mon.Stop(); err != nil {
+		t.Fatalf("Failed to stop monitor: %v", err)
+	}
+	before := openFiles(t)
+
+	for i := 0; i < 3; i++ {
+		if err := mon.Start(ctx); err != nil {
+			t.Fatalf("Failed to start monitor: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if err := mon.Stop(); err != nil {
+			t.Fatalf("Failed to stop monitor: %v", err)
+		}
+	}
+	if after := openFiles(t); after > before {
+		t.Errorf("Expected Start and Stop not to leak files, had %d open and now %d", before, after)
+	}
+
+	// A failed Start closes the monitors it created
+	notDir := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(notDir, nil, 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	bad := NewMonitorWithConfig(config).WithInhibitSocket(filepath.Join(notDir, "inhibit.sock"))
+	if err := bad.Start(ctx); err == nil {
+		bad.Stop()
+		t.Fatalf("Expected Start to fail with a bad socket path")
+	}
+	if after := openFiles(t); after > before {
+		t.Errorf("Expected a failed Start not to leak files, had %d open and now %d", before, after)
+	}
+}
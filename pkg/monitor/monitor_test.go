@@ -4,6 +4,8 @@ import (
 	"context"
 	"testing"
 	"time"
+
+	"github.com/scttfrdmn/snoozebot/pkg/monitor/resources"
 )
 
 func TestMonitor_ResourceMonitoring(t *testing.T) {
@@ -33,7 +35,7 @@ func TestMonitor_ResourceMonitoring(t *testing.T) {
 	state := mon.GetCurrentState()
 
 	// Check that the standard resource types are present
-	standardTypes := []ResourceType{CPU, Memory, Network, Disk, UserInput, GPU}
+	standardTypes := []ResourceType{CPU, Memory, Network, Disk, UserInput, GPU, UserSessions}
 	for _, resourceType := range standardTypes {
 		usage, ok := state.CurrentUsage[resourceType]
 		if !ok {
@@ -82,6 +84,7 @@ func TestMonitor_IdleDetection(t *testing.T) {
 	config.NapTime = 2 * time.Second            // Shorter naptime for testing
 	config.CheckInterval = 500 * time.Millisecond // More frequent checks for testing
 	config.Thresholds[CPU] = 99.0               // Set high threshold so we're always "idle"
+	config.Thresholds[UserSessions] = 100.0     // Ignore the terminal running the tests
 
 	mon := NewMonitorWithConfig(config)
 
@@ -129,4 +132,42 @@ func TestMonitor_IdleDetection(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to stop monitor: %v", err)
 	}
-}
\ No newline at end of file
+}
+
+func TestMonitor_ProcessResource(t *testing.T) {
+	config := DefaultConfig()
+	config.CheckInterval = 100 * time.Millisecond
+	config.AgentURL = ""
+
+	mon := NewMonitorWithConfig(config).WithProcesses(resources.ProcessMonitorConfig{
+		Include:     []resources.ProcessMatcher{{Name: "no-such-process"}},
+		IncludeSelf: true,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := mon.Start(ctx); err != nil {
+		t.Fatalf("Failed to start monitor: %v", err)
+	}
+	defer mon.Stop()
+
+	time.Sleep(500 * time.Millisecond)
+
+	usage, ok := mon.GetCurrentState().CurrentUsage[Process]
+	if !ok {
+		t.Fatalf("Missing resource type: %s", Process)
+	}
+	if usage.Value != 0 {
+		t.Errorf("Expected no process activity, got %f", usage.Value)
+	}
+
+	// Invalid matchers are rejected on start
+	bad := NewMonitorWithConfig(config).WithProcesses(resources.ProcessMonitorConfig{
+		Include: []resources.ProcessMatcher{{Cmdline: "("}},
+	})
+	if err := bad.Start(ctx); err == nil {
+		bad.Stop()
+		t.Error("Expected invalid process matcher to fail on start")
+	}
+}
@@ -0,0 +1,29 @@
+package resources
+
+// CgroupConfig configures the cgroup v2 CPU, memory and I/O monitors
+type CgroupConfig struct {
+	// Path is the cgroup to monitor relative to the cgroup root, such as
+	// /system.slice/jupyter.service. If empty, the monitor's own cgroup is used.
+	Path string
+	// Root is the cgroup v2 mount point, /sys/fs/cgroup by default
+	Root string
+	// ProcRoot is the proc filesystem mount point, /proc by default
+	ProcRoot string
+	// MaxIOBytesPerSec is the I/O rate reported as 100% when the cgroup has
+	// no io.max limit. Defaults to 500 MB/s, the same as the disk monitor.
+	MaxIOBytesPerSec float64
+}
+
+// withDefaults fills in the unset fields of the configuration
+func (c CgroupConfig) withDefaults() CgroupConfig {
+	if c.Root == "" {
+		c.Root = "/sys/fs/cgroup"
+	}
+	if c.ProcRoot == "" {
+		c.ProcRoot = "/proc"
+	}
+	if c.MaxIOBytesPerSec <= 0 {
+		c.MaxIOBytesPerSec = 500 * 1000 * 1000
+	}
+	return c
+}
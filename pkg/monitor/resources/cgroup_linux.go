@@ -0,0 +1,381 @@
+// +build linux
+
+package resources
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewCgroupMonitors creates cgroup v2 CPU, memory and disk I/O monitors.
+// The monitors report usage against the cgroup's limits rather than host totals,
+// and can replace the standard monitors when running in a container or systemd slice.
+func NewCgroupMonitors(config CgroupConfig) (map[ResourceType]ResourceMonitor, error) {
+	config = config.withDefaults()
+
+	dir, err := resolveCgroupDir(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize cgroup monitors: %w", err)
+	}
+
+	cpu, err := newCgroupCPUMonitor(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize cgroup monitors: %w", err)
+	}
+	memory, err := newCgroupMemoryMonitor(dir, config.ProcRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize cgroup monitors: %w", err)
+	}
+	disk, err := newCgroupIOMonitor(dir, config.MaxIOBytesPerSec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize cgroup monitors: %w", err)
+	}
+
+	return map[ResourceType]ResourceMonitor{
+		CPU:    cpu,
+		Memory: memory,
+		Disk:   disk,
+	}, nil
+}
+
+// resolveCgroupDir returns the directory of the configured cgroup, or of our own
+// cgroup as listed in /proc/self/cgroup
+func resolveCgroupDir(config CgroupConfig) (string, error) {
+	path := config.Path
+	if path == "" {
+		data, err := os.ReadFile(filepath.Join(config.ProcRoot, "self", "cgroup"))
+		if err != nil {
+			return "", err
+		}
+
+		// On cgroup v2 the only entry is 0::<path>
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.HasPrefix(line, "0::") {
+				path = strings.TrimPrefix(line, "0::")
+				break
+			}
+		}
+		if path == "" {
+			return "", fmt.Errorf("no cgroup v2 entry in %s/self/cgroup", config.ProcRoot)
+		}
+	}
+
+	dir := filepath.Join(config.Root, filepath.Clean("/"+path))
+	if _, err := os.Stat(filepath.Join(dir, "cgroup.controllers")); err != nil {
+		return "", fmt.Errorf("%s is not a cgroup v2 directory: %w", dir, err)
+	}
+
+	return dir, nil
+}
+
+// CgroupCPUMonitor monitors the CPU usage of a cgroup relative to its cpu.max quota
+type CgroupCPUMonitor struct {
+	dir           string
+	lastUsageUsec uint64
+	lastMeasureTs time.Time
+	mutex         sync.Mutex
+}
+
+// newCgroupCPUMonitor creates a new cgroup CPU monitor
+func newCgroupCPUMonitor(dir string) (*CgroupCPUMonitor, error) {
+	usage, err := readCgroupKeyValue(filepath.Join(dir, "cpu.stat"), "usage_usec")
+	if err != nil {
+		return nil, err
+	}
+
+	return &CgroupCPUMonitor{
+		dir:           dir,
+		lastUsageUsec: usage,
+		lastMeasureTs: time.Now(),
+	}, nil
+}
+
+// GetUsage returns the current CPU usage as a percentage (0-100) of the cgroup's CPU limit
+func (m *CgroupCPUMonitor) GetUsage() (float64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	usage, err := readCgroupKeyValue(filepath.Join(m.dir, "cpu.stat"), "usage_usec")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read cgroup CPU stats: %w", err)
+	}
+
+	// Calculate time difference
+	now := time.Now()
+	elapsedUsec := float64(now.Sub(m.lastMeasureTs).Microseconds())
+	if elapsedUsec <= 0 {
+		return 0, fmt.Errorf("time difference too small")
+	}
+
+	// The counter restarts if the cgroup is recreated
+	var cpuUsage float64
+	if usage >= m.lastUsageUsec {
+		cpuUsage = 100.0 * float64(usage-m.lastUsageUsec) / (elapsedUsec * readCgroupCPULimit(m.dir))
+	}
+
+	// Update last stats
+	m.lastUsageUsec = usage
+	m.lastMeasureTs = now
+
+	// Cap at 100%
+	if cpuUsage > 100.0 {
+		cpuUsage = 100.0
+	}
+
+	return cpuUsage, nil
+}
+
+// readCgroupCPULimit returns the number of CPUs the cgroup may use, from cpu.max
+func readCgroupCPULimit(dir string) float64 {
+	cpus := float64(runtime.NumCPU())
+
+	// Format: $MAX $PERIOD, where $MAX is "max" when there is no quota
+	data, err := os.ReadFile(filepath.Join(dir, "cpu.max"))
+	if err != nil {
+		return cpus
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 || fields[0] == "max" {
+		return cpus
+	}
+
+	quota, err1 := strconv.ParseFloat(fields[0], 64)
+	period, err2 := strconv.ParseFloat(fields[1], 64)
+	if err1 != nil || err2 != nil || quota <= 0 || period <= 0 {
+		return cpus
+	}
+	if limit := quota / period; limit < cpus {
+		return limit
+	}
+	return cpus
+}
+
+// CgroupMemoryMonitor monitors the memory usage of a cgroup relative to its memory.max limit
+type CgroupMemoryMonitor struct {
+	dir      string
+	procRoot string
+	mutex    sync.Mutex
+}
+
+// newCgroupMemoryMonitor creates a new cgroup memory monitor
+func newCgroupMemoryMonitor(dir, procRoot string) (*CgroupMemoryMonitor, error) {
+	if _, err := os.Stat(filepath.Join(dir, "memory.current")); err != nil {
+		return nil, err
+	}
+
+	return &CgroupMemoryMonitor{
+		dir:      dir,
+		procRoot: procRoot,
+	}, nil
+}
+
+// GetUsage returns the current memory usage as a percentage (0-100) of the cgroup's memory limit
+func (m *CgroupMemoryMonitor) GetUsage() (float64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	current, err := readCgroupValue(filepath.Join(m.dir, "memory.current"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read cgroup memory usage: %w", err)
+	}
+
+	// Page cache that can be reclaimed does not count as used, as in docker stats
+	if inactive, err := readCgroupKeyValue(filepath.Join(m.dir, "memory.stat"), "inactive_file"); err == nil && inactive < current {
+		current -= inactive
+	}
+
+	limit, err := readCgroupValue(filepath.Join(m.dir, "memory.max"))
+	if err != nil || limit == 0 {
+		// No limit, so the cgroup may use all host memory
+		limit, err = readMemTotal(m.procRoot)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read memory limit: %w", err)
+		}
+	}
+
+	memoryUsage := 100.0 * float64(current) / float64(limit)
+
+	// Cap at 100%
+	if memoryUsage > 100.0 {
+		memoryUsage = 100.0
+	}
+
+	return memoryUsage, nil
+}
+
+// readMemTotal reads the host's total memory in bytes from /proc/meminfo
+func readMemTotal(procRoot string) (uint64, error) {
+	total, err := readCgroupKeyValue(filepath.Join(procRoot, "meminfo"), "MemTotal:")
+	if err != nil {
+		return 0, err
+	}
+	return total * 1024, nil
+}
+
+// CgroupIOMonitor monitors the storage I/O of a cgroup relative to its io.max limits
+type CgroupIOMonitor struct {
+	dir            string
+	maxBytesPerSec float64
+	lastBytes      uint64
+	lastMeasureTs  time.Time
+	mutex          sync.Mutex
+}
+
+// newCgroupIOMonitor creates a new cgroup I/O monitor
+func newCgroupIOMonitor(dir string, maxBytesPerSec float64) (*CgroupIOMonitor, error) {
+	bytes, err := readCgroupIOBytes(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CgroupIOMonitor{
+		dir:            dir,
+		maxBytesPerSec: maxBytesPerSec,
+		lastBytes:      bytes,
+		lastMeasureTs:  time.Now(),
+	}, nil
+}
+
+// GetUsage returns the current I/O usage as a percentage (0-100) of the cgroup's I/O limit
+func (m *CgroupIOMonitor) GetUsage() (float64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	bytes, err := readCgroupIOBytes(m.dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read cgroup I/O stats: %w", err)
+	}
+
+	// Calculate time difference
+	now := time.Now()
+	timeDiff := now.Sub(m.lastMeasureTs).Seconds()
+	if timeDiff <= 0 {
+		return 0, fmt.Errorf("time difference too small")
+	}
+
+	maxBytesPerSec := readCgroupIOLimit(m.dir)
+	if maxBytesPerSec == 0 {
+		maxBytesPerSec = m.maxBytesPerSec
+	}
+
+	// The counters restart if the cgroup is recreated or a device is removed
+	var ioUsage float64
+	if bytes >= m.lastBytes {
+		ioUsage = 100.0 * float64(bytes-m.lastBytes) / timeDiff / maxBytesPerSec
+	}
+
+	// Update last stats
+	m.lastBytes = bytes
+	m.lastMeasureTs = now
+
+	// Cap at 100%
+	if ioUsage > 100.0 {
+		ioUsage = 100.0
+	}
+
+	return ioUsage, nil
+}
+
+// readCgroupIOBytes sums the bytes read and written on all devices from io.stat
+func readCgroupIOBytes(dir string) (uint64, error) {
+	file, err := os.Open(filepath.Join(dir, "io.stat"))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var total uint64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Format: MAJ:MIN rbytes=N wbytes=N rios=N wios=N dbytes=N dios=N
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok || (key != "rbytes" && key != "wbytes") {
+				continue
+			}
+			n, _ := strconv.ParseUint(value, 10, 64)
+			total += n
+		}
+	}
+
+	return total, scanner.Err()
+}
+
+// readCgroupIOLimit sums the read and write bandwidth limits from io.max.
+// It returns 0 if the cgroup has no bandwidth limits.
+func readCgroupIOLimit(dir string) float64 {
+	file, err := os.Open(filepath.Join(dir, "io.max"))
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	var total float64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Format: MAJ:MIN rbps=N wbps=N riops=N wiops=N, where N may be "max"
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok || (key != "rbps" && key != "wbps") {
+				continue
+			}
+			if n, err := strconv.ParseFloat(value, 64); err == nil {
+				total += n
+			}
+		}
+	}
+
+	return total
+}
+
+// readCgroupValue reads a single-value cgroup file such as memory.max.
+// It returns 0 for "max", meaning no limit.
+func readCgroupValue(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// readCgroupKeyValue reads a value from a flat keyed file such as cpu.stat
+func readCgroupKeyValue(path, key string) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == key {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	return 0, fmt.Errorf("%s not found in %s", key, path)
+}
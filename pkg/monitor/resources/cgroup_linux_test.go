@@ -0,0 +1,163 @@
+// +build linux
+
+package resources
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// writeFiles writes files relative to a root directory
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir for %s: %v", name, err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+}
+
+// setupCgroupTree creates a fake cgroup v2 hierarchy and /proc
+func setupCgroupTree(t *testing.T) CgroupConfig {
+	t.Helper()
+
+	root := t.TempDir()
+	config := CgroupConfig{
+		Root:     filepath.Join(root, "cgroup"),
+		ProcRoot: filepath.Join(root, "proc"),
+	}
+
+	writeFiles(t, root, map[string]string{
+		"proc/self/cgroup": "0::/system.slice/jupyter.service\n",
+		"proc/meminfo":     "MemTotal:        8000000 kB\nMemFree:         4000000 kB\n",
+
+		"cgroup/system.slice/jupyter.service/cgroup.controllers": "cpu io memory pids\n",
+		"cgroup/system.slice/jupyter.service/cpu.stat":           "usage_usec 1000000\nuser_usec 800000\nsystem_usec 200000\n",
+		"cgroup/system.slice/jupyter.service/cpu.max":            "50000 100000\n",
+		"cgroup/system.slice/jupyter.service/memory.current":     "600000000\n",
+		"cgroup/system.slice/jupyter.service/memory.max":         "1000000000\n",
+		"cgroup/system.slice/jupyter.service/memory.stat":        "anon 400000000\nfile 200000000\ninactive_file 100000000\n",
+		"cgroup/system.slice/jupyter.service/io.stat":            "8:0 rbytes=1000 wbytes=2000 rios=1 wios=2 dbytes=0 dios=0\n",
+		"cgroup/system.slice/jupyter.service/io.max":             "8:0 rbps=1000000 wbps=1000000 riops=max wiops=max\n",
+	})
+
+	return config
+}
+
+func TestNewCgroupMonitors(t *testing.T) {
+	config := setupCgroupTree(t)
+
+	monitors, err := NewCgroupMonitors(config)
+	if err != nil {
+		t.Fatalf("Failed to create cgroup monitors: %v", err)
+	}
+	for _, resourceType := range []ResourceType{CPU, Memory, Disk} {
+		if _, ok := monitors[resourceType]; !ok {
+			t.Errorf("Missing cgroup monitor for %s", resourceType)
+		}
+	}
+
+	// Memory is measured against memory.max, excluding inactive page cache
+	usage, err := monitors[Memory].GetUsage()
+	if err != nil {
+		t.Fatalf("Failed to get memory usage: %v", err)
+	}
+	if usage != 50.0 {
+		t.Errorf("Expected memory usage 50%%, got %f", usage)
+	}
+
+	// Without a limit the host total is used
+	writeFiles(t, config.Root, map[string]string{"system.slice/jupyter.service/memory.max": "max\n"})
+	usage, err = monitors[Memory].GetUsage()
+	if err != nil {
+		t.Fatalf("Failed to get memory usage: %v", err)
+	}
+	if usage < 6.0 || usage > 6.2 {
+		t.Errorf("Expected memory usage of about 6.1%%, got %f", usage)
+	}
+
+	// An explicit path overrides our own cgroup
+	config.Path = "/user.slice"
+	if _, err := NewCgroupMonitors(config); err == nil {
+		t.Error("Expected error for a missing cgroup")
+	}
+}
+
+func TestCgroupCPUMonitor_GetUsage(t *testing.T) {
+	config := setupCgroupTree(t)
+	dir := filepath.Join(config.Root, "system.slice/jupyter.service")
+
+	monitor, err := newCgroupCPUMonitor(dir)
+	if err != nil {
+		t.Fatalf("Failed to create cgroup CPU monitor: %v", err)
+	}
+
+	// A quarter of a CPU over the last second, against a quota of half a CPU
+	monitor.lastMeasureTs = time.Now().Add(-time.Second)
+	writeFiles(t, dir, map[string]string{"cpu.stat": "usage_usec 1250000\n"})
+
+	usage, err := monitor.GetUsage()
+	if err != nil {
+		t.Fatalf("Failed to get CPU usage: %v", err)
+	}
+	if usage < 45 || usage > 51 {
+		t.Errorf("Expected CPU usage of about 50%%, got %f", usage)
+	}
+
+	if limit := readCgroupCPULimit(dir); limit != 0.5 {
+		t.Errorf("Expected CPU limit 0.5, got %f", limit)
+	}
+	writeFiles(t, dir, map[string]string{"cpu.max": "max 100000\n"})
+	if limit := readCgroupCPULimit(dir); limit != float64(runtime.NumCPU()) {
+		t.Errorf("Expected CPU limit %d, got %f", runtime.NumCPU(), limit)
+	}
+}
+
+func TestCgroupIOMonitor_GetUsage(t *testing.T) {
+	config := setupCgroupTree(t)
+	dir := filepath.Join(config.Root, "system.slice/jupyter.service")
+
+	monitor, err := newCgroupIOMonitor(dir, 1000000)
+	if err != nil {
+		t.Fatalf("Failed to create cgroup I/O monitor: %v", err)
+	}
+
+	// 500 KB/s against an io.max limit of 2 MB/s
+	monitor.lastMeasureTs = time.Now().Add(-time.Second)
+	writeFiles(t, dir, map[string]string{
+		"io.stat": "8:0 rbytes=401000 wbytes=102000 rios=10 wios=20 dbytes=0 dios=0\n",
+	})
+
+	usage, err := monitor.GetUsage()
+	if err != nil {
+		t.Fatalf("Failed to get I/O usage: %v", err)
+	}
+	if usage < 22 || usage > 26 {
+		t.Errorf("Expected I/O usage of about 25%%, got %f", usage)
+	}
+
+	// Without io.max the configured maximum is used
+	if err := os.Remove(filepath.Join(dir, "io.max")); err != nil {
+		t.Fatalf("Failed to remove io.max: %v", err)
+	}
+	monitor.lastMeasureTs = time.Now().Add(-time.Second)
+	writeFiles(t, dir, map[string]string{
+		"io.stat": "8:0 rbytes=901000 wbytes=102000 rios=10 wios=20 dbytes=0 dios=0\n",
+	})
+
+	usage, err = monitor.GetUsage()
+	if err != nil {
+		t.Fatalf("Failed to get I/O usage: %v", err)
+	}
+	if usage < 45 || usage > 51 {
+		t.Errorf("Expected I/O usage of about 50%%, got %f", usage)
+	}
+}
@@ -0,0 +1,13 @@
+// +build !linux
+
+package resources
+
+import (
+	"fmt"
+)
+
+// NewCgroupMonitors creates cgroup v2 CPU, memory and disk I/O monitors
+// This is a stub implementation for non-Linux platforms
+func NewCgroupMonitors(config CgroupConfig) (map[ResourceType]ResourceMonitor, error) {
+	return nil, fmt.Errorf("cgroup monitoring is only supported on Linux")
+}
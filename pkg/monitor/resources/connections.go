@@ -0,0 +1,50 @@
+package resources
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Connections represents established TCP connections on selected local ports
+const Connections ResourceType = "connections"
+
+// ConnectionMonitorConfig configures an established-connection monitor
+type ConnectionMonitorConfig struct {
+	// Ports are the local ports to count connections on, such as 22 or 8888.
+	// An empty list counts connections on every port something listens on,
+	// so outbound connections never count.
+	Ports []int
+	// ExcludeCIDRs are remote networks whose connections are ignored,
+	// such as the agent's own address. Single addresses are also accepted.
+	ExcludeCIDRs []string
+	// ProcRoot is the proc filesystem mount point, /proc by default
+	ProcRoot string
+}
+
+// parseCIDRs parses networks, treating bare addresses as single-host networks
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", cidr)
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				networks = append(networks, &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)})
+			} else {
+				networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)})
+			}
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
@@ -0,0 +1,198 @@
+// +build linux
+
+package resources
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TCP states in /proc/net/tcp
+const (
+	tcpEstablished = "01"
+	tcpListen      = "0A"
+)
+
+// tcpSocket is a socket from a /proc/net/tcp table
+type tcpSocket struct {
+	state     string
+	localPort int
+	remoteIP  net.IP
+}
+
+// ConnectionMonitor counts established TCP connections on Linux systems
+type ConnectionMonitor struct {
+	config  ConnectionMonitorConfig
+	ports   map[int]bool
+	exclude []*net.IPNet
+	count   int
+	mutex   sync.Mutex
+}
+
+// NewConnectionMonitor creates a new connection monitor for Linux
+func NewConnectionMonitor(config ConnectionMonitorConfig) (*ConnectionMonitor, error) {
+	if config.ProcRoot == "" {
+		config.ProcRoot = "/proc"
+	}
+
+	exclude, err := parseCIDRs(config.ExcludeCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize connection monitor: %w", err)
+	}
+
+	ports := make(map[int]bool)
+	for _, port := range config.Ports {
+		ports[port] = true
+	}
+
+	return &ConnectionMonitor{
+		config:  config,
+		ports:   ports,
+		exclude: exclude,
+	}, nil
+}
+
+// GetUsage returns whether any matching connection is established as a percentage (0-100)
+// Note: For connections, we return either 0% (no connection) or 100% (connected)
+func (m *ConnectionMonitor) GetUsage() (float64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var sockets []tcpSocket
+	found := false
+	for _, name := range []string{"tcp", "tcp6"} {
+		table, err := readTCPTable(filepath.Join(m.config.ProcRoot, "net", name))
+		if os.IsNotExist(err) {
+			// IPv6 may be disabled
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read connections: %w", err)
+		}
+		sockets = append(sockets, table...)
+		found = true
+	}
+	if !found {
+		return 0, fmt.Errorf("failed to read connections: no tcp tables in %s", m.config.ProcRoot)
+	}
+
+	count := m.countConnections(sockets)
+	m.count = count
+
+	if count > 0 {
+		return 100.0, nil
+	}
+	return 0.0, nil
+}
+
+// Count returns the number of matching connections from the last reading
+func (m *ConnectionMonitor) Count() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.count
+}
+
+// countConnections counts the matching established connections. Without
+// configured ports, only connections to listening ports count, so outbound
+// connections such as snoozed's own connection to the agent are ignored.
+func (m *ConnectionMonitor) countConnections(sockets []tcpSocket) int {
+	ports := m.ports
+	if len(ports) == 0 {
+		ports = make(map[int]bool)
+		for _, socket := range sockets {
+			if socket.state == tcpListen {
+				ports[socket.localPort] = true
+			}
+		}
+	}
+
+	count := 0
+	for _, socket := range sockets {
+		if socket.state != tcpEstablished || !ports[socket.localPort] || m.excluded(socket.remoteIP) {
+			continue
+		}
+		count++
+	}
+
+	return count
+}
+
+// readTCPTable reads the listening and established sockets in a /proc/net/tcp table
+func readTCPTable(path string) ([]tcpSocket, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var sockets []tcpSocket
+	scanner := bufio.NewScanner(file)
+
+	// Skip the header line
+	scanner.Scan()
+
+	for scanner.Scan() {
+		// Format: sl local_address rem_address st ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || (fields[3] != tcpEstablished && fields[3] != tcpListen) {
+			continue
+		}
+
+		_, localPort, err := parseProcNetAddress(fields[1])
+		if err != nil {
+			continue
+		}
+		remoteIP, _, err := parseProcNetAddress(fields[2])
+		if err != nil {
+			continue
+		}
+
+		sockets = append(sockets, tcpSocket{state: fields[3], localPort: localPort, remoteIP: remoteIP})
+	}
+
+	return sockets, scanner.Err()
+}
+
+// excluded reports whether an address is in one of the excluded networks
+func (m *ConnectionMonitor) excluded(ip net.IP) bool {
+	for _, network := range m.exclude {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseProcNetAddress parses an address such as 0100007F:0016 from /proc/net/tcp.
+// The address is written as 32-bit words in host byte order, which is
+// little-endian on all architectures we support; the port is big-endian hex.
+func parseProcNetAddress(s string) (net.IP, int, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return nil, 0, fmt.Errorf("invalid address: %s", s)
+	}
+
+	raw, err := hex.DecodeString(parts[0])
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return nil, 0, fmt.Errorf("invalid address: %s", s)
+	}
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		ip[i], ip[i+1], ip[i+2], ip[i+3] = raw[i+3], raw[i+2], raw[i+1], raw[i]
+	}
+
+	port, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid port: %s", s)
+	}
+
+	return ip, int(port), nil
+}
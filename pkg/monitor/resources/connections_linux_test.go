@@ -0,0 +1,135 @@
+// +build linux
+
+package resources
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const fakeTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1000 1 0000000000000000 100 0 0 10 0
+   1: 0500000A:0016 6400000A:D431 01 00000000:00000000 02:000A7B1C 00000000     0        0 1001 4 0000000000000000 20 4 31 10 -1
+   2: 0500000A:22B8 6500000A:E001 01 00000000:00000000 00:00000000 00000000  1000        0 1002 1 0000000000000000 20 4 30 10 -1
+   3: 0500000A:22B8 0100000A:E002 01 00000000:00000000 00:00000000 00000000  1000        0 1003 1 0000000000000000 20 4 30 10 -1
+   4: 0500000A:0BB8 6600000A:E003 06 00000000:00000000 03:00001770 00000000     0        0 0 3 0000000000000000
+   5: 0500000A:C350 0A0A0A0A:01BB 01 00000000:00000000 00:00000000 00000000     0        0 1004 1 0000000000000000 20 4 30 10 -1
+`
+
+const fakeTCP6 = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000000000000:22B8 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 2000 1 0000000000000000 100 0 0 10 0
+   1: 0000000000000000FFFF00000500000A:22B8 0000000000000000FFFF00000302010A:E004 01 00000000:00000000 00:00000000 00000000  1000        0 2001 1 0000000000000000 20 4 30 10 -1
+   2: B80D0120000000000000000002000000:0016 B80D0120000000000000000001000000:E005 01 00000000:00000000 00:00000000 00000000     0        0 2002 1 0000000000000000 20 4 30 10 -1
+`
+
+// setupNetTree creates a fake /proc/net with the given tables
+func setupNetTree(t *testing.T, tables map[string]string) string {
+	t.Helper()
+
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "net"), 0755); err != nil {
+		t.Fatalf("Failed to create net dir: %v", err)
+	}
+	for name, content := range tables {
+		if err := os.WriteFile(filepath.Join(root, "net", name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	return root
+}
+
+func TestConnectionMonitor_Count(t *testing.T) {
+	root := setupNetTree(t, map[string]string{"tcp": fakeTCP, "tcp6": fakeTCP6})
+
+	tests := []struct {
+		name   string
+		config ConnectionMonitorConfig
+		count  int
+	}{
+		// Without ports, the outbound connection to 10.10.10.10:443 isn't counted
+		{"listening ports", ConnectionMonitorConfig{}, 5},
+		{"ssh", ConnectionMonitorConfig{Ports: []int{22}}, 2},
+		{"jupyter", ConnectionMonitorConfig{Ports: []int{8888}}, 3},
+		{"exclude agent", ConnectionMonitorConfig{Ports: []int{8888}, ExcludeCIDRs: []string{"10.0.0.1"}}, 2},
+		{"exclude network", ConnectionMonitorConfig{Ports: []int{22, 8888}, ExcludeCIDRs: []string{"10.0.0.0/24", "2001:db8::/32"}}, 1},
+		{"time wait only", ConnectionMonitorConfig{Ports: []int{3000}}, 0},
+	}
+
+	for _, tt := range tests {
+		tt.config.ProcRoot = root
+		monitor, err := NewConnectionMonitor(tt.config)
+		if err != nil {
+			t.Fatalf("%s: failed to create connection monitor: %v", tt.name, err)
+		}
+
+		usage, err := monitor.GetUsage()
+		if err != nil {
+			t.Fatalf("%s: failed to get connection usage: %v", tt.name, err)
+		}
+		if monitor.Count() != tt.count {
+			t.Errorf("%s: expected %d connections, got %d", tt.name, tt.count, monitor.Count())
+		}
+
+		expected := 0.0
+		if tt.count > 0 {
+			expected = 100.0
+		}
+		if usage != expected {
+			t.Errorf("%s: expected usage %f, got %f", tt.name, expected, usage)
+		}
+	}
+}
+
+func TestConnectionMonitor_Errors(t *testing.T) {
+	if _, err := NewConnectionMonitor(ConnectionMonitorConfig{ExcludeCIDRs: []string{"10.0.0.0/33"}}); err == nil {
+		t.Error("Expected error for invalid CIDR")
+	}
+	if _, err := NewConnectionMonitor(ConnectionMonitorConfig{ExcludeCIDRs: []string{"agent"}}); err == nil {
+		t.Error("Expected error for invalid address")
+	}
+
+	// IPv6 disabled
+	monitor, err := NewConnectionMonitor(ConnectionMonitorConfig{ProcRoot: setupNetTree(t, map[string]string{"tcp": fakeTCP})})
+	if err != nil {
+		t.Fatalf("Failed to create connection monitor: %v", err)
+	}
+	if _, err := monitor.GetUsage(); err != nil {
+		t.Errorf("Expected missing tcp6 to be ignored, got %v", err)
+	}
+
+	// No tables at all
+	monitor, _ = NewConnectionMonitor(ConnectionMonitorConfig{ProcRoot: t.TempDir()})
+	if _, err := monitor.GetUsage(); err == nil {
+		t.Error("Expected error without tcp tables")
+	}
+}
+
+func TestParseProcNetAddress(t *testing.T) {
+	tests := []struct {
+		input string
+		ip    string
+		port  int
+	}{
+		{"0100007F:0016", "127.0.0.1", 22},
+		{"0500000A:22B8", "10.0.0.5", 8888},
+		{"0000000000000000FFFF00000302010A:E004", "10.1.2.3", 57348},
+		{"B80D0120000000000000000001000000:01BB", "2001:db8::1", 443},
+	}
+
+	for _, tt := range tests {
+		ip, port, err := parseProcNetAddress(tt.input)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", tt.input, err)
+		}
+		if ip.String() != tt.ip || port != tt.port {
+			t.Errorf("%s: expected %s:%d, got %s:%d", tt.input, tt.ip, tt.port, ip, port)
+		}
+	}
+
+	for _, invalid := range []string{"", "0100007F", "XYZ:0016", "0100:0016", "0100007F:FFFFF"} {
+		if _, _, err := parseProcNetAddress(invalid); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// +build linux
+
+package resources
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Event types that are user input, from linux/input-event-codes.h
+const (
+	evKey = 0x01
+	evRel = 0x02
+	evAbs = 0x03
+)
+
+// inputEvent is struct input_event from linux/input.h
+type inputEvent struct {
+	Time  syscall.Timeval
+	Type  uint16
+	Code  uint16
+	Value int32
+}
+
+// inputEventSize is the size of an input event on this architecture
+const inputEventSize = int(unsafe.Sizeof(inputEvent{}))
+
+// evdevDevice is an open input event device
+type evdevDevice struct {
+	path string
+	name string
+	file *os.File
+}
+
+// evdevReader records the time of the last input event from a set of devices
+type evdevReader struct {
+	devices   []evdevDevice
+	lastInput time.Time
+	now       func() time.Time
+	mutex     sync.Mutex
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// openEvdevDevices opens the event devices that pass the configured filters
+// and starts reading them. Devices that can't be opened are skipped.
+func openEvdevDevices(config UserInputConfig) (*evdevReader, error) {
+	reader := &evdevReader{now: time.Now}
+
+	var openErr error
+	for _, pattern := range config.Devices {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid device pattern %q: %w", pattern, err)
+		}
+
+		for _, path := range paths {
+			name := inputDeviceName(config.SysRoot, path)
+			if !config.keepDevice(name) {
+				continue
+			}
+
+			file, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+			if err != nil {
+				openErr = err
+				continue
+			}
+			reader.devices = append(reader.devices, evdevDevice{path: path, name: name, file: file})
+		}
+	}
+
+	if len(reader.devices) == 0 {
+		if openErr != nil {
+			return nil, fmt.Errorf("no input devices could be opened: %w", openErr)
+		}
+		return nil, fmt.Errorf("no input devices match %v", config.Devices)
+	}
+
+	// Without any input yet, the user has been idle since the devices were opened
+	reader.lastInput = reader.now()
+	for _, device := range reader.devices {
+		reader.wg.Add(1)
+		go reader.read(device)
+	}
+
+	return reader, nil
+}
+
+// read records input events from a device until it is closed
+func (r *evdevReader) read(device evdevDevice) {
+	defer r.wg.Done()
+
+	buf := make([]byte, inputEventSize*64)
+	pending := 0
+	for {
+		n, err := device.file.Read(buf[pending:])
+		if err != nil {
+			return
+		}
+		pending += n
+
+		events := pending / inputEventSize
+		for i := 0; i < events; i++ {
+			event := (*inputEvent)(unsafe.Pointer(&buf[i*inputEventSize]))
+			if event.Type == evKey || event.Type == evRel || event.Type == evAbs {
+				sec, nsec := event.Time.Unix()
+				r.record(time.Unix(sec, nsec))
+			}
+		}
+
+		// Keep a partial event for the next read
+		copy(buf, buf[events*inputEventSize:pending])
+		pending -= events * inputEventSize
+	}
+}
+
+// record updates the last input time from an event timestamp
+func (r *evdevReader) record(timestamp time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// Devices using another clock report timestamps far from the wall clock
+	now := r.now()
+	if timestamp.After(now) || now.Sub(timestamp) > time.Minute {
+		timestamp = now
+	}
+	if timestamp.After(r.lastInput) {
+		r.lastInput = timestamp
+	}
+}
+
+// idleTime returns the time since the last input event
+func (r *evdevReader) idleTime() time.Duration {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.now().Sub(r.lastInput)
+}
+
+// deviceNames returns the names of the devices being read
+func (r *evdevReader) deviceNames() []string {
+	names := make([]string, len(r.devices))
+	for i, device := range r.devices {
+		names[i] = device.name
+		if names[i] == "" {
+			names[i] = device.path
+		}
+	}
+	return names
+}
+
+// close stops reading the devices and waits for the readers to exit.
+// Closing a closed reader does nothing.
+func (r *evdevReader) close() error {
+	r.closeOnce.Do(func() {
+		for _, device := range r.devices {
+			device.file.Close()
+		}
+	})
+	r.wg.Wait()
+	return nil
+}
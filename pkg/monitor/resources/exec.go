@@ -0,0 +1,258 @@
+package resources
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExecOutput selects how the output of an exec monitor's command is read
+type ExecOutput string
+
+const (
+	// ExecOutputNumber reads the output as a number, such as a queue length
+	ExecOutputNumber ExecOutput = "number"
+	// ExecOutputJSON reads a JSON object such as {"value": 3, "busy": true}.
+	// A busy flag reports ExecBusyValue, or the value if it is higher.
+	ExecOutputJSON ExecOutput = "json"
+	// ExecOutputExitCode reports ExecBusyValue if the command exits with 0
+	// and zero otherwise, like pgrep or a health check
+	ExecOutputExitCode ExecOutput = "exit_code"
+)
+
+// ExecBusyValue is the usage reported for a busy flag or a successful exit code
+const ExecBusyValue = 100.0
+
+// DefaultExecTimeout is how long an exec monitor's command may run
+const DefaultExecTimeout = 10 * time.Second
+
+// ExecWaitDelay is how long a command's output is waited for after it is
+// killed, in case a process it started still holds the output open
+const ExecWaitDelay = time.Second
+
+// ExecMonitorConfig declares a custom resource measured by an external command
+type ExecMonitorConfig struct {
+	// Name is the resource name used for thresholds and idle rules
+	Name string
+	// Command is the program and its arguments
+	Command []string
+	// Output selects how the output is read, ExecOutputNumber by default
+	Output ExecOutput
+	// Threshold is the value at or below which the resource is idle
+	Threshold float64
+	// Interval is how often the command runs. If zero, it runs on every check.
+	Interval time.Duration
+	// Timeout stops a command that runs too long, DefaultExecTimeout by default
+	Timeout time.Duration
+	// Env adds environment variables, in the form KEY=VALUE
+	Env []string
+}
+
+// execMonitorConfigJSON is the configuration file form of ExecMonitorConfig
+type execMonitorConfigJSON struct {
+	Name      string     `json:"name"`
+	Command   []string   `json:"command"`
+	Output    ExecOutput `json:"output,omitempty"`
+	Threshold float64    `json:"threshold"`
+	Interval  string     `json:"interval,omitempty"`
+	Timeout   string     `json:"timeout,omitempty"`
+	Env       []string   `json:"env,omitempty"`
+}
+
+// MarshalJSON encodes the configuration with readable durations
+func (c ExecMonitorConfig) MarshalJSON() ([]byte, error) {
+	wire := execMonitorConfigJSON{
+		Name:      c.Name,
+		Command:   c.Command,
+		Output:    c.Output,
+		Threshold: c.Threshold,
+		Env:       c.Env,
+	}
+	if c.Interval != 0 {
+		wire.Interval = c.Interval.String()
+	}
+	if c.Timeout != 0 {
+		wire.Timeout = c.Timeout.String()
+	}
+	return json.Marshal(wire)
+}
+
+// UnmarshalJSON decodes a configuration with duration strings such as "30s"
+func (c *ExecMonitorConfig) UnmarshalJSON(data []byte) error {
+	var wire execMonitorConfigJSON
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+
+	*c = ExecMonitorConfig{
+		Name:      wire.Name,
+		Command:   wire.Command,
+		Output:    wire.Output,
+		Threshold: wire.Threshold,
+		Env:       wire.Env,
+	}
+	if wire.Interval != "" {
+		interval, err := time.ParseDuration(wire.Interval)
+		if err != nil {
+			return fmt.Errorf("invalid interval for %s: %w", wire.Name, err)
+		}
+		c.Interval = interval
+	}
+	if wire.Timeout != "" {
+		timeout, err := time.ParseDuration(wire.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout for %s: %w", wire.Name, err)
+		}
+		c.Timeout = timeout
+	}
+	return nil
+}
+
+// LoadExecMonitorConfigs reads a JSON list of exec monitors from a file
+func LoadExecMonitorConfigs(path string) ([]ExecMonitorConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read exec monitors: %w", err)
+	}
+
+	var configs []ExecMonitorConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse exec monitors: %w", err)
+	}
+
+	for _, config := range configs {
+		if err := config.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return configs, nil
+}
+
+// validate checks that the configuration can be run
+func (c ExecMonitorConfig) validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("exec monitor has no name")
+	}
+	if len(c.Command) == 0 || c.Command[0] == "" {
+		return fmt.Errorf("exec monitor %s has no command", c.Name)
+	}
+	switch c.Output {
+	case "", ExecOutputNumber, ExecOutputJSON, ExecOutputExitCode:
+	default:
+		return fmt.Errorf("exec monitor %s has unknown output %q", c.Name, c.Output)
+	}
+	if c.Interval < 0 || c.Timeout < 0 {
+		return fmt.Errorf("exec monitor %s has a negative interval or timeout", c.Name)
+	}
+	return nil
+}
+
+// ExecMonitor measures a custom resource by running an external command
+type ExecMonitor struct {
+	config ExecMonitorConfig
+}
+
+// NewExecMonitor creates a new exec monitor
+func NewExecMonitor(config ExecMonitorConfig) (*ExecMonitor, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if config.Output == "" {
+		config.Output = ExecOutputNumber
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultExecTimeout
+	}
+
+	return &ExecMonitor{config: config}, nil
+}
+
+// Config returns the monitor's configuration with defaults applied
+func (m *ExecMonitor) Config() ExecMonitorConfig {
+	return m.config
+}
+
+// GetUsage runs the command and reads its output
+func (m *ExecMonitor) GetUsage() (float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.config.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, m.config.Command[0], m.config.Command[1:]...)
+	cmd.Env = append(os.Environ(), m.config.Env...)
+	cmd.WaitDelay = ExecWaitDelay
+	KillProcessGroup(cmd)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return 0, fmt.Errorf("exec monitor %s timed out after %s", m.config.Name, m.config.Timeout)
+	}
+
+	var exitErr *exec.ExitError
+	if m.config.Output == ExecOutputExitCode {
+		if err == nil {
+			return ExecBusyValue, nil
+		}
+		if errors.As(err, &exitErr) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("exec monitor %s failed: %w", m.config.Name, err)
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("exec monitor %s failed: %w: %s", m.config.Name, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return parseExecOutput(m.config.Output, stdout.Bytes())
+}
+
+// execJSONOutput is the output read by ExecOutputJSON
+type execJSONOutput struct {
+	Value *float64 `json:"value"`
+	Busy  *bool    `json:"busy"`
+}
+
+// parseExecOutput reads the value from a command's output
+func parseExecOutput(output ExecOutput, data []byte) (float64, error) {
+	switch output {
+	case ExecOutputJSON:
+		var result execJSONOutput
+		if err := json.Unmarshal(data, &result); err != nil {
+			return 0, fmt.Errorf("invalid JSON output: %w", err)
+		}
+		if result.Value == nil && result.Busy == nil {
+			return 0, fmt.Errorf("JSON output has neither value nor busy")
+		}
+
+		var value float64
+		if result.Value != nil {
+			value = *result.Value
+		}
+		if result.Busy != nil && *result.Busy && value < ExecBusyValue {
+			value = ExecBusyValue
+		}
+		return value, nil
+
+	default:
+		// Only the first line is read, so a trailing message is allowed
+		text := strings.TrimSpace(string(data))
+		if i := strings.IndexByte(text, '\n'); i >= 0 {
+			text = strings.TrimSpace(text[:i])
+		}
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number output %q", text)
+		}
+		return value, nil
+	}
+}
@@ -0,0 +1,18 @@
+// +build linux
+
+package resources
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// KillProcessGroup runs a command in its own process group and kills the
+// whole group when its context is done, so the children of a shell pipeline
+// don't outlive the timeout
+func KillProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
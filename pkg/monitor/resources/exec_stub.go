@@ -0,0 +1,11 @@
+// +build !linux
+
+package resources
+
+import (
+	"os/exec"
+)
+
+// KillProcessGroup is a stub for non-Linux platforms, where only the command
+// itself is killed when its context is done
+func KillProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,158 @@
+package resources
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExecMonitor_Outputs(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+
+	tests := []struct {
+		name     string
+		output   ExecOutput
+		script   string
+		expected float64
+	}{
+		{"number", ExecOutputNumber, "echo 3", 3},
+		{"number with message", ExecOutputNumber, "printf ' 2.5\\n2 jobs pending\\n'", 2.5},
+		{"json value", ExecOutputJSON, `echo '{"value": 4}'`, 4},
+		{"json busy", ExecOutputJSON, `echo '{"value": 1, "busy": true}'`, ExecBusyValue},
+		{"json idle", ExecOutputJSON, `echo '{"busy": false}'`, 0},
+		{"exit code busy", ExecOutputExitCode, "exit 0", ExecBusyValue},
+		{"exit code idle", ExecOutputExitCode, "exit 1", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			monitor, err := NewExecMonitor(ExecMonitorConfig{
+				Name:    "queue",
+				Command: []string{"sh", "-c", tt.script},
+				Output:  tt.output,
+			})
+			if err != nil {
+				t.Fatalf("Failed to create exec monitor: %v", err)
+			}
+
+			value, err := monitor.GetUsage()
+			if err != nil {
+				t.Fatalf("Failed to get usage: %v", err)
+			}
+			if value != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, value)
+			}
+		})
+	}
+}
+
+func TestExecMonitor_Errors(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+
+	tests := []struct {
+		name   string
+		output ExecOutput
+		script string
+	}{
+		{"not a number", ExecOutputNumber, "echo busy"},
+		{"command failed", ExecOutputNumber, "echo 3; exit 2"},
+		{"invalid json", ExecOutputJSON, "echo 3"},
+		{"empty json", ExecOutputJSON, "echo '{}'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			monitor, err := NewExecMonitor(ExecMonitorConfig{
+				Name:    "queue",
+				Command: []string{"sh", "-c", tt.script},
+				Output:  tt.output,
+			})
+			if err != nil {
+				t.Fatalf("Failed to create exec monitor: %v", err)
+			}
+			if _, err := monitor.GetUsage(); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+
+	// A slow command is stopped at the timeout
+	monitor, err := NewExecMonitor(ExecMonitorConfig{
+		Name:    "slow",
+		Command: []string{"sleep", "5"},
+		Timeout: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create exec monitor: %v", err)
+	}
+	start := time.Now()
+	if _, err := monitor.GetUsage(); err == nil {
+		t.Errorf("Expected a timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the command to be stopped, took %s", elapsed)
+	}
+
+	// So is a pipeline whose other processes hold the output open
+	monitor, err = NewExecMonitor(ExecMonitorConfig{
+		Name:    "pipeline",
+		Command: []string{"sh", "-c", "sleep 5 | sleep 5"},
+		Timeout: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create exec monitor: %v", err)
+	}
+	start = time.Now()
+	if _, err := monitor.GetUsage(); err == nil {
+		t.Errorf("Expected a timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the pipeline to be stopped, took %s", elapsed)
+	}
+}
+
+func TestLoadExecMonitorConfigs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "monitors.json")
+	config := `[
+		{"name": "slurm_jobs", "command": ["squeue", "-h", "-t", "R"], "threshold": 0, "interval": "2m"},
+		{"name": "ci_runner", "command": ["/usr/local/bin/runner-busy"], "output": "exit_code", "threshold": 50, "timeout": "5s"}
+	]`
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	configs, err := LoadExecMonitorConfigs(path)
+	if err != nil {
+		t.Fatalf("Failed to load exec monitors: %v", err)
+	}
+	if len(configs) != 2 {
+		t.Fatalf("Expected 2 exec monitors, got %d", len(configs))
+	}
+	if configs[0].Interval != 2*time.Minute || configs[1].Timeout != 5*time.Second {
+		t.Errorf("Unexpected durations: %s, %s", configs[0].Interval, configs[1].Timeout)
+	}
+	if configs[1].Output != ExecOutputExitCode || configs[1].Threshold != 50 {
+		t.Errorf("Unexpected config: %+v", configs[1])
+	}
+
+	invalid := []string{
+		`[{"command": ["true"]}]`,
+		`[{"name": "empty"}]`,
+		`[{"name": "odd", "command": ["true"], "output": "yaml"}]`,
+		`[{"name": "slow", "command": ["true"], "interval": "often"}]`,
+	}
+	for _, config := range invalid {
+		if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		if _, err := LoadExecMonitorConfigs(path); err == nil {
+			t.Errorf("Expected an error for %s", config)
+		}
+	}
+}
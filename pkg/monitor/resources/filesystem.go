@@ -0,0 +1,59 @@
+package resources
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Filesystem represents files created or modified in watched directory trees
+const Filesystem ResourceType = "filesystem"
+
+// DefaultFilesystemWindow is how recent a file change must be to count as activity
+const DefaultFilesystemWindow = 5 * time.Minute
+
+// DefaultFilesystemMaxWatches bounds the number of directories watched
+const DefaultFilesystemMaxWatches = 8192
+
+// FilesystemMonitorConfig configures a filesystem activity monitor
+type FilesystemMonitorConfig struct {
+	// Paths are the directory trees to watch, such as /home/alice/src
+	Paths []string
+	// Exclude skips matching files and directories. A pattern with a slash,
+	// such as /home/alice/src/build, matches a path and everything under it.
+	// Other patterns, such as node_modules or *.pyc, match any path element.
+	Exclude []string
+	// Window is how recent a change must be to count as activity.
+	// Defaults to DefaultFilesystemWindow.
+	Window time.Duration
+	// MaxWatches bounds the number of directories watched, since each one
+	// uses a kernel inotify watch. Defaults to DefaultFilesystemMaxWatches.
+	MaxWatches int
+}
+
+// excluded reports whether a path matches one of the exclude patterns
+func excluded(path string, patterns []string) bool {
+	path = filepath.Clean(path)
+	elements := strings.Split(path, string(filepath.Separator))
+
+	for _, pattern := range patterns {
+		if strings.Contains(pattern, "/") {
+			pattern = filepath.Clean(pattern)
+			if path == pattern || strings.HasPrefix(path, pattern+string(filepath.Separator)) {
+				return true
+			}
+			if matched, _ := filepath.Match(pattern, path); matched {
+				return true
+			}
+			continue
+		}
+
+		for _, element := range elements {
+			if matched, _ := filepath.Match(pattern, element); matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,217 @@
+// +build linux
+
+package resources
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// inotifyChangeMask selects the events that count as file activity
+const inotifyChangeMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE |
+	syscall.IN_MOVED_TO | syscall.IN_DELETE
+
+// FilesystemMonitor reports file changes in watched directory trees on Linux systems
+type FilesystemMonitor struct {
+	config       FilesystemMonitorConfig
+	fd           int
+	watches      map[int32]string
+	lastActivity time.Time
+	lastPath     string
+	now          func() time.Time
+	mutex        sync.Mutex
+}
+
+// NewFilesystemMonitor creates a new filesystem activity monitor for Linux.
+// Every directory under the configured paths is watched with inotify.
+func NewFilesystemMonitor(config FilesystemMonitorConfig) (*FilesystemMonitor, error) {
+	if len(config.Paths) == 0 {
+		return nil, fmt.Errorf("failed to initialize filesystem monitor: no paths to watch")
+	}
+	if config.Window == 0 {
+		config.Window = DefaultFilesystemWindow
+	}
+	if config.MaxWatches == 0 {
+		config.MaxWatches = DefaultFilesystemMaxWatches
+	}
+
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize filesystem monitor: %w", err)
+	}
+
+	m := &FilesystemMonitor{
+		config:  config,
+		fd:      fd,
+		watches: make(map[int32]string),
+		now:     time.Now,
+	}
+
+	for _, path := range config.Paths {
+		if err := m.watchTree(path); err != nil {
+			syscall.Close(fd)
+			return nil, fmt.Errorf("failed to initialize filesystem monitor: %w", err)
+		}
+	}
+
+	return m, nil
+}
+
+// watchTree adds a watch to a directory and every directory below it
+func (m *FilesystemMonitor) watchTree(root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// A directory removed or unreadable while walking is skipped
+			if path != root {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if path != root && excluded(path, m.config.Exclude) {
+			return filepath.SkipDir
+		}
+		if len(m.watches) >= m.config.MaxWatches {
+			return fmt.Errorf("more than %d directories under %s", m.config.MaxWatches, root)
+		}
+
+		wd, err := syscall.InotifyAddWatch(m.fd, path, inotifyChangeMask|syscall.IN_ONLYDIR)
+		if err != nil {
+			if path == root {
+				return fmt.Errorf("failed to watch %s: %w", path, err)
+			}
+			return nil
+		}
+		m.watches[int32(wd)] = path
+		return nil
+	})
+}
+
+// GetUsage returns whether files changed within the window as a percentage (0-100)
+// Note: For the filesystem, we return either 0% (no recent change) or 100% (changed)
+func (m *FilesystemMonitor) GetUsage() (float64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.readEvents(); err != nil {
+		return 0, err
+	}
+
+	if !m.lastActivity.IsZero() && m.now().Sub(m.lastActivity) < m.config.Window {
+		return 100.0, nil
+	}
+	return 0.0, nil
+}
+
+// readEvents drains the pending inotify events. The caller must hold the mutex.
+func (m *FilesystemMonitor) readEvents() error {
+	buf := make([]byte, 64*1024)
+
+	for {
+		n, err := syscall.Read(m.fd, buf)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read filesystem events: %w", err)
+		}
+		if n < syscall.SizeofInotifyEvent {
+			return nil
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			nameEnd := nameStart + int(event.Len)
+			if nameEnd > n {
+				break
+			}
+			name := string(trimNull(buf[nameStart:nameEnd]))
+			offset = nameEnd
+
+			m.handleEvent(event.Wd, event.Mask, name)
+		}
+	}
+}
+
+// handleEvent records a file change and watches new directories
+func (m *FilesystemMonitor) handleEvent(wd int32, mask uint32, name string) {
+	// Events were lost, so something changed
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		m.lastActivity = m.now()
+		return
+	}
+
+	// The watched directory is gone
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(m.watches, wd)
+		return
+	}
+
+	dir, ok := m.watches[wd]
+	if !ok {
+		return
+	}
+	path := dir
+	if name != "" {
+		path = filepath.Join(dir, name)
+	}
+	if excluded(path, m.config.Exclude) {
+		return
+	}
+
+	m.lastActivity = m.now()
+	m.lastPath = path
+
+	// Watch directories created or moved into a watched tree
+	if mask&syscall.IN_ISDIR != 0 && mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+		m.watchTree(path)
+	}
+}
+
+// LastActivity returns the time and path of the most recent file change
+func (m *FilesystemMonitor) LastActivity() (time.Time, string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.lastActivity, m.lastPath
+}
+
+// Watches returns the number of directories being watched
+func (m *FilesystemMonitor) Watches() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return len(m.watches)
+}
+
+// Close stops watching. Closing a closed monitor does nothing.
+func (m *FilesystemMonitor) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.fd < 0 {
+		return nil
+	}
+	fd := m.fd
+	m.fd = -1
+	m.watches = make(map[int32]string)
+	return syscall.Close(fd)
+}
+
+// trimNull removes the null padding after an inotify event name
+func trimNull(name []byte) []byte {
+	for i, b := range name {
+		if b == 0 {
+			return name[:i]
+		}
+	}
+	return name
+}
@@ -0,0 +1,92 @@
+// +build linux
+
+package resources
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFilesystemMonitor(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"src/app", "src/node_modules/lib", "build"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+
+	monitor, err := NewFilesystemMonitor(FilesystemMonitorConfig{
+		Paths:   []string{root},
+		Exclude: []string{"node_modules", "*.pyc", filepath.Join(root, "build")},
+		Window:  time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create filesystem monitor: %v", err)
+	}
+	defer monitor.Close()
+
+	now := time.Now()
+	monitor.now = func() time.Time { return now }
+
+	if watches := monitor.Watches(); watches != 3 {
+		t.Errorf("Expected 3 watched directories, got %d", watches)
+	}
+
+	write := func(path string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(root, path), []byte("print('hello')\n"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	usage := func() float64 {
+		t.Helper()
+		value, err := monitor.GetUsage()
+		if err != nil {
+			t.Fatalf("Failed to get usage: %v", err)
+		}
+		return value
+	}
+
+	if usage() != 0.0 {
+		t.Errorf("Expected no activity before any change")
+	}
+
+	// Changes to excluded paths are ignored
+	write("src/node_modules/lib/index.js")
+	write("src/app/main.pyc")
+	write("build/output.o")
+	if usage() != 0.0 {
+		t.Errorf("Expected excluded changes to be ignored")
+	}
+
+	write("src/app/main.py")
+	if usage() != 100.0 {
+		t.Errorf("Expected activity after a file change")
+	}
+	if _, path := monitor.LastActivity(); path != filepath.Join(root, "src/app/main.py") {
+		t.Errorf("Expected the changed file, got %s", path)
+	}
+
+	// The activity expires after the window
+	now = now.Add(2 * time.Minute)
+	if usage() != 0.0 {
+		t.Errorf("Expected no activity after the window")
+	}
+
+	// New directories are watched
+	if err := os.Mkdir(filepath.Join(root, "src/new"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	usage()
+	now = now.Add(2 * time.Minute)
+	write("src/new/notes.txt")
+	if usage() != 100.0 {
+		t.Errorf("Expected activity in a new directory")
+	}
+
+	if _, err := NewFilesystemMonitor(FilesystemMonitorConfig{Paths: []string{filepath.Join(root, "missing")}}); err == nil {
+		t.Errorf("Expected an error for a missing path")
+	}
+}
@@ -0,0 +1,37 @@
+// +build !linux
+
+package resources
+
+import (
+	"fmt"
+	"time"
+)
+
+// FilesystemMonitor is a stub implementation for non-Linux platforms
+type FilesystemMonitor struct{}
+
+// NewFilesystemMonitor creates a new filesystem activity monitor
+// This is a stub implementation for non-Linux platforms
+func NewFilesystemMonitor(config FilesystemMonitorConfig) (*FilesystemMonitor, error) {
+	return nil, fmt.Errorf("filesystem monitoring is only supported on Linux")
+}
+
+// GetUsage returns a dummy filesystem value
+func (m *FilesystemMonitor) GetUsage() (float64, error) {
+	return 0.0, nil
+}
+
+// LastActivity returns no activity
+func (m *FilesystemMonitor) LastActivity() (time.Time, string) {
+	return time.Time{}, ""
+}
+
+// Watches returns no watches
+func (m *FilesystemMonitor) Watches() int {
+	return 0
+}
+
+// Close does nothing
+func (m *FilesystemMonitor) Close() error {
+	return nil
+}
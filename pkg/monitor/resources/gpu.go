@@ -0,0 +1,136 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// GPUDevice is a reading of a single GPU
+type GPUDevice struct {
+	// Backend is the name of the backend that read the device
+	Backend string
+	// Index is the device index within its backend
+	Index int
+	// Name is the device model, if known
+	Name string
+	// Utilization is the GPU busy percentage (0-100)
+	Utilization float64
+	// MemoryUsedBytes is the device memory in use
+	MemoryUsedBytes uint64
+	// MemoryTotalBytes is the device memory size, 0 if unknown
+	MemoryTotalBytes uint64
+	// ProcessCount is the number of active compute processes, -1 if unknown
+	ProcessCount int
+}
+
+// GPUBackend reads per-device GPU usage from one vendor's interface
+type GPUBackend interface {
+	// Name returns the name of the backend, such as "nvidia"
+	Name() string
+	// Devices returns the current readings of all devices
+	Devices() ([]GPUDevice, error)
+}
+
+// GPUAggregation selects how per-device readings are combined into one usage value
+type GPUAggregation string
+
+const (
+	// GPUAggregateMax reports the utilization of the busiest device
+	GPUAggregateMax GPUAggregation = "max"
+	// GPUAggregateMean reports the mean utilization of all devices
+	GPUAggregateMean GPUAggregation = "mean"
+	// GPUAggregateAnyBusy reports 100% if any device is busy and 0% otherwise
+	GPUAggregateAnyBusy GPUAggregation = "any_busy"
+)
+
+// GPUMonitorConfig configures a GPU monitor
+type GPUMonitorConfig struct {
+	// Backends are the GPU backends to read. If empty, the NVIDIA, AMD and
+	// Intel backends are detected automatically.
+	Backends []GPUBackend
+	// Aggregation combines the device readings, GPUAggregateMax by default
+	Aggregation GPUAggregation
+	// BusyThreshold is the utilization above which a device counts as busy
+	// for GPUAggregateAnyBusy. Defaults to 5%.
+	BusyThreshold float64
+	// ProcessesAreBusy counts a device with active compute processes as busy
+	// for GPUAggregateAnyBusy, even when its utilization is low
+	ProcessesAreBusy bool
+}
+
+// CommandRunner runs a command and returns its standard output
+type CommandRunner func(name string, args ...string) ([]byte, error)
+
+// gpuCommandTimeout stops a GPU tool such as nvidia-smi that hangs, which
+// happens when the driver is wedged
+const gpuCommandTimeout = 10 * time.Second
+
+// runCommand runs a command with os/exec, stopping it after gpuCommandTimeout
+func runCommand(name string, args ...string) ([]byte, error) {
+	return runCommandTimeout(gpuCommandTimeout, name, args...)
+}
+
+// runCommandTimeout runs a command, killing it and its children if it runs
+// longer than the timeout
+func runCommandTimeout(timeout time.Duration, name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.WaitDelay = ExecWaitDelay
+	KillProcessGroup(cmd)
+
+	output, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%s timed out after %s", name, timeout)
+	}
+	return output, err
+}
+
+// withDefaults fills in the unset fields of the configuration
+func (c GPUMonitorConfig) withDefaults() GPUMonitorConfig {
+	if c.Aggregation == "" {
+		c.Aggregation = GPUAggregateMax
+	}
+	if c.BusyThreshold <= 0 {
+		c.BusyThreshold = 5.0
+	}
+	return c
+}
+
+// AggregateGPUUsage combines per-device readings into a single usage value (0-100)
+func AggregateGPUUsage(devices []GPUDevice, config GPUMonitorConfig) (float64, error) {
+	config = config.withDefaults()
+	if len(devices) == 0 {
+		return 0.0, nil
+	}
+
+	switch config.Aggregation {
+	case GPUAggregateMax:
+		var usage float64
+		for _, device := range devices {
+			if device.Utilization > usage {
+				usage = device.Utilization
+			}
+		}
+		return usage, nil
+	case GPUAggregateMean:
+		var total float64
+		for _, device := range devices {
+			total += device.Utilization
+		}
+		return total / float64(len(devices)), nil
+	case GPUAggregateAnyBusy:
+		for _, device := range devices {
+			if device.Utilization > config.BusyThreshold ||
+				(config.ProcessesAreBusy && device.ProcessCount > 0) {
+				return 100.0, nil
+			}
+		}
+		return 0.0, nil
+	default:
+		return 0, fmt.Errorf("unknown GPU aggregation: %s", config.Aggregation)
+	}
+}
@@ -4,48 +4,52 @@ package resources
 
 import (
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
-// GPUMonitor monitors GPU usage on Linux systems with NVIDIA GPUs
+// GPUMonitor monitors GPU usage on Linux systems with NVIDIA, AMD or Intel GPUs
 type GPUMonitor struct {
+	config          GPUMonitorConfig
+	devices         []GPUDevice
 	lastMeasurement float64
 	lastUpdateTime  time.Time
 	mutex           sync.Mutex
 	hasGPU          bool
 }
 
-// NewGPUMonitor creates a new GPU monitor for Linux
+// NewGPUMonitor creates a new GPU monitor for Linux with automatically detected backends
 func NewGPUMonitor() (*GPUMonitor, error) {
-	// Check if we have an NVIDIA GPU and nvidia-smi
-	hasGPU, err := checkNvidiaSmi()
-	if err != nil {
-		// We don't return an error, just note that we don't have a GPU
-		return &GPUMonitor{
-			lastMeasurement: 0.0,
-			lastUpdateTime:  time.Now(),
-			hasGPU:          false,
-		}, nil
+	return NewGPUMonitorWithConfig(GPUMonitorConfig{})
+}
+
+// NewGPUMonitorWithConfig creates a new GPU monitor for Linux
+func NewGPUMonitorWithConfig(config GPUMonitorConfig) (*GPUMonitor, error) {
+	config = config.withDefaults()
+	if len(config.Backends) == 0 {
+		config.Backends = DetectGPUBackends()
+	}
+
+	m := &GPUMonitor{
+		config:         config,
+		lastUpdateTime: time.Now(),
+		hasGPU:         len(config.Backends) > 0,
 	}
 
 	// If we have a GPU, initialize with current utilization
-	var utilization float64
-	if hasGPU {
-		utilization, err = getGPUUtilization()
-		if err != nil {
+	if m.hasGPU {
+		if _, err := m.measure(); err != nil {
 			return nil, fmt.Errorf("failed to initialize GPU monitor: %w", err)
 		}
 	}
 
-	return &GPUMonitor{
-		lastMeasurement: utilization,
-		lastUpdateTime:  time.Now(),
-		hasGPU:          hasGPU,
-	}, nil
+	return m, nil
 }
 
 // GetUsage returns the current GPU usage as a percentage (0-100)
@@ -64,18 +68,71 @@ func (m *GPUMonitor) GetUsage() (float64, error) {
 		return m.lastMeasurement, nil
 	}
 
-	utilization, err := getGPUUtilization()
+	utilization, err := m.measure()
 	if err != nil {
 		// Return last known value on error
 		return m.lastMeasurement, err
 	}
-
-	m.lastMeasurement = utilization
 	m.lastUpdateTime = now
 
 	return utilization, nil
 }
 
+// Devices returns the per-device readings from the last measurement
+func (m *GPUMonitor) Devices() []GPUDevice {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	devices := make([]GPUDevice, len(m.devices))
+	copy(devices, m.devices)
+	return devices
+}
+
+// measure reads all backends and aggregates the device readings
+func (m *GPUMonitor) measure() (float64, error) {
+	var devices []GPUDevice
+	var errs []string
+	for _, backend := range m.config.Backends {
+		backendDevices, err := backend.Devices()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", backend.Name(), err))
+			continue
+		}
+		devices = append(devices, backendDevices...)
+	}
+
+	// A failing backend only hides its own devices
+	if len(errs) == len(m.config.Backends) {
+		return 0, fmt.Errorf("failed to read GPU usage: %s", strings.Join(errs, "; "))
+	}
+
+	utilization, err := AggregateGPUUsage(devices, m.config)
+	if err != nil {
+		return 0, err
+	}
+
+	m.devices = devices
+	m.lastMeasurement = utilization
+	return utilization, nil
+}
+
+// DetectGPUBackends returns the backends for the GPUs present on this system
+func DetectGPUBackends() []GPUBackend {
+	var backends []GPUBackend
+
+	if hasGPU, err := checkNvidiaSmi(); err == nil && hasGPU {
+		backends = append(backends, &NvidiaSMIBackend{})
+	}
+	if amd := (&AMDSysfsBackend{}); len(amd.cards()) > 0 {
+		backends = append(backends, amd)
+	}
+	if intel := (&IntelSysfsBackend{}); len(intel.cards()) > 0 {
+		backends = append(backends, intel)
+	}
+
+	return backends
+}
+
 // checkNvidiaSmi checks if nvidia-smi is available and NVIDIA GPU is present
 func checkNvidiaSmi() (bool, error) {
 	// Check if nvidia-smi is available
@@ -91,8 +148,9 @@ func checkNvidiaSmi() (bool, error) {
 		return false, fmt.Errorf("failed to execute nvidia-smi: %w", err)
 	}
 
-	// Parse output
-	count, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	// Parse output, which has one line per GPU
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	count, err := strconv.Atoi(strings.TrimSpace(lines[0]))
 	if err != nil {
 		return false, fmt.Errorf("failed to parse GPU count: %w", err)
 	}
@@ -100,43 +158,252 @@ func checkNvidiaSmi() (bool, error) {
 	return count > 0, nil
 }
 
-// getGPUUtilization gets GPU utilization using nvidia-smi
-func getGPUUtilization() (float64, error) {
-	// Run nvidia-smi to get GPU utilization
-	cmd := exec.Command("nvidia-smi", "--query-gpu=utilization.gpu", "--format=csv,noheader,nounits")
-	output, err := cmd.Output()
+// NvidiaSMIBackend reads NVIDIA GPUs with nvidia-smi
+type NvidiaSMIBackend struct {
+	// Run runs nvidia-smi. If nil, the command is executed with os/exec.
+	Run CommandRunner
+}
+
+// Name returns the name of the backend
+func (b *NvidiaSMIBackend) Name() string {
+	return "nvidia"
+}
+
+// Devices returns the current readings of all NVIDIA GPUs
+func (b *NvidiaSMIBackend) Devices() ([]GPUDevice, error) {
+	run := b.Run
+	if run == nil {
+		run = runCommand
+	}
+
+	output, err := run("nvidia-smi", "--query-gpu=index,uuid,name,utilization.gpu,memory.used,memory.total", "--format=csv,noheader,nounits")
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute nvidia-smi: %w", err)
+	}
+
+	var devices []GPUDevice
+	uuids := make(map[string]int)
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := splitCSV(line)
+		if len(fields) != 6 {
+			continue
+		}
+
+		index, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+
+		// Values are "[N/A]" when the driver does not support them
+		utilization, _ := strconv.ParseFloat(fields[3], 64)
+		memoryUsed, _ := strconv.ParseUint(fields[4], 10, 64)
+		memoryTotal, _ := strconv.ParseUint(fields[5], 10, 64)
+
+		uuids[fields[1]] = len(devices)
+		devices = append(devices, GPUDevice{
+			Backend:          b.Name(),
+			Index:            index,
+			Name:             fields[2],
+			Utilization:      utilization,
+			MemoryUsedBytes:  memoryUsed * 1024 * 1024,
+			MemoryTotalBytes: memoryTotal * 1024 * 1024,
+			ProcessCount:     -1,
+		})
+	}
+
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("no valid GPU utilization data")
+	}
+
+	// Count the compute processes on each GPU
+	output, err = run("nvidia-smi", "--query-compute-apps=gpu_uuid,pid", "--format=csv,noheader")
 	if err != nil {
-		return 0, fmt.Errorf("failed to execute nvidia-smi: %w", err)
+		// Process counts are optional, for example in containers without PID access
+		return devices, nil
+	}
+	for i := range devices {
+		devices[i].ProcessCount = 0
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := splitCSV(line)
+		if len(fields) < 1 {
+			continue
+		}
+		if i, ok := uuids[fields[0]]; ok {
+			devices[i].ProcessCount++
+		}
+	}
+
+	return devices, nil
+}
+
+// splitCSV splits a line of nvidia-smi CSV output into trimmed fields
+func splitCSV(line string) []string {
+	if strings.TrimSpace(line) == "" {
+		return nil
 	}
 
-	// Parse output
-	lines := strings.Split(string(output), "\n")
-	if len(lines) == 0 {
-		return 0, fmt.Errorf("no output from nvidia-smi")
+	fields := strings.Split(line, ",")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	return fields
+}
+
+// drmVendorAMD and drmVendorIntel are the PCI vendor IDs in /sys/class/drm/card*/device/vendor
+const (
+	drmVendorAMD   = "0x1002"
+	drmVendorIntel = "0x8086"
+)
+
+// drmCards returns the DRM card directories of a PCI vendor, such as /sys/class/drm/card0
+func drmCards(sysfsRoot, vendor string) []string {
+	if sysfsRoot == "" {
+		sysfsRoot = "/sys"
 	}
 
-	// If we have multiple GPUs, average the utilization
-	var totalUtilization float64
-	var gpuCount int
+	paths, _ := filepath.Glob(filepath.Join(sysfsRoot, "class", "drm", "card*"))
+	sort.Strings(paths)
 
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
-		if trimmed == "" {
+	var cards []string
+	for _, path := range paths {
+		// Skip connectors such as card0-HDMI-A-1
+		if strings.Contains(filepath.Base(path), "-") {
 			continue
 		}
 
-		utilization, err := strconv.ParseFloat(trimmed, 64)
-		if err != nil {
+		data, err := os.ReadFile(filepath.Join(path, "device", "vendor"))
+		if err != nil || strings.TrimSpace(string(data)) != vendor {
 			continue
 		}
+		cards = append(cards, path)
+	}
+
+	return cards
+}
+
+// cardIndex returns the number of a DRM card directory
+func cardIndex(card string) int {
+	index, _ := strconv.Atoi(strings.TrimPrefix(filepath.Base(card), "card"))
+	return index
+}
 
-		totalUtilization += utilization
-		gpuCount++
+// readSysfsUint reads a single unsigned integer from a sysfs file
+func readSysfsUint(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
 	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// AMDSysfsBackend reads AMD GPUs from the amdgpu driver's sysfs files
+type AMDSysfsBackend struct {
+	// SysfsRoot is the sysfs mount point, /sys by default
+	SysfsRoot string
+}
 
-	if gpuCount == 0 {
-		return 0, fmt.Errorf("no valid GPU utilization data")
+// Name returns the name of the backend
+func (b *AMDSysfsBackend) Name() string {
+	return "amd"
+}
+
+// cards returns the AMD DRM cards
+func (b *AMDSysfsBackend) cards() []string {
+	var cards []string
+	for _, card := range drmCards(b.SysfsRoot, drmVendorAMD) {
+		// Only amdgpu exposes the busy percentage
+		if _, err := os.Stat(filepath.Join(card, "device", "gpu_busy_percent")); err == nil {
+			cards = append(cards, card)
+		}
+	}
+	return cards
+}
+
+// Devices returns the current readings of all AMD GPUs.
+// The driver does not report processes, so ProcessCount is -1.
+func (b *AMDSysfsBackend) Devices() ([]GPUDevice, error) {
+	var devices []GPUDevice
+	for _, card := range b.cards() {
+		device := filepath.Join(card, "device")
+
+		busy, err := readSysfsUint(filepath.Join(device, "gpu_busy_percent"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", card, err)
+		}
+		memoryUsed, _ := readSysfsUint(filepath.Join(device, "mem_info_vram_used"))
+		memoryTotal, _ := readSysfsUint(filepath.Join(device, "mem_info_vram_total"))
+
+		devices = append(devices, GPUDevice{
+			Backend:          b.Name(),
+			Index:            cardIndex(card),
+			Name:             filepath.Base(card),
+			Utilization:      float64(busy),
+			MemoryUsedBytes:  memoryUsed,
+			MemoryTotalBytes: memoryTotal,
+			ProcessCount:     -1,
+		})
 	}
 
-	return totalUtilization / float64(gpuCount), nil
-}
\ No newline at end of file
+	return devices, nil
+}
+
+// IntelSysfsBackend reads Intel GPUs from the i915 driver's frequency files.
+// The driver has no busy counter in sysfs, so utilization is estimated as the
+// actual frequency relative to the maximum frequency.
+type IntelSysfsBackend struct {
+	// SysfsRoot is the sysfs mount point, /sys by default
+	SysfsRoot string
+}
+
+// Name returns the name of the backend
+func (b *IntelSysfsBackend) Name() string {
+	return "intel"
+}
+
+// cards returns the Intel DRM cards
+func (b *IntelSysfsBackend) cards() []string {
+	var cards []string
+	for _, card := range drmCards(b.SysfsRoot, drmVendorIntel) {
+		if _, err := os.Stat(filepath.Join(card, "gt_act_freq_mhz")); err == nil {
+			cards = append(cards, card)
+		}
+	}
+	return cards
+}
+
+// Devices returns the current readings of all Intel GPUs.
+// Memory and processes are not reported, so ProcessCount is -1.
+func (b *IntelSysfsBackend) Devices() ([]GPUDevice, error) {
+	var devices []GPUDevice
+	for _, card := range b.cards() {
+		actual, err := readSysfsUint(filepath.Join(card, "gt_act_freq_mhz"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", card, err)
+		}
+		minimum, _ := readSysfsUint(filepath.Join(card, "gt_min_freq_mhz"))
+		maximum, err := readSysfsUint(filepath.Join(card, "gt_max_freq_mhz"))
+		if err != nil || maximum <= minimum {
+			return nil, fmt.Errorf("failed to read frequency range of %s", card)
+		}
+
+		// An idle GPU is clocked down to its minimum, or to 0 when in RC6
+		var utilization float64
+		if actual > minimum {
+			utilization = 100.0 * float64(actual-minimum) / float64(maximum-minimum)
+		}
+		if utilization > 100.0 {
+			utilization = 100.0
+		}
+
+		devices = append(devices, GPUDevice{
+			Backend:      b.Name(),
+			Index:        cardIndex(card),
+			Name:         filepath.Base(card),
+			Utilization:  utilization,
+			ProcessCount: -1,
+		})
+	}
+
+	return devices, nil
+}
@@ -0,0 +1,207 @@
+// +build linux
+
+package resources
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNvidiaSMI returns canned nvidia-smi output
+func fakeNvidiaSMI(gpus, apps string, appsErr error) CommandRunner {
+	return func(name string, args ...string) ([]byte, error) {
+		if name != "nvidia-smi" {
+			return nil, fmt.Errorf("unexpected command %s", name)
+		}
+		if strings.HasPrefix(args[0], "--query-compute-apps") {
+			return []byte(apps), appsErr
+		}
+		return []byte(gpus), nil
+	}
+}
+
+const eightGPUs = `0, GPU-0000, NVIDIA A100-SXM4-40GB, 0, 3, 40960
+1, GPU-1111, NVIDIA A100-SXM4-40GB, 0, 3, 40960
+2, GPU-2222, NVIDIA A100-SXM4-40GB, 0, 3, 40960
+3, GPU-3333, NVIDIA A100-SXM4-40GB, 97, 30000, 40960
+4, GPU-4444, NVIDIA A100-SXM4-40GB, 0, 3, 40960
+5, GPU-5555, NVIDIA A100-SXM4-40GB, [N/A], 3, 40960
+6, GPU-6666, NVIDIA A100-SXM4-40GB, 0, 3, 40960
+7, GPU-7777, NVIDIA A100-SXM4-40GB, 1, 1200, 40960
+`
+
+func TestNvidiaSMIBackend_Devices(t *testing.T) {
+	backend := &NvidiaSMIBackend{Run: fakeNvidiaSMI(eightGPUs, "GPU-3333, 4242\nGPU-3333, 4243\nGPU-7777, 5000\n", nil)}
+
+	devices, err := backend.Devices()
+	if err != nil {
+		t.Fatalf("Failed to read devices: %v", err)
+	}
+	if len(devices) != 8 {
+		t.Fatalf("Expected 8 devices, got %d", len(devices))
+	}
+
+	busy := devices[3]
+	if busy.Index != 3 || busy.Utilization != 97 || busy.ProcessCount != 2 {
+		t.Errorf("Unexpected reading for GPU 3: %+v", busy)
+	}
+	if busy.MemoryUsedBytes != 30000*1024*1024 || busy.MemoryTotalBytes != 40960*1024*1024 {
+		t.Errorf("Unexpected memory for GPU 3: %+v", busy)
+	}
+	if devices[5].Utilization != 0 || devices[0].ProcessCount != 0 || devices[7].ProcessCount != 1 {
+		t.Errorf("Unexpected readings: %+v", devices)
+	}
+
+	// Process counts are unknown if the apps query fails
+	backend.Run = fakeNvidiaSMI(eightGPUs, "", fmt.Errorf("permission denied"))
+	devices, err = backend.Devices()
+	if err != nil {
+		t.Fatalf("Failed to read devices: %v", err)
+	}
+	if devices[3].ProcessCount != -1 {
+		t.Errorf("Expected unknown process count, got %d", devices[3].ProcessCount)
+	}
+
+	backend.Run = fakeNvidiaSMI("NVIDIA-SMI has failed\n", "", nil)
+	if _, err := backend.Devices(); err == nil {
+		t.Error("Expected error for invalid nvidia-smi output")
+	}
+}
+
+func TestAggregateGPUUsage(t *testing.T) {
+	devices, err := (&NvidiaSMIBackend{Run: fakeNvidiaSMI(eightGPUs, "", nil)}).Devices()
+	if err != nil {
+		t.Fatalf("Failed to read devices: %v", err)
+	}
+
+	tests := []struct {
+		config GPUMonitorConfig
+		usage  float64
+	}{
+		{GPUMonitorConfig{}, 97},
+		{GPUMonitorConfig{Aggregation: GPUAggregateMax}, 97},
+		{GPUMonitorConfig{Aggregation: GPUAggregateMean}, 12.25},
+		{GPUMonitorConfig{Aggregation: GPUAggregateAnyBusy}, 100},
+		{GPUMonitorConfig{Aggregation: GPUAggregateAnyBusy, BusyThreshold: 98}, 0},
+	}
+
+	for _, tt := range tests {
+		usage, err := AggregateGPUUsage(devices, tt.config)
+		if err != nil {
+			t.Fatalf("%s: failed to aggregate: %v", tt.config.Aggregation, err)
+		}
+		if usage != tt.usage {
+			t.Errorf("%s: expected usage %f, got %f", tt.config.Aggregation, tt.usage, usage)
+		}
+	}
+
+	// A device holding compute processes can count as busy
+	idle := []GPUDevice{{Utilization: 0, ProcessCount: 1}}
+	usage, _ := AggregateGPUUsage(idle, GPUMonitorConfig{Aggregation: GPUAggregateAnyBusy, ProcessesAreBusy: true})
+	if usage != 100 {
+		t.Errorf("Expected device with processes to be busy, got %f", usage)
+	}
+
+	if _, err := AggregateGPUUsage(devices, GPUMonitorConfig{Aggregation: "median"}); err == nil {
+		t.Error("Expected error for unknown aggregation")
+	}
+}
+
+func TestSysfsGPUBackends(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		// AMD discrete GPU
+		"class/drm/card0/device/vendor":              "0x1002\n",
+		"class/drm/card0/device/gpu_busy_percent":    "42\n",
+		"class/drm/card0/device/mem_info_vram_used":  "1073741824\n",
+		"class/drm/card0/device/mem_info_vram_total": "17179869184\n",
+		"class/drm/card0-DP-1/status":                "connected\n",
+		// Intel integrated GPU
+		"class/drm/card1/device/vendor":   "0x8086\n",
+		"class/drm/card1/gt_act_freq_mhz": "650\n",
+		"class/drm/card1/gt_min_freq_mhz": "300\n",
+		"class/drm/card1/gt_max_freq_mhz": "1000\n",
+		// Another vendor's card
+		"class/drm/card2/device/vendor": "0x10de\n",
+	})
+
+	amd := &AMDSysfsBackend{SysfsRoot: root}
+	devices, err := amd.Devices()
+	if err != nil {
+		t.Fatalf("Failed to read AMD devices: %v", err)
+	}
+	if len(devices) != 1 {
+		t.Fatalf("Expected 1 AMD device, got %d", len(devices))
+	}
+	if devices[0].Index != 0 || devices[0].Utilization != 42 || devices[0].MemoryUsedBytes != 1<<30 || devices[0].MemoryTotalBytes != 16<<30 {
+		t.Errorf("Unexpected AMD reading: %+v", devices[0])
+	}
+
+	intel := &IntelSysfsBackend{SysfsRoot: root}
+	devices, err = intel.Devices()
+	if err != nil {
+		t.Fatalf("Failed to read Intel devices: %v", err)
+	}
+	if len(devices) != 1 {
+		t.Fatalf("Expected 1 Intel device, got %d", len(devices))
+	}
+	if devices[0].Index != 1 || devices[0].Utilization != 50 {
+		t.Errorf("Unexpected Intel reading: %+v", devices[0])
+	}
+
+	// Both backends feed the same monitor
+	monitor, err := NewGPUMonitorWithConfig(GPUMonitorConfig{Backends: []GPUBackend{amd, intel}})
+	if err != nil {
+		t.Fatalf("Failed to create GPU monitor: %v", err)
+	}
+	if len(monitor.Devices()) != 2 {
+		t.Errorf("Expected 2 devices, got %d", len(monitor.Devices()))
+	}
+	if monitor.lastMeasurement != 50 {
+		t.Errorf("Expected usage 50, got %f", monitor.lastMeasurement)
+	}
+
+	// An empty tree has no devices
+	if cards := (&AMDSysfsBackend{SysfsRoot: filepath.Join(root, "missing")}).cards(); len(cards) != 0 {
+		t.Errorf("Expected no cards, got %v", cards)
+	}
+}
+
+func TestGPUMonitor_BackendErrors(t *testing.T) {
+	working := &NvidiaSMIBackend{Run: fakeNvidiaSMI(eightGPUs, "", nil)}
+	broken := &NvidiaSMIBackend{Run: func(string, ...string) ([]byte, error) {
+		return nil, fmt.Errorf("driver not loaded")
+	}}
+
+	// A failing backend does not hide the others
+	monitor, err := NewGPUMonitorWithConfig(GPUMonitorConfig{Backends: []GPUBackend{broken, working}})
+	if err != nil {
+		t.Fatalf("Failed to create GPU monitor: %v", err)
+	}
+	if monitor.lastMeasurement != 97 {
+		t.Errorf("Expected usage 97, got %f", monitor.lastMeasurement)
+	}
+
+	if _, err := NewGPUMonitorWithConfig(GPUMonitorConfig{Backends: []GPUBackend{broken}}); err == nil {
+		t.Error("Expected error when all backends fail")
+	}
+}
+
+func TestRunCommandTimeout(t *testing.T) {
+	output, err := runCommandTimeout(time.Second, "echo", "ok")
+	if err != nil || strings.TrimSpace(string(output)) != "ok" {
+		t.Errorf("Expected the command output, got %q %v", output, err)
+	}
+
+	// A hung tool is stopped with the children holding its output open
+	start := time.Now()
+	if _, err := runCommandTimeout(100*time.Millisecond, "sh", "-c", "sleep 5 | sleep 5"); err == nil {
+		t.Errorf("Expected a timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the command to be stopped, took %s", elapsed)
+	}
+}
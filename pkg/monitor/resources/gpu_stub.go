@@ -17,6 +17,12 @@ func NewGPUMonitor() (*GPUMonitor, error) {
 	return &GPUMonitor{}, nil
 }
 
+// NewGPUMonitorWithConfig creates a new GPU monitor
+// This is a stub implementation for non-Linux platforms
+func NewGPUMonitorWithConfig(config GPUMonitorConfig) (*GPUMonitor, error) {
+	return &GPUMonitor{}, nil
+}
+
 // GetUsage returns a dummy GPU usage value
 func (m *GPUMonitor) GetUsage() (float64, error) {
 	m.mutex.Lock()
@@ -24,4 +30,9 @@ func (m *GPUMonitor) GetUsage() (float64, error) {
 	
 	// Return a dummy value
 	return 5.0, nil
-}
\ No newline at end of file
+}
+
+// Devices returns no devices
+func (m *GPUMonitor) Devices() []GPUDevice {
+	return nil
+}
@@ -0,0 +1,213 @@
+package resources
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Jupyter represents the activity of a local Jupyter Server or JupyterLab
+const Jupyter ResourceType = "jupyter"
+
+// DefaultJupyterURL is the address of a Jupyter server started with the default options
+const DefaultJupyterURL = "http://localhost:8888"
+
+// DefaultJupyterActivityWindow is how recent the last activity must be to count as busy
+const DefaultJupyterActivityWindow = 5 * time.Minute
+
+// JupyterMonitorConfig configures a Jupyter server activity monitor
+type JupyterMonitorConfig struct {
+	// URL is the server's base URL, including any base path such as
+	// http://localhost:8888/user/alice. Defaults to DefaultJupyterURL.
+	URL string
+	// Token is the server's API token. If empty, the JUPYTER_TOKEN
+	// environment variable is used.
+	Token string
+	// ActivityWindow is how recent the server's or a kernel's last activity
+	// must be for the server to be busy. Defaults to DefaultJupyterActivityWindow.
+	ActivityWindow time.Duration
+	// ConnectionsAreBusy counts an open browser connection to a kernel as
+	// activity, even if nothing has run within the activity window. A tab
+	// left open keeps its connection, so this is off by default.
+	ConnectionsAreBusy bool
+	// Timeout bounds each API request, 5 seconds by default
+	Timeout time.Duration
+	// Client is the HTTP client used for requests. If nil, a client with
+	// Timeout is used.
+	Client *http.Client
+}
+
+// JupyterActivity is a summary of a Jupyter server's state
+type JupyterActivity struct {
+	// LastActivity is the most recent activity of the server or any kernel
+	LastActivity time.Time
+	// Kernels is the number of running kernels
+	Kernels int
+	// BusyKernels is the number of kernels executing code
+	BusyKernels int
+	// Connections is the number of browser connections to kernels
+	Connections int
+	// Sessions is the number of notebooks, consoles and terminals with a session
+	Sessions int
+}
+
+// jupyterStatus is the response of /api/status
+type jupyterStatus struct {
+	LastActivity time.Time `json:"last_activity"`
+	Connections  int       `json:"connections"`
+	Kernels      int       `json:"kernels"`
+}
+
+// jupyterKernel is an element of the response of /api/kernels
+type jupyterKernel struct {
+	ID             string    `json:"id"`
+	LastActivity   time.Time `json:"last_activity"`
+	ExecutionState string    `json:"execution_state"`
+	Connections    int       `json:"connections"`
+}
+
+// jupyterSession is an element of the response of /api/sessions
+type jupyterSession struct {
+	ID     string         `json:"id"`
+	Kernel *jupyterKernel `json:"kernel"`
+}
+
+// JupyterMonitor reports whether a Jupyter server is in use
+type JupyterMonitor struct {
+	config   JupyterMonitorConfig
+	now      func() time.Time
+	activity JupyterActivity
+	mutex    sync.Mutex
+}
+
+// NewJupyterMonitor creates a new Jupyter server activity monitor
+func NewJupyterMonitor(config JupyterMonitorConfig) (*JupyterMonitor, error) {
+	if config.URL == "" {
+		config.URL = DefaultJupyterURL
+	}
+	if !strings.HasPrefix(config.URL, "http://") && !strings.HasPrefix(config.URL, "https://") {
+		return nil, fmt.Errorf("invalid Jupyter URL %q", config.URL)
+	}
+	config.URL = strings.TrimSuffix(config.URL, "/")
+	if config.Token == "" {
+		config.Token = os.Getenv("JUPYTER_TOKEN")
+	}
+	if config.ActivityWindow == 0 {
+		config.ActivityWindow = DefaultJupyterActivityWindow
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 5 * time.Second
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: config.Timeout}
+	}
+
+	return &JupyterMonitor{
+		config: config,
+		now:    time.Now,
+	}, nil
+}
+
+// GetUsage returns whether the Jupyter server is in use as a percentage (0-100)
+// Note: For Jupyter, we return either 0% (idle) or 100% (in use)
+func (m *JupyterMonitor) GetUsage() (float64, error) {
+	activity, err := m.poll()
+	if err != nil {
+		return 0, err
+	}
+
+	m.mutex.Lock()
+	m.activity = activity
+	now := m.now()
+	m.mutex.Unlock()
+
+	if activity.BusyKernels > 0 {
+		return 100.0, nil
+	}
+	if m.config.ConnectionsAreBusy && activity.Connections > 0 {
+		return 100.0, nil
+	}
+	if !activity.LastActivity.IsZero() && now.Sub(activity.LastActivity) < m.config.ActivityWindow {
+		return 100.0, nil
+	}
+
+	return 0.0, nil
+}
+
+// Activity returns the server state read by the last call to GetUsage
+func (m *JupyterMonitor) Activity() JupyterActivity {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.activity
+}
+
+// poll reads the server status, kernels and sessions
+func (m *JupyterMonitor) poll() (JupyterActivity, error) {
+	var activity JupyterActivity
+
+	var status jupyterStatus
+	if err := m.get("/api/status", &status); err != nil {
+		return activity, err
+	}
+	activity.LastActivity = status.LastActivity
+
+	var kernels []jupyterKernel
+	if err := m.get("/api/kernels", &kernels); err != nil {
+		return activity, err
+	}
+	for _, kernel := range kernels {
+		activity.Kernels++
+		activity.Connections += kernel.Connections
+		if kernel.ExecutionState == "busy" || kernel.ExecutionState == "starting" {
+			activity.BusyKernels++
+		}
+		if kernel.LastActivity.After(activity.LastActivity) {
+			activity.LastActivity = kernel.LastActivity
+		}
+	}
+
+	var sessions []jupyterSession
+	if err := m.get("/api/sessions", &sessions); err != nil {
+		return activity, err
+	}
+	activity.Sessions = len(sessions)
+
+	return activity, nil
+}
+
+// get requests an API endpoint and decodes its JSON response. Jupyter
+// counts authenticated API requests as activity, so the request asks not to
+// be tracked; otherwise polling would keep the server busy forever.
+func (m *JupyterMonitor) get(path string, out interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.config.URL+path+"?no_track_activity=1", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create Jupyter request: %w", err)
+	}
+	if m.config.Token != "" {
+		req.Header.Set("Authorization", "token "+m.config.Token)
+	}
+
+	resp, err := m.config.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to query Jupyter %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Jupyter %s returned %s", path, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid Jupyter %s response: %w", path, err)
+	}
+
+	return nil
+}
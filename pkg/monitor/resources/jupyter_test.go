@@ -0,0 +1,147 @@
+package resources
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeJupyter is a stand-in for the Jupyter Server REST API
+type fakeJupyter struct {
+	token    string
+	status   map[string]interface{}
+	kernels  []map[string]interface{}
+	sessions []map[string]interface{}
+
+	// clock, if set, makes requests count as activity like Jupyter does
+	// unless they ask not to be tracked
+	clock func() time.Time
+}
+
+func (f *fakeJupyter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "token "+f.token {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if f.clock != nil && r.URL.Query().Get("no_track_activity") == "" {
+		f.status["last_activity"] = f.clock().Format(time.RFC3339)
+	}
+
+	var body interface{}
+	switch r.URL.Path {
+	case "/user/alice/api/status":
+		body = f.status
+	case "/user/alice/api/kernels":
+		body = f.kernels
+	case "/user/alice/api/sessions":
+		body = f.sessions
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+func TestJupyterMonitor(t *testing.T) {
+	now := time.Date(2025, 7, 2, 12, 0, 0, 0, time.UTC)
+	jupyter := &fakeJupyter{
+		token: "secret",
+		status: map[string]interface{}{
+			"last_activity": now.Add(-time.Hour).Format(time.RFC3339),
+			"connections":   1,
+			"kernels":       1,
+		},
+		kernels: []map[string]interface{}{{
+			"id":              "k1",
+			"last_activity":   now.Add(-2 * time.Minute).Format(time.RFC3339),
+			"execution_state": "idle",
+			"connections":     1,
+		}},
+		sessions: []map[string]interface{}{{"id": "s1", "kernel": map[string]interface{}{"id": "k1"}}},
+	}
+	server := httptest.NewServer(jupyter)
+	defer server.Close()
+
+	monitor, err := NewJupyterMonitor(JupyterMonitorConfig{URL: server.URL + "/user/alice/", Token: "secret"})
+	if err != nil {
+		t.Fatalf("Failed to create Jupyter monitor: %v", err)
+	}
+	monitor.now = func() time.Time { return now }
+
+	// Output was produced two minutes ago
+	usage, err := monitor.GetUsage()
+	if err != nil {
+		t.Fatalf("Failed to get usage: %v", err)
+	}
+	if usage != 100.0 {
+		t.Errorf("Expected recent kernel activity to be busy, got %f", usage)
+	}
+	activity := monitor.Activity()
+	if activity.Kernels != 1 || activity.Sessions != 1 || activity.Connections != 1 {
+		t.Errorf("Unexpected activity: %+v", activity)
+	}
+	if !activity.LastActivity.Equal(now.Add(-2 * time.Minute)) {
+		t.Errorf("Expected the kernel's last activity, got %s", activity.LastActivity)
+	}
+
+	// An open tab alone is idle
+	now = now.Add(10 * time.Minute)
+	if usage, _ := monitor.GetUsage(); usage != 0.0 {
+		t.Errorf("Expected an open connection without activity to be idle, got %f", usage)
+	}
+	monitor.config.ConnectionsAreBusy = true
+	if usage, _ := monitor.GetUsage(); usage != 100.0 {
+		t.Errorf("Expected the connection to be busy, got %f", usage)
+	}
+	monitor.config.ConnectionsAreBusy = false
+
+	// A long-running cell is busy however old its last output
+	jupyter.kernels[0]["execution_state"] = "busy"
+	if usage, _ := monitor.GetUsage(); usage != 100.0 {
+		t.Errorf("Expected a busy kernel to be busy, got %f", usage)
+	}
+
+	// A wrong token is an error
+	monitor.config.Token = "wrong"
+	if _, err := monitor.GetUsage(); err == nil {
+		t.Errorf("Expected an error for a rejected token")
+	}
+
+	if _, err := NewJupyterMonitor(JupyterMonitorConfig{URL: "localhost:8888"}); err == nil {
+		t.Errorf("Expected an error for a URL without a scheme")
+	}
+}
+
+func TestJupyterMonitor_UntrackedRequests(t *testing.T) {
+	now := time.Date(2025, 7, 2, 12, 0, 0, 0, time.UTC)
+	jupyter := &fakeJupyter{
+		token:    "secret",
+		status:   map[string]interface{}{"last_activity": now.Add(-time.Hour).Format(time.RFC3339)},
+		kernels:  []map[string]interface{}{},
+		sessions: []map[string]interface{}{},
+		clock:    func() time.Time { return now },
+	}
+	server := httptest.NewServer(jupyter)
+	defer server.Close()
+
+	monitor, err := NewJupyterMonitor(JupyterMonitorConfig{URL: server.URL + "/user/alice", Token: "secret"})
+	if err != nil {
+		t.Fatalf("Failed to create Jupyter monitor: %v", err)
+	}
+	monitor.now = func() time.Time { return now }
+
+	// Polling must not count as the activity it is looking for
+	for i := 0; i < 3; i++ {
+		if usage, err := monitor.GetUsage(); err != nil || usage != 0.0 {
+			t.Fatalf("Expected an idle server to stay idle while polled, got %f (%v)", usage, err)
+		}
+		now = now.Add(time.Minute)
+	}
+	if last := monitor.Activity().LastActivity; !last.Equal(now.Add(-time.Hour - 3*time.Minute)) {
+		t.Errorf("Expected the last activity to be unchanged, got %s", last)
+	}
+}
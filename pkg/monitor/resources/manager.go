@@ -1,7 +1,9 @@
 package resources
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"sync"
 	"time"
 )
@@ -133,4 +135,60 @@ func (m *MonitorManager) GetAllUsage() (map[ResourceType]*ResourceUsage, error)
 	}
 
 	return usage, nil
-}
\ No newline at end of file
+}
+
+// SetMonitor replaces the monitor used for a standard resource type.
+// The replaced monitor is closed if it implements io.Closer.
+func (m *MonitorManager) SetMonitor(resourceType ResourceType, monitor ResourceMonitor) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if closer, ok := m.monitors[resourceType].(io.Closer); ok && m.monitors[resourceType] != monitor {
+		closer.Close()
+	}
+	m.monitors[resourceType] = monitor
+}
+
+// Close closes the monitors that implement io.Closer, such as those holding
+// inotify watches or input devices. The manager must not be used afterwards.
+func (m *MonitorManager) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var errs []error
+	for resourceType, monitor := range m.monitors {
+		if closer, ok := monitor.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("failed to close %s monitor: %w", resourceType, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ResourceTypes returns the standard resource types handled by the manager
+func (m *MonitorManager) ResourceTypes() []ResourceType {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	types := make([]ResourceType, 0, len(m.monitors))
+	for resourceType := range m.monitors {
+		types = append(types, resourceType)
+	}
+
+	return types
+}
+
+// CustomMonitorNames returns the names of all registered custom monitors
+func (m *MonitorManager) CustomMonitorNames() []string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	names := make([]string, 0, len(m.custom))
+	for name := range m.custom {
+		names = append(names, name)
+	}
+
+	return names
+}
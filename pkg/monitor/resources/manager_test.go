@@ -163,4 +163,42 @@ func TestMonitorManager_GetAllUsage(t *testing.T) {
 	if err == nil {
 		t.Error("Expected error due to failing custom monitor, got nil")
 	}
-}
\ No newline at end of file
+}
+// closingMonitor is a monitor that records being closed
+type closingMonitor struct {
+	closed int
+}
+
+func (m *closingMonitor) GetUsage() (float64, error) { return 0, nil }
+
+func (m *closingMonitor) Close() error {
+	m.closed++
+	return nil
+}
+
+func TestMonitorManager_Close(t *testing.T) {
+	manager, err := NewMonitorManager()
+	if err != nil {
+		t.Fatalf("Failed to create monitor manager: %v", err)
+	}
+
+	// A replaced monitor is closed
+	replaced := &closingMonitor{}
+	manager.SetMonitor(Filesystem, replaced)
+	manager.SetMonitor(Filesystem, replaced)
+	if replaced.closed != 0 {
+		t.Errorf("Expected setting the same monitor again not to close it")
+	}
+	current := &closingMonitor{}
+	manager.SetMonitor(Filesystem, current)
+	if replaced.closed != 1 {
+		t.Errorf("Expected the replaced monitor to be closed once, got %d", replaced.closed)
+	}
+
+	if err := manager.Close(); err != nil {
+		t.Fatalf("Failed to close monitor manager: %v", err)
+	}
+	if current.closed != 1 {
+		t.Errorf("Expected the monitor to be closed once, got %d", current.closed)
+	}
+}
@@ -0,0 +1,69 @@
+package resources
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Process represents the activity of selected processes
+const Process ResourceType = "process"
+
+// ProcessMatcher selects processes. All non-empty fields must match.
+type ProcessMatcher struct {
+	// Name is the process name (the comm field of /proc/<pid>/stat)
+	Name string
+	// Cmdline is a regular expression matched against the full command line
+	Cmdline string
+	// User is the user name or numeric UID that owns the process
+	User string
+	// Cgroup is a cgroup path prefix, such as /system.slice/docker.service
+	Cgroup string
+
+	cmdline *regexp.Regexp
+}
+
+// ProcessMonitorConfig configures a process activity monitor
+type ProcessMonitorConfig struct {
+	// Include selects the processes to track. An empty list tracks all processes.
+	Include []ProcessMatcher
+	// Exclude removes processes from the tracked set
+	Exclude []ProcessMatcher
+	// IncludeSelf tracks the monitor's own process, which is ignored by default
+	IncludeSelf bool
+	// MaxIOBytesPerSec is the per-process I/O rate reported as 100%.
+	// If zero, only CPU usage is considered.
+	MaxIOBytesPerSec float64
+	// ProcRoot is the proc filesystem mount point, /proc by default
+	ProcRoot string
+}
+
+// ProcessActivity is the measured activity of a single tracked process
+type ProcessActivity struct {
+	// PID is the process ID
+	PID int
+	// Name is the process name
+	Name string
+	// CPUPercent is the CPU usage as a percentage of one core
+	CPUPercent float64
+	// IOBytesPerSec is the rate of bytes read from and written to storage
+	IOBytesPerSec float64
+}
+
+// compileProcessMatchers compiles the command line expressions of the matchers
+func compileProcessMatchers(matchers []ProcessMatcher) ([]ProcessMatcher, error) {
+	compiled := make([]ProcessMatcher, len(matchers))
+	for i, matcher := range matchers {
+		compiled[i] = matcher
+		if matcher.Cmdline == "" {
+			continue
+		}
+
+		re, err := regexp.Compile(matcher.Cmdline)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cmdline pattern %q: %w", matcher.Cmdline, err)
+		}
+		compiled[i].cmdline = re
+	}
+
+	return compiled, nil
+}
@@ -0,0 +1,378 @@
+// +build linux
+
+package resources
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clockTicksPerSecond is the kernel USER_HZ used for /proc CPU times
+const clockTicksPerSecond = 100
+
+// processSample is a single reading of a process's counters
+type processSample struct {
+	startTime uint64
+	cpuTicks  uint64
+	ioBytes   uint64
+}
+
+// ProcessMonitor tracks the CPU and I/O activity of selected processes on Linux
+type ProcessMonitor struct {
+	config        ProcessMonitorConfig
+	include       []ProcessMatcher
+	exclude       []ProcessMatcher
+	lastSamples   map[int]processSample
+	lastMeasureTs time.Time
+	activity      []ProcessActivity
+	userNames     map[string]string
+	mutex         sync.Mutex
+}
+
+// NewProcessMonitor creates a new process monitor for Linux
+func NewProcessMonitor(config ProcessMonitorConfig) (*ProcessMonitor, error) {
+	if config.ProcRoot == "" {
+		config.ProcRoot = "/proc"
+	}
+
+	include, err := compileProcessMatchers(config.Include)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize process monitor: %w", err)
+	}
+	exclude, err := compileProcessMatchers(config.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize process monitor: %w", err)
+	}
+
+	m := &ProcessMonitor{
+		config:    config,
+		include:   include,
+		exclude:   exclude,
+		userNames: make(map[string]string),
+	}
+
+	// Take the initial sample so the first usage reading has a baseline
+	samples, _, err := m.scan()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize process monitor: %w", err)
+	}
+	m.lastSamples = samples
+	m.lastMeasureTs = time.Now()
+
+	return m, nil
+}
+
+// GetUsage returns the activity of the busiest tracked process as a percentage (0-100)
+func (m *ProcessMonitor) GetUsage() (float64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	samples, names, err := m.scan()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read process stats: %w", err)
+	}
+
+	// Calculate time difference
+	now := time.Now()
+	timeDiff := now.Sub(m.lastMeasureTs).Seconds()
+	if timeDiff <= 0 {
+		return 0, fmt.Errorf("time difference too small")
+	}
+
+	var usage float64
+	activity := make([]ProcessActivity, 0, len(samples))
+	for pid, sample := range samples {
+		last, ok := m.lastSamples[pid]
+		if !ok || last.startTime != sample.startTime {
+			// New process, or the PID was reused
+			continue
+		}
+
+		cpuPercent := 100.0 * float64(counterDelta(sample.cpuTicks, last.cpuTicks)) / clockTicksPerSecond / timeDiff
+		ioRate := float64(counterDelta(sample.ioBytes, last.ioBytes)) / timeDiff
+		activity = append(activity, ProcessActivity{
+			PID:           pid,
+			Name:          names[pid],
+			CPUPercent:    cpuPercent,
+			IOBytesPerSec: ioRate,
+		})
+
+		processUsage := cpuPercent
+		if m.config.MaxIOBytesPerSec > 0 {
+			if ioUsage := 100.0 * ioRate / m.config.MaxIOBytesPerSec; ioUsage > processUsage {
+				processUsage = ioUsage
+			}
+		}
+		if processUsage > usage {
+			usage = processUsage
+		}
+	}
+
+	// Update last stats
+	m.lastSamples = samples
+	m.lastMeasureTs = now
+	m.activity = activity
+
+	// Cap at 100%
+	if usage > 100.0 {
+		usage = 100.0
+	}
+
+	return usage, nil
+}
+
+// counterDelta returns how much a counter grew. A counter that went
+// backwards, such as an I/O count that became unreadable and was read as 0,
+// didn't grow.
+func counterDelta(current, last uint64) uint64 {
+	if current < last {
+		return 0
+	}
+	return current - last
+}
+
+// Processes returns the activity of the tracked processes from the last reading
+func (m *ProcessMonitor) Processes() []ProcessActivity {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	activity := make([]ProcessActivity, len(m.activity))
+	copy(activity, m.activity)
+	return activity
+}
+
+// scan reads the counters of all tracked processes
+func (m *ProcessMonitor) scan() (map[int]processSample, map[int]string, error) {
+	entries, err := os.ReadDir(m.config.ProcRoot)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	self := os.Getpid()
+	samples := make(map[int]processSample)
+	names := make(map[int]string)
+
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		if pid == self && !m.config.IncludeSelf {
+			continue
+		}
+
+		// Processes can exit at any time, so read errors just skip the process
+		dir := filepath.Join(m.config.ProcRoot, entry.Name())
+		name, startTime, cpuTicks, err := readProcessStat(dir)
+		if err != nil {
+			continue
+		}
+
+		proc := &procInfo{dir: dir, name: name, monitor: m}
+		if !m.tracked(proc) {
+			continue
+		}
+
+		samples[pid] = processSample{
+			startTime: startTime,
+			cpuTicks:  cpuTicks,
+			ioBytes:   readProcessIO(dir),
+		}
+		names[pid] = name
+	}
+
+	return samples, names, nil
+}
+
+// tracked reports whether a process is selected by the include and exclude matchers
+func (m *ProcessMonitor) tracked(proc *procInfo) bool {
+	if len(m.include) > 0 {
+		included := false
+		for i := range m.include {
+			if proc.matches(&m.include[i]) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+
+	for i := range m.exclude {
+		if proc.matches(&m.exclude[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// lookupUserName resolves a UID to a user name, caching the result
+func (m *ProcessMonitor) lookupUserName(uid string) string {
+	if name, ok := m.userNames[uid]; ok {
+		return name
+	}
+
+	name := ""
+	if u, err := user.LookupId(uid); err == nil {
+		name = u.Username
+	}
+	m.userNames[uid] = name
+	return name
+}
+
+// procInfo lazily reads the process attributes needed by the matchers
+type procInfo struct {
+	dir     string
+	name    string
+	monitor *ProcessMonitor
+
+	cmdline       *string
+	uid           *string
+	cgroups       []string
+	cgroupsLoaded bool
+}
+
+// matches reports whether the process matches all set fields of the matcher
+func (p *procInfo) matches(matcher *ProcessMatcher) bool {
+	if matcher.Name != "" && matcher.Name != p.name {
+		return false
+	}
+
+	if matcher.cmdline != nil && !matcher.cmdline.MatchString(p.getCmdline()) {
+		return false
+	}
+
+	if matcher.User != "" {
+		uid := p.getUID()
+		if matcher.User != uid && matcher.User != p.monitor.lookupUserName(uid) {
+			return false
+		}
+	}
+
+	if matcher.Cgroup != "" {
+		found := false
+		for _, cgroup := range p.getCgroups() {
+			if strings.HasPrefix(cgroup, matcher.Cgroup) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// getCmdline returns the command line with arguments separated by spaces
+func (p *procInfo) getCmdline() string {
+	if p.cmdline == nil {
+		data, _ := os.ReadFile(filepath.Join(p.dir, "cmdline"))
+		cmdline := strings.TrimSpace(strings.ReplaceAll(string(data), "\x00", " "))
+		p.cmdline = &cmdline
+	}
+	return *p.cmdline
+}
+
+// getUID returns the real UID of the process
+func (p *procInfo) getUID() string {
+	if p.uid == nil {
+		uid := ""
+		if file, err := os.Open(filepath.Join(p.dir, "status")); err == nil {
+			scanner := bufio.NewScanner(file)
+			for scanner.Scan() {
+				fields := strings.Fields(scanner.Text())
+				if len(fields) >= 2 && fields[0] == "Uid:" {
+					uid = fields[1]
+					break
+				}
+			}
+			file.Close()
+		}
+		p.uid = &uid
+	}
+	return *p.uid
+}
+
+// getCgroups returns the cgroup paths of the process
+func (p *procInfo) getCgroups() []string {
+	if !p.cgroupsLoaded {
+		p.cgroupsLoaded = true
+		data, _ := os.ReadFile(filepath.Join(p.dir, "cgroup"))
+		for _, line := range strings.Split(string(data), "\n") {
+			// Format: hierarchy-ID:controller-list:cgroup-path
+			parts := strings.SplitN(line, ":", 3)
+			if len(parts) == 3 {
+				p.cgroups = append(p.cgroups, parts[2])
+			}
+		}
+	}
+	return p.cgroups
+}
+
+// readProcessStat reads the name, start time and CPU ticks from /proc/<pid>/stat
+func readProcessStat(dir string) (string, uint64, uint64, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	// The name is in parentheses and may itself contain spaces or parentheses
+	line := string(data)
+	nameStart := strings.Index(line, "(")
+	nameEnd := strings.LastIndex(line, ")")
+	if nameStart < 0 || nameEnd < nameStart {
+		return "", 0, 0, fmt.Errorf("invalid process stat format: %s", line)
+	}
+	name := line[nameStart+1 : nameEnd]
+
+	// Fields after the name start at field 3 (state)
+	fields := strings.Fields(line[nameEnd+1:])
+	if len(fields) < 20 {
+		return "", 0, 0, fmt.Errorf("invalid process stat format: %s", line)
+	}
+
+	utime, _ := strconv.ParseUint(fields[11], 10, 64)
+	stime, _ := strconv.ParseUint(fields[12], 10, 64)
+	startTime, _ := strconv.ParseUint(fields[19], 10, 64)
+
+	return name, startTime, utime + stime, nil
+}
+
+// readProcessIO reads the storage bytes from /proc/<pid>/io.
+// The file is only readable for our own processes unless running as root,
+// so errors are reported as no I/O.
+func readProcessIO(dir string) uint64 {
+	file, err := os.Open(filepath.Join(dir, "io"))
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	var total uint64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "read_bytes:", "write_bytes:":
+			value, _ := strconv.ParseUint(fields[1], 10, 64)
+			total += value
+		}
+	}
+
+	return total
+}
@@ -0,0 +1,192 @@
+// +build linux
+
+package resources
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeProcess describes a process in a fake /proc tree
+type fakeProcess struct {
+	pid     int
+	name    string
+	cmdline []string
+	uid     string
+	cgroup  string
+	ticks   uint64
+	ioBytes uint64
+}
+
+// writeFakeProcess writes the files of a process to a fake /proc tree
+func writeFakeProcess(t *testing.T, root string, p fakeProcess) {
+	t.Helper()
+
+	dir := filepath.Join(root, fmt.Sprint(p.pid))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create process dir: %v", err)
+	}
+
+	// Fields 3-22 of /proc/<pid>/stat, utime is field 14 and starttime field 22
+	fields := make([]string, 20)
+	for i := range fields {
+		fields[i] = "0"
+	}
+	fields[0] = "S"
+	fields[11] = fmt.Sprint(p.ticks)
+	fields[19] = "4242"
+	stat := fmt.Sprintf("%d (%s) %s\n", p.pid, p.name, strings.Join(fields, " "))
+
+	files := map[string]string{
+		"stat":    stat,
+		"cmdline": strings.Join(p.cmdline, "\x00") + "\x00",
+		"status":  fmt.Sprintf("Name:\t%s\nUid:\t%s\t%s\t%s\t%s\n", p.name, p.uid, p.uid, p.uid, p.uid),
+		"cgroup":  "0::" + p.cgroup + "\n",
+		"io":      fmt.Sprintf("rchar: 1\nwchar: 1\nread_bytes: %d\nwrite_bytes: 0\n", p.ioBytes),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+}
+
+func TestProcessMonitor_Matching(t *testing.T) {
+	root := t.TempDir()
+	processes := []fakeProcess{
+		{pid: 100, name: "python", cmdline: []string{"python", "train.py", "--epochs=10"}, uid: "1000", cgroup: "/user.slice"},
+		{pid: 200, name: "python", cmdline: []string{"python", "serve.py"}, uid: "1000", cgroup: "/user.slice"},
+		{pid: 300, name: "dockerd", cmdline: []string{"/usr/bin/dockerd"}, uid: "0", cgroup: "/system.slice/docker.service"},
+		{pid: 400, name: "snoozed", cmdline: []string{"/usr/bin/snoozed"}, uid: "0", cgroup: "/system.slice/snoozed.service"},
+		{pid: 500, name: "Web Content (x)", cmdline: []string{"firefox"}, uid: "1001", cgroup: "/user.slice"},
+	}
+	for _, p := range processes {
+		writeFakeProcess(t, root, p)
+	}
+
+	tests := []struct {
+		name   string
+		config ProcessMonitorConfig
+		pids   []int
+	}{
+		{"all", ProcessMonitorConfig{}, []int{100, 200, 300, 400, 500}},
+		{"cmdline", ProcessMonitorConfig{Include: []ProcessMatcher{{Cmdline: `python .*train\.py`}}}, []int{100}},
+		{"user", ProcessMonitorConfig{Include: []ProcessMatcher{{User: "1001"}}}, []int{500}},
+		{"cgroup", ProcessMonitorConfig{Include: []ProcessMatcher{{Cgroup: "/system.slice"}}}, []int{300, 400}},
+		{"exclude", ProcessMonitorConfig{Exclude: []ProcessMatcher{{Name: "dockerd"}, {Name: "snoozed"}}}, []int{100, 200, 500}},
+		{"name with parens", ProcessMonitorConfig{Include: []ProcessMatcher{{Name: "Web Content (x)"}}}, []int{500}},
+		{"all fields", ProcessMonitorConfig{Include: []ProcessMatcher{{Name: "python", User: "1000", Cmdline: "serve"}}}, []int{200}},
+	}
+
+	for _, tt := range tests {
+		tt.config.ProcRoot = root
+		monitor, err := NewProcessMonitor(tt.config)
+		if err != nil {
+			t.Fatalf("%s: failed to create process monitor: %v", tt.name, err)
+		}
+
+		samples, _, err := monitor.scan()
+		if err != nil {
+			t.Fatalf("%s: failed to scan: %v", tt.name, err)
+		}
+		if len(samples) != len(tt.pids) {
+			t.Errorf("%s: expected %d processes, got %d", tt.name, len(tt.pids), len(samples))
+		}
+		for _, pid := range tt.pids {
+			if _, ok := samples[pid]; !ok {
+				t.Errorf("%s: expected process %d to be tracked", tt.name, pid)
+			}
+		}
+	}
+
+	if _, err := NewProcessMonitor(ProcessMonitorConfig{ProcRoot: root, Include: []ProcessMatcher{{Cmdline: "("}}}); err == nil {
+		t.Error("Expected error for invalid cmdline pattern")
+	}
+}
+
+func TestProcessMonitor_GetUsage(t *testing.T) {
+	root := t.TempDir()
+	train := fakeProcess{pid: 100, name: "python", cmdline: []string{"python", "train.py"}, uid: "1000", cgroup: "/"}
+	idle := fakeProcess{pid: 200, name: "python", cmdline: []string{"python", "train.py", "--resume"}, uid: "1000", cgroup: "/"}
+	writeFakeProcess(t, root, train)
+	writeFakeProcess(t, root, idle)
+
+	monitor, err := NewProcessMonitor(ProcessMonitorConfig{
+		ProcRoot:         root,
+		Include:          []ProcessMatcher{{Cmdline: "train.py"}},
+		MaxIOBytesPerSec: 1 << 40,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create process monitor: %v", err)
+	}
+
+	// Pretend the last sample was taken one second ago and the training
+	// process used 50 ticks (half a core) since then
+	monitor.lastMeasureTs = time.Now().Add(-time.Second)
+	train.ticks = 50
+	writeFakeProcess(t, root, train)
+
+	usage, err := monitor.GetUsage()
+	if err != nil {
+		t.Fatalf("Failed to get process usage: %v", err)
+	}
+	if usage < 40 || usage > 55 {
+		t.Errorf("Expected usage of about 50%%, got %f", usage)
+	}
+
+	activity := monitor.Processes()
+	if len(activity) != 2 {
+		t.Fatalf("Expected 2 tracked processes, got %d", len(activity))
+	}
+	for _, a := range activity {
+		if a.PID == 200 && a.CPUPercent != 0 {
+			t.Errorf("Expected idle process to have no CPU usage, got %f", a.CPUPercent)
+		}
+	}
+
+	// Heavy I/O counts as activity too
+	monitor.lastMeasureTs = time.Now().Add(-time.Second)
+	idle.ioBytes = 1 << 40
+	writeFakeProcess(t, root, idle)
+
+	usage, err = monitor.GetUsage()
+	if err != nil {
+		t.Fatalf("Failed to get process usage: %v", err)
+	}
+	if usage < 90 {
+		t.Errorf("Expected I/O to be reported as usage, got %f", usage)
+	}
+
+	// A counter that goes backwards, as when /proc/<pid>/io becomes
+	// unreadable, isn't a huge rate
+	monitor.lastMeasureTs = time.Now().Add(-time.Second)
+	idle.ioBytes = 0
+	writeFakeProcess(t, root, idle)
+
+	usage, err = monitor.GetUsage()
+	if err != nil {
+		t.Fatalf("Failed to get process usage: %v", err)
+	}
+	if usage != 0 {
+		t.Errorf("Expected no usage when the I/O counter goes backwards, got %f", usage)
+	}
+}
+
+func TestReadProcessStat_Self(t *testing.T) {
+	// Skip test if not running on Linux
+	if os.Getenv("SKIP_LINUX_TESTS") != "" {
+		t.Skip("Skipping Linux-specific test")
+	}
+
+	name, startTime, _, err := readProcessStat("/proc/self")
+	if err != nil {
+		t.Fatalf("Failed to read process stat: %v", err)
+	}
+	if name == "" || startTime == 0 {
+		t.Errorf("Unexpected stat values: name=%q startTime=%d", name, startTime)
+	}
+}
@@ -0,0 +1,37 @@
+// +build !linux
+
+package resources
+
+import (
+	"sync"
+)
+
+// ProcessMonitor is a stub implementation for non-Linux platforms
+type ProcessMonitor struct {
+	mutex sync.Mutex
+}
+
+// NewProcessMonitor creates a new process monitor
+// This is a stub implementation for non-Linux platforms
+func NewProcessMonitor(config ProcessMonitorConfig) (*ProcessMonitor, error) {
+	if _, err := compileProcessMatchers(config.Include); err != nil {
+		return nil, err
+	}
+	if _, err := compileProcessMatchers(config.Exclude); err != nil {
+		return nil, err
+	}
+	return &ProcessMonitor{}, nil
+}
+
+// GetUsage returns no process activity
+func (m *ProcessMonitor) GetUsage() (float64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return 0.0, nil
+}
+
+// Processes returns no tracked processes
+func (m *ProcessMonitor) Processes() []ProcessActivity {
+	return nil
+}
@@ -6,7 +6,9 @@ import (
 	"time"
 )
 
-// ResourceMonitor is the common interface for all resource monitors
+// ResourceMonitor is the common interface for all resource monitors.
+// Monitors that hold open files or goroutines also implement io.Closer,
+// and are closed by the MonitorManager.
 type ResourceMonitor interface {
 	// GetUsage returns the current resource usage as a percentage (0-100)
 	GetUsage() (float64, error)
@@ -55,6 +57,8 @@ func NewResourceMonitor(resourceType ResourceType) (ResourceMonitor, error) {
 		return newUserInputMonitor()
 	case GPU:
 		return newGPUMonitor()
+	case UserSessions:
+		return newUserSessionsMonitor()
 	default:
 		return nil, fmt.Errorf("unsupported resource type: %s", resourceType)
 	}
@@ -124,6 +128,17 @@ func newGPUMonitor() (ResourceMonitor, error) {
 	}
 }
 
+// newUserSessionsMonitor creates a new interactive session monitor based on the current platform
+func newUserSessionsMonitor() (ResourceMonitor, error) {
+	// Currently only implemented for Linux
+	switch runtime.GOOS {
+	case "linux":
+		return NewUserSessionsMonitor()
+	default:
+		return &dummyMonitor{resourceType: UserSessions}, nil
+	}
+}
+
 // dummyMonitor is a placeholder monitor that returns fixed values
 type dummyMonitor struct {
 	resourceType ResourceType
@@ -0,0 +1,207 @@
+package resources
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UserInputBackend selects how keyboard and mouse idle time is read
+type UserInputBackend string
+
+const (
+	// UserInputAuto reads input devices directly if any can be opened, and
+	// uses xprintidle otherwise
+	UserInputAuto UserInputBackend = "auto"
+	// UserInputXprintidle asks the X server with xprintidle
+	UserInputXprintidle UserInputBackend = "xprintidle"
+	// UserInputEvdev reads event timestamps from /dev/input/event*, which
+	// works on Wayland and console sessions as well as X
+	UserInputEvdev UserInputBackend = "evdev"
+)
+
+// DefaultUserInputWindow is how recent input must be to count as activity
+const DefaultUserInputWindow = 5 * time.Second
+
+// UserInputConfig configures a user input monitor
+type UserInputConfig struct {
+	// Backend selects how idle time is read, UserInputAuto by default
+	Backend UserInputBackend
+	// Devices are glob patterns of the event devices read by the evdev
+	// backend. Defaults to /dev/input/event*.
+	Devices []string
+	// DeviceNames keeps only devices whose name contains one of these
+	// strings, ignoring case, such as "keyboard" or "mouse". If empty, all
+	// devices are kept.
+	DeviceNames []string
+	// ExcludeDeviceNames drops devices whose name contains one of these
+	// strings, ignoring case, such as "Power Button" or "Lid Switch"
+	ExcludeDeviceNames []string
+	// UseLogind also reads the idle hints of logind sessions, which
+	// desktop environments set after their own idle timeout
+	UseLogind bool
+	// LogindRoot is the logind runtime directory, /run/systemd by default
+	LogindRoot string
+	// SysRoot is the sysfs mount point used to read device names, /sys by default
+	SysRoot string
+	// ActiveWindow is how recent input must be to count as activity.
+	// Defaults to DefaultUserInputWindow.
+	ActiveWindow time.Duration
+}
+
+// withDefaults returns the configuration with defaults applied
+func (c UserInputConfig) withDefaults() UserInputConfig {
+	if c.Backend == "" {
+		c.Backend = UserInputAuto
+	}
+	if len(c.Devices) == 0 {
+		c.Devices = []string{"/dev/input/event*"}
+	}
+	if c.LogindRoot == "" {
+		c.LogindRoot = "/run/systemd"
+	}
+	if c.SysRoot == "" {
+		c.SysRoot = "/sys"
+	}
+	if c.ActiveWindow == 0 {
+		c.ActiveWindow = DefaultUserInputWindow
+	}
+	return c
+}
+
+// keepDevice reports whether an input device passes the name filters
+func (c UserInputConfig) keepDevice(name string) bool {
+	name = strings.ToLower(name)
+	for _, exclude := range c.ExcludeDeviceNames {
+		if strings.Contains(name, strings.ToLower(exclude)) {
+			return false
+		}
+	}
+	if len(c.DeviceNames) == 0 {
+		return true
+	}
+	for _, include := range c.DeviceNames {
+		if strings.Contains(name, strings.ToLower(include)) {
+			return true
+		}
+	}
+	return false
+}
+
+// inputDeviceName returns the name of an event device from sysfs, or an
+// empty string if it is unknown
+func inputDeviceName(sysRoot, devicePath string) string {
+	data, err := os.ReadFile(filepath.Join(sysRoot, "class", "input", filepath.Base(devicePath), "device", "name"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// logindSession is the state of a logind session read from its session file
+type logindSession struct {
+	ID     string
+	Active bool
+	Class  string
+	// HasIdleHint is set if the session file carries an idle hint
+	HasIdleHint bool
+	IdleHint    bool
+	// IdleHintSince is when the idle hint last changed
+	IdleHintSince time.Time
+}
+
+// readLogindSessions reads the session files under the logind runtime directory
+func readLogindSessions(root string) ([]logindSession, error) {
+	paths, err := filepath.Glob(filepath.Join(root, "sessions", "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []logindSession
+	for _, path := range paths {
+		if strings.HasSuffix(path, ".ref") {
+			continue
+		}
+		session, err := readLogindSession(path)
+		if err != nil {
+			// The session ended while reading
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+// readLogindSession parses the KEY=VALUE lines of a logind session file
+func readLogindSession(path string) (logindSession, error) {
+	session := logindSession{ID: filepath.Base(path), Active: true}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return session, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "ACTIVE":
+			session.Active = parseLogindBool(value)
+		case "CLASS":
+			session.Class = value
+		case "IDLE_HINT":
+			session.HasIdleHint = true
+			session.IdleHint = parseLogindBool(value)
+		case "IDLE_HINT_TIMESTAMP":
+			usec, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return session, fmt.Errorf("invalid idle hint timestamp in %s: %w", path, err)
+			}
+			session.IdleHintSince = time.UnixMicro(usec)
+		}
+	}
+
+	return session, scanner.Err()
+}
+
+// parseLogindBool parses a boolean as written in logind session files
+func parseLogindBool(value string) bool {
+	switch strings.ToLower(value) {
+	case "1", "yes", "true", "on":
+		return true
+	}
+	return false
+}
+
+// logindIdleTime returns the shortest idle time of the active user sessions
+// that carry an idle hint. A session without the idle hint is in use.
+func logindIdleTime(sessions []logindSession, now time.Time) (time.Duration, bool) {
+	var idle time.Duration
+	found := false
+
+	for _, session := range sessions {
+		if !session.Active || !session.HasIdleHint || (session.Class != "" && session.Class != "user") {
+			continue
+		}
+
+		sessionIdle := time.Duration(0)
+		if session.IdleHint && !session.IdleHintSince.IsZero() && now.After(session.IdleHintSince) {
+			sessionIdle = now.Sub(session.IdleHintSince)
+		}
+		if !found || sessionIdle < idle {
+			idle = sessionIdle
+			found = true
+		}
+	}
+
+	return idle, found
+}
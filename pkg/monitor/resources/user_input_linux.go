@@ -15,28 +15,57 @@ import (
 
 // UserInputMonitor monitors user input (keyboard and mouse) activity on Linux systems
 type UserInputMonitor struct {
+	config         UserInputConfig
+	evdev          *evdevReader
 	lastIdleTime   time.Duration
 	lastUpdateTime time.Time
 	mutex          sync.Mutex
 }
 
-// NewUserInputMonitor creates a new user input monitor for Linux
+// NewUserInputMonitor creates a new user input monitor for Linux that uses xprintidle
 func NewUserInputMonitor() (*UserInputMonitor, error) {
-	// Check if we have the necessary tools
-	if err := checkXprintidle(); err != nil {
-		return nil, fmt.Errorf("prerequisites not met for user input monitoring: %w", err)
+	return NewUserInputMonitorWithConfig(UserInputConfig{Backend: UserInputXprintidle})
+}
+
+// NewUserInputMonitorWithConfig creates a new user input monitor for Linux
+func NewUserInputMonitorWithConfig(config UserInputConfig) (*UserInputMonitor, error) {
+	config = config.withDefaults()
+	m := &UserInputMonitor{config: config}
+
+	switch config.Backend {
+	case UserInputEvdev:
+		evdev, err := openEvdevDevices(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize user input monitor: %w", err)
+		}
+		m.evdev = evdev
+	case UserInputAuto:
+		// Fall back to xprintidle without access to the input devices
+		if evdev, err := openEvdevDevices(config); err == nil {
+			m.evdev = evdev
+		}
+	case UserInputXprintidle:
+	default:
+		return nil, fmt.Errorf("unknown user input backend %q", config.Backend)
+	}
+
+	if m.evdev == nil {
+		// Check if we have the necessary tools
+		if err := checkXprintidle(); err != nil {
+			return nil, fmt.Errorf("prerequisites not met for user input monitoring: %w", err)
+		}
 	}
 
 	// Initialize with current idle time
-	idleTime, err := getIdleTime()
+	idleTime, err := m.idleTime()
 	if err != nil {
+		m.Close()
 		return nil, fmt.Errorf("failed to initialize user input monitor: %w", err)
 	}
+	m.lastIdleTime = idleTime
+	m.lastUpdateTime = time.Now()
 
-	return &UserInputMonitor{
-		lastIdleTime:   idleTime,
-		lastUpdateTime: time.Now(),
-	}, nil
+	return m, nil
 }
 
 // GetUsage returns the current user input activity as a percentage (0-100)
@@ -48,13 +77,13 @@ func (m *UserInputMonitor) GetUsage() (float64, error) {
 	// On Linux, we'll refresh measurements every second at most
 	now := time.Now()
 	if now.Sub(m.lastUpdateTime) < time.Second {
-		if isRecentlyActive(m.lastIdleTime) {
+		if m.lastIdleTime < m.config.ActiveWindow {
 			return 100.0, nil
 		}
 		return 0.0, nil
 	}
 
-	idleTime, err := getIdleTime()
+	idleTime, err := m.idleTime()
 	if err != nil {
 		return 0.0, err
 	}
@@ -62,12 +91,62 @@ func (m *UserInputMonitor) GetUsage() (float64, error) {
 	m.lastIdleTime = idleTime
 	m.lastUpdateTime = now
 
-	if isRecentlyActive(idleTime) {
+	if idleTime < m.config.ActiveWindow {
 		return 100.0, nil
 	}
 	return 0.0, nil
 }
 
+// IdleTime returns the user idle time read by the last call to GetUsage
+func (m *UserInputMonitor) IdleTime() time.Duration {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.lastIdleTime
+}
+
+// Devices returns the names of the input devices read by the evdev backend
+func (m *UserInputMonitor) Devices() []string {
+	if m.evdev == nil {
+		return nil
+	}
+	return m.evdev.deviceNames()
+}
+
+// Close stops reading the input devices
+func (m *UserInputMonitor) Close() error {
+	if m.evdev == nil {
+		return nil
+	}
+	return m.evdev.close()
+}
+
+// idleTime reads the user idle time from the input devices or xprintidle.
+// A logind session with a more recent idle hint shortens it.
+func (m *UserInputMonitor) idleTime() (time.Duration, error) {
+	var idleTime time.Duration
+	if m.evdev != nil {
+		idleTime = m.evdev.idleTime()
+	} else {
+		var err error
+		if idleTime, err = getIdleTime(); err != nil {
+			return 0, err
+		}
+	}
+
+	if m.config.UseLogind {
+		sessions, err := readLogindSessions(m.config.LogindRoot)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read logind sessions: %w", err)
+		}
+		if hintIdle, ok := logindIdleTime(sessions, time.Now()); ok && hintIdle < idleTime {
+			idleTime = hintIdle
+		}
+	}
+
+	return idleTime, nil
+}
+
 // getIdleTime gets the user idle time using xprintidle
 func getIdleTime() (time.Duration, error) {
 	// xprintidle returns the idle time in milliseconds
@@ -131,9 +210,3 @@ func checkXprintidle() error {
 	}
 	return nil
 }
-
-// isRecentlyActive returns true if the user was active recently
-func isRecentlyActive(idleTime time.Duration) bool {
-	// We consider the user active if they were active in the last 5 seconds
-	return idleTime < 5*time.Second
-}
\ No newline at end of file
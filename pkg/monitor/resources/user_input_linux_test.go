@@ -0,0 +1,208 @@
+// +build linux
+
+package resources
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// fakeInputDevice creates a pipe standing in for an event device, with its
+// name in a fake sysfs tree, and returns the end written by the test
+func fakeInputDevice(t *testing.T, devDir, sysRoot, device, name string) *os.File {
+	t.Helper()
+
+	path := filepath.Join(devDir, device)
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Fatalf("Failed to create fake device: %v", err)
+	}
+	nameDir := filepath.Join(sysRoot, "class", "input", device, "device")
+	if err := os.MkdirAll(nameDir, 0755); err != nil {
+		t.Fatalf("Failed to create fake sysfs: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(nameDir, "name"), []byte(name+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write device name: %v", err)
+	}
+
+	// Opening for reading and writing doesn't wait for the monitor
+	writer, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("Failed to open fake device: %v", err)
+	}
+	t.Cleanup(func() { writer.Close() })
+	return writer
+}
+
+// writeInputEvent writes an input event with the given timestamp to a fake device
+func writeInputEvent(t *testing.T, device *os.File, eventType uint16, timestamp time.Time) {
+	t.Helper()
+
+	event := inputEvent{
+		Time:  syscall.NsecToTimeval(timestamp.UnixNano()),
+		Type:  eventType,
+		Code:  30,
+		Value: 1,
+	}
+
+	// Writing the event in two parts exercises partial reads
+	data := (*[unsafe.Sizeof(inputEvent{})]byte)(unsafe.Pointer(&event))[:]
+	if _, err := device.Write(data[:8]); err != nil {
+		t.Fatalf("Failed to write event: %v", err)
+	}
+	if _, err := device.Write(data[8:]); err != nil {
+		t.Fatalf("Failed to write event: %v", err)
+	}
+}
+
+func TestUserInputMonitor_Evdev(t *testing.T) {
+	devDir := t.TempDir()
+	sysRoot := t.TempDir()
+	keyboard := fakeInputDevice(t, devDir, sysRoot, "event0", "AT Translated Set 2 keyboard")
+	power := fakeInputDevice(t, devDir, sysRoot, "event1", "Power Button")
+
+	monitor, err := NewUserInputMonitorWithConfig(UserInputConfig{
+		Backend:            UserInputEvdev,
+		Devices:            []string{filepath.Join(devDir, "event*")},
+		ExcludeDeviceNames: []string{"power button"},
+		SysRoot:            sysRoot,
+		ActiveWindow:       time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create user input monitor: %v", err)
+	}
+	defer monitor.Close()
+
+	devices := monitor.Devices()
+	if len(devices) != 1 || devices[0] != "AT Translated Set 2 keyboard" {
+		t.Errorf("Expected only the keyboard, got %v", devices)
+	}
+
+	// Pretend the devices were opened an hour ago. Event timestamps have
+	// microsecond precision.
+	now := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+	monitor.evdev.mutex.Lock()
+	monitor.evdev.now = func() time.Time { return now }
+	monitor.evdev.mutex.Unlock()
+
+	waitForIdleTime := func(check func(time.Duration) bool) time.Duration {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			idleTime := monitor.evdev.idleTime()
+			if check(idleTime) || time.Now().After(deadline) {
+				return idleTime
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if idleTime := monitor.evdev.idleTime(); idleTime < 59*time.Minute {
+		t.Errorf("Expected an hour without input, got %s", idleTime)
+	}
+
+	// Excluded devices and synchronization events are ignored
+	writeInputEvent(t, power, evKey, now.Add(-time.Second))
+	writeInputEvent(t, keyboard, 0, now.Add(-time.Second))
+	time.Sleep(50 * time.Millisecond)
+	if idleTime := monitor.evdev.idleTime(); idleTime < 59*time.Minute {
+		t.Errorf("Expected ignored events not to count, got %s", idleTime)
+	}
+
+	// The event timestamp is the time of the input
+	writeInputEvent(t, keyboard, evKey, now.Add(-10*time.Second))
+	idleTime := waitForIdleTime(func(d time.Duration) bool { return d < time.Minute })
+	if idleTime != 10*time.Second {
+		t.Errorf("Expected 10s since the key press, got %s", idleTime)
+	}
+
+	if _, err := NewUserInputMonitorWithConfig(UserInputConfig{
+		Backend: UserInputEvdev,
+		Devices: []string{filepath.Join(devDir, "missing*")},
+	}); err == nil {
+		t.Errorf("Expected an error without input devices")
+	}
+}
+
+func TestLogindIdleTime(t *testing.T) {
+	root := t.TempDir()
+	sessionsDir := filepath.Join(root, "sessions")
+	if err := os.MkdirAll(sessionsDir, 0755); err != nil {
+		t.Fatalf("Failed to create sessions directory: %v", err)
+	}
+
+	now := time.Date(2025, 7, 2, 12, 0, 0, 0, time.UTC)
+	idleSince := strconv.FormatInt(now.Add(-20*time.Minute).UnixMicro(), 10)
+	sessions := map[string]string{
+		"2":     "UID=1000\nUSER=alice\nACTIVE=1\nTYPE=wayland\nCLASS=user\nIDLE_HINT=1\nIDLE_HINT_TIMESTAMP=" + idleSince + "\n",
+		"3":     "UID=1000\nUSER=alice\nACTIVE=0\nTYPE=tty\nCLASS=user\nIDLE_HINT=0\n",
+		"c1":    "UID=120\nUSER=gdm\nACTIVE=1\nCLASS=greeter\nIDLE_HINT=0\n",
+		"2.ref": "",
+	}
+	for name, content := range sessions {
+		if err := os.WriteFile(filepath.Join(sessionsDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write session: %v", err)
+		}
+	}
+
+	parsed, err := readLogindSessions(root)
+	if err != nil {
+		t.Fatalf("Failed to read sessions: %v", err)
+	}
+	if len(parsed) != 3 {
+		t.Fatalf("Expected 3 sessions, got %d", len(parsed))
+	}
+
+	// Only the active user session counts
+	idleTime, ok := logindIdleTime(parsed, now)
+	if !ok || idleTime != 20*time.Minute {
+		t.Errorf("Expected 20m idle, got %s, %v", idleTime, ok)
+	}
+
+	// A session in use isn't idle
+	parsed = append(parsed, logindSession{ID: "4", Active: true, Class: "user", HasIdleHint: true})
+	if idleTime, _ := logindIdleTime(parsed, now); idleTime != 0 {
+		t.Errorf("Expected a session in use, got %s", idleTime)
+	}
+
+	if _, ok := logindIdleTime(nil, now); ok {
+		t.Errorf("Expected no idle time without sessions")
+	}
+}
+
+func TestUserInputMonitor_Close(t *testing.T) {
+	devDir := t.TempDir()
+	sysRoot := t.TempDir()
+	fakeInputDevice(t, devDir, sysRoot, "event0", "AT Translated Set 2 keyboard")
+
+	monitor, err := NewUserInputMonitorWithConfig(UserInputConfig{
+		Backend: UserInputEvdev,
+		Devices: []string{filepath.Join(devDir, "event*")},
+		SysRoot: sysRoot,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create user input monitor: %v", err)
+	}
+
+	// The manager closes the monitor, which waits for its readers to exit
+	manager, err := NewMonitorManager()
+	if err != nil {
+		t.Fatalf("Failed to create monitor manager: %v", err)
+	}
+	manager.SetMonitor(UserInput, monitor)
+	if err := manager.Close(); err != nil {
+		t.Fatalf("Failed to close monitor manager: %v", err)
+	}
+	if err := monitor.evdev.devices[0].file.Close(); err == nil {
+		t.Errorf("Expected the device to be closed")
+	}
+
+	// Closing again does nothing
+	if err := monitor.Close(); err != nil {
+		t.Errorf("Expected closing twice to succeed, got %v", err)
+	}
+}
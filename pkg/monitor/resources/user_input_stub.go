@@ -4,6 +4,7 @@ package resources
 
 import (
 	"sync"
+	"time"
 )
 
 // UserInputMonitor is a stub implementation for non-Linux platforms
@@ -17,6 +18,12 @@ func NewUserInputMonitor() (*UserInputMonitor, error) {
 	return &UserInputMonitor{}, nil
 }
 
+// NewUserInputMonitorWithConfig creates a new user input monitor
+// This is a stub implementation for non-Linux platforms
+func NewUserInputMonitorWithConfig(config UserInputConfig) (*UserInputMonitor, error) {
+	return &UserInputMonitor{}, nil
+}
+
 // GetUsage returns a dummy user input value
 func (m *UserInputMonitor) GetUsage() (float64, error) {
 	m.mutex.Lock()
@@ -24,4 +31,19 @@ func (m *UserInputMonitor) GetUsage() (float64, error) {
 	
 	// Return a dummy value (0 means no user activity)
 	return 0.0, nil
+}
+
+// IdleTime returns no idle time
+func (m *UserInputMonitor) IdleTime() time.Duration {
+	return 0
+}
+
+// Devices returns no devices
+func (m *UserInputMonitor) Devices() []string {
+	return nil
+}
+
+// Close does nothing
+func (m *UserInputMonitor) Close() error {
+	return nil
 }
\ No newline at end of file
@@ -0,0 +1,65 @@
+package resources
+
+import (
+	"time"
+)
+
+// UserSessions represents interactive login sessions, such as SSH and tmux terminals
+const UserSessions ResourceType = "user_sessions"
+
+// DefaultSessionIdleTimeout is how long a terminal can go untouched before
+// its session is considered abandoned
+const DefaultSessionIdleTimeout = 15 * time.Minute
+
+// UserSessionsConfig configures an interactive session monitor
+type UserSessionsConfig struct {
+	// IdleTimeout is how long a terminal can go without input before its
+	// session no longer counts as active
+	IdleTimeout time.Duration
+	// UtmpOnly ignores pseudo-terminals that have no utmp entry.
+	// By default all of /dev/pts is scanned, which also covers tmux and
+	// screen windows that do not register themselves in utmp.
+	UtmpOnly bool
+	// UtmpPath is the utmp file, /var/run/utmp by default
+	UtmpPath string
+	// DevRoot is the device directory, /dev by default
+	DevRoot string
+	// ProcRoot is the proc filesystem mount point, /proc by default.
+	// It is used to drop utmp entries left behind by processes that died.
+	ProcRoot string
+}
+
+// UserSession is a logged-in terminal session
+type UserSession struct {
+	// User is the login name, empty for terminals without a utmp entry
+	User string
+	// Line is the terminal device relative to the device directory, such as pts/0
+	Line string
+	// Host is the remote host for SSH sessions
+	Host string
+	// PID is the session leader process ID
+	PID int
+	// LoginTime is when the session started
+	LoginTime time.Time
+	// LastActivity is the last time there was input on the terminal
+	LastActivity time.Time
+	// Active reports whether the terminal was used within the idle timeout
+	Active bool
+}
+
+// withDefaults fills in the unset fields of the configuration
+func (c UserSessionsConfig) withDefaults() UserSessionsConfig {
+	if c.IdleTimeout <= 0 {
+		c.IdleTimeout = DefaultSessionIdleTimeout
+	}
+	if c.UtmpPath == "" {
+		c.UtmpPath = "/var/run/utmp"
+	}
+	if c.DevRoot == "" {
+		c.DevRoot = "/dev"
+	}
+	if c.ProcRoot == "" {
+		c.ProcRoot = "/proc"
+	}
+	return c
+}
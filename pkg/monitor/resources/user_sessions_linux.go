@@ -0,0 +1,190 @@
+// +build linux
+
+package resources
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// utmp record layout on Linux (struct utmp from <utmp.h>)
+const (
+	utmpRecordSize  = 384
+	utmpUserProcess = 7
+
+	utmpTypeOffset = 0
+	utmpPIDOffset  = 4
+	utmpLineOffset = 8
+	utmpLineSize   = 32
+	utmpUserOffset = 44
+	utmpUserSize   = 32
+	utmpHostOffset = 76
+	utmpHostSize   = 256
+	utmpTimeOffset = 340
+)
+
+// UserSessionsMonitor monitors interactive terminal sessions on Linux systems.
+// Unlike UserInputMonitor it does not need X11, so it works on headless servers.
+type UserSessionsMonitor struct {
+	config   UserSessionsConfig
+	sessions []UserSession
+	mutex    sync.Mutex
+}
+
+// NewUserSessionsMonitor creates a new session monitor for Linux with the default configuration
+func NewUserSessionsMonitor() (*UserSessionsMonitor, error) {
+	return NewUserSessionsMonitorWithConfig(UserSessionsConfig{})
+}
+
+// NewUserSessionsMonitorWithConfig creates a new session monitor for Linux
+func NewUserSessionsMonitorWithConfig(config UserSessionsConfig) (*UserSessionsMonitor, error) {
+	return &UserSessionsMonitor{
+		config: config.withDefaults(),
+	}, nil
+}
+
+// GetUsage returns whether any session is active as a percentage (0-100)
+// Note: For sessions, we return either 0% (no active session) or 100% (session active)
+func (m *UserSessionsMonitor) GetUsage() (float64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	sessions, err := m.readSessions(time.Now())
+	if err != nil {
+		return 0.0, fmt.Errorf("failed to read user sessions: %w", err)
+	}
+	m.sessions = sessions
+
+	for _, session := range sessions {
+		if session.Active {
+			return 100.0, nil
+		}
+	}
+	return 0.0, nil
+}
+
+// Sessions returns the sessions found by the last reading
+func (m *UserSessionsMonitor) Sessions() []UserSession {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	sessions := make([]UserSession, len(m.sessions))
+	copy(sessions, m.sessions)
+	return sessions
+}
+
+// readSessions combines the utmp entries with the pseudo-terminals in use
+func (m *UserSessionsMonitor) readSessions(now time.Time) ([]UserSession, error) {
+	sessions, err := m.readUtmp()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for _, session := range sessions {
+		seen[session.Line] = true
+	}
+
+	if !m.config.UtmpOnly {
+		// Terminals without a utmp entry, such as tmux windows
+		ptys, _ := filepath.Glob(filepath.Join(m.config.DevRoot, "pts", "[0-9]*"))
+		for _, pty := range ptys {
+			line := "pts/" + filepath.Base(pty)
+			if !seen[line] {
+				sessions = append(sessions, UserSession{Line: line})
+			}
+		}
+	}
+
+	active := sessions[:0]
+	for _, session := range sessions {
+		lastActivity, err := terminalActivity(filepath.Join(m.config.DevRoot, session.Line))
+		if err != nil {
+			// The terminal is gone, so the session is stale
+			continue
+		}
+		session.LastActivity = lastActivity
+		session.Active = now.Sub(lastActivity) < m.config.IdleTimeout
+		active = append(active, session)
+	}
+
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].Line < active[j].Line
+	})
+
+	return active, nil
+}
+
+// readUtmp reads the user process entries from the utmp file
+func (m *UserSessionsMonitor) readUtmp() ([]UserSession, error) {
+	data, err := os.ReadFile(m.config.UtmpPath)
+	if os.IsNotExist(err) {
+		// Minimal images often have no utmp at all
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []UserSession
+	for offset := 0; offset+utmpRecordSize <= len(data); offset += utmpRecordSize {
+		record := data[offset : offset+utmpRecordSize]
+		if binary.LittleEndian.Uint16(record[utmpTypeOffset:]) != utmpUserProcess {
+			continue
+		}
+
+		pid := int(int32(binary.LittleEndian.Uint32(record[utmpPIDOffset:])))
+		if _, err := os.Stat(filepath.Join(m.config.ProcRoot, strconv.Itoa(pid))); err != nil {
+			// Entry left behind by a process that died without cleaning up
+			continue
+		}
+
+		line := cString(record[utmpLineOffset : utmpLineOffset+utmpLineSize])
+		if line == "" || strings.Contains(line, "..") {
+			continue
+		}
+
+		sessions = append(sessions, UserSession{
+			User:      cString(record[utmpUserOffset : utmpUserOffset+utmpUserSize]),
+			Line:      line,
+			Host:      cString(record[utmpHostOffset : utmpHostOffset+utmpHostSize]),
+			PID:       pid,
+			LoginTime: time.Unix(int64(int32(binary.LittleEndian.Uint32(record[utmpTimeOffset:]))), 0),
+		})
+	}
+
+	return sessions, nil
+}
+
+// terminalActivity returns the last access time of a terminal, which input
+// updates. Output updates the modification time, which is ignored so that an
+// abandoned pane running watch or tail -f doesn't count as a person.
+func terminalActivity(path string) (time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.ModTime(), nil
+	}
+	return time.Unix(int64(stat.Atim.Sec), int64(stat.Atim.Nsec)), nil
+}
+
+// cString converts a NUL-padded byte array to a string
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
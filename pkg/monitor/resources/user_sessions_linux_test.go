@@ -0,0 +1,155 @@
+// +build linux
+
+package resources
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// utmpRecord builds a single binary utmp record
+func utmpRecord(recordType uint16, pid int, line, user, host string, login time.Time) []byte {
+	record := make([]byte, utmpRecordSize)
+	binary.LittleEndian.PutUint16(record[utmpTypeOffset:], recordType)
+	binary.LittleEndian.PutUint32(record[utmpPIDOffset:], uint32(pid))
+	copy(record[utmpLineOffset:utmpLineOffset+utmpLineSize], line)
+	copy(record[utmpUserOffset:utmpUserOffset+utmpUserSize], user)
+	copy(record[utmpHostOffset:utmpHostOffset+utmpHostSize], host)
+	binary.LittleEndian.PutUint32(record[utmpTimeOffset:], uint32(login.Unix()))
+	return record
+}
+
+// setupSessionTree creates a fake utmp file, /dev and /proc
+func setupSessionTree(t *testing.T, now time.Time) UserSessionsConfig {
+	t.Helper()
+
+	root := t.TempDir()
+	config := UserSessionsConfig{
+		IdleTimeout: 10 * time.Minute,
+		UtmpPath:    filepath.Join(root, "utmp"),
+		DevRoot:     filepath.Join(root, "dev"),
+		ProcRoot:    filepath.Join(root, "proc"),
+	}
+
+	login := now.Add(-time.Hour)
+	var utmp []byte
+	utmp = append(utmp, utmpRecord(2, 0, "~", "reboot", "", login)...)
+	utmp = append(utmp, utmpRecord(utmpUserProcess, 100, "pts/0", "alice", "10.0.0.1", login)...)
+	utmp = append(utmp, utmpRecord(utmpUserProcess, 200, "pts/1", "bob", "10.0.0.2", login)...)
+	utmp = append(utmp, utmpRecord(utmpUserProcess, 300, "pts/7", "carol", "10.0.0.3", login)...)
+	if err := os.WriteFile(config.UtmpPath, utmp, 0644); err != nil {
+		t.Fatalf("Failed to write utmp: %v", err)
+	}
+
+	// PID 300 has exited without removing its utmp entry
+	for _, pid := range []int{100, 200} {
+		if err := os.MkdirAll(filepath.Join(config.ProcRoot, strconv.Itoa(pid)), 0755); err != nil {
+			t.Fatalf("Failed to create proc dir: %v", err)
+		}
+	}
+
+	// pts/0 was typed in recently, pts/1 is abandoned and pts/2 is a tmux window typed in recently
+	ptys := map[string]time.Time{
+		"0": now.Add(-time.Minute),
+		"1": now.Add(-2 * time.Hour),
+		"2": now.Add(-30 * time.Second),
+	}
+	if err := os.MkdirAll(filepath.Join(config.DevRoot, "pts"), 0755); err != nil {
+		t.Fatalf("Failed to create pts dir: %v", err)
+	}
+	for name, lastActivity := range ptys {
+		path := filepath.Join(config.DevRoot, "pts", name)
+		if err := os.WriteFile(path, nil, 0620); err != nil {
+			t.Fatalf("Failed to create pty: %v", err)
+		}
+		if err := os.Chtimes(path, lastActivity, lastActivity); err != nil {
+			t.Fatalf("Failed to set pty times: %v", err)
+		}
+	}
+
+	return config
+}
+
+func TestUserSessionsMonitor_Sessions(t *testing.T) {
+	now := time.Now()
+	config := setupSessionTree(t, now)
+
+	monitor, err := NewUserSessionsMonitorWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create session monitor: %v", err)
+	}
+
+	usage, err := monitor.GetUsage()
+	if err != nil {
+		t.Fatalf("Failed to get session usage: %v", err)
+	}
+	if usage != 100.0 {
+		t.Errorf("Expected usage 100, got %f", usage)
+	}
+
+	sessions := monitor.Sessions()
+	if len(sessions) != 3 {
+		t.Fatalf("Expected 3 sessions, got %d: %+v", len(sessions), sessions)
+	}
+
+	expected := []struct {
+		line   string
+		user   string
+		active bool
+	}{
+		{"pts/0", "alice", true},
+		{"pts/1", "bob", false},
+		{"pts/2", "", true},
+	}
+	for i, e := range expected {
+		if sessions[i].Line != e.line || sessions[i].User != e.user || sessions[i].Active != e.active {
+			t.Errorf("Expected session %s/%s active=%v, got %+v", e.line, e.user, e.active, sessions[i])
+		}
+	}
+	if sessions[0].Host != "10.0.0.1" || sessions[0].PID != 100 {
+		t.Errorf("Unexpected utmp fields: %+v", sessions[0])
+	}
+	if sessions[0].LoginTime.Unix() != now.Add(-time.Hour).Unix() {
+		t.Errorf("Expected login time %v, got %v", now.Add(-time.Hour), sessions[0].LoginTime)
+	}
+}
+
+func TestUserSessionsMonitor_Idle(t *testing.T) {
+	now := time.Now()
+	config := setupSessionTree(t, now)
+	config.UtmpOnly = true
+
+	// Abandon the only active utmp session, leaving something such as
+	// watch writing to it: output alone isn't activity
+	old := now.Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(config.DevRoot, "pts", "0"), old, now); err != nil {
+		t.Fatalf("Failed to set pty times: %v", err)
+	}
+
+	monitor, err := NewUserSessionsMonitorWithConfig(config)
+	if err != nil {
+		t.Fatalf("Failed to create session monitor: %v", err)
+	}
+
+	usage, err := monitor.GetUsage()
+	if err != nil {
+		t.Fatalf("Failed to get session usage: %v", err)
+	}
+	if usage != 0.0 {
+		t.Errorf("Expected usage 0, got %f", usage)
+	}
+	if len(monitor.Sessions()) != 2 {
+		t.Errorf("Expected 2 sessions, got %d", len(monitor.Sessions()))
+	}
+
+	// A missing utmp file means no sessions
+	config.UtmpPath = filepath.Join(t.TempDir(), "missing")
+	monitor, _ = NewUserSessionsMonitorWithConfig(config)
+	if usage, err := monitor.GetUsage(); err != nil || usage != 0.0 {
+		t.Errorf("Expected usage 0 without utmp, got %f (%v)", usage, err)
+	}
+}
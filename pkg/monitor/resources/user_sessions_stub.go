@@ -0,0 +1,38 @@
+// +build !linux
+
+package resources
+
+import (
+	"sync"
+)
+
+// UserSessionsMonitor is a stub implementation for non-Linux platforms
+type UserSessionsMonitor struct {
+	mutex sync.Mutex
+}
+
+// NewUserSessionsMonitor creates a new session monitor
+// This is a stub implementation for non-Linux platforms
+func NewUserSessionsMonitor() (*UserSessionsMonitor, error) {
+	return &UserSessionsMonitor{}, nil
+}
+
+// NewUserSessionsMonitorWithConfig creates a new session monitor
+// This is a stub implementation for non-Linux platforms
+func NewUserSessionsMonitorWithConfig(config UserSessionsConfig) (*UserSessionsMonitor, error) {
+	return &UserSessionsMonitor{}, nil
+}
+
+// GetUsage returns a dummy session value
+func (m *UserSessionsMonitor) GetUsage() (float64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Return a dummy value (0 means no active session)
+	return 0.0, nil
+}
+
+// Sessions returns no sessions
+func (m *UserSessionsMonitor) Sessions() []UserSession {
+	return nil
+}
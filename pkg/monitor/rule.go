@@ -0,0 +1,433 @@
+package monitor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Rule is a compiled idle rule expression such as
+//
+//	cpu < 5 && (gpu < 2 || user_input == 0) && ssh_sessions == 0
+//
+// Identifiers refer to resource types, including custom monitors, and are
+// resolved against the current resource usage when the rule is evaluated.
+// Comparisons (<, <=, >, >=, ==, !=) can be combined with &&, || and ! and
+// grouped with parentheses.
+type Rule struct {
+	expression string
+	root       ruleNode
+}
+
+// CompileRule parses an idle rule expression
+func CompileRule(expression string) (*Rule, error) {
+	tokens, err := tokenizeRule(expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid idle rule %q: %w", expression, err)
+	}
+
+	p := &ruleParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid idle rule %q: %w", expression, err)
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("invalid idle rule %q: unexpected %q at position %d",
+			expression, p.peek().text, p.peek().pos)
+	}
+
+	return &Rule{expression: expression, root: root}, nil
+}
+
+// String returns the source expression of the rule
+func (r *Rule) String() string {
+	return r.expression
+}
+
+// Resources returns the resource types referenced by the rule
+func (r *Rule) Resources() []ResourceType {
+	seen := make(map[ResourceType]bool)
+	var resourceTypes []ResourceType
+	r.root.visit(func(n ruleNode) {
+		if ref, ok := n.(*ruleRef); ok && !seen[ref.resourceType] {
+			seen[ref.resourceType] = true
+			resourceTypes = append(resourceTypes, ref.resourceType)
+		}
+	})
+	return resourceTypes
+}
+
+// Evaluate evaluates the rule against the given resource usage
+func (r *Rule) Evaluate(usage map[ResourceType]*ResourceUsage) (bool, error) {
+	return r.root.eval(usage)
+}
+
+// RuleDetector is an IdleDetector that evaluates an idle rule expression
+type RuleDetector struct {
+	rule *Rule
+}
+
+// NewRuleDetector compiles an idle rule expression into a detector
+func NewRuleDetector(expression string) (*RuleDetector, error) {
+	rule, err := CompileRule(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RuleDetector{rule: rule}, nil
+}
+
+// IsIdle implements IdleDetector. Thresholds are not used, the rule
+// carries its own per-resource limits.
+func (d *RuleDetector) IsIdle(usage map[ResourceType]*ResourceUsage, thresholds map[ResourceType]float64) (bool, error) {
+	idle, err := d.rule.Evaluate(usage)
+	if err != nil {
+		return false, fmt.Errorf("failed to evaluate idle rule %q: %w", d.rule, err)
+	}
+
+	return idle, nil
+}
+
+// Rule AST
+
+// ruleNode is a node in a compiled rule
+type ruleNode interface {
+	eval(usage map[ResourceType]*ResourceUsage) (bool, error)
+	visit(fn func(ruleNode))
+}
+
+// ruleOperand is a value in a comparison
+type ruleOperand interface {
+	ruleNode
+	value(usage map[ResourceType]*ResourceUsage) (float64, error)
+}
+
+// ruleRef references the usage of a resource
+type ruleRef struct {
+	resourceType ResourceType
+}
+
+func (n *ruleRef) value(usage map[ResourceType]*ResourceUsage) (float64, error) {
+	u, ok := usage[n.resourceType]
+	if !ok {
+		return 0, fmt.Errorf("no usage for resource %q", n.resourceType)
+	}
+	return u.Value, nil
+}
+
+func (n *ruleRef) eval(usage map[ResourceType]*ResourceUsage) (bool, error) {
+	return false, fmt.Errorf("resource %q used as a condition", n.resourceType)
+}
+
+func (n *ruleRef) visit(fn func(ruleNode)) { fn(n) }
+
+// ruleNumber is a numeric literal
+type ruleNumber struct {
+	number float64
+}
+
+func (n *ruleNumber) value(usage map[ResourceType]*ResourceUsage) (float64, error) {
+	return n.number, nil
+}
+
+func (n *ruleNumber) eval(usage map[ResourceType]*ResourceUsage) (bool, error) {
+	return false, fmt.Errorf("number %v used as a condition", n.number)
+}
+
+func (n *ruleNumber) visit(fn func(ruleNode)) { fn(n) }
+
+// ruleCompare compares two operands
+type ruleCompare struct {
+	op          string
+	left, right ruleOperand
+}
+
+func (n *ruleCompare) eval(usage map[ResourceType]*ResourceUsage) (bool, error) {
+	left, err := n.left.value(usage)
+	if err != nil {
+		return false, err
+	}
+	right, err := n.right.value(usage)
+	if err != nil {
+		return false, err
+	}
+
+	switch n.op {
+	case "<":
+		return left < right, nil
+	case "<=":
+		return left <= right, nil
+	case ">":
+		return left > right, nil
+	case ">=":
+		return left >= right, nil
+	case "==":
+		return left == right, nil
+	case "!=":
+		return left != right, nil
+	default:
+		return false, fmt.Errorf("unknown operator %q", n.op)
+	}
+}
+
+func (n *ruleCompare) visit(fn func(ruleNode)) {
+	fn(n)
+	n.left.visit(fn)
+	n.right.visit(fn)
+}
+
+// ruleLogical combines two conditions with && or ||
+type ruleLogical struct {
+	op          string
+	left, right ruleNode
+}
+
+func (n *ruleLogical) eval(usage map[ResourceType]*ResourceUsage) (bool, error) {
+	left, err := n.left.eval(usage)
+	if err != nil {
+		return false, err
+	}
+
+	// Short-circuit like Go does
+	if n.op == "&&" && !left {
+		return false, nil
+	}
+	if n.op == "||" && left {
+		return true, nil
+	}
+
+	return n.right.eval(usage)
+}
+
+func (n *ruleLogical) visit(fn func(ruleNode)) {
+	fn(n)
+	n.left.visit(fn)
+	n.right.visit(fn)
+}
+
+// ruleNot negates a condition
+type ruleNot struct {
+	operand ruleNode
+}
+
+func (n *ruleNot) eval(usage map[ResourceType]*ResourceUsage) (bool, error) {
+	result, err := n.operand.eval(usage)
+	return !result, err
+}
+
+func (n *ruleNot) visit(fn func(ruleNode)) {
+	fn(n)
+	n.operand.visit(fn)
+}
+
+// Tokenizer
+
+// ruleTokenKind is the kind of a rule token
+type ruleTokenKind int
+
+const (
+	ruleTokenIdent ruleTokenKind = iota
+	ruleTokenNumber
+	ruleTokenOperator
+	ruleTokenLParen
+	ruleTokenRParen
+	ruleTokenEOF
+)
+
+// ruleToken is a lexical token in a rule expression
+type ruleToken struct {
+	kind ruleTokenKind
+	text string
+	pos  int
+}
+
+// tokenizeRule splits a rule expression into tokens
+func tokenizeRule(expression string) ([]ruleToken, error) {
+	var tokens []ruleToken
+	runes := []rune(expression)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '(':
+			tokens = append(tokens, ruleToken{kind: ruleTokenLParen, text: "(", pos: i})
+			i++
+
+		case r == ')':
+			tokens = append(tokens, ruleToken{kind: ruleTokenRParen, text: ")", pos: i})
+			i++
+
+		case unicode.IsDigit(r) || r == '.':
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, ruleToken{kind: ruleTokenNumber, text: string(runes[start:i]), pos: start})
+
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) ||
+				runes[i] == '_' || runes[i] == '.' || runes[i] == '-') {
+				i++
+			}
+			tokens = append(tokens, ruleToken{kind: ruleTokenIdent, text: string(runes[start:i]), pos: start})
+
+		default:
+			op := ""
+			rest := string(runes[i:])
+			for _, candidate := range []string{"&&", "||", "<=", ">=", "==", "!=", "<", ">", "!"} {
+				if strings.HasPrefix(rest, candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at position %d", r, i)
+			}
+			tokens = append(tokens, ruleToken{kind: ruleTokenOperator, text: op, pos: i})
+			i += len(op)
+		}
+	}
+
+	tokens = append(tokens, ruleToken{kind: ruleTokenEOF, pos: len(runes)})
+	return tokens, nil
+}
+
+// Parser
+
+// ruleParser is a recursive descent parser for rule expressions
+type ruleParser struct {
+	tokens []ruleToken
+	pos    int
+}
+
+func (p *ruleParser) peek() ruleToken {
+	return p.tokens[p.pos]
+}
+
+func (p *ruleParser) next() ruleToken {
+	token := p.tokens[p.pos]
+	if token.kind != ruleTokenEOF {
+		p.pos++
+	}
+	return token
+}
+
+func (p *ruleParser) done() bool {
+	return p.peek().kind == ruleTokenEOF
+}
+
+func (p *ruleParser) acceptOperator(op string) bool {
+	if token := p.peek(); token.kind == ruleTokenOperator && token.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// parseOr parses: and ( "||" and )*
+func (p *ruleParser) parseOr() (ruleNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptOperator("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &ruleLogical{op: "||", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+// parseAnd parses: unary ( "&&" unary )*
+func (p *ruleParser) parseAnd() (ruleNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptOperator("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &ruleLogical{op: "&&", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+// parseUnary parses: "!" unary | "(" or ")" | comparison
+func (p *ruleParser) parseUnary() (ruleNode, error) {
+	if p.acceptOperator("!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &ruleNot{operand: operand}, nil
+	}
+
+	if p.peek().kind == ruleTokenLParen {
+		p.next()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if token := p.next(); token.kind != ruleTokenRParen {
+			return nil, fmt.Errorf("expected ')' at position %d", token.pos)
+		}
+		return node, nil
+	}
+
+	return p.parseComparison()
+}
+
+// parseComparison parses: operand op operand
+func (p *ruleParser) parseComparison() (ruleNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	token := p.next()
+	switch token.text {
+	case "<", "<=", ">", ">=", "==", "!=":
+	default:
+		return nil, fmt.Errorf("expected comparison operator at position %d", token.pos)
+	}
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ruleCompare{op: token.text, left: left, right: right}, nil
+}
+
+// parseOperand parses a resource reference or a number
+func (p *ruleParser) parseOperand() (ruleOperand, error) {
+	token := p.next()
+	switch token.kind {
+	case ruleTokenIdent:
+		return &ruleRef{resourceType: ResourceType(token.text)}, nil
+	case ruleTokenNumber:
+		number, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", token.text, token.pos)
+		}
+		return &ruleNumber{number: number}, nil
+	case ruleTokenEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	default:
+		return nil, fmt.Errorf("expected resource or number at position %d, got %q", token.pos, token.text)
+	}
+}
@@ -0,0 +1,137 @@
+package monitor
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompileRule_Evaluate(t *testing.T) {
+	usage := usageOf(map[ResourceType]float64{
+		CPU:            3,
+		GPU:            10,
+		UserInput:      0,
+		"ssh_sessions": 0,
+		"jupyter.busy": 1,
+	}, time.Now())
+
+	tests := []struct {
+		expression string
+		idle       bool
+	}{
+		{"cpu < 5", true},
+		{"cpu >= 5", false},
+		{"cpu < 5 && (gpu < 2 || user_input == 0) && ssh_sessions == 0", true},
+		{"cpu < 5 && gpu < 2", false},
+		{"cpu < 5 && !(gpu < 2)", true},
+		{"gpu <= 10 || undefined_metric == 0", true},
+		{"jupyter.busy != 1 || cpu < 1", false},
+		{"2.5 < cpu", true},
+	}
+
+	for _, tt := range tests {
+		rule, err := CompileRule(tt.expression)
+		if err != nil {
+			t.Fatalf("Failed to compile %q: %v", tt.expression, err)
+		}
+
+		idle, err := rule.Evaluate(usage)
+		if err != nil {
+			t.Fatalf("Failed to evaluate %q: %v", tt.expression, err)
+		}
+		if idle != tt.idle {
+			t.Errorf("%q: expected %v, got %v", tt.expression, tt.idle, idle)
+		}
+	}
+}
+
+func TestCompileRule_Errors(t *testing.T) {
+	invalid := []string{
+		"",
+		"cpu",
+		"cpu < ",
+		"cpu < 5 &&",
+		"(cpu < 5",
+		"cpu < 5)",
+		"cpu = 5",
+		"cpu < 5 # comment",
+	}
+
+	for _, expression := range invalid {
+		if _, err := CompileRule(expression); err == nil {
+			t.Errorf("Expected compile error for %q", expression)
+		}
+	}
+}
+
+func TestRule_Resources(t *testing.T) {
+	rule, err := CompileRule("cpu < 5 && (gpu < 2 || cpu == 0) && custom_metric < 10")
+	if err != nil {
+		t.Fatalf("Failed to compile rule: %v", err)
+	}
+
+	resources := rule.Resources()
+	expected := []ResourceType{CPU, GPU, "custom_metric"}
+	if len(resources) != len(expected) {
+		t.Fatalf("Expected resources %v, got %v", expected, resources)
+	}
+	for i := range expected {
+		if resources[i] != expected[i] {
+			t.Errorf("Expected resources %v, got %v", expected, resources)
+		}
+	}
+}
+
+func TestRuleDetector_UnknownResource(t *testing.T) {
+	detector, err := NewRuleDetector("cpu < 5 && missing < 1")
+	if err != nil {
+		t.Fatalf("Failed to create rule detector: %v", err)
+	}
+
+	_, err = detector.IsIdle(usageOf(map[ResourceType]float64{CPU: 1}, time.Now()), nil)
+	if err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("Expected error naming the missing resource, got %v", err)
+	}
+}
+
+func TestMonitor_IdleRuleErrorsReported(t *testing.T) {
+	config := DefaultConfig()
+	config.CheckInterval = 100 * time.Millisecond
+	config.AgentURL = ""
+	config.IdleRule = "no_such_resource < 1"
+
+	mon := NewMonitorWithConfig(config)
+
+	errs := make(chan error, 10)
+	mon.OnError(func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := mon.Start(ctx); err != nil {
+		t.Fatalf("Failed to start monitor: %v", err)
+	}
+	defer mon.Stop()
+
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "no_such_resource") {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Timed out waiting for the rule evaluation error")
+	}
+
+	// Invalid rules are rejected on start
+	bad := NewMonitorWithConfig(config).WithIdleRule("cpu <")
+	if err := bad.Start(ctx); err == nil {
+		bad.Stop()
+		t.Error("Expected invalid rule to fail on start")
+	}
+}
@@ -0,0 +1,258 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/scttfrdmn/snoozebot/pkg/monitor/resources"
+)
+
+// staleIntervals is how many sampling intervals a sample is used for. A
+// source that stops producing samples, such as a hung command, is then
+// treated as unknown rather than reporting its last value forever.
+const staleIntervals = 3
+
+// refreshTimeout bounds how long a refresh waits for new samples
+const refreshTimeout = 10 * time.Second
+
+// sampleFunc collects a single sample for a resource
+type sampleFunc func() (*resources.ResourceUsage, error)
+
+// sampleSource is a resource that is sampled by its own goroutine
+type sampleSource struct {
+	resourceType ResourceType
+	interval     time.Duration
+	collect      sampleFunc
+	refresh      chan struct{}
+
+	// waiters are closed once a sample started after they were added is
+	// published. They are guarded by the sampler's mutex.
+	waiters []chan struct{}
+}
+
+// sampler is a long-lived sampling pipeline. Every resource is polled by its
+// own goroutine on its own interval and the latest result is published to a
+// shared snapshot, so a slow collector never delays the idle decision or the
+// sampling of other resources. The underlying resource monitors live as long
+// as the sampler, which lets delta-based monitors keep their previous sample.
+type sampler struct {
+	manager         *resources.MonitorManager
+	intervals       map[ResourceType]time.Duration
+	defaultInterval time.Duration
+	onError         func(error)
+
+	sources  map[ResourceType]*sampleSource
+	snapshot map[ResourceType]*ResourceUsage
+	ctx      context.Context
+	running  bool
+	mutex    sync.RWMutex
+	wg       sync.WaitGroup
+}
+
+// newSampler creates a sampler for all resources known to the manager
+func newSampler(manager *resources.MonitorManager, intervals map[ResourceType]time.Duration,
+	defaultInterval time.Duration, onError func(error)) *sampler {
+	s := &sampler{
+		manager:         manager,
+		intervals:       make(map[ResourceType]time.Duration),
+		defaultInterval: defaultInterval,
+		onError:         onError,
+		sources:         make(map[ResourceType]*sampleSource),
+		snapshot:        make(map[ResourceType]*ResourceUsage),
+	}
+
+	for resourceType, interval := range intervals {
+		s.intervals[resourceType] = interval
+	}
+
+	for _, resourceType := range manager.ResourceTypes() {
+		rt := resourceType
+		s.addSource(ResourceType(rt), func() (*resources.ResourceUsage, error) {
+			return manager.GetUsage(rt)
+		})
+	}
+
+	for _, name := range manager.CustomMonitorNames() {
+		s.addCustom(name)
+	}
+
+	return s
+}
+
+// intervalFor returns the sampling interval for a resource type
+func (s *sampler) intervalFor(resourceType ResourceType) time.Duration {
+	if interval, ok := s.intervals[resourceType]; ok && interval > 0 {
+		return interval
+	}
+	return s.defaultInterval
+}
+
+// addCustom adds a source for a custom monitor already registered with the manager
+func (s *sampler) addCustom(name string) {
+	s.addSource(ResourceType(name), func() (*resources.ResourceUsage, error) {
+		return s.manager.GetCustomUsage(name)
+	})
+}
+
+// addSource adds a sampling source, starting its goroutine if the sampler is running
+func (s *sampler) addSource(resourceType ResourceType, collect sampleFunc) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.sources[resourceType]; exists && s.running {
+		// The existing goroutine picks up the new collector through the manager
+		return
+	}
+
+	source := &sampleSource{
+		resourceType: resourceType,
+		interval:     s.intervalFor(resourceType),
+		collect:      collect,
+		refresh:      make(chan struct{}, 1),
+	}
+	s.sources[resourceType] = source
+
+	if s.running {
+		s.wg.Add(1)
+		go s.run(source)
+	}
+}
+
+// start starts one sampling goroutine per source
+func (s *sampler) start(ctx context.Context) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.ctx = ctx
+	s.running = true
+
+	for _, source := range s.sources {
+		s.wg.Add(1)
+		go s.run(source)
+	}
+}
+
+// wait blocks until all sampling goroutines have exited
+func (s *sampler) wait() {
+	s.wg.Wait()
+
+	s.mutex.Lock()
+	s.running = false
+	s.mutex.Unlock()
+}
+
+// refresh asks every source to take a sample and waits until they have. It
+// gives up after refreshTimeout, so a hung collector can't block it.
+func (s *sampler) refresh() {
+	s.mutex.Lock()
+	if !s.running {
+		s.mutex.Unlock()
+		return
+	}
+
+	waiters := make([]chan struct{}, 0, len(s.sources))
+	for _, source := range s.sources {
+		waiter := make(chan struct{})
+		source.waiters = append(source.waiters, waiter)
+		waiters = append(waiters, waiter)
+
+		select {
+		case source.refresh <- struct{}{}:
+		default:
+			// A refresh is already pending and will answer the new waiter
+		}
+	}
+	ctx := s.ctx
+	s.mutex.Unlock()
+
+	timeout := time.NewTimer(refreshTimeout)
+	defer timeout.Stop()
+
+	for _, waiter := range waiters {
+		select {
+		case <-waiter:
+		case <-ctx.Done():
+			return
+		case <-timeout.C:
+			return
+		}
+	}
+}
+
+// latest returns a copy of the most recent sample for every resource.
+// Samples older than staleIntervals sampling intervals are left out.
+func (s *sampler) latest() map[ResourceType]*ResourceUsage {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	now := time.Now()
+	usage := make(map[ResourceType]*ResourceUsage, len(s.snapshot))
+	for resourceType, sample := range s.snapshot {
+		if source, ok := s.sources[resourceType]; ok && now.Sub(sample.Timestamp) > staleIntervals*source.interval {
+			continue
+		}
+		sampleCopy := *sample
+		usage[resourceType] = &sampleCopy
+	}
+
+	return usage
+}
+
+// run samples a single source until the sampler's context is cancelled
+func (s *sampler) run(source *sampleSource) {
+	defer s.wg.Done()
+
+	// Take the first sample immediately so the snapshot is populated quickly
+	s.sample(source)
+
+	ticker := time.NewTicker(source.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.sample(source)
+		case <-source.refresh:
+			s.sample(source)
+		}
+	}
+}
+
+// sample collects one sample from a source and publishes it to the snapshot.
+// A failed sample removes the resource from the snapshot, so its last value
+// isn't reported while it is unknown.
+func (s *sampler) sample(source *sampleSource) {
+	// Refreshes requested before the sample started are answered by it
+	s.mutex.Lock()
+	waiters := source.waiters
+	source.waiters = nil
+	s.mutex.Unlock()
+
+	defer func() {
+		for _, waiter := range waiters {
+			close(waiter)
+		}
+	}()
+
+	usage, err := source.collect()
+
+	s.mutex.Lock()
+	if err != nil {
+		delete(s.snapshot, source.resourceType)
+	} else {
+		s.snapshot[source.resourceType] = &ResourceUsage{
+			Type:      source.resourceType,
+			Value:     usage.Value,
+			Timestamp: usage.Timestamp,
+		}
+	}
+	s.mutex.Unlock()
+
+	if err != nil && s.onError != nil {
+		s.onError(fmt.Errorf("failed to sample %s: %w", source.resourceType, err))
+	}
+}
@@ -0,0 +1,150 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/scttfrdmn/snoozebot/pkg/monitor/resources"
+)
+
+func TestSampler_SlowCollectorDoesNotBlock(t *testing.T) {
+	manager, err := resources.NewMonitorManager()
+	if err != nil {
+		t.Fatalf("Failed to create monitor manager: %v", err)
+	}
+
+	// A collector that hangs, like nvidia-smi on a wedged driver
+	release := make(chan struct{})
+	defer close(release)
+	manager.AddCustomMonitor("slow", func() (float64, error) {
+		<-release
+		return 50.0, nil
+	})
+
+	// A fast collector that counts its samples
+	var calls int32
+	manager.AddCustomMonitor("fast", func() (float64, error) {
+		atomic.AddInt32(&calls, 1)
+		return 10.0, nil
+	})
+
+	s := newSampler(manager, map[ResourceType]time.Duration{
+		"fast": 50 * time.Millisecond,
+	}, time.Minute, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.start(ctx)
+
+	time.Sleep(300 * time.Millisecond)
+	snapshot := s.latest()
+
+	if _, ok := snapshot["slow"]; ok {
+		t.Error("Expected no sample for the slow collector yet")
+	}
+	if usage, ok := snapshot["fast"]; !ok || usage.Value != 10.0 {
+		t.Errorf("Expected fast sample of 10.0, got %v", usage)
+	}
+	if n := atomic.LoadInt32(&calls); n < 3 {
+		t.Errorf("Expected the fast collector to be sampled on its own interval, got %d samples", n)
+	}
+
+	// Standard monitors are sampled immediately on start
+	if _, ok := snapshot[CPU]; !ok {
+		t.Error("Missing CPU sample")
+	}
+
+	cancel()
+	release <- struct{}{}
+	s.wait()
+}
+
+func TestSampler_Refresh(t *testing.T) {
+	manager, err := resources.NewMonitorManager()
+	if err != nil {
+		t.Fatalf("Failed to create monitor manager: %v", err)
+	}
+
+	var value int32
+	manager.AddCustomMonitor("counter", func() (float64, error) {
+		return float64(atomic.AddInt32(&value, 1)), nil
+	})
+
+	s := newSampler(manager, nil, time.Hour, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		s.wait()
+	}()
+	s.start(ctx)
+
+	time.Sleep(100 * time.Millisecond)
+
+	// The new samples are published by the time refresh returns
+	s.refresh()
+	usage, ok := s.latest()["counter"]
+	if !ok {
+		t.Fatal("Missing counter sample")
+	}
+	if usage.Value < 2 {
+		t.Errorf("Expected refresh to trigger a second sample, got %f", usage.Value)
+	}
+
+	s.refresh()
+	if usage := s.latest()["counter"]; usage.Value < 3 {
+		t.Errorf("Expected every refresh to take a sample, got %f", usage.Value)
+	}
+}
+
+func TestSampler_UnknownSources(t *testing.T) {
+	manager, err := resources.NewMonitorManager()
+	if err != nil {
+		t.Fatalf("Failed to create monitor manager: %v", err)
+	}
+
+	// A source that fails after its first sample, like a Jupyter server
+	// that went away
+	var failing int32
+	manager.AddCustomMonitor("failing", func() (float64, error) {
+		if atomic.AddInt32(&failing, 1) > 1 {
+			return 0, errors.New("connection refused")
+		}
+		return 100.0, nil
+	})
+
+	// A source that hangs after its first sample
+	release := make(chan struct{})
+	var hung int32
+	manager.AddCustomMonitor("hung", func() (float64, error) {
+		if atomic.AddInt32(&hung, 1) > 1 {
+			<-release
+		}
+		return 100.0, nil
+	})
+
+	s := newSampler(manager, map[ResourceType]time.Duration{
+		"failing": 20 * time.Millisecond,
+		"hung":    20 * time.Millisecond,
+	}, time.Hour, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		close(release)
+		s.wait()
+	}()
+	s.start(ctx)
+
+	time.Sleep(200 * time.Millisecond)
+	snapshot := s.latest()
+	if usage, ok := snapshot["failing"]; ok {
+		t.Errorf("Expected a failing source to be unknown, got %f", usage.Value)
+	}
+	if usage, ok := snapshot["hung"]; ok {
+		t.Errorf("Expected a stale sample to be dropped, got %f", usage.Value)
+	}
+	if _, ok := snapshot[CPU]; !ok {
+		t.Error("Missing CPU sample")
+	}
+}
@@ -0,0 +1,121 @@
+package monitor
+
+import (
+	"sync"
+	"time"
+)
+
+// SmoothingMethod is the method used to smooth resource samples
+type SmoothingMethod string
+
+const (
+	// SmoothingNone judges every sample on its own
+	SmoothingNone SmoothingMethod = "none"
+	// SmoothingMovingAverage averages the last Window samples
+	SmoothingMovingAverage SmoothingMethod = "moving_average"
+	// SmoothingEWMA uses an exponentially weighted moving average
+	SmoothingEWMA SmoothingMethod = "ewma"
+)
+
+// SmoothingConfig configures how samples are smoothed before the idle decision
+type SmoothingConfig struct {
+	// Method is the smoothing method
+	Method SmoothingMethod
+	// Window is the number of samples to smooth over
+	Window int
+	// Alpha is the EWMA smoothing factor (0-1). If zero it is derived
+	// from Window as 2/(Window+1).
+	Alpha float64
+}
+
+// smoother keeps the per-resource smoothing state
+type smoother struct {
+	config   SmoothingConfig
+	samples  map[ResourceType][]float64
+	averages map[ResourceType]float64
+	lastSeen map[ResourceType]time.Time
+	mutex    sync.Mutex
+}
+
+// newSmoother creates a smoother for the given configuration
+func newSmoother(config SmoothingConfig) *smoother {
+	if config.Window < 1 {
+		config.Window = 1
+	}
+	if config.Method == SmoothingEWMA && (config.Alpha <= 0 || config.Alpha > 1) {
+		config.Alpha = 2.0 / float64(config.Window+1)
+	}
+
+	return &smoother{
+		config:   config,
+		samples:  make(map[ResourceType][]float64),
+		averages: make(map[ResourceType]float64),
+		lastSeen: make(map[ResourceType]time.Time),
+	}
+}
+
+// apply feeds new samples into the smoother and returns the smoothed usage.
+// A sample that was already seen is not counted twice, so resources sampled
+// less often than they are checked are not over-weighted.
+func (s *smoother) apply(usage map[ResourceType]*ResourceUsage) map[ResourceType]*ResourceUsage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	smoothed := make(map[ResourceType]*ResourceUsage, len(usage))
+	for resourceType, u := range usage {
+		value := u.Value
+
+		switch s.config.Method {
+		case SmoothingMovingAverage:
+			if s.isNew(u) {
+				samples := append(s.samples[resourceType], u.Value)
+				if len(samples) > s.config.Window {
+					samples = samples[len(samples)-s.config.Window:]
+				}
+				s.samples[resourceType] = samples
+			}
+			value = mean(s.samples[resourceType])
+
+		case SmoothingEWMA:
+			if s.isNew(u) {
+				if average, ok := s.averages[resourceType]; ok {
+					s.averages[resourceType] = s.config.Alpha*u.Value + (1-s.config.Alpha)*average
+				} else {
+					s.averages[resourceType] = u.Value
+				}
+			}
+			value = s.averages[resourceType]
+		}
+
+		smoothed[resourceType] = &ResourceUsage{
+			Type:      u.Type,
+			Value:     value,
+			Timestamp: u.Timestamp,
+		}
+	}
+
+	return smoothed
+}
+
+// isNew records the sample timestamp and reports whether it was not seen before
+func (s *smoother) isNew(u *ResourceUsage) bool {
+	if last, ok := s.lastSeen[u.Type]; ok && !u.Timestamp.After(last) {
+		return false
+	}
+	s.lastSeen[u.Type] = u.Timestamp
+	return true
+}
+
+// mean returns the arithmetic mean of the values
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+
+	return sum / float64(len(values))
+}
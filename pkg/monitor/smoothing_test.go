@@ -0,0 +1,108 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
+)
+
+func TestSmoother_MovingAverage(t *testing.T) {
+	s := newSmoother(SmoothingConfig{Method: SmoothingMovingAverage, Window: 3})
+
+	start := time.Now()
+	var value float64
+	for i, v := range []float64{0, 0, 90, 0} {
+		usage := usageOf(map[ResourceType]float64{CPU: v}, start.Add(time.Duration(i)*time.Second))
+		value = s.apply(usage)[CPU].Value
+	}
+
+	// Average of the last three samples: (0 + 90 + 0) / 3
+	if value != 30 {
+		t.Errorf("Expected moving average 30, got %f", value)
+	}
+
+	// The same sample seen again is not counted twice
+	usage := usageOf(map[ResourceType]float64{CPU: 0}, start.Add(3*time.Second))
+	if v := s.apply(usage)[CPU].Value; v != 30 {
+		t.Errorf("Expected repeated sample to be ignored, got %f", v)
+	}
+}
+
+func TestSmoother_EWMA(t *testing.T) {
+	s := newSmoother(SmoothingConfig{Method: SmoothingEWMA, Alpha: 0.5})
+
+	start := time.Now()
+	s.apply(usageOf(map[ResourceType]float64{CPU: 0}, start))
+	v := s.apply(usageOf(map[ResourceType]float64{CPU: 100}, start.Add(time.Second)))[CPU].Value
+	if v != 50 {
+		t.Errorf("Expected EWMA 50, got %f", v)
+	}
+
+	// Alpha is derived from the window when not set
+	s = newSmoother(SmoothingConfig{Method: SmoothingEWMA, Window: 3})
+	if s.config.Alpha != 0.5 {
+		t.Errorf("Expected derived alpha 0.5, got %f", s.config.Alpha)
+	}
+}
+
+func TestMonitor_HysteresisAndMinActiveBurst(t *testing.T) {
+	config := DefaultConfig()
+	config.Thresholds = map[ResourceType]float64{CPU: 10}
+	config.ExitThresholds = map[ResourceType]float64{CPU: 30}
+	config.MinActiveBurst = time.Hour
+
+	m := newMonitor(config)
+	check := func(cpu float64) {
+		m.currentState.CurrentUsage = usageOf(map[ResourceType]float64{CPU: cpu}, time.Now())
+		m.checkIdleState()
+	}
+
+	// Above the enter threshold keeps the system active
+	check(20)
+	if m.IsIdle() {
+		t.Fatal("Expected active above the enter threshold")
+	}
+
+	// Below the enter threshold the system becomes idle
+	check(5)
+	if !m.IsIdle() {
+		t.Fatal("Expected idle below the enter threshold")
+	}
+	idleSince := m.GetCurrentState().IdleSince
+
+	// Between the two thresholds the system stays idle
+	check(20)
+	if !m.IsIdle() {
+		t.Fatal("Expected idle below the exit threshold")
+	}
+
+	// A short burst above the exit threshold doesn't reset the idle timer
+	check(90)
+	if !m.IsIdle() {
+		t.Fatal("Expected a short burst to be ignored")
+	}
+	if got := m.GetCurrentState().IdleSince; !got.Equal(idleSince) {
+		t.Errorf("Expected idle timer to keep running from %v, got %v", idleSince, got)
+	}
+
+	// Stages wait during a burst and are reached once it ends
+	m.config.Stages = []protocol.IdleStage{{After: time.Minute, Action: protocol.StageNotify}}
+	m.currentState.IdleSince = time.Now().Add(-time.Hour)
+	check(90)
+	if m.stagesReached != 0 {
+		t.Errorf("Expected no stages during a burst, got %d reached", m.stagesReached)
+	}
+	m.activeSince = time.Time{}
+	check(5)
+	if m.stagesReached != 1 {
+		t.Errorf("Expected the stage after the burst, got %d reached", m.stagesReached)
+	}
+
+	// Activity lasting longer than the minimum burst makes the system active
+	m.activeSince = time.Now().Add(-2 * time.Hour)
+	check(90)
+	if m.IsIdle() {
+		t.Error("Expected sustained activity to end the idle state")
+	}
+}
@@ -0,0 +1,78 @@
+package monitor
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
+)
+
+// BroadcastFunc shows a message to everyone logged in to the system
+type BroadcastFunc func(message string) error
+
+// WallBroadcast broadcasts a message to all terminals with wall
+func WallBroadcast(message string) error {
+	cmd := exec.Command("wall")
+	cmd.Stdin = strings.NewReader(message + "\n")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("wall failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// stageWarning returns the warning broadcast when a warn stage is reached
+func stageWarning(stages []protocol.IdleStage, idleDuration time.Duration) string {
+	next, ok := protocol.NextStop(stages, idleDuration)
+	if !ok {
+		return fmt.Sprintf("Snoozebot: this system has been idle for %s.", idleDuration.Round(time.Second))
+	}
+	return fmt.Sprintf("Snoozebot: this system has been idle for %s and will %s in %s. Any activity cancels the %s.",
+		idleDuration.Round(time.Second), next.Action, (next.After - idleDuration).Round(time.Second), next.Action)
+}
+
+// advanceStages records the stages reached after being idle for the given
+// duration and returns the warnings to broadcast. The caller must hold the mutex.
+func (m *monitor) advanceStages(idleDuration time.Duration) []string {
+	var warnings []string
+
+	reached := protocol.ReachedStages(m.config.Stages, m.stagesReached, idleDuration)
+	m.stagesReached += len(reached)
+	for _, stage := range reached {
+		if stage.Action == protocol.StageWarn {
+			m.warned = true
+			warnings = append(warnings, stageWarning(m.config.Stages, idleDuration))
+		}
+	}
+
+	return warnings
+}
+
+// resetStages starts the stages again from the first one. If a warning had
+// been given, it returns the message explaining why the stop was cancelled.
+// The caller must hold the mutex.
+func (m *monitor) resetStages(why string) []string {
+	warned := m.warned
+	m.stagesReached = 0
+	m.warned = false
+
+	if warned && why != "" {
+		return []string{fmt.Sprintf("Snoozebot: %s, the pending stop was cancelled.", why)}
+	}
+	return nil
+}
+
+// broadcast shows messages on the system's terminals in order
+func (m *monitor) broadcast(messages []string) {
+	m.mutex.RLock()
+	broadcast := m.config.Broadcast
+	m.mutex.RUnlock()
+
+	for _, message := range messages {
+		fmt.Println(message)
+		if err := broadcast(message); err != nil {
+			m.handleError(fmt.Errorf("failed to broadcast idle warning: %w", err))
+		}
+	}
+}
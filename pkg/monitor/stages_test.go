@@ -0,0 +1,91 @@
+package monitor
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
+)
+
+func TestMonitor_Stages(t *testing.T) {
+	stages, err := protocol.ParseStages("30m notify; 45m warn; 1h stop")
+	if err != nil {
+		t.Fatalf("Failed to parse stages: %v", err)
+	}
+
+	var mutex sync.Mutex
+	var messages []string
+	broadcasts := make(chan string, 10)
+
+	config := DefaultConfig()
+	config.Thresholds = map[ResourceType]float64{CPU: 10.0}
+	config.Stages = stages
+	config.Broadcast = func(message string) error {
+		mutex.Lock()
+		messages = append(messages, message)
+		mutex.Unlock()
+		broadcasts <- message
+		return nil
+	}
+	m := newMonitor(config)
+
+	setCPU := func(value float64) {
+		m.mutex.Lock()
+		m.currentState.CurrentUsage[CPU] = &ResourceUsage{Type: CPU, Value: value, Timestamp: time.Now()}
+		m.mutex.Unlock()
+	}
+	idleFor := func(duration time.Duration) {
+		m.mutex.Lock()
+		m.currentState.IdleSince = time.Now().Add(-duration)
+		m.mutex.Unlock()
+		m.checkIdleState()
+	}
+	waitForBroadcast := func() string {
+		t.Helper()
+		select {
+		case message := <-broadcasts:
+			return message
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for a broadcast")
+			return ""
+		}
+	}
+
+	setCPU(1.0)
+	m.checkIdleState()
+	idleFor(35 * time.Minute)
+	if m.stagesReached != 1 || m.warned {
+		t.Errorf("Expected only the notify stage, got %d stages reached", m.stagesReached)
+	}
+
+	// The warning counts down to the stop
+	idleFor(50 * time.Minute)
+	if warning := waitForBroadcast(); !strings.Contains(warning, "will stop in 10m0s") {
+		t.Errorf("Expected a countdown to the stop, got %q", warning)
+	}
+
+	// Activity cancels the stages
+	setCPU(50.0)
+	m.checkIdleState()
+	if cancelled := waitForBroadcast(); !strings.Contains(cancelled, "cancelled") {
+		t.Errorf("Expected the stop to be cancelled, got %q", cancelled)
+	}
+	if m.stagesReached != 0 || m.warned {
+		t.Errorf("Expected the stages to be reset")
+	}
+
+	// Going idle again without reaching the warning broadcasts nothing
+	setCPU(1.0)
+	m.checkIdleState()
+	idleFor(40 * time.Minute)
+	setCPU(50.0)
+	m.checkIdleState()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(messages) != 2 {
+		t.Errorf("Expected 2 broadcasts, got %v", messages)
+	}
+}
@@ -0,0 +1,192 @@
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultStateFile is where snoozed keeps the monitor state between restarts
+const DefaultStateFile = "/var/lib/snoozebot/monitor-state.json"
+
+// stateVersion is the version of the state file format
+const stateVersion = 1
+
+// bootTimeTolerance allows for the boot time moving slightly as the clock is adjusted
+const bootTimeTolerance = 5 * time.Second
+
+// maxStateAgeIntervals is how many check intervals old the saved idle clock
+// may be. The state is saved on every check, so an older file means snoozed
+// was stopped for a while and can't tell whether the system was idle then.
+const maxStateAgeIntervals = 3
+
+// ErrStaleState is returned when the saved state is from an earlier boot
+var ErrStaleState = errors.New("saved state is from an earlier boot")
+
+// PersistedState is the monitor state kept across restarts of the monitor
+type PersistedState struct {
+	// Version is the state file format version
+	Version int `json:"version"`
+	// BootTime is when the host booted, to discard state after a reboot
+	BootTime time.Time `json:"boot_time"`
+	// SavedAt is when the state was saved
+	SavedAt time.Time `json:"saved_at"`
+	// IdleSince is when the system became idle, or zero if it is active
+	IdleSince time.Time `json:"idle_since,omitempty"`
+	// StagesReached is the number of idle stages reached
+	StagesReached int `json:"stages_reached,omitempty"`
+	// Warned indicates that a stage warning was broadcast
+	Warned bool `json:"warned,omitempty"`
+	// LastCommand is the last command received from the agent
+	LastCommand string `json:"last_command,omitempty"`
+	// LastCommandAt is when the last command was received
+	LastCommandAt time.Time `json:"last_command_at,omitempty"`
+}
+
+// SaveState atomically writes the state to a file. The version, boot time
+// and save time are filled in.
+func SaveState(path string, state PersistedState) error {
+	state.Version = stateVersion
+	state.SavedAt = time.Now()
+	if bootTime, err := BootTime(); err == nil {
+		state.BootTime = bootTime
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	// Write a temporary file and rename it so a crash never leaves a partial file
+	file, err := os.CreateTemp(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create state file: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync state file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close state file: %w", err)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace state file: %w", err)
+	}
+
+	return nil
+}
+
+// LoadState reads the state saved by SaveState. It returns nil if there is
+// no saved state, and ErrStaleState if the host rebooted since it was saved.
+func LoadState(path string) (*PersistedState, error) {
+	bootTime, err := BootTime()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check the saved state: %w", err)
+	}
+	return loadState(path, bootTime, time.Now())
+}
+
+// loadState reads and checks the saved state against the current boot time
+func loadState(path string, bootTime, now time.Time) (*PersistedState, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	var state PersistedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w", err)
+	}
+
+	if state.Version != stateVersion {
+		return nil, fmt.Errorf("unsupported state file version %d", state.Version)
+	}
+	if diff := state.BootTime.Sub(bootTime); diff > bootTimeTolerance || diff < -bootTimeTolerance {
+		return nil, ErrStaleState
+	}
+	if state.SavedAt.After(now) {
+		return nil, fmt.Errorf("state was saved in the future at %s", state.SavedAt.Format(time.RFC3339))
+	}
+	if !state.IdleSince.IsZero() && (state.IdleSince.After(state.SavedAt) || state.IdleSince.Before(bootTime.Add(-bootTimeTolerance))) {
+		return nil, fmt.Errorf("idle since %s is outside the current boot", state.IdleSince.Format(time.RFC3339))
+	}
+	if state.StagesReached < 0 {
+		return nil, fmt.Errorf("invalid number of stages reached: %d", state.StagesReached)
+	}
+
+	return &state, nil
+}
+
+// savedState returns the state to save. The caller must hold the mutex.
+func (m *monitor) savedState() PersistedState {
+	state := PersistedState{
+		StagesReached: m.stagesReached,
+		Warned:        m.warned,
+		LastCommand:   m.lastCommand,
+		LastCommandAt: m.lastCommandAt,
+	}
+	if m.currentState.IsIdle {
+		state.IdleSince = m.currentState.IdleSince
+	}
+	return state
+}
+
+// persistState saves the state if a state file is configured
+func (m *monitor) persistState() {
+	m.stateMutex.Lock()
+	defer m.stateMutex.Unlock()
+
+	m.mutex.RLock()
+	path := m.config.StateFile
+	state := m.savedState()
+	m.mutex.RUnlock()
+
+	if path == "" {
+		return
+	}
+	if err := SaveState(path, state); err != nil {
+		m.handleError(fmt.Errorf("failed to save monitor state: %w", err))
+	}
+}
+
+// restoreState resumes the idle clock, stages and last command from the
+// saved state. The idle clock is only resumed if the state was saved within
+// a few check intervals. The caller must hold the mutex.
+func (m *monitor) restoreState(state *PersistedState, now time.Time) {
+	if state.StagesReached > len(m.config.Stages) {
+		state.StagesReached = len(m.config.Stages)
+	}
+
+	maxAge := maxStateAgeIntervals * m.config.CheckInterval
+	if !state.IdleSince.IsZero() && now.Sub(state.SavedAt) <= maxAge {
+		m.currentState.IsIdle = true
+		m.currentState.IdleSince = state.IdleSince
+		m.currentState.IdleDuration = now.Sub(state.IdleSince)
+		m.stagesReached = state.StagesReached
+		m.warned = state.Warned
+	}
+
+	// Don't prepare a stop again that was prepared just before the restart
+	m.lastCommand = state.LastCommand
+	m.lastCommandAt = state.LastCommandAt
+	if isStopCommand(state.LastCommand) {
+		m.stopHeldUntil = state.LastCommandAt.Add(m.config.NapTime)
+	}
+}
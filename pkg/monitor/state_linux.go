@@ -0,0 +1,46 @@
+// +build linux
+
+package monitor
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BootTime returns when the host booted, from the btime line of /proc/stat
+func BootTime() (time.Time, error) {
+	file, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to open /proc/stat: %w", err)
+	}
+	defer file.Close()
+
+	return parseBootTime(file)
+}
+
+// parseBootTime reads the btime line from the contents of /proc/stat
+func parseBootTime(reader io.Reader) (time.Time, error) {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[0] != "btime" {
+			continue
+		}
+
+		seconds, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid btime in /proc/stat: %w", err)
+		}
+		return time.Unix(seconds, 0), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return time.Time{}, fmt.Errorf("failed to read /proc/stat: %w", err)
+	}
+
+	return time.Time{}, fmt.Errorf("no btime in /proc/stat")
+}
@@ -0,0 +1,28 @@
+// +build linux
+
+package monitor
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBootTime(t *testing.T) {
+	stat := "cpu  4705 356 584 3699 23 23 0 0 0 0\nintr 114930548 113199788 3\nctxt 1990473\nbtime 1751457600\nprocesses 2915\n"
+	bootTime, err := parseBootTime(strings.NewReader(stat))
+	if err != nil {
+		t.Fatalf("Failed to parse boot time: %v", err)
+	}
+	if !bootTime.Equal(time.Unix(1751457600, 0)) {
+		t.Errorf("Expected 1751457600, got %d", bootTime.Unix())
+	}
+
+	if _, err := parseBootTime(strings.NewReader("cpu 1 2 3\n")); err == nil {
+		t.Errorf("Expected an error without a btime line")
+	}
+
+	if _, err := BootTime(); err != nil {
+		t.Errorf("Failed to read the boot time: %v", err)
+	}
+}
@@ -0,0 +1,14 @@
+// +build !linux
+
+package monitor
+
+import (
+	"fmt"
+	"time"
+)
+
+// BootTime returns when the host booted
+// This is a stub implementation for non-Linux platforms
+func BootTime() (time.Time, error) {
+	return time.Time{}, fmt.Errorf("boot time is only available on Linux")
+}
@@ -0,0 +1,122 @@
+package monitor
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/scttfrdmn/snoozebot/pkg/common/protocol"
+)
+
+func TestLoadState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "monitor-state.json")
+
+	if state, err := loadState(path, time.Now(), time.Now()); state != nil || err != nil {
+		t.Errorf("Expected no state without a file, got %v, %v", state, err)
+	}
+
+	idleSince := time.Now().Add(-20 * time.Minute).Truncate(time.Second)
+	saved := PersistedState{IdleSince: idleSince, StagesReached: 1, LastCommand: "stop", LastCommandAt: idleSince}
+	if err := SaveState(path, saved); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	// Only the state file is left behind
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("Failed to read state directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected only the state file, got %d entries", len(entries))
+	}
+
+	// Zero where the boot time is unavailable, as in the saved state
+	bootTime, _ := BootTime()
+	state, err := loadState(path, bootTime, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if !state.IdleSince.Equal(idleSince) || state.StagesReached != 1 || state.LastCommand != "stop" {
+		t.Errorf("Expected the saved state, got %+v", state)
+	}
+
+	// A reboot discards the state
+	if _, err := loadState(path, bootTime.Add(time.Hour), time.Now()); !errors.Is(err, ErrStaleState) {
+		t.Errorf("Expected ErrStaleState after a reboot, got %v", err)
+	}
+
+	// A clock that went backwards discards the state
+	if _, err := loadState(path, bootTime, time.Now().Add(-time.Hour)); err == nil {
+		t.Errorf("Expected an error for state saved in the future")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"version": 99}`), 0644); err != nil {
+		t.Fatalf("Failed to write state: %v", err)
+	}
+	if _, err := loadState(path, bootTime, time.Now()); err == nil {
+		t.Errorf("Expected an error for an unknown version")
+	}
+}
+
+func TestMonitor_PersistState(t *testing.T) {
+	stages, err := protocol.ParseStages("10m notify; 1h stop")
+	if err != nil {
+		t.Fatalf("Failed to parse stages: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.Thresholds = map[ResourceType]float64{CPU: 10.0}
+	config.Stages = stages
+	config.StateFile = filepath.Join(t.TempDir(), "monitor-state.json")
+	config.Broadcast = func(string) error { return nil }
+	m := newMonitor(config)
+	m.currentState.CurrentUsage[CPU] = &ResourceUsage{Type: CPU, Value: 1.0, Timestamp: time.Now()}
+
+	// Becoming idle and reaching a stage are saved
+	m.checkIdleState()
+	m.mutex.Lock()
+	m.currentState.IdleSince = time.Now().Add(-15 * time.Minute)
+	m.mutex.Unlock()
+	m.checkIdleState()
+
+	bootTime, _ := BootTime()
+	state, err := loadState(config.StateFile, bootTime, time.Now())
+	if err != nil || state == nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if state.IdleSince.IsZero() || state.StagesReached != 1 {
+		t.Errorf("Expected an idle state with one stage reached, got %+v", state)
+	}
+
+	// A new monitor resumes the idle clock
+	restarted := newMonitor(config)
+	restarted.restoreState(state, time.Now())
+	if !restarted.IsIdle() || restarted.IdleDuration() < 15*time.Minute {
+		t.Errorf("Expected the idle clock to resume, got %s", restarted.IdleDuration())
+	}
+	if restarted.stagesReached != 1 {
+		t.Errorf("Expected the stage progress to resume, got %d", restarted.stagesReached)
+	}
+
+	// The idle clock isn't resumed after the monitor was down for a while
+	stale := newMonitor(config)
+	stale.restoreState(state, state.SavedAt.Add(maxStateAgeIntervals*config.CheckInterval+time.Second))
+	if stale.IsIdle() || stale.stagesReached != 0 {
+		t.Errorf("Expected a stale idle clock to be discarded, got idle %v with %d stages", stale.IsIdle(), stale.stagesReached)
+	}
+
+	// The state is saved on every check, not only on transitions
+	m.mutex.Lock()
+	m.currentState.IdleSince = time.Now().Add(-20 * time.Minute)
+	m.mutex.Unlock()
+	m.checkIdleState()
+	saved, err := loadState(config.StateFile, bootTime, time.Now())
+	if err != nil || saved == nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if !saved.SavedAt.After(state.SavedAt) {
+		t.Errorf("Expected the state to be saved again, got %s and %s", saved.SavedAt, state.SavedAt)
+	}
+}
@@ -200,6 +200,52 @@ func (m *Manager) NotifyStateChange(ctx context.Context, instanceID, instanceNam
 	return m.SendNotification(ctx, notification)
 }
 
+// NotifyIdleStage creates and sends a notification that an instance reached an idle stage.
+// The notification type is chosen by the stage.
+func (m *Manager) NotifyIdleStage(ctx context.Context, instanceID, instanceName, provider, region string, notificationType types.NotificationType, action string, idleDuration time.Duration, reason string) []error {
+	severity := types.SeverityInfo
+	if action != "notify" {
+		severity = types.SeverityWarning
+	}
+
+	notification := &types.Notification{
+		Type:         notificationType,
+		Severity:     severity,
+		InstanceID:   instanceID,
+		InstanceName: instanceName,
+		Provider:     provider,
+		Region:       region,
+		Title:        fmt.Sprintf("Idle Stage: %s", action),
+		Message:      fmt.Sprintf("Instance %s has been idle for %s. %s", instanceName, idleDuration, reason),
+		Data: map[string]interface{}{
+			"action":        action,
+			"idle_duration": idleDuration.String(),
+			"reason":        reason,
+		},
+	}
+
+	return m.SendNotification(ctx, notification)
+}
+
+// NotifyStopCancelled creates and sends a notification that activity cancelled a pending stop
+func (m *Manager) NotifyStopCancelled(ctx context.Context, instanceID, instanceName, provider, region, reason string) []error {
+	notification := &types.Notification{
+		Type:         types.NotificationTypeStopCancelled,
+		Severity:     types.SeverityInfo,
+		InstanceID:   instanceID,
+		InstanceName: instanceName,
+		Provider:     provider,
+		Region:       region,
+		Title:        "Stop Cancelled",
+		Message:      fmt.Sprintf("Pending stop of instance %s was cancelled. Reason: %s", instanceName, reason),
+		Data: map[string]interface{}{
+			"reason": reason,
+		},
+	}
+
+	return m.SendNotification(ctx, notification)
+}
+
 // Close closes all providers
 func (m *Manager) Close() error {
 	m.mu.Lock()
@@ -19,6 +19,7 @@ const (
 	NotificationTypeActionExecuted  = types.NotificationTypeActionExecuted
 	NotificationTypeError           = types.NotificationTypeError
 	NotificationTypeStateChange     = types.NotificationTypeStateChange
+	NotificationTypeStopCancelled   = types.NotificationTypeStopCancelled
 
 	SeverityInfo     = types.SeverityInfo
 	SeverityWarning  = types.SeverityWarning
@@ -23,6 +23,9 @@ const (
 	
 	// NotificationTypeStateChange is sent when an instance changes state
 	NotificationTypeStateChange NotificationType = "state_change"
+	
+	// NotificationTypeStopCancelled is sent when activity cancels a pending stop
+	NotificationTypeStopCancelled NotificationType = "stop_cancelled"
 )
 
 // Severity represents the severity level of a notification
@@ -0,0 +1,191 @@
+package schedule
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Calendar is a set of holiday dates. Dates are compared on the wall clock,
+// so a holiday covers the whole day in the schedule's timezone.
+type Calendar struct {
+	dates  map[string]bool
+	annual map[string]bool
+}
+
+// NewCalendar creates an empty calendar
+func NewCalendar() *Calendar {
+	return &Calendar{
+		dates:  make(map[string]bool),
+		annual: make(map[string]bool),
+	}
+}
+
+// Add adds a holiday on the given date
+func (c *Calendar) Add(date time.Time) {
+	c.dates[date.Format("2006-01-02")] = true
+}
+
+// AddAnnual adds a holiday on the same date every year
+func (c *Calendar) AddAnnual(month time.Month, day int) {
+	c.annual[fmt.Sprintf("%02d-%02d", month, day)] = true
+}
+
+// Merge adds the holidays of another calendar
+func (c *Calendar) Merge(other *Calendar) {
+	if other == nil {
+		return
+	}
+	for date := range other.dates {
+		c.dates[date] = true
+	}
+	for date := range other.annual {
+		c.annual[date] = true
+	}
+}
+
+// Contains reports whether the date of t is a holiday
+func (c *Calendar) Contains(t time.Time) bool {
+	if c == nil {
+		return false
+	}
+	return c.dates[t.Format("2006-01-02")] || c.annual[t.Format("01-02")]
+}
+
+// Dates returns the holidays in the form used by holiday rules, sorted
+func (c *Calendar) Dates() []string {
+	dates := make([]string, 0, len(c.dates)+len(c.annual))
+	for date := range c.dates {
+		dates = append(dates, date)
+	}
+	for date := range c.annual {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+	return dates
+}
+
+// LoadICS loads the holidays from an iCalendar file
+func LoadICS(path string) (*Calendar, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open calendar: %w", err)
+	}
+	defer f.Close()
+
+	calendar, err := ParseICS(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return calendar, nil
+}
+
+// ParseICS reads the events of an iCalendar file as holidays. Every day an
+// event touches is a holiday. Yearly recurring events repeat on the same
+// date every year; other recurrence rules are ignored and only the first
+// occurrence is used.
+func ParseICS(r io.Reader) (*Calendar, error) {
+	calendar := NewCalendar()
+
+	lines, err := unfoldICS(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var inEvent, yearly bool
+	var start, end time.Time
+	for _, line := range lines {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		params := strings.Split(name, ";")
+		property := strings.ToUpper(params[0])
+
+		switch {
+		case property == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			inEvent, yearly = true, false
+			start, end = time.Time{}, time.Time{}
+
+		case property == "END" && strings.EqualFold(value, "VEVENT"):
+			if !inEvent || start.IsZero() {
+				return nil, fmt.Errorf("event without a start date")
+			}
+			addEvent(calendar, start, end, yearly)
+			inEvent = false
+
+		case !inEvent:
+
+		case property == "DTSTART":
+			if start, err = parseICSDate(value); err != nil {
+				return nil, err
+			}
+
+		case property == "DTEND":
+			if end, err = parseICSDate(value); err != nil {
+				return nil, err
+			}
+
+		case property == "RRULE":
+			yearly = strings.Contains(strings.ToUpper(value), "FREQ=YEARLY")
+		}
+	}
+
+	return calendar, nil
+}
+
+// addEvent adds the days an event covers. The end date is exclusive.
+func addEvent(calendar *Calendar, start, end time.Time, yearly bool) {
+	if end.IsZero() || !end.After(start) {
+		end = start.AddDate(0, 0, 1)
+	}
+
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		if yearly {
+			calendar.AddAnnual(day.Month(), day.Day())
+		} else {
+			calendar.Add(day)
+		}
+	}
+}
+
+// parseICSDate parses the date of a DATE or DATE-TIME value
+func parseICSDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if len(value) < 8 {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+
+	date, err := time.Parse("20060102", value[:8])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+
+	return date, nil
+}
+
+// unfoldICS reads the content lines of an iCalendar file, joining lines
+// that were folded onto continuation lines
+func unfoldICS(r io.Reader) ([]string, error) {
+	var lines []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read calendar: %w", err)
+	}
+
+	return lines, nil
+}
@@ -0,0 +1,78 @@
+package schedule
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const holidaysICS = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Example//Holidays//EN\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;VALUE=DATE:20250101\r\n" +
+	"DTEND;VALUE=DATE:20250102\r\n" +
+	"RRULE:FREQ=YEARLY\r\n" +
+	"SUMMARY:New Year's Day\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;VALUE=DATE:20251224\r\n" +
+	"DTEND;VALUE=DATE:20251227\r\n" +
+	"SUMMARY:Winter break with a summary that is long enough to be folded onto\r\n" +
+	"  a continuation line\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;TZID=America/New_York:20250526T000000\r\n" +
+	"SUMMARY:Memorial Day\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseICS(t *testing.T) {
+	calendar, err := ParseICS(strings.NewReader(holidaysICS))
+	if err != nil {
+		t.Fatalf("Failed to parse calendar: %v", err)
+	}
+
+	holidays := []time.Time{
+		time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2031, 1, 1, 12, 0, 0, 0, time.UTC),
+		time.Date(2025, 12, 24, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 12, 26, 23, 59, 0, 0, time.UTC),
+		time.Date(2025, 5, 26, 9, 0, 0, 0, time.UTC),
+	}
+	for _, day := range holidays {
+		if !calendar.Contains(day) {
+			t.Errorf("Expected %s to be a holiday", day.Format("2006-01-02"))
+		}
+	}
+
+	workdays := []time.Time{
+		time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 12, 27, 0, 0, 0, 0, time.UTC),
+		time.Date(2026, 12, 24, 0, 0, 0, 0, time.UTC),
+		time.Date(2026, 5, 26, 0, 0, 0, 0, time.UTC),
+	}
+	for _, day := range workdays {
+		if calendar.Contains(day) {
+			t.Errorf("Expected %s not to be a holiday", day.Format("2006-01-02"))
+		}
+	}
+
+	expected := "01-01,2025-05-26,2025-12-24,2025-12-25,2025-12-26"
+	if dates := strings.Join(calendar.Dates(), ","); dates != expected {
+		t.Errorf("Expected %s, got %s", expected, dates)
+	}
+}
+
+func TestParseICS_Errors(t *testing.T) {
+	invalid := []string{
+		"BEGIN:VEVENT\r\nSUMMARY:No start\r\nEND:VEVENT\r\n",
+		"BEGIN:VEVENT\r\nDTSTART:2025\r\nEND:VEVENT\r\n",
+	}
+
+	for _, ics := range invalid {
+		if _, err := ParseICS(strings.NewReader(ics)); err == nil {
+			t.Errorf("Expected an error for %q", ics)
+		}
+	}
+}
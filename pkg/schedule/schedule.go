@@ -0,0 +1,449 @@
+// Package schedule changes idle behavior by time of day, day of week and
+// holiday calendar.
+//
+// A schedule is written as one rule per line, or separated by semicolons
+// when it is carried in instance metadata:
+//
+//	timezone Europe/Berlin
+//	calendar holidays.ics
+//	holiday 2025-12-24
+//	holiday 12-25
+//	weekdays 09:00-18:00 never-stop
+//	daily 22:00-07:00 naptime=10m
+//	holidays naptime=15m
+//
+// The first rule that matches a time decides the policy. Rules select days
+// with daily, weekdays, weekends, holidays, day names such as mon or sat,
+// ranges such as mon-fri and comma separated lists. On a holiday only the
+// daily and holidays selectors match, so business hours don't apply. Holiday
+// dates without a year repeat every year. A rule without a time range covers
+// the whole day, and a range that ends before it starts runs past midnight
+// into the next day.
+package schedule
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MetadataKey is the instance registration metadata key that carries a
+// per-instance schedule
+const MetadataKey = "schedule"
+
+// Policy is the idle behavior in effect at a point in time
+type Policy struct {
+	// NeverStop is true if the instance must not be stopped
+	NeverStop bool
+	// NapTime overrides how long the instance must be idle before it is
+	// stopped. Zero means the default nap time applies.
+	NapTime time.Duration
+	// Rule is the rule that matched, empty if none did
+	Rule string
+}
+
+// NapTimeOr returns the policy's nap time, or the default if it has none
+func (p Policy) NapTimeOr(napTime time.Duration) time.Duration {
+	if p.NapTime > 0 {
+		return p.NapTime
+	}
+	return napTime
+}
+
+// daySet selects days of the week and holidays
+type daySet struct {
+	weekdays [7]bool
+	holidays bool
+	daily    bool
+}
+
+// rule is a single schedule rule
+type rule struct {
+	source    string
+	days      daySet
+	start     time.Duration
+	end       time.Duration
+	allDay    bool
+	neverStop bool
+	napTime   time.Duration
+}
+
+// Schedule decides the idle policy by time window
+type Schedule struct {
+	location *time.Location
+	holidays *Calendar
+	rules    []rule
+	// directives keeps the timezone and inline holiday lines for String
+	directives []string
+}
+
+// Parse parses a schedule. Calendar files can only be used in schedule
+// files, see LoadFile.
+func Parse(spec string) (*Schedule, error) {
+	return parse(spec, "")
+}
+
+// LoadFile loads a schedule from a file. Relative calendar paths are
+// resolved from the directory of the file.
+func LoadFile(path string) (*Schedule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedule: %w", err)
+	}
+
+	schedule, err := parse(string(data), filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return schedule, nil
+}
+
+// FromMetadata parses the schedule carried in instance metadata.
+// It returns nil if the metadata has no schedule.
+func FromMetadata(metadata map[string]string) (*Schedule, error) {
+	spec, ok := metadata[MetadataKey]
+	if !ok || strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	return Parse(spec)
+}
+
+// parse parses a schedule. Calendar directives are allowed if dir is set.
+func parse(spec, dir string) (*Schedule, error) {
+	schedule := &Schedule{
+		location: time.Local,
+		holidays: NewCalendar(),
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(spec))
+	for scanner.Scan() {
+		for _, line := range strings.Split(scanner.Text(), ";") {
+			if i := strings.Index(line, "#"); i >= 0 {
+				line = line[:i]
+			}
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			if err := schedule.parseLine(line, dir); err != nil {
+				return nil, fmt.Errorf("invalid schedule rule %q: %w", line, err)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return schedule, nil
+}
+
+// parseLine parses a directive or rule
+func (s *Schedule) parseLine(line, dir string) error {
+	fields := strings.Fields(line)
+
+	switch fields[0] {
+	case "timezone":
+		if len(fields) != 2 {
+			return fmt.Errorf("expected a timezone name")
+		}
+		location, err := time.LoadLocation(fields[1])
+		if err != nil {
+			return err
+		}
+		s.location = location
+		s.directives = append(s.directives, line)
+		return nil
+
+	case "holiday":
+		if len(fields) != 2 {
+			return fmt.Errorf("expected a date")
+		}
+		// A date without a year is a holiday every year
+		if annual, err := time.Parse("01-02", fields[1]); err == nil {
+			s.holidays.AddAnnual(annual.Month(), annual.Day())
+		} else if date, err := time.Parse("2006-01-02", fields[1]); err == nil {
+			s.holidays.Add(date)
+		} else {
+			return fmt.Errorf("invalid date %q", fields[1])
+		}
+		s.directives = append(s.directives, line)
+		return nil
+
+	case "calendar":
+		if dir == "" {
+			return fmt.Errorf("calendar files can only be used in schedule files")
+		}
+		if len(fields) != 2 {
+			return fmt.Errorf("expected a calendar file")
+		}
+		path := fields[1]
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		calendar, err := LoadICS(path)
+		if err != nil {
+			return err
+		}
+		s.holidays.Merge(calendar)
+		return nil
+	}
+
+	r, err := parseRule(fields)
+	if err != nil {
+		return err
+	}
+	r.source = line
+	s.rules = append(s.rules, r)
+
+	return nil
+}
+
+// parseRule parses the days, optional time range and actions of a rule
+func parseRule(fields []string) (rule, error) {
+	var r rule
+
+	days, err := parseDays(fields[0])
+	if err != nil {
+		return r, err
+	}
+	r.days = days
+	fields = fields[1:]
+
+	r.allDay = true
+	if len(fields) > 0 && strings.Contains(fields[0], ":") {
+		if r.start, r.end, err = parseTimeRange(fields[0]); err != nil {
+			return r, err
+		}
+		r.allDay = false
+		fields = fields[1:]
+	}
+
+	if len(fields) == 0 {
+		return r, fmt.Errorf("expected never-stop or naptime=DURATION")
+	}
+	for _, action := range fields {
+		switch {
+		case action == "never-stop":
+			r.neverStop = true
+		case strings.HasPrefix(action, "naptime="):
+			napTime, err := time.ParseDuration(strings.TrimPrefix(action, "naptime="))
+			if err != nil {
+				return r, err
+			}
+			if napTime <= 0 {
+				return r, fmt.Errorf("naptime must be positive")
+			}
+			r.napTime = napTime
+		default:
+			return r, fmt.Errorf("unknown action %q", action)
+		}
+	}
+
+	return r, nil
+}
+
+// dayNames maps day names to weekdays
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseDays parses a day selector such as weekdays, sat,sun or mon-fri
+func parseDays(selector string) (daySet, error) {
+	var days daySet
+
+	for _, part := range strings.Split(strings.ToLower(selector), ",") {
+		switch part {
+		case "daily":
+			days.daily = true
+			for i := range days.weekdays {
+				days.weekdays[i] = true
+			}
+		case "weekdays":
+			for d := time.Monday; d <= time.Friday; d++ {
+				days.weekdays[d] = true
+			}
+		case "weekends":
+			days.weekdays[time.Saturday] = true
+			days.weekdays[time.Sunday] = true
+		case "holidays":
+			days.holidays = true
+		default:
+			from, to, isRange := strings.Cut(part, "-")
+			first, ok := dayNames[from]
+			if !ok {
+				return days, fmt.Errorf("unknown day %q", from)
+			}
+			last := first
+			if isRange {
+				if last, ok = dayNames[to]; !ok {
+					return days, fmt.Errorf("unknown day %q", to)
+				}
+			}
+			for d := first; ; d = (d + 1) % 7 {
+				days.weekdays[d] = true
+				if d == last {
+					break
+				}
+			}
+		}
+	}
+
+	return days, nil
+}
+
+// parseTimeRange parses a range such as 09:00-18:00
+func parseTimeRange(value string) (time.Duration, time.Duration, error) {
+	from, to, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("expected a time range such as 09:00-18:00")
+	}
+
+	start, err := parseClock(from)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return 0, 0, err
+	}
+	if start == end {
+		return 0, 0, fmt.Errorf("time range is empty")
+	}
+
+	return start, end, nil
+}
+
+// parseClock parses a time of day such as 18:30 as the offset from midnight
+func parseClock(value string) (time.Duration, error) {
+	hours, minutes, ok := strings.Cut(value, ":")
+	if !ok {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	h, err := strconv.Atoi(hours)
+	if err != nil || h < 0 || h > 24 {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	m, err := strconv.Atoi(minutes)
+	if err != nil || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// matchesDay reports whether the rule applies to the given date
+func (r rule) matchesDay(date time.Time, holiday bool) bool {
+	if holiday {
+		return r.days.daily || r.days.holidays
+	}
+	return r.days.weekdays[date.Weekday()]
+}
+
+// matches reports whether the rule covers the given time
+func (r rule) matches(t time.Time, holidays *Calendar) bool {
+	if r.allDay {
+		return r.matchesDay(t, holidays.Contains(t))
+	}
+
+	offset := sinceMidnight(t)
+	if r.start < r.end {
+		return offset >= r.start && offset < r.end && r.matchesDay(t, holidays.Contains(t))
+	}
+
+	// The window runs past midnight, so the early hours belong to the previous day
+	if offset >= r.start {
+		return r.matchesDay(t, holidays.Contains(t))
+	}
+	if offset < r.end {
+		yesterday := t.AddDate(0, 0, -1)
+		return r.matchesDay(yesterday, holidays.Contains(yesterday))
+	}
+	return false
+}
+
+// sinceMidnight returns how long after midnight the time is, on the wall clock
+func sinceMidnight(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
+
+// At returns the policy in effect at the given time
+func (s *Schedule) At(t time.Time) Policy {
+	if s == nil {
+		return Policy{}
+	}
+
+	t = t.In(s.location)
+	for _, r := range s.rules {
+		if r.matches(t, s.holidays) {
+			return Policy{
+				NeverStop: r.neverStop,
+				NapTime:   r.napTime,
+				Rule:      r.source,
+			}
+		}
+	}
+
+	return Policy{}
+}
+
+// Location returns the timezone the schedule is evaluated in
+func (s *Schedule) Location() *time.Location {
+	return s.location
+}
+
+// Override returns a schedule whose rules are tried before the rules of the
+// base schedule. The override's timezone is used if it sets one, and the
+// holidays of both schedules apply.
+func (s *Schedule) Override(base *Schedule) *Schedule {
+	if s == nil {
+		return base
+	}
+	if base == nil {
+		return s
+	}
+
+	merged := &Schedule{
+		location: base.location,
+		holidays: NewCalendar(),
+	}
+	merged.holidays.Merge(base.holidays)
+	merged.holidays.Merge(s.holidays)
+	for _, directive := range s.directives {
+		if strings.HasPrefix(directive, "timezone ") {
+			merged.location = s.location
+		}
+	}
+	merged.rules = append(append(merged.rules, s.rules...), base.rules...)
+	merged.directives = append(append(merged.directives, base.directives...), s.directives...)
+
+	return merged
+}
+
+// String returns the schedule in the form read by Parse, with calendar
+// files expanded into holiday lines and rules separated by semicolons
+func (s *Schedule) String() string {
+	var lines []string
+	for _, directive := range s.directives {
+		if !strings.HasPrefix(directive, "holiday ") {
+			lines = append(lines, directive)
+		}
+	}
+	for _, date := range s.holidays.Dates() {
+		lines = append(lines, "holiday "+date)
+	}
+	for _, r := range s.rules {
+		lines = append(lines, r.source)
+	}
+	return strings.Join(lines, "; ")
+}
@@ -0,0 +1,178 @@
+package schedule
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const officeSchedule = `
+timezone America/New_York
+holiday 2025-07-04
+
+# Never interrupt people during working hours
+weekdays 09:00-18:00 never-stop
+daily 22:00-07:00 naptime=10m
+sat,sun naptime=15m
+`
+
+func TestSchedule_At(t *testing.T) {
+	schedule, err := Parse(officeSchedule)
+	if err != nil {
+		t.Fatalf("Failed to parse schedule: %v", err)
+	}
+
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("Timezone data not available: %v", err)
+	}
+	at := func(value string) time.Time {
+		t.Helper()
+		parsed, err := time.ParseInLocation("2006-01-02 15:04", value, newYork)
+		if err != nil {
+			t.Fatalf("Failed to parse time: %v", err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		name      string
+		time      time.Time
+		neverStop bool
+		napTime   time.Duration
+	}{
+		{"weekday lunch", at("2025-07-02 12:30"), true, 0},
+		{"weekday evening", at("2025-07-02 19:00"), false, 0},
+		{"weekday night", at("2025-07-02 23:00"), false, 10 * time.Minute},
+		{"early morning after a weekday", at("2025-07-03 06:59"), false, 10 * time.Minute},
+		{"weekday morning", at("2025-07-03 07:00"), false, 0},
+		{"holiday lunch", at("2025-07-04 12:30"), false, 0},
+		{"holiday night", at("2025-07-04 23:30"), false, 10 * time.Minute},
+		{"saturday", at("2025-07-05 12:00"), false, 15 * time.Minute},
+		// The same instant expressed in another timezone
+		{"weekday lunch in UTC", at("2025-07-02 12:30").UTC(), true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := schedule.At(tt.time)
+			if policy.NeverStop != tt.neverStop {
+				t.Errorf("Expected never-stop %v, got %v (rule %q)", tt.neverStop, policy.NeverStop, policy.Rule)
+			}
+			if policy.NapTime != tt.napTime {
+				t.Errorf("Expected naptime %s, got %s (rule %q)", tt.napTime, policy.NapTime, policy.Rule)
+			}
+		})
+	}
+
+	if napTime := schedule.At(at("2025-07-02 19:00")).NapTimeOr(30 * time.Minute); napTime != 30*time.Minute {
+		t.Errorf("Expected the default naptime outside any rule, got %s", napTime)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	specs := []string{
+		"timezone Mars/Olympus_Mons",
+		"holiday 2025-13-01",
+		"someday never-stop",
+		"weekdays 09:00-18:00",
+		"weekdays 09:00-09:00 never-stop",
+		"weekdays 25:00-26:00 never-stop",
+		"daily naptime=0s",
+		"daily sleep",
+		"calendar holidays.ics",
+	}
+
+	for _, spec := range specs {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
+
+func TestSchedule_DayRanges(t *testing.T) {
+	schedule, err := Parse("timezone UTC; fri-mon never-stop")
+	if err != nil {
+		t.Fatalf("Failed to parse schedule: %v", err)
+	}
+
+	// 2025-07-07 is a Monday
+	monday := time.Date(2025, 7, 7, 12, 0, 0, 0, time.UTC)
+	for i, expected := range []bool{true, false, false, false, true, true, true} {
+		day := monday.AddDate(0, 0, i)
+		if schedule.At(day).NeverStop != expected {
+			t.Errorf("Expected never-stop %v on %s", expected, day.Weekday())
+		}
+	}
+}
+
+func TestSchedule_Override(t *testing.T) {
+	base, err := Parse("timezone UTC; holiday 12-25; daily naptime=30m")
+	if err != nil {
+		t.Fatalf("Failed to parse schedule: %v", err)
+	}
+	instance, err := FromMetadata(map[string]string{
+		MetadataKey: "timezone Asia/Tokyo; weekdays 09:00-18:00 never-stop",
+	})
+	if err != nil {
+		t.Fatalf("Failed to parse schedule from metadata: %v", err)
+	}
+	if missing, err := FromMetadata(map[string]string{"name": "devbox"}); missing != nil || err != nil {
+		t.Errorf("Expected no schedule without metadata, got %v, %v", missing, err)
+	}
+
+	merged := instance.Override(base)
+	if merged.Location().String() != "Asia/Tokyo" {
+		t.Errorf("Expected the instance timezone, got %s", merged.Location())
+	}
+
+	// 10:00 in Tokyo on a Wednesday
+	workday := time.Date(2025, 7, 2, 1, 0, 0, 0, time.UTC)
+	if !merged.At(workday).NeverStop {
+		t.Errorf("Expected the instance rule to apply")
+	}
+	if napTime := merged.At(workday.Add(12 * time.Hour)).NapTime; napTime != 30*time.Minute {
+		t.Errorf("Expected the base rule to apply outside the instance rule, got %s", napTime)
+	}
+
+	// The base holidays still apply
+	christmas := time.Date(2025, 12, 25, 1, 0, 0, 0, time.UTC)
+	if merged.At(christmas).NeverStop {
+		t.Errorf("Expected working hours not to apply on a holiday")
+	}
+
+	// The merged schedule survives a round trip through metadata
+	parsed, err := Parse(merged.String())
+	if err != nil {
+		t.Fatalf("Failed to parse %q: %v", merged.String(), err)
+	}
+	if parsed.String() != merged.String() {
+		t.Errorf("Expected %q, got %q", merged.String(), parsed.String())
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	ics := "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART;VALUE=DATE:20251226\r\nSUMMARY:Boxing Day\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	if err := os.WriteFile(filepath.Join(dir, "holidays.ics"), []byte(ics), 0644); err != nil {
+		t.Fatalf("Failed to write calendar: %v", err)
+	}
+	spec := "timezone UTC\ncalendar holidays.ics\nweekdays never-stop\n"
+	if err := os.WriteFile(filepath.Join(dir, "schedule.conf"), []byte(spec), 0644); err != nil {
+		t.Fatalf("Failed to write schedule: %v", err)
+	}
+
+	schedule, err := LoadFile(filepath.Join(dir, "schedule.conf"))
+	if err != nil {
+		t.Fatalf("Failed to load schedule: %v", err)
+	}
+
+	boxingDay := time.Date(2025, 12, 26, 12, 0, 0, 0, time.UTC)
+	if schedule.At(boxingDay).NeverStop {
+		t.Errorf("Expected the calendar holiday to skip the weekday rule")
+	}
+	if !schedule.At(boxingDay.AddDate(0, 0, -1)).NeverStop {
+		t.Errorf("Expected the weekday rule on a normal weekday")
+	}
+}